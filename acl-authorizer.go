@@ -0,0 +1,396 @@
+package kafkamock
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+type (
+	kafkaAclResourceType int8
+	kafkaAclPatternType  int8
+	kafkaAclOperation    int8
+	kafkaAclPermission   int8
+
+	// An ACL binding for KafkaMock.AddAcls. The enum fields take the names
+	// Kafka uses, in any case and with or without separators, such as
+	// "TransactionalId", "transactional-id" or "TRANSACTIONAL_ID".
+	KafkaAcl struct {
+		ResourceType string // topic, group, cluster or transactional id
+		ResourceName string // "*" for every resource, and "kafka-cluster" for the cluster
+		PatternType  string // literal when empty, or prefixed
+		Principal    string // such as "User:alice", or "User:*" for everyone
+		Host         string // "*" when empty
+		Operation    string // such as read, write, describe or all
+		Permission   string // allow when empty, or deny
+	}
+
+	// a rule that allows or denies a principal an operation on the resources
+	// that match its pattern
+	kafkaAcl struct {
+		resourceType kafkaAclResourceType
+		resourceName string
+		patternType  kafkaAclPatternType
+		principal    string
+		host         string
+		operation    kafkaAclOperation
+		permission   kafkaAclPermission
+	}
+
+	// selects the ACLs to describe or delete; the "any" values and nil
+	// strings match every ACL
+	kafkaAclFilter struct {
+		resourceType kafkaAclResourceType
+		resourceName *string
+		patternType  kafkaAclPatternType
+		principal    *string
+		host         *string
+		operation    kafkaAclOperation
+		permission   kafkaAclPermission
+	}
+
+	// the ACLs of the cluster, which are only enforced once enabled
+	kafkaAuthorizer struct {
+		mu         sync.Mutex
+		enabled    bool
+		superUsers map[string]bool // principals that are allowed everything
+		acls       []kafkaAcl
+	}
+)
+
+const (
+	aclResourceAny             kafkaAclResourceType = 1
+	aclResourceTopic           kafkaAclResourceType = 2
+	aclResourceGroup           kafkaAclResourceType = 3
+	aclResourceCluster         kafkaAclResourceType = 4
+	aclResourceTransactionalId kafkaAclResourceType = 5
+)
+
+const (
+	aclPatternAny      kafkaAclPatternType = 1
+	aclPatternMatch    kafkaAclPatternType = 2 // filters for the ACLs that apply to a resource name
+	aclPatternLiteral  kafkaAclPatternType = 3
+	aclPatternPrefixed kafkaAclPatternType = 4
+)
+
+const (
+	aclOpAny             kafkaAclOperation = 1
+	aclOpAll             kafkaAclOperation = 2
+	aclOpRead            kafkaAclOperation = 3
+	aclOpWrite           kafkaAclOperation = 4
+	aclOpCreate          kafkaAclOperation = 5
+	aclOpDelete          kafkaAclOperation = 6
+	aclOpAlter           kafkaAclOperation = 7
+	aclOpDescribe        kafkaAclOperation = 8
+	aclOpClusterAction   kafkaAclOperation = 9
+	aclOpDescribeConfigs kafkaAclOperation = 10
+	aclOpAlterConfigs    kafkaAclOperation = 11
+	aclOpIdempotentWrite kafkaAclOperation = 12
+)
+
+const (
+	aclPermissionAny   kafkaAclPermission = 1
+	aclPermissionDeny  kafkaAclPermission = 2
+	aclPermissionAllow kafkaAclPermission = 3
+)
+
+const (
+	kClusterResourceName = "kafka-cluster"
+	kAclWildcard         = "*"
+)
+
+var aclResourceTypeNames = map[string]kafkaAclResourceType{
+	"topic":           aclResourceTopic,
+	"group":           aclResourceGroup,
+	"cluster":         aclResourceCluster,
+	"transactionalid": aclResourceTransactionalId,
+}
+
+var aclPatternTypeNames = map[string]kafkaAclPatternType{
+	"":         aclPatternLiteral,
+	"literal":  aclPatternLiteral,
+	"prefixed": aclPatternPrefixed,
+}
+
+var aclOperationNames = map[string]kafkaAclOperation{
+	"all":             aclOpAll,
+	"read":            aclOpRead,
+	"write":           aclOpWrite,
+	"create":          aclOpCreate,
+	"delete":          aclOpDelete,
+	"alter":           aclOpAlter,
+	"describe":        aclOpDescribe,
+	"clusteraction":   aclOpClusterAction,
+	"describeconfigs": aclOpDescribeConfigs,
+	"alterconfigs":    aclOpAlterConfigs,
+	"idempotentwrite": aclOpIdempotentWrite,
+}
+
+var aclPermissionNames = map[string]kafkaAclPermission{
+	"":      aclPermissionAllow,
+	"allow": aclPermissionAllow,
+	"deny":  aclPermissionDeny,
+}
+
+// the operations that an allowed operation also allows
+var aclImpliedOperations = map[kafkaAclOperation][]kafkaAclOperation{
+	aclOpDescribe:        {aclOpRead, aclOpWrite, aclOpDelete, aclOpAlter},
+	aclOpDescribeConfigs: {aclOpAlterConfigs},
+}
+
+func newKafkaAuthorizer() *kafkaAuthorizer {
+	return &kafkaAuthorizer{superUsers: map[string]bool{}, acls: []kafkaAcl{}}
+}
+
+func (a *kafkaAuthorizer) enable(superUsers []string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.enabled = true
+	for _, principal := range superUsers {
+		a.superUsers[principal] = true
+	}
+}
+
+func (a *kafkaAuthorizer) isEnabled() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.enabled
+}
+
+// whether the principal connecting from the host may perform the operation
+// on the resource; a matching deny wins over any allow, and a resource that
+// no ACL allows is denied
+func (a *kafkaAuthorizer) authorize(principal, host string, op kafkaAclOperation, resourceType kafkaAclResourceType, resourceName string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.enabled || a.superUsers[principal] {
+		return true
+	}
+
+	allowed := false
+	for _, acl := range a.acls {
+		if acl.resourceType != resourceType || !acl.appliesTo(resourceName) {
+			continue
+		}
+		if acl.principal != principal && acl.principal != "User:"+kAclWildcard {
+			continue
+		}
+		if acl.host != host && acl.host != kAclWildcard {
+			continue
+		}
+
+		if acl.permission == aclPermissionDeny {
+			if acl.operation == op || acl.operation == aclOpAll {
+				return false
+			}
+		} else if acl.operation == op || acl.operation == aclOpAll {
+			allowed = true
+		} else {
+			for _, implied := range aclImpliedOperations[op] {
+				if acl.operation == implied {
+					allowed = true
+				}
+			}
+		}
+	}
+	return allowed
+}
+
+// whether the ACL's pattern matches the name of a resource
+func (acl *kafkaAcl) appliesTo(resourceName string) bool {
+	if acl.patternType == aclPatternPrefixed {
+		return strings.HasPrefix(resourceName, acl.resourceName)
+	}
+	return acl.resourceName == resourceName || acl.resourceName == kAclWildcard
+}
+
+// returns why an ACL can't be created, or an empty string if it can
+func (acl *kafkaAcl) validate() string {
+	switch {
+	case acl.resourceType < aclResourceTopic || acl.resourceType > aclResourceTransactionalId:
+		return fmt.Sprintf("invalid resource type %d", acl.resourceType)
+	case acl.patternType != aclPatternLiteral && acl.patternType != aclPatternPrefixed:
+		return fmt.Sprintf("invalid pattern type %d", acl.patternType)
+	case acl.resourceName == "":
+		return "the resource name is empty"
+	case acl.resourceType == aclResourceCluster && acl.resourceName != kClusterResourceName:
+		return "the cluster resource must be named " + kClusterResourceName
+	case acl.operation < aclOpAll || acl.operation > aclOpIdempotentWrite:
+		return fmt.Sprintf("invalid operation %d", acl.operation)
+	case acl.permission != aclPermissionAllow && acl.permission != aclPermissionDeny:
+		return fmt.Sprintf("invalid permission type %d", acl.permission)
+	case acl.host == "":
+		return "the host is empty"
+	}
+
+	if kind, name, found := strings.Cut(acl.principal, ":"); !found || kind == "" || name == "" {
+		return "invalid principal " + acl.principal + ", which must be in the form of Type:name"
+	}
+	return ""
+}
+
+// adds an ACL, unless an identical one already exists
+func (a *kafkaAuthorizer) addAcl(acl kafkaAcl) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, existing := range a.acls {
+		if existing == acl {
+			return
+		}
+	}
+	a.acls = append(a.acls, acl)
+}
+
+func (a *kafkaAuthorizer) describeAcls(filter *kafkaAclFilter) []kafkaAcl {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	matches := []kafkaAcl{}
+	for _, acl := range a.acls {
+		if filter.matches(&acl) {
+			matches = append(matches, acl)
+		}
+	}
+	return matches
+}
+
+// removes the ACLs that match the filter, returning them
+func (a *kafkaAuthorizer) deleteAcls(filter *kafkaAclFilter) []kafkaAcl {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	deleted := []kafkaAcl{}
+	kept := make([]kafkaAcl, 0, len(a.acls))
+	for _, acl := range a.acls {
+		if filter.matches(&acl) {
+			deleted = append(deleted, acl)
+		} else {
+			kept = append(kept, acl)
+		}
+	}
+	a.acls = kept
+	return deleted
+}
+
+// returns why a filter is invalid, or an empty string if it is valid
+func (filter *kafkaAclFilter) validate() string {
+	switch {
+	case filter.resourceType < aclResourceAny || filter.resourceType > aclResourceTransactionalId:
+		return fmt.Sprintf("invalid resource type filter %d", filter.resourceType)
+	case filter.patternType < aclPatternAny || filter.patternType > aclPatternPrefixed:
+		return fmt.Sprintf("invalid pattern type filter %d", filter.patternType)
+	case filter.operation < aclOpAny || filter.operation > aclOpIdempotentWrite:
+		return fmt.Sprintf("invalid operation filter %d", filter.operation)
+	case filter.permission < aclPermissionAny || filter.permission > aclPermissionAllow:
+		return fmt.Sprintf("invalid permission type filter %d", filter.permission)
+	}
+	return ""
+}
+
+func (filter *kafkaAclFilter) matches(acl *kafkaAcl) bool {
+	if filter.resourceType != aclResourceAny && filter.resourceType != acl.resourceType {
+		return false
+	}
+	if filter.operation != aclOpAny && filter.operation != acl.operation {
+		return false
+	}
+	if filter.permission != aclPermissionAny && filter.permission != acl.permission {
+		return false
+	}
+	if filter.principal != nil && *filter.principal != acl.principal {
+		return false
+	}
+	if filter.host != nil && *filter.host != acl.host {
+		return false
+	}
+
+	switch filter.patternType {
+	case aclPatternAny:
+		return filter.resourceName == nil || *filter.resourceName == acl.resourceName
+	case aclPatternMatch:
+		// the ACLs that apply to the named resource
+		return filter.resourceName == nil || acl.appliesTo(*filter.resourceName)
+	}
+	return filter.patternType == acl.patternType && (filter.resourceName == nil || *filter.resourceName == acl.resourceName)
+}
+
+// converts an ACL binding given by name
+func (binding *KafkaAcl) toAcl() (acl kafkaAcl, err error) {
+	normalize := func(name string) string {
+		return strings.NewReplacer("-", "", "_", "").Replace(strings.ToLower(name))
+	}
+
+	var found [4]bool
+	acl.resourceType, found[0] = aclResourceTypeNames[normalize(binding.ResourceType)]
+	acl.patternType, found[1] = aclPatternTypeNames[normalize(binding.PatternType)]
+	acl.operation, found[2] = aclOperationNames[normalize(binding.Operation)]
+	acl.permission, found[3] = aclPermissionNames[normalize(binding.Permission)]
+	for n, field := range []string{binding.ResourceType, binding.PatternType, binding.Operation, binding.Permission} {
+		if !found[n] {
+			return acl, fmt.Errorf("unknown acl value %q", field)
+		}
+	}
+
+	acl.resourceName = binding.ResourceName
+	acl.principal = binding.Principal
+	acl.host = binding.Host
+	if acl.host == "" {
+		acl.host = kAclWildcard
+	}
+
+	if reason := acl.validate(); reason != "" {
+		return acl, fmt.Errorf("invalid acl: %s", reason)
+	}
+	return acl, nil
+}
+
+// whether the client's principal may perform the operation on the resource
+func (kc *kafkaClient) authorized(op kafkaAclOperation, resourceType kafkaAclResourceType, resourceName string) bool {
+	return kc.ds.Acls.authorize(kc.principal, kc.host(), op, resourceType, resourceName)
+}
+
+// the error for an operation the client's principal may not perform on the
+// resource, or NoError when it may
+func (kc *kafkaClient) authorizationError(op kafkaAclOperation, resourceType kafkaAclResourceType, resourceName string) kafkaErrorCode {
+	if kc.authorized(op, resourceType, resourceName) {
+		return NoError
+	}
+
+	switch resourceType {
+	case aclResourceTopic:
+		return TopicAuthorizationFailed
+	case aclResourceGroup:
+		return GroupAuthorizationFailed
+	case aclResourceTransactionalId:
+		return TransactionalIdAuthorizationFailed
+	}
+	return ClusterAuthorizationFailed
+}
+
+// the IP address the client connected from
+func (kc *kafkaClient) host() string {
+	if kc.conn == nil {
+		return ""
+	}
+	if addr, is := kc.conn.RemoteAddr().(*net.TCPAddr); is {
+		return addr.IP.String()
+	}
+	return ""
+}
+
+// the error for a config operation the client's principal may not perform,
+// which is authorized on the topic, or on the cluster for a broker's configs
+func (kc *kafkaClient) configAuthorizationError(op kafkaAclOperation, resourceType kafkaResourceType, resourceName string) kafkaErrorCode {
+	switch resourceType {
+	case resourceTopic:
+		return kc.authorizationError(op, aclResourceTopic, resourceName)
+	case resourceBroker:
+		return kc.authorizationError(op, aclResourceCluster, kClusterResourceName)
+	}
+	return NoError
+}
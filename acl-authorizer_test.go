@@ -0,0 +1,167 @@
+package kafkamock
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+)
+
+func TestAclAuthorize(t *testing.T) {
+	a := newKafkaAuthorizer()
+	if !a.authorize("User:alice", "127.0.0.1", aclOpWrite, aclResourceTopic, "orders") {
+		t.Error("acls aren't enforced until they are enabled")
+	}
+
+	a.enable([]string{"User:admin"})
+	for _, acl := range []kafkaAcl{
+		{resourceType: aclResourceTopic, resourceName: "orders", patternType: aclPatternLiteral, principal: "User:alice", host: "*", operation: aclOpRead, permission: aclPermissionAllow},
+		{resourceType: aclResourceTopic, resourceName: "billing-", patternType: aclPatternPrefixed, principal: "User:*", host: "*", operation: aclOpAll, permission: aclPermissionAllow},
+		{resourceType: aclResourceTopic, resourceName: "billing-audit", patternType: aclPatternLiteral, principal: "User:bob", host: "*", operation: aclOpWrite, permission: aclPermissionDeny},
+		{resourceType: aclResourceGroup, resourceName: "*", patternType: aclPatternLiteral, principal: "User:alice", host: "10.0.0.1", operation: aclOpRead, permission: aclPermissionAllow},
+	} {
+		a.addAcl(acl)
+	}
+
+	cases := []struct {
+		principal, host string
+		op              kafkaAclOperation
+		resourceType    kafkaAclResourceType
+		name            string
+		expected        bool
+	}{
+		{"User:alice", "127.0.0.1", aclOpRead, aclResourceTopic, "orders", true},
+		{"User:alice", "127.0.0.1", aclOpDescribe, aclResourceTopic, "orders", true}, // implied by read
+		{"User:alice", "127.0.0.1", aclOpWrite, aclResourceTopic, "orders", false},
+		{"User:alice", "127.0.0.1", aclOpRead, aclResourceTopic, "orders-2", false},
+		{"User:bob", "127.0.0.1", aclOpWrite, aclResourceTopic, "billing-invoices", true},
+		{"User:bob", "127.0.0.1", aclOpWrite, aclResourceTopic, "billing-audit", false}, // deny wins
+		{"User:bob", "127.0.0.1", aclOpRead, aclResourceTopic, "billing-audit", true},
+		{"User:alice", "10.0.0.1", aclOpRead, aclResourceGroup, "any-group", true},
+		{"User:alice", "127.0.0.1", aclOpRead, aclResourceGroup, "any-group", false},
+		{"User:admin", "127.0.0.1", aclOpAlter, aclResourceCluster, kClusterResourceName, true},
+	}
+	for _, c := range cases {
+		if a.authorize(c.principal, c.host, c.op, c.resourceType, c.name) != c.expected {
+			t.Errorf("expected %s from %s doing %d on %s to be %v", c.principal, c.host, c.op, c.name, c.expected)
+		}
+	}
+
+	name := "billing-invoices"
+	matching := a.describeAcls(&kafkaAclFilter{resourceType: aclResourceTopic, resourceName: &name, patternType: aclPatternMatch, operation: aclOpAny, permission: aclPermissionAny})
+	if len(matching) != 1 || matching[0].resourceName != "billing-" {
+		t.Errorf("unexpected matching acls %+v", matching)
+	}
+}
+
+func TestKafkaAcls(t *testing.T) {
+	tl, mock := testCreateKafkaMockServer(t, 21001, []string{"orders", "payments"})
+	defer testStopMockServer(t, mock)
+
+	mock.SetSaslPlainUsers(map[string]string{"admin": "admin-secret", "alice": "alice-secret"})
+	mock.Restart()
+
+	admin := testSaslKafkaClient(21001, plain.Mechanism{Username: "admin", Password: "admin-secret"})
+	alice := testSaslKafkaClient(21001, plain.Mechanism{Username: "alice", Password: "alice-secret"})
+
+	// the acl apis need an authorizer
+	describeResp, err := admin.DescribeACLs(tl, &kafka.DescribeACLsRequest{Filter: kafka.ACLFilter{ResourceTypeFilter: kafka.ResourceTypeAny, ResourcePatternTypeFilter: kafka.PatternTypeAny, Operation: kafka.ACLOperationTypeAny, PermissionType: kafka.ACLPermissionTypeAny}})
+	if err != nil || !errors.Is(describeResp.Error, kafka.SecurityDisabled) {
+		t.Fatalf("expected security disabled, got %v %+v", err, describeResp)
+	}
+
+	mock.EnableAcls("User:admin")
+
+	createResp, err := admin.CreateACLs(tl, &kafka.CreateACLsRequest{ACLs: []kafka.ACLEntry{
+		{ResourceType: kafka.ResourceTypeTopic, ResourceName: "orders", ResourcePatternType: kafka.PatternTypeLiteral, Principal: "User:alice", Host: "*", Operation: kafka.ACLOperationTypeRead, PermissionType: kafka.ACLPermissionTypeAllow},
+		{ResourceType: kafka.ResourceTypeGroup, ResourceName: "svc-", ResourcePatternType: kafka.PatternTypePrefixed, Principal: "User:alice", Host: "*", Operation: kafka.ACLOperationTypeRead, PermissionType: kafka.ACLPermissionTypeAllow},
+		{ResourceType: kafka.ResourceTypeCluster, ResourceName: "my-cluster", ResourcePatternType: kafka.PatternTypeLiteral, Principal: "User:alice", Host: "*", Operation: kafka.ACLOperationTypeAlter, PermissionType: kafka.ACLPermissionTypeAllow},
+	}})
+	if err != nil || len(createResp.Errors) != 3 || createResp.Errors[0] != nil || createResp.Errors[1] != nil || !errors.Is(createResp.Errors[2], kafka.InvalidRequest) {
+		t.Fatalf("create acls error: %v %+v", err, createResp)
+	}
+
+	describeResp, err = admin.DescribeACLs(tl, &kafka.DescribeACLsRequest{Filter: kafka.ACLFilter{ResourceTypeFilter: kafka.ResourceTypeAny, ResourcePatternTypeFilter: kafka.PatternTypeAny, PrincipalFilter: "User:alice", Operation: kafka.ACLOperationTypeAny, PermissionType: kafka.ACLPermissionTypeAny}})
+	if err != nil || describeResp.Error != nil || len(describeResp.Resources) != 2 || describeResp.Resources[1].ResourceName != "svc-" || describeResp.Resources[1].PatternType != kafka.PatternTypePrefixed {
+		t.Fatalf("describe acls error: %v %+v", err, describeResp)
+	}
+
+	// alice can see and read orders, but nothing else
+	metaResp, err := alice.Metadata(tl, &kafka.MetadataRequest{})
+	if err != nil || len(metaResp.Topics) != 1 || metaResp.Topics[0].Name != "orders" {
+		t.Errorf("expected only orders to be listed, got %v %+v", err, metaResp)
+	}
+
+	orders, payments := "orders", "payments"
+	aliceClient := &kafkaClient{l: tl, ds: mock.ds, principal: "User:alice"}
+	mr := testClientRequest[*metadataResponse](t, aliceClient, metadata, ApiKeyMetadata, 12, &metadataRequest{Topics: []metadataRequestTopic{{Name: &orders}, {Name: &payments}}})
+	if len(mr.Topics) != 2 || mr.Topics[0].ErrorCode != int16(NoError) || mr.Topics[1].ErrorCode != int16(TopicAuthorizationFailed) {
+		t.Errorf("unexpected metadata %+v", mr)
+	}
+	fr := testClientRequest[*fetchResponse](t, aliceClient, fetch, ApiKeyFetch, 11, &fetchRequest{
+		ReplicaId: -1,
+		Topics: []fetchTopic{
+			{Topic: "orders", Partitions: []fetchPartition{{Partition: 2, PartitionMaxBytes: 10000}}},
+			{Topic: "payments", Partitions: []fetchPartition{{Partition: 2, PartitionMaxBytes: 10000}}},
+		},
+	})
+	if fr.Responses[0].Partitions[0].ErrorCode != int16(NoError) || fr.Responses[1].Partitions[0].ErrorCode != int16(TopicAuthorizationFailed) {
+		t.Errorf("unexpected fetch response %+v", fr)
+	}
+
+	produceResp, err := alice.Produce(tl, &kafka.ProduceRequest{Topic: "orders", Partition: 2, RequiredAcks: kafka.RequireAll, Records: kafka.NewRecordReader(kafka.Record{Value: kafka.NewBytes([]byte("order-1"))})})
+	if err != nil || !errors.Is(produceResp.Error, kafka.TopicAuthorizationFailed) {
+		t.Errorf("expected topic authorization failed, got %v %+v", err, produceResp)
+	}
+	mock.SimplePost("orders", 2, nil, []byte("order-1"))
+	offsetsResp, err := alice.ListOffsets(tl, &kafka.ListOffsetsRequest{Topics: map[string][]kafka.OffsetRequest{"orders": {kafka.LastOffsetOf(2)}}})
+	if err != nil || offsetsResp.Topics["orders"][0].Error != nil || offsetsResp.Topics["orders"][0].LastOffset != 1 {
+		t.Errorf("list offsets error: %v %+v", err, offsetsResp)
+	}
+
+	// group access follows the prefixed acl
+	commitResp, err := alice.OffsetCommit(tl, &kafka.OffsetCommitRequest{GroupID: "batch-jobs", GenerationID: -1, Topics: map[string][]kafka.OffsetCommit{"orders": {{Partition: 2, Offset: 1}}}})
+	if err != nil || !errors.Is(commitResp.Topics["orders"][0].Error, kafka.GroupAuthorizationFailed) {
+		t.Errorf("expected group authorization failed, got %v %+v", err, commitResp)
+	}
+	commitResp, err = alice.OffsetCommit(tl, &kafka.OffsetCommitRequest{GroupID: "svc-orders", GenerationID: -1, Topics: map[string][]kafka.OffsetCommit{"orders": {{Partition: 2, Offset: 1}}, "payments": {{Partition: 2, Offset: 1}}}})
+	if err != nil || commitResp.Topics["orders"][0].Error != nil || !errors.Is(commitResp.Topics["payments"][0].Error, kafka.TopicAuthorizationFailed) {
+		t.Errorf("unexpected commit response %v %+v", err, commitResp)
+	}
+
+	// alice isn't an admin
+	if _, err = alice.CreateTopics(tl, &kafka.CreateTopicsRequest{Topics: []kafka.TopicConfig{{Topic: "refunds", NumPartitions: 1, ReplicationFactor: 1}}}); err != nil {
+		t.Fatalf("create topics error: %v", err)
+	}
+	if mock.ds.getTopic("refunds") != nil {
+		t.Error("alice created a topic")
+	}
+	describeResp, err = alice.DescribeACLs(tl, &kafka.DescribeACLsRequest{Filter: kafka.ACLFilter{ResourceTypeFilter: kafka.ResourceTypeAny, ResourcePatternTypeFilter: kafka.PatternTypeAny, Operation: kafka.ACLOperationTypeAny, PermissionType: kafka.ACLPermissionTypeAny}})
+	if err != nil || !errors.Is(describeResp.Error, kafka.ClusterAuthorizationFailed) {
+		t.Errorf("expected cluster authorization failed, got %v %+v", err, describeResp)
+	}
+
+	deleteResp, err := admin.DeleteACLs(tl, &kafka.DeleteACLsRequest{Filters: []kafka.DeleteACLsFilter{{ResourceTypeFilter: kafka.ResourceTypeTopic, ResourceNameFilter: "orders", ResourcePatternTypeFilter: kafka.PatternTypeLiteral, Operation: kafka.ACLOperationTypeAny, PermissionType: kafka.ACLPermissionTypeAny}}})
+	if err != nil || len(deleteResp.Results) != 1 || len(deleteResp.Results[0].MatchingACLs) != 1 {
+		t.Fatalf("delete acls error: %v %+v", err, deleteResp)
+	}
+	alice = testSaslKafkaClient(21001, plain.Mechanism{Username: "alice", Password: "alice-secret"})
+	metaResp, err = alice.Metadata(tl, &kafka.MetadataRequest{})
+	if err != nil || len(metaResp.Topics) != 0 {
+		t.Errorf("expected no topics to be listed, got %v %+v", err, metaResp)
+	}
+
+	// acls can also be given directly
+	if err = mock.AddAcls(KafkaAcl{ResourceType: "Topic", ResourceName: "pay", PatternType: "prefixed", Principal: "User:alice", Operation: "WRITE"}); err != nil {
+		t.Fatalf("add acls error: %v", err)
+	}
+	alice = testSaslKafkaClient(21001, plain.Mechanism{Username: "alice", Password: "alice-secret"})
+	produceResp, err = alice.Produce(tl, &kafka.ProduceRequest{Topic: "payments", Partition: 2, RequiredAcks: kafka.RequireAll, Records: kafka.NewRecordReader(kafka.Record{Value: kafka.NewBytes([]byte("payment-1"))})})
+	if err != nil || produceResp.Error != nil {
+		t.Errorf("produce error: %v %+v", err, produceResp)
+	}
+	if err = mock.AddAcls(KafkaAcl{ResourceType: "topic", ResourceName: "payments", Principal: "User:alice", Operation: "scribble"}); err == nil {
+		t.Error("expected an unknown operation to be rejected")
+	}
+}
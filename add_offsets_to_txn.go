@@ -0,0 +1,38 @@
+package kafkamock
+
+import (
+	"bufio"
+)
+
+type (
+	addOffsetsToTxnRequest struct {
+		TransactionalId string
+		ProducerId      int64
+		ProducerEpoch   int16
+		GroupId         string
+	}
+
+	addOffsetsToTxnResponse struct {
+		ThrottleTimeMs int32
+		ErrorCode      int16
+	}
+)
+
+func addOffsetsToTxn(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeader) (response any, rtags map[int]any, err error) {
+	request, err := readVersionedRequest[addOffsetsToTxnRequest](reader, kmh)
+	if err != nil {
+		return
+	}
+
+	ec := kc.authorizationError(aclOpWrite, aclResourceTransactionalId, request.TransactionalId)
+	if ec == NoError {
+		ec = kc.authorizationError(aclOpRead, aclResourceGroup, request.GroupId)
+	}
+	if ec == NoError {
+		ec = kc.ds.addOffsetsToTxn(kc.l, request.TransactionalId, request.ProducerId, request.ProducerEpoch, request.GroupId)
+	}
+	kc.l.Tracef("add group %s to the transaction of %s: %d", request.GroupId, request.TransactionalId, ec)
+
+	response = &addOffsetsToTxnResponse{ErrorCode: int16(fencedError(ec, kmh.RequestApiVersion, 2))}
+	return
+}
@@ -0,0 +1,88 @@
+package kafkamock
+
+import (
+	"bufio"
+)
+
+type (
+	addPartitionsToTxnRequest struct {
+		TransactionalId string
+		ProducerId      int64
+		ProducerEpoch   int16
+		Topics          []addPartitionsToTxnTopic
+	}
+
+	addPartitionsToTxnTopic struct {
+		Name       string
+		Partitions []int32
+	}
+
+	addPartitionsToTxnResponse struct {
+		ThrottleTimeMs int32
+		Results        []addPartitionsToTxnTopicResult
+	}
+
+	addPartitionsToTxnTopicResult struct {
+		Name    string
+		Results []addPartitionsToTxnPartitionResult
+	}
+
+	addPartitionsToTxnPartitionResult struct {
+		PartitionIndex int32
+		ErrorCode      int16
+	}
+)
+
+func addPartitionsToTxn(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeader) (response any, rtags map[int]any, err error) {
+	request, err := readVersionedRequest[addPartitionsToTxnRequest](reader, kmh)
+	if err != nil {
+		return
+	}
+
+	// the partitions are added all together, or not at all when any are
+	// unknown or not writable
+	txnAuthEc := kc.authorizationError(aclOpWrite, aclResourceTransactionalId, request.TransactionalId)
+	failed := map[kafkaTxnPartition]kafkaErrorCode{}
+	partitions := []kafkaTxnPartition{}
+	for _, topic := range request.Topics {
+		kt := kc.ds.getTopic(topic.Name)
+		authEc := kc.authorizationError(aclOpWrite, aclResourceTopic, topic.Name)
+		for _, index := range topic.Partitions {
+			tp := kafkaTxnPartition{topic: topic.Name, partition: index}
+			if txnAuthEc != NoError {
+				failed[tp] = txnAuthEc
+			} else if authEc != NoError {
+				failed[tp] = authEc
+			} else if kt == nil || kt.getPartition(index) == nil {
+				failed[tp] = UnknownTopicOrPartition
+			}
+			partitions = append(partitions, tp)
+		}
+	}
+
+	ec := OperationNotAttempted
+	if len(failed) == 0 {
+		ec = kc.ds.addPartitionsToTxn(kc.l, request.TransactionalId, request.ProducerId, request.ProducerEpoch, partitions)
+		ec = fencedError(ec, kmh.RequestApiVersion, 2)
+		kc.l.Tracef("add %d partitions to the transaction of %s: %d", len(partitions), request.TransactionalId, ec)
+	}
+
+	apr := &addPartitionsToTxnResponse{Results: make([]addPartitionsToTxnTopicResult, 0, len(request.Topics))}
+	for _, topic := range request.Topics {
+		rtopic := addPartitionsToTxnTopicResult{
+			Name:    topic.Name,
+			Results: make([]addPartitionsToTxnPartitionResult, 0, len(topic.Partitions)),
+		}
+		for _, index := range topic.Partitions {
+			rpar := addPartitionsToTxnPartitionResult{PartitionIndex: index, ErrorCode: int16(ec)}
+			if ec, is := failed[kafkaTxnPartition{topic: topic.Name, partition: index}]; is {
+				rpar.ErrorCode = int16(ec)
+			}
+			rtopic.Results = append(rtopic.Results, rpar)
+		}
+		apr.Results = append(apr.Results, rtopic)
+	}
+
+	response = apr
+	return
+}
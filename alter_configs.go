@@ -0,0 +1,86 @@
+package kafkamock
+
+import (
+	"bufio"
+)
+
+type (
+	alterConfigsRequest struct {
+		Resources    []alterConfigsResource
+		ValidateOnly bool
+	}
+
+	alterConfigsResource struct {
+		ResourceType int8
+		ResourceName string
+		Configs      []alterConfigsConfig
+	}
+
+	alterConfigsConfig struct {
+		Name  string
+		Value NullableString
+	}
+
+	alterConfigsResponse struct {
+		ThrottleTimeMs int32
+		Responses      []alterConfigsResult
+	}
+
+	alterConfigsResult struct {
+		ErrorCode    int16
+		ErrorMessage NullableString
+		ResourceType int8
+		ResourceName string
+	}
+)
+
+func alterConfigs(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeader) (response any, rtags map[int]any, err error) {
+	request, err := readVersionedRequest[alterConfigsRequest](reader, kmh)
+	if err != nil {
+		return
+	}
+
+	acr := &alterConfigsResponse{Responses: make([]alterConfigsResult, 0, len(request.Resources))}
+	for _, resource := range request.Resources {
+		// the listed configs replace all of the resource's dynamic configs
+		changes := make([]kafkaConfigChange, 0, len(resource.Configs))
+		for _, config := range resource.Configs {
+			changes = append(changes, kafkaConfigChange{name: config.Name, op: configOpSet, value: config.Value})
+		}
+
+		ec, message := kc.configAuthorizationError(aclOpAlterConfigs, kafkaResourceType(resource.ResourceType), resource.ResourceName), "authorization failed"
+		if ec == NoError {
+			ec, message = kc.ds.alterResourceConfigs(kafkaResourceType(resource.ResourceType), resource.ResourceName, changes, true, request.ValidateOnly)
+		}
+
+		result := alterConfigsResult{ErrorCode: int16(ec), ResourceType: resource.ResourceType, ResourceName: resource.ResourceName}
+		if ec != NoError {
+			result.ErrorMessage = &message
+			kc.l.Tracef("alter configs of %s failed: %s", resource.ResourceName, message)
+		}
+		acr.Responses = append(acr.Responses, result)
+	}
+
+	response = acr
+	return
+}
+
+// changes the dynamic configs of a topic or broker
+func (ds *kafkaDataStore) alterResourceConfigs(resourceType kafkaResourceType, resourceName string, changes []kafkaConfigChange, replace, validateOnly bool) (ec kafkaErrorCode, message string) {
+	switch resourceType {
+	case resourceTopic:
+		kt := ds.getTopic(resourceName)
+		if kt == nil {
+			return UnknownTopicOrPartition, "topic " + resourceName + " does not exist"
+		}
+		return ds.alterTopicConfigs(kt, changes, replace, validateOnly)
+
+	case resourceBroker:
+		key, ok := ds.brokerConfigKey(resourceName)
+		if !ok {
+			return InvalidRequest, "unexpected broker id " + resourceName
+		}
+		return ds.alterBrokerConfigs(key, changes, replace, validateOnly)
+	}
+	return InvalidRequest, "unsupported resource type"
+}
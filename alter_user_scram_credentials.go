@@ -0,0 +1,83 @@
+package kafkamock
+
+import (
+	"bufio"
+	"slices"
+)
+
+type (
+	alterUserScramCredentialsRequest struct {
+		Deletions  []alterUserScramCredentialsDeletion
+		Upsertions []alterUserScramCredentialsUpsertion
+	}
+
+	alterUserScramCredentialsDeletion struct {
+		Name      string
+		Mechanism int8
+	}
+
+	alterUserScramCredentialsUpsertion struct {
+		Name           string
+		Mechanism      int8
+		Iterations     int32
+		Salt           []byte
+		SaltedPassword []byte
+	}
+
+	alterUserScramCredentialsResponse struct {
+		ThrottleTimeMs int32
+		Results        []alterUserScramCredentialsResult
+	}
+
+	alterUserScramCredentialsResult struct {
+		User         string
+		ErrorCode    int16
+		ErrorMessage NullableString
+	}
+)
+
+func alterUserScramCredentials(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeader) (response any, rtags map[int]any, err error) {
+	request, err := readVersionedRequest[alterUserScramCredentialsRequest](reader, kmh)
+	if err != nil {
+		return
+	}
+
+	alterations := make([]kafkaScramAlteration, 0, len(request.Deletions)+len(request.Upsertions))
+	for _, d := range request.Deletions {
+		alterations = append(alterations, kafkaScramAlteration{user: d.Name, kind: d.Mechanism, delete: true})
+	}
+	for _, u := range request.Upsertions {
+		alterations = append(alterations, kafkaScramAlteration{
+			user:           u.Name,
+			kind:           u.Mechanism,
+			iterations:     int(u.Iterations),
+			salt:           u.Salt,
+			saltedPassword: u.SaltedPassword,
+		})
+	}
+
+	var altered []kafkaScramAlterResult
+	if ec := kc.authorizationError(aclOpAlter, aclResourceCluster, kClusterResourceName); ec != NoError {
+		// every user's alterations fail
+		for _, a := range alterations {
+			if !slices.ContainsFunc(altered, func(r kafkaScramAlterResult) bool { return r.user == a.user }) {
+				altered = append(altered, kafkaScramAlterResult{user: a.user, ec: ec, message: "cluster authorization failed"})
+			}
+		}
+	} else {
+		altered = kc.ds.alterScramCredentials(alterations)
+	}
+	results := make([]alterUserScramCredentialsResult, 0, len(altered))
+	for _, a := range altered {
+		result := alterUserScramCredentialsResult{User: a.user, ErrorCode: int16(a.ec)}
+		if a.ec != NoError {
+			kc.l.Tracef("alter scram credentials of %s failed: %s", a.user, a.message)
+			message := a.message
+			result.ErrorMessage = &message
+		}
+		results = append(results, result)
+	}
+
+	response = &alterUserScramCredentialsResponse{Results: results}
+	return
+}
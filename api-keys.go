@@ -90,6 +90,46 @@ var apiVersions map[kafkaApiKey]versionRange = nil
 
 var apiHasTags = map[string]bool{}
 
+// first version of each api that uses the flexible (tagged field) encoding
+var apiFlexibleVersions = map[kafkaApiKey]int{
+	ApiKeyProduce:                      9,
+	ApiKeyFetch:                        12,
+	ApiKeyListOffsets:                  6,
+	ApiKeyMetadata:                     9,
+	ApiKeyOffsetCommit:                 8,
+	ApiKeyOffsetFetch:                  6,
+	ApiKeyFindCoordinator:              3,
+	ApiKeyJoinGroup:                    6,
+	ApiKeyHeartbeat:                    4,
+	ApiKeyLeaveGroup:                   4,
+	ApiKeySyncGroup:                    4,
+	ApiKeyDescribeGroups:               5,
+	ApiKeyListGroups:                   3,
+	ApiKeyApiVersions:                  3,
+	ApiKeyCreateTopics:                 5,
+	ApiKeyDeleteTopics:                 4,
+	ApiKeyDeleteRecords:                2,
+	ApiKeyInitProducerId:               2,
+	ApiKeyOffsetForLeaderEpoch:         4,
+	ApiKeyAddPartitionsToTxn:           3,
+	ApiKeyAddOffsetsToTxn:              3,
+	ApiKeyEndTxn:                       3,
+	ApiKeyWriteTxnMarkers:              1,
+	ApiKeyTxnOffsetCommit:              3,
+	ApiKeyDescribeAcls:                 2,
+	ApiKeyCreateAcls:                   2,
+	ApiKeyDeleteAcls:                   2,
+	ApiKeyDescribeConfigs:              4,
+	ApiKeyAlterConfigs:                 2,
+	ApiKeySaslAuthenticate:             2,
+	ApiKeyCreatePartitions:             2,
+	ApiKeyDeleteGroups:                 2,
+	ApiKeyIncrementalAlterConfigs:      1,
+	ApiKeyDescribeUserScramCredentials: 0,
+	ApiKeyAlterUserScramCredentials:    0,
+	ApiKeyConsumerGroupHeartbeat:       0,
+}
+
 var apiNames = map[kafkaApiKey]string{
 	ApiKeyProduce:                      "Produce",
 	ApiKeyFetch:                        "Fetch",
@@ -178,19 +218,43 @@ func initializeApis() {
 	}
 
 	apiTable = map[string]dispatchHandler{
-		makeApiKey(ApiKeyMetadata, 1):        metadataV1,
-		makeApiKey(ApiKeyFindCoordinator, 0): findCoordinatorV0,
-		makeApiKey(ApiKeyOffsetFetch, 1):     offsetFetchV1,
-		makeApiKey(ApiKeyJoinGroup, 1):       joinGroupV1,
-		makeApiKey(ApiKeySyncGroup, 0):       syncGroupV0,
-		makeApiKey(ApiKeyLeaveGroup, 0):      leaveGroupV0,
-		makeApiKey(ApiKeyApiVersions, 0):     apiVersionsV0,
-		makeApiKey(ApiKeyHeartbeat, 0):       heartbeatV0,
-		makeApiKey(ApiKeyListOffsets, 1):     listOffsetsV1,
-		makeApiKey(ApiKeyFetch, 2):           fetchV2,
-		makeApiKey(ApiKeyOffsetCommit, 2):    offsetCommitV2,
+		makeApiKey(ApiKeyOffsetFetch, 1):  offsetFetchV1,
+		makeApiKey(ApiKeyApiVersions, 0):  apiVersionsV0,
+		makeApiKey(ApiKeyFetch, 2):        fetch,
+		makeApiKey(ApiKeyOffsetCommit, 2): offsetCommitV2,
 	}
 
+	addApiVersions(ApiKeyProduce, 3, 9, produce)
+	addApiVersions(ApiKeyFetch, 3, 13, fetch)
+	addApiVersions(ApiKeyListOffsets, 1, 7, listOffsets)
+	addApiVersions(ApiKeyMetadata, 0, 12, metadata)
+	addApiVersions(ApiKeyOffsetForLeaderEpoch, 0, 4, offsetForLeaderEpoch)
+	addApiVersions(ApiKeyJoinGroup, 0, 9, joinGroup)
+	addApiVersions(ApiKeySyncGroup, 0, 5, syncGroup)
+	addApiVersions(ApiKeyHeartbeat, 0, 4, heartbeat)
+	addApiVersions(ApiKeyLeaveGroup, 0, 5, leaveGroup)
+	addApiVersions(ApiKeyConsumerGroupHeartbeat, 0, 0, consumerGroupHeartbeat)
+	addApiVersions(ApiKeyCreateTopics, 0, 7, createTopics)
+	addApiVersions(ApiKeyDeleteTopics, 0, 6, deleteTopics)
+	addApiVersions(ApiKeyDeleteRecords, 0, 2, deleteRecords)
+	addApiVersions(ApiKeyCreatePartitions, 0, 3, createPartitions)
+	addApiVersions(ApiKeyDescribeConfigs, 0, 4, describeConfigs)
+	addApiVersions(ApiKeyAlterConfigs, 0, 2, alterConfigs)
+	addApiVersions(ApiKeyIncrementalAlterConfigs, 0, 1, incrementalAlterConfigs)
+	addApiVersions(ApiKeyFindCoordinator, 0, 4, findCoordinator)
+	addApiVersions(ApiKeyInitProducerId, 0, 4, initProducerId)
+	addApiVersions(ApiKeyAddPartitionsToTxn, 0, 3, addPartitionsToTxn)
+	addApiVersions(ApiKeyAddOffsetsToTxn, 0, 3, addOffsetsToTxn)
+	addApiVersions(ApiKeyEndTxn, 0, 3, endTxn)
+	addApiVersions(ApiKeyTxnOffsetCommit, 0, 3, txnOffsetCommit)
+	addApiVersions(ApiKeySaslHandshake, 1, 1, saslHandshake)
+	addApiVersions(ApiKeySaslAuthenticate, 0, 2, saslAuthenticate)
+	addApiVersions(ApiKeyDescribeUserScramCredentials, 0, 0, describeUserScramCredentials)
+	addApiVersions(ApiKeyAlterUserScramCredentials, 0, 0, alterUserScramCredentials)
+	addApiVersions(ApiKeyDescribeAcls, 0, 1, describeAcls) // kafka-go's flexible v2+ requests have a stray tag buffer
+	addApiVersions(ApiKeyCreateAcls, 0, 3, createAcls)
+	addApiVersions(ApiKeyDeleteAcls, 0, 3, deleteAcls)
+
 	apiVersions = map[kafkaApiKey]versionRange{}
 
 	for keyVer := range apiTable {
@@ -198,6 +262,11 @@ func initializeApis() {
 		key, _ := strconv.Atoi(parts[0])
 		ver, _ := strconv.Atoi(parts[1])
 
+		flexible, exists := apiFlexibleVersions[kafkaApiKey(key)]
+		if exists && ver >= flexible {
+			apiHasTags[keyVer] = true
+		}
+
 		vr, exists := apiVersions[kafkaApiKey(key)]
 		if !exists {
 			vr = versionRange{min: int16(ver), max: int16(ver)}
@@ -209,6 +278,15 @@ func initializeApis() {
 			if ver > int(vr.max) {
 				vr.max = int16(ver)
 			}
+			apiVersions[kafkaApiKey(key)] = vr
 		}
 	}
 }
+
+// registers one handler for a range of api versions; the handler uses
+// the request header's version to decode and encode its messages
+func addApiVersions(apiKey kafkaApiKey, minVersion, maxVersion int, handler dispatchHandler) {
+	for ver := minVersion; ver <= maxVersion; ver++ {
+		apiTable[makeApiKey(apiKey, ver)] = handler
+	}
+}
@@ -0,0 +1,441 @@
+package kafkamock
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type (
+	kafkaResourceType int8
+	kafkaConfigSource int8
+	kafkaConfigType   int8
+	kafkaConfigOp     int8
+
+	// a config the mock knows about, with its static default
+	kafkaConfigDef struct {
+		name          string
+		configType    kafkaConfigType
+		defaultValue  string
+		minimum       int64    // for numeric configs
+		validValues   []string // for string and list configs that have a fixed set of choices
+		synonym       string   // the broker config that a topic config falls back to
+		readOnly      bool
+		documentation string
+	}
+
+	// a config value as it is described to a client
+	kafkaConfigEntry struct {
+		def      *kafkaConfigDef
+		value    string
+		source   kafkaConfigSource
+		synonyms []kafkaConfigSynonym // the levels that define the config, by precedence
+	}
+
+	kafkaConfigSynonym struct {
+		name   string
+		value  string
+		source kafkaConfigSource
+	}
+
+	kafkaConfigChange struct {
+		name  string
+		op    kafkaConfigOp
+		value *string
+	}
+)
+
+const (
+	resourceTopic  kafkaResourceType = 2
+	resourceBroker kafkaResourceType = 4
+)
+
+const (
+	configSourceDynamicTopic         kafkaConfigSource = 1
+	configSourceDynamicBroker        kafkaConfigSource = 2
+	configSourceDynamicDefaultBroker kafkaConfigSource = 3
+	configSourceDefault              kafkaConfigSource = 5
+)
+
+const (
+	configTypeBoolean kafkaConfigType = 1
+	configTypeString  kafkaConfigType = 2
+	configTypeInt     kafkaConfigType = 3
+	configTypeLong    kafkaConfigType = 5
+	configTypeList    kafkaConfigType = 7
+)
+
+const (
+	configOpSet      kafkaConfigOp = 0
+	configOpDelete   kafkaConfigOp = 1
+	configOpAppend   kafkaConfigOp = 2
+	configOpSubtract kafkaConfigOp = 3
+)
+
+// the key of the cluster-wide broker defaults
+const kClusterDefaults = ""
+
+var topicConfigDefs = makeConfigDefs([]*kafkaConfigDef{
+	{name: "cleanup.policy", configType: configTypeList, defaultValue: "delete", validValues: []string{"compact", "delete"}, synonym: "log.cleanup.policy", documentation: "The retention policy to use on log segments."},
+	{name: "compression.type", configType: configTypeString, defaultValue: "producer", validValues: []string{"uncompressed", "zstd", "lz4", "snappy", "gzip", "producer"}, synonym: "compression.type", documentation: "The final compression type for a given topic."},
+	{name: "delete.retention.ms", configType: configTypeLong, defaultValue: "86400000", synonym: "log.cleaner.delete.retention.ms", documentation: "The amount of time to retain delete tombstone markers for log compacted topics."},
+	{name: "max.compaction.lag.ms", configType: configTypeLong, defaultValue: "9223372036854775807", minimum: 1, synonym: "log.cleaner.max.compaction.lag.ms", documentation: "The maximum time a message will remain ineligible for compaction in the log."},
+	{name: "max.message.bytes", configType: configTypeInt, defaultValue: "1048588", synonym: "message.max.bytes", documentation: "The largest record batch size allowed by Kafka."},
+	{name: "message.timestamp.type", configType: configTypeString, defaultValue: "CreateTime", validValues: []string{"CreateTime", "LogAppendTime"}, synonym: "log.message.timestamp.type", documentation: "Define whether the timestamp in the message is message create time or log append time."},
+	{name: "min.compaction.lag.ms", configType: configTypeLong, defaultValue: "0", synonym: "log.cleaner.min.compaction.lag.ms", documentation: "The minimum time a message will remain uncompacted in the log."},
+	{name: "min.insync.replicas", configType: configTypeInt, defaultValue: "1", minimum: 1, synonym: "min.insync.replicas", documentation: "The minimum number of replicas that must acknowledge a write for it to be considered successful."},
+	{name: "retention.bytes", configType: configTypeLong, defaultValue: "-1", minimum: -1, synonym: "log.retention.bytes", documentation: "The maximum size a partition can grow to before old log segments are discarded."},
+	{name: "retention.ms", configType: configTypeLong, defaultValue: "604800000", minimum: -1, synonym: "log.retention.ms", documentation: "The maximum time a log is retained before old log segments are discarded."},
+	{name: "segment.bytes", configType: configTypeInt, defaultValue: "1073741824", minimum: 14, synonym: "log.segment.bytes", documentation: "The segment file size for the log."},
+	{name: "segment.ms", configType: configTypeLong, defaultValue: "604800000", minimum: 1, synonym: "log.roll.ms", documentation: "The period of time after which Kafka will force the log to roll."},
+	{name: "unclean.leader.election.enable", configType: configTypeBoolean, defaultValue: "false", synonym: "unclean.leader.election.enable", documentation: "Whether to enable replicas not in the ISR set to be elected as leader."},
+})
+
+var brokerConfigDefs = makeConfigDefs([]*kafkaConfigDef{
+	{name: "auto.create.topics.enable", configType: configTypeBoolean, defaultValue: "false", readOnly: true, documentation: "Enable auto creation of topics on the server."},
+	{name: "broker.id", configType: configTypeInt, defaultValue: strconv.Itoa(kFirstBrokerId), readOnly: true, documentation: "The broker id for this server."},
+	{name: "compression.type", configType: configTypeString, defaultValue: "producer", validValues: []string{"uncompressed", "zstd", "lz4", "snappy", "gzip", "producer"}, documentation: "The final compression type for a given topic."},
+	{name: "default.replication.factor", configType: configTypeInt, defaultValue: strconv.Itoa(kDefaultReplicationFactor), readOnly: true, documentation: "The default replication factor for automatically created topics."},
+	{name: "log.cleaner.backoff.ms", configType: configTypeLong, defaultValue: "15000", documentation: "The amount of time to sleep when there are no logs to clean."},
+	{name: "log.cleaner.delete.retention.ms", configType: configTypeLong, defaultValue: "86400000", documentation: "The amount of time to retain delete tombstone markers for log compacted topics."},
+	{name: "log.cleaner.max.compaction.lag.ms", configType: configTypeLong, defaultValue: "9223372036854775807", minimum: 1, documentation: "The maximum time a message will remain ineligible for compaction in the log."},
+	{name: "log.cleaner.min.compaction.lag.ms", configType: configTypeLong, defaultValue: "0", documentation: "The minimum time a message will remain uncompacted in the log."},
+	{name: "log.cleanup.policy", configType: configTypeList, defaultValue: "delete", validValues: []string{"compact", "delete"}, documentation: "The default cleanup policy for segments beyond the retention window."},
+	{name: "log.message.timestamp.type", configType: configTypeString, defaultValue: "CreateTime", validValues: []string{"CreateTime", "LogAppendTime"}, documentation: "Define whether the timestamp in the message is message create time or log append time."},
+	{name: "log.retention.bytes", configType: configTypeLong, defaultValue: "-1", minimum: -1, documentation: "The maximum size of the log before deleting it."},
+	{name: "log.retention.ms", configType: configTypeLong, defaultValue: "604800000", minimum: -1, documentation: "The number of milliseconds to keep a log file before deleting it."},
+	{name: "log.roll.ms", configType: configTypeLong, defaultValue: "604800000", minimum: 1, documentation: "The maximum time before a new log segment is rolled out."},
+	{name: "log.segment.bytes", configType: configTypeInt, defaultValue: "1073741824", minimum: 14, documentation: "The maximum size of a single log file."},
+	{name: "message.max.bytes", configType: configTypeInt, defaultValue: "1048588", documentation: "The largest record batch size allowed by Kafka."},
+	{name: "min.insync.replicas", configType: configTypeInt, defaultValue: "1", minimum: 1, documentation: "The minimum number of replicas that must acknowledge a write for it to be considered successful."},
+	{name: "num.partitions", configType: configTypeInt, defaultValue: strconv.Itoa(kDefaultNumPartitions), minimum: 1, readOnly: true, documentation: "The default number of log partitions per topic."},
+	{name: "transaction.max.timeout.ms", configType: configTypeInt, defaultValue: "900000", minimum: 1, readOnly: true, documentation: "The maximum allowed timeout for transactions."},
+	{name: "unclean.leader.election.enable", configType: configTypeBoolean, defaultValue: "false", documentation: "Whether to enable replicas not in the ISR set to be elected as leader."},
+})
+
+func makeConfigDefs(defs []*kafkaConfigDef) map[string]*kafkaConfigDef {
+	m := make(map[string]*kafkaConfigDef, len(defs))
+	for _, def := range defs {
+		m[def.name] = def
+	}
+	return m
+}
+
+// returns the names of the defs, in order
+func sortedConfigNames(defs map[string]*kafkaConfigDef) []string {
+	names := make([]string, 0, len(defs))
+	for name := range defs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// returns the reason a value isn't valid for the config, or an empty string if it is valid
+func (def *kafkaConfigDef) validate(value string) string {
+	switch def.configType {
+	case configTypeBoolean:
+		if !strings.EqualFold(value, "true") && !strings.EqualFold(value, "false") {
+			return fmt.Sprintf("invalid value %s for configuration %s: expected a boolean", value, def.name)
+		}
+
+	case configTypeInt, configTypeLong:
+		bits := 64
+		if def.configType == configTypeInt {
+			bits = 32
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(value), 10, bits)
+		if err != nil {
+			return fmt.Sprintf("invalid value %s for configuration %s: not a number of type %d bits", value, def.name, bits)
+		}
+		if n < def.minimum {
+			return fmt.Sprintf("invalid value %s for configuration %s: value must be at least %d", value, def.name, def.minimum)
+		}
+
+	case configTypeString:
+		if def.validValues != nil && !slices.Contains(def.validValues, value) {
+			return fmt.Sprintf("invalid value %s for configuration %s: string must be one of: %s", value, def.name, strings.Join(def.validValues, ", "))
+		}
+
+	case configTypeList:
+		for _, item := range splitConfigList(value) {
+			if def.validValues != nil && !slices.Contains(def.validValues, item) {
+				return fmt.Sprintf("invalid value %s for configuration %s: items must be one of: %s", value, def.name, strings.Join(def.validValues, ", "))
+			}
+		}
+	}
+	return ""
+}
+
+func splitConfigList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// applies changes to the dynamic configs of a resource; with replace, every
+// config that isn't listed is removed, as AlterConfigs does
+func applyConfigChanges(defs map[string]*kafkaConfigDef, current map[string]string, inherited func(name string) string, changes []kafkaConfigChange, replace bool) (updated map[string]string, ec kafkaErrorCode, message string) {
+	updated = map[string]string{}
+	if !replace {
+		for name, value := range current {
+			updated[name] = value
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, change := range changes {
+		if seen[change.name] {
+			return nil, InvalidRequest, "configuration " + change.name + " is listed more than once"
+		}
+		seen[change.name] = true
+
+		def := defs[change.name]
+		if def == nil {
+			return nil, InvalidConfig, "unknown configuration " + change.name
+		}
+		if def.readOnly {
+			return nil, InvalidRequest, "configuration " + change.name + " can't be updated dynamically"
+		}
+
+		switch change.op {
+		case configOpSet:
+			if change.value == nil {
+				delete(updated, change.name)
+				continue
+			}
+			updated[change.name] = *change.value
+
+		case configOpDelete:
+			delete(updated, change.name)
+
+		case configOpAppend, configOpSubtract:
+			if def.configType != configTypeList {
+				return nil, InvalidConfig, "configuration " + change.name + " is not a list"
+			}
+			value, exists := updated[change.name]
+			if !exists {
+				value = inherited(change.name)
+			}
+			items := splitConfigList(value)
+			for _, item := range splitConfigList(stringOrEmpty(change.value)) {
+				if change.op == configOpAppend && !slices.Contains(items, item) {
+					items = append(items, item)
+				} else if change.op == configOpSubtract {
+					items = slices.DeleteFunc(items, func(s string) bool { return s == item })
+				}
+			}
+			updated[change.name] = strings.Join(items, ",")
+
+		default:
+			return nil, InvalidRequest, fmt.Sprintf("invalid config operation %d for %s", change.op, change.name)
+		}
+
+		if value, exists := updated[change.name]; exists {
+			if reason := def.validate(value); reason != "" {
+				return nil, InvalidConfig, reason
+			}
+		}
+	}
+	return
+}
+
+// validates the configs of a topic that is being created
+func validateTopicConfigs(configs map[string]string) (ec kafkaErrorCode, message string) {
+	for _, name := range sortedKeys(configs) {
+		def := topicConfigDefs[name]
+		if def == nil {
+			return InvalidConfig, "unknown configuration " + name
+		}
+		if reason := def.validate(configs[name]); reason != "" {
+			return InvalidConfig, reason
+		}
+	}
+	return
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// maps a broker resource name to the key of its config storage
+func (ds *kafkaDataStore) brokerConfigKey(resourceName string) (key string, ok bool) {
+	if resourceName == kClusterDefaults {
+		return resourceName, true
+	}
+	if id, err := strconv.ParseInt(resourceName, 10, 32); err == nil && ds.getBroker(int32(id)) != nil {
+		return strconv.Itoa(int(id)), true
+	}
+	return
+}
+
+// returns a copy of the dynamic configs of a broker, or of the cluster defaults
+func (ds *kafkaDataStore) brokerConfigs(key string) map[string]string {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	configs := map[string]string{}
+	for name, value := range ds.BrokerConfigs[key] {
+		configs[name] = value
+	}
+	return configs
+}
+
+func (ds *kafkaDataStore) setBrokerConfigs(key string, configs map[string]string) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	ds.BrokerConfigs[key] = configs
+}
+
+// describes the broker configs in effect; a broker falls back to the cluster
+// defaults, then to the static defaults
+func (ds *kafkaDataStore) describeBrokerConfigs(key string) []*kafkaConfigEntry {
+	defaults := ds.brokerConfigs(kClusterDefaults)
+	if key == kClusterDefaults {
+		// only the cluster defaults that are set are described
+		entries := []*kafkaConfigEntry{}
+		for _, name := range sortedKeys(defaults) {
+			synonym := kafkaConfigSynonym{name: name, value: defaults[name], source: configSourceDynamicDefaultBroker}
+			entries = append(entries, &kafkaConfigEntry{def: brokerConfigDefs[name], value: synonym.value, source: synonym.source, synonyms: []kafkaConfigSynonym{synonym}})
+		}
+		return entries
+	}
+
+	overrides := ds.brokerConfigs(key)
+	entries := make([]*kafkaConfigEntry, 0, len(brokerConfigDefs))
+	for _, name := range sortedConfigNames(brokerConfigDefs) {
+		entry := resolveConfig(brokerConfigDefs[name], name, overrides, configSourceDynamicBroker, name, defaults)
+		if name == "broker.id" {
+			// each broker of a cluster has its own id
+			entry.value = key
+			entry.synonyms[len(entry.synonyms)-1].value = key
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// gets the value of a broker config that is in effect on the controller
+func (ds *kafkaDataStore) brokerConfig(name string) string {
+	for _, entry := range ds.describeBrokerConfigs(strconv.Itoa(int(ds.controllerId()))) {
+		if entry.def.name == name {
+			return entry.value
+		}
+	}
+	return ""
+}
+
+// describes the topic configs in effect; a topic falls back to the controller's
+// synonym of the config, then to the cluster defaults, then to the static defaults
+func (ds *kafkaDataStore) describeTopicConfigs(kt *kafkaTopic) []*kafkaConfigEntry {
+	broker := ds.brokerConfigs(strconv.Itoa(int(ds.controllerId())))
+	defaults := ds.brokerConfigs(kClusterDefaults)
+
+	kt.mu.Lock()
+	overrides := map[string]string{}
+	for name, value := range kt.Configs {
+		overrides[name] = value
+	}
+	kt.mu.Unlock()
+
+	entries := make([]*kafkaConfigEntry, 0, len(topicConfigDefs))
+	for _, name := range sortedConfigNames(topicConfigDefs) {
+		def := topicConfigDefs[name]
+		entry := resolveConfig(def, def.synonym, broker, configSourceDynamicBroker, def.synonym, defaults)
+		if value, exists := overrides[name]; exists {
+			synonym := kafkaConfigSynonym{name: name, value: value, source: configSourceDynamicTopic}
+			entry.value = value
+			entry.source = configSourceDynamicTopic
+			entry.synonyms = append([]kafkaConfigSynonym{synonym}, entry.synonyms...)
+		}
+		entry.def = def
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// resolves a broker-level config through its dynamic value, the cluster default and the static default
+func resolveConfig(def *kafkaConfigDef, brokerName string, broker map[string]string, brokerSource kafkaConfigSource, defaultName string, defaults map[string]string) *kafkaConfigEntry {
+	entry := &kafkaConfigEntry{def: def, value: def.defaultValue, source: configSourceDefault}
+
+	if value, exists := broker[brokerName]; exists {
+		entry.synonyms = append(entry.synonyms, kafkaConfigSynonym{name: brokerName, value: value, source: brokerSource})
+	}
+	if value, exists := defaults[defaultName]; exists {
+		entry.synonyms = append(entry.synonyms, kafkaConfigSynonym{name: defaultName, value: value, source: configSourceDynamicDefaultBroker})
+	}
+	entry.synonyms = append(entry.synonyms, kafkaConfigSynonym{name: brokerName, value: def.defaultValue, source: configSourceDefault})
+
+	entry.value = entry.synonyms[0].value
+	entry.source = entry.synonyms[0].source
+	return entry
+}
+
+// gets the value of a topic config that is in effect
+func (ds *kafkaDataStore) topicConfig(kt *kafkaTopic, name string) string {
+	if entry := ds.topicConfigEntry(kt, name); entry != nil {
+		return entry.value
+	}
+	return ""
+}
+
+// describes a topic config that is in effect, or returns nil if the config is unknown
+func (ds *kafkaDataStore) topicConfigEntry(kt *kafkaTopic, name string) *kafkaConfigEntry {
+	for _, entry := range ds.describeTopicConfigs(kt) {
+		if entry.def.name == name {
+			return entry
+		}
+	}
+	return nil
+}
+
+// the value a topic config has when the topic doesn't override it
+func (ds *kafkaDataStore) inheritedTopicConfig(name string) string {
+	def := topicConfigDefs[name]
+	if def == nil {
+		return ""
+	}
+	broker := ds.brokerConfigs(strconv.Itoa(int(ds.controllerId())))
+	defaults := ds.brokerConfigs(kClusterDefaults)
+	return resolveConfig(def, def.synonym, broker, configSourceDynamicBroker, def.synonym, defaults).value
+}
+
+// changes the dynamic configs of a topic
+func (ds *kafkaDataStore) alterTopicConfigs(kt *kafkaTopic, changes []kafkaConfigChange, replace, validateOnly bool) (ec kafkaErrorCode, message string) {
+	kt.mu.Lock()
+	defer kt.mu.Unlock()
+
+	updated, ec, message := applyConfigChanges(topicConfigDefs, kt.Configs, ds.inheritedTopicConfig, changes, replace)
+	if ec == NoError && !validateOnly {
+		kt.Configs = updated
+	}
+	return
+}
+
+// changes the dynamic configs of a broker, or the cluster defaults
+func (ds *kafkaDataStore) alterBrokerConfigs(key string, changes []kafkaConfigChange, replace, validateOnly bool) (ec kafkaErrorCode, message string) {
+	inherited := func(name string) string {
+		if value, exists := ds.brokerConfigs(kClusterDefaults)[name]; exists && key != kClusterDefaults {
+			return value
+		}
+		return brokerConfigDefs[name].defaultValue
+	}
+
+	updated, ec, message := applyConfigChanges(brokerConfigDefs, ds.brokerConfigs(key), inherited, changes, replace)
+	if ec == NoError && !validateOnly {
+		ds.setBrokerConfigs(key, updated)
+	}
+	return
+}
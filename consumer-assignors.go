@@ -0,0 +1,150 @@
+package kafkamock
+
+import (
+	"slices"
+
+	"github.com/google/uuid"
+)
+
+type (
+	// computes the target assignment of each member; members are given in
+	// member id order and topics in name order
+	kafkaAssignor func(members []*kafkaAssignorMember, topics []*kafkaAssignorTopic) map[string]kafkaPartitionSet
+
+	kafkaAssignorMember struct {
+		memberId string
+		topics   []string          // subscribed topic names
+		current  kafkaPartitionSet // the prior target, so that an assignor can be sticky
+	}
+
+	kafkaAssignorTopic struct {
+		name       string
+		id         uuid.UUID
+		partitions []int32
+	}
+)
+
+const kDefaultAssignor = "uniform"
+
+// the server-side assignors, by the name a client requests
+var consumerAssignors = map[string]kafkaAssignor{
+	"uniform": uniformAssignor,
+	"range":   rangeAssignor,
+}
+
+// spreads the partitions evenly over the members, keeping as many of
+// each member's prior partitions as its share allows
+func uniformAssignor(members []*kafkaAssignorMember, topics []*kafkaAssignorTopic) map[string]kafkaPartitionSet {
+	targets := map[string]kafkaPartitionSet{}
+	for _, member := range members {
+		targets[member.memberId] = kafkaPartitionSet{}
+	}
+	if len(members) == 0 {
+		return targets
+	}
+
+	// the partitions that at least one member subscribes to
+	valid := kafkaPartitionSet{}
+	for _, kt := range topics {
+		if subscribers(members, kt.name) == nil {
+			continue
+		}
+		for _, p := range kt.partitions {
+			valid[kafkaTopicPartition{topicId: kt.id, partition: p}] = true
+		}
+	}
+	names := map[uuid.UUID]string{}
+	for _, kt := range topics {
+		names[kt.id] = kt.name
+	}
+
+	quota := len(valid) / len(members)
+	extra := len(valid) % len(members)
+
+	// keep prior partitions, up to the member's share
+	owned := kafkaPartitionSet{}
+	for _, member := range members {
+		ids, partitions := member.current.byTopic()
+		for _, id := range ids {
+			if !slices.Contains(member.topics, names[id]) {
+				continue
+			}
+			for _, p := range partitions[id] {
+				tp := kafkaTopicPartition{topicId: id, partition: p}
+				if !valid[tp] || owned[tp] {
+					continue
+				}
+				n := len(targets[member.memberId])
+				if n == quota && extra > 0 {
+					extra--
+				} else if n >= quota {
+					continue
+				}
+				targets[member.memberId][tp] = true
+				owned[tp] = true
+			}
+		}
+	}
+
+	// hand out the rest to the least loaded subscribers
+	for _, kt := range topics {
+		subs := subscribers(members, kt.name)
+		for _, p := range kt.partitions {
+			tp := kafkaTopicPartition{topicId: kt.id, partition: p}
+			if owned[tp] || len(subs) == 0 {
+				continue
+			}
+			least := subs[0]
+			for _, member := range subs[1:] {
+				if len(targets[member.memberId]) < len(targets[least.memberId]) {
+					least = member
+				}
+			}
+			targets[least.memberId][tp] = true
+			owned[tp] = true
+		}
+	}
+
+	return targets
+}
+
+// gives each subscriber of a topic a contiguous range of its partitions,
+// with the first members taking one extra when they don't divide evenly
+func rangeAssignor(members []*kafkaAssignorMember, topics []*kafkaAssignorTopic) map[string]kafkaPartitionSet {
+	targets := map[string]kafkaPartitionSet{}
+	for _, member := range members {
+		targets[member.memberId] = kafkaPartitionSet{}
+	}
+
+	for _, kt := range topics {
+		subs := subscribers(members, kt.name)
+		if len(subs) == 0 {
+			continue
+		}
+
+		per := len(kt.partitions) / len(subs)
+		extra := len(kt.partitions) % len(subs)
+		next := 0
+		for i, member := range subs {
+			n := per
+			if i < extra {
+				n++
+			}
+			for _, p := range kt.partitions[next : next+n] {
+				targets[member.memberId][kafkaTopicPartition{topicId: kt.id, partition: p}] = true
+			}
+			next += n
+		}
+	}
+
+	return targets
+}
+
+func subscribers(members []*kafkaAssignorMember, topic string) (subs []*kafkaAssignorMember) {
+	for _, member := range members {
+		if slices.Contains(member.topics, topic) {
+			subs = append(subs, member)
+		}
+	}
+	return
+}
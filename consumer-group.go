@@ -0,0 +1,563 @@
+package kafkamock
+
+import (
+	"reflect"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jimsnab/go-lane"
+)
+
+type (
+	// a consumer group of the KIP-848 protocol: the coordinator computes a
+	// target assignment with a server-side assignor, and each member converges
+	// on its target through its heartbeats
+	kafkaConsumerGroup struct {
+		mu                sync.Mutex
+		ds                *kafkaDataStore
+		id                string
+		groupEpoch        int32 // advanced whenever membership, subscriptions or topics change
+		assignmentEpoch   int32 // the group epoch of the current target assignment
+		sessionTimeout    time.Duration
+		heartbeatInterval time.Duration
+		topics            []*kafkaAssignorTopic // the subscribed topics as of the last heartbeat
+		members           map[string]*kafkaConsumerMember
+		instances         map[string]string // static members: group instance id to member id
+	}
+
+	kafkaConsumerMember struct {
+		memberId         string
+		instanceId       string
+		rackId           string
+		clientId         string
+		clientHost       string
+		rebalanceTimeout time.Duration
+		subscribedTopics []string
+		serverAssignor   string
+		memberEpoch      int32
+		previousEpoch    int32
+		target           kafkaPartitionSet // what the assignor wants the member to own
+		assigned         kafkaPartitionSet // what the member has been told it owns
+		revoking         kafkaPartitionSet // what the member must release before it can move on
+		sendAssignment   bool              // assigned changed since the last response
+		left             bool              // a static member that left, holding its assignment for its next instance
+		deadline         time.Time         // the member is expired if it doesn't heartbeat by then
+		revokeDeadline   time.Time         // the member is expired if it doesn't release partitions by then
+		session          *time.Timer       // checks the deadlines
+	}
+
+	kafkaTopicPartition struct {
+		topicId   uuid.UUID
+		partition int32
+	}
+
+	kafkaPartitionSet map[kafkaTopicPartition]bool
+
+	kafkaConsumerHeartbeat struct {
+		memberId         string
+		instanceId       string
+		rackId           string
+		clientId         string
+		clientHost       string
+		memberEpoch      int32
+		rebalanceTimeout time.Duration     // negative when unchanged
+		subscribedTopics []string          // nil when unchanged
+		serverAssignor   *string           // nil when unchanged
+		owned            kafkaPartitionSet // nil when unchanged
+	}
+
+	kafkaConsumerHeartbeatResult struct {
+		ec                kafkaErrorCode
+		message           string
+		memberId          string
+		memberEpoch       int32
+		heartbeatInterval time.Duration
+		assignment        kafkaPartitionSet // nil when the member already has it
+	}
+)
+
+const (
+	kConsumerSessionTimeout    = 45 * time.Second
+	kConsumerHeartbeatInterval = 5 * time.Second
+
+	// member epochs that have a special meaning in a heartbeat
+	kJoinEpoch        = 0
+	kLeaveEpoch       = -1
+	kStaticLeaveEpoch = -2
+)
+
+// finds a consumer group, creating it if necessary; the id can't be
+// in use by a classic group that has members
+func (ds *kafkaDataStore) getConsumerGroup(groupId string) (*kafkaConsumerGroup, kafkaErrorCode) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	if kg, exists := ds.Groups[groupId]; exists {
+		kg.mu.Lock()
+		classic := len(kg.members) > 0
+		kg.mu.Unlock()
+		if classic {
+			return nil, GroupIdNotFound
+		}
+	}
+
+	cg, exists := ds.ConsumerGroups[groupId]
+	if !exists {
+		cg = &kafkaConsumerGroup{
+			ds:                ds,
+			id:                groupId,
+			sessionTimeout:    kConsumerSessionTimeout,
+			heartbeatInterval: kConsumerHeartbeatInterval,
+			members:           map[string]*kafkaConsumerMember{},
+			instances:         map[string]string{},
+		}
+		ds.ConsumerGroups[groupId] = cg
+	}
+	return cg, NoError
+}
+
+// finds a consumer group without creating it
+func (ds *kafkaDataStore) findConsumerGroup(groupId string) *kafkaConsumerGroup {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	return ds.ConsumerGroups[groupId]
+}
+
+// forgets the members; the epochs carry on so that old member epochs stay stale
+func (cg *kafkaConsumerGroup) reset() {
+	cg.mu.Lock()
+	defer cg.mu.Unlock()
+
+	for _, member := range cg.members {
+		member.session.Stop()
+	}
+	if len(cg.members) > 0 {
+		cg.groupEpoch++
+	}
+	cg.members = map[string]*kafkaConsumerMember{}
+	cg.instances = map[string]string{}
+}
+
+// processes a member's heartbeat: joins, leaves, subscription changes and
+// acknowledged revocations all arrive this way, and the response moves the
+// member one step closer to its target assignment
+func (cg *kafkaConsumerGroup) heartbeat(l lane.Lane, req *kafkaConsumerHeartbeat) *kafkaConsumerHeartbeatResult {
+	cg.mu.Lock()
+	defer cg.mu.Unlock()
+
+	if req.serverAssignor != nil && *req.serverAssignor != "" && consumerAssignors[*req.serverAssignor] == nil {
+		return &kafkaConsumerHeartbeatResult{ec: UnsupportedAssignor, message: "assignor " + *req.serverAssignor + " is not supported"}
+	}
+
+	if req.memberEpoch == kLeaveEpoch || req.memberEpoch == kStaticLeaveEpoch {
+		return cg.leave(l, req)
+	}
+
+	var member *kafkaConsumerMember
+	joined := false
+	if req.memberEpoch == kJoinEpoch && (req.memberId == "" || cg.members[req.memberId] == nil) {
+		var result *kafkaConsumerHeartbeatResult
+		if member, joined, result = cg.join(l, req); result != nil {
+			return result
+		}
+	} else {
+		member = cg.members[req.memberId]
+		if member == nil || member.left {
+			return &kafkaConsumerHeartbeatResult{ec: UnknownMemberId, message: "member " + req.memberId + " is not in the group"}
+		}
+		if req.instanceId != "" && member.instanceId != req.instanceId {
+			return &kafkaConsumerHeartbeatResult{ec: FencedInstanceId}
+		}
+		if ec := member.validateEpoch(req.memberEpoch, req.owned); ec != NoError {
+			return &kafkaConsumerHeartbeatResult{ec: ec, message: "member epoch is not current"}
+		}
+	}
+
+	// a change to the membership, the subscriptions or the subscribed topics
+	// advances the group epoch, which calls for a new target assignment
+	member.deadline = time.Now().Add(cg.sessionTimeout)
+	subscriptionChanged := member.update(req)
+	topicsChanged := cg.refreshTopics()
+	if joined {
+		cg.bumpEpoch(l, "member "+member.memberId+" joined")
+	} else if subscriptionChanged {
+		cg.bumpEpoch(l, "member "+member.memberId+" changed its subscription")
+	} else if topicsChanged {
+		cg.bumpEpoch(l, "subscribed topics changed")
+	}
+	cg.updateTarget(l)
+
+	// the member acknowledges a revocation by no longer listing the partitions
+	if req.owned != nil && len(member.revoking) > 0 && !req.owned.intersects(member.revoking) {
+		member.revoking = nil
+	}
+	cg.reconcile(member)
+
+	result := &kafkaConsumerHeartbeatResult{
+		memberId:          member.memberId,
+		memberEpoch:       member.memberEpoch,
+		heartbeatInterval: cg.heartbeatInterval,
+	}
+	if member.sendAssignment || req.memberEpoch == kJoinEpoch {
+		result.assignment = member.assigned.clone()
+		member.sendAssignment = false
+	}
+	return result
+}
+
+// adds a member; a static member whose previous instance left takes over its assignment
+func (cg *kafkaConsumerGroup) join(l lane.Lane, req *kafkaConsumerHeartbeat) (member *kafkaConsumerMember, isNew bool, result *kafkaConsumerHeartbeatResult) {
+	if req.subscribedTopics == nil {
+		result = &kafkaConsumerHeartbeatResult{ec: InvalidRequest, message: "subscribed topic names must be set when joining"}
+		return
+	}
+	if req.rebalanceTimeout < 0 {
+		result = &kafkaConsumerHeartbeatResult{ec: InvalidRequest, message: "rebalance timeout must be set when joining"}
+		return
+	}
+
+	memberId := req.memberId
+	if memberId == "" {
+		memberId = uuid.NewString()
+	}
+
+	if req.instanceId != "" {
+		if oldId, exists := cg.instances[req.instanceId]; exists {
+			member = cg.members[oldId]
+			if !member.left {
+				result = &kafkaConsumerHeartbeatResult{ec: UnreleasedInstanceId, message: "instance " + req.instanceId + " is still in use"}
+				return
+			}
+
+			delete(cg.members, oldId)
+			member.memberId = memberId
+			member.left = false
+			member.sendAssignment = true
+			cg.members[memberId] = member
+			cg.instances[req.instanceId] = memberId
+			l.Tracef("consumer group %s static member %s replaced %s with %s", cg.id, req.instanceId, oldId, memberId)
+			return
+		}
+	}
+
+	member = &kafkaConsumerMember{
+		memberId:       memberId,
+		instanceId:     req.instanceId,
+		target:         kafkaPartitionSet{},
+		assigned:       kafkaPartitionSet{},
+		sendAssignment: true,
+	}
+	cg.members[memberId] = member
+	if req.instanceId != "" {
+		cg.instances[req.instanceId] = memberId
+	}
+	cg.startSession(l, member)
+	isNew = true
+	return
+}
+
+// removes a member; a static member that leaves temporarily keeps its
+// assignment until its session expires
+func (cg *kafkaConsumerGroup) leave(l lane.Lane, req *kafkaConsumerHeartbeat) *kafkaConsumerHeartbeatResult {
+	member := cg.members[req.memberId]
+	if member == nil || member.left {
+		return &kafkaConsumerHeartbeatResult{ec: UnknownMemberId, message: "member " + req.memberId + " is not in the group"}
+	}
+	if req.instanceId != "" && member.instanceId != req.instanceId {
+		return &kafkaConsumerHeartbeatResult{ec: FencedInstanceId}
+	}
+
+	if req.memberEpoch == kStaticLeaveEpoch && member.instanceId != "" {
+		member.left = true
+		l.Tracef("consumer group %s static member %s left temporarily", cg.id, member.instanceId)
+	} else {
+		cg.removeMember(l, member, "member "+member.memberId+" left")
+	}
+	return &kafkaConsumerHeartbeatResult{memberId: req.memberId, memberEpoch: req.memberEpoch}
+}
+
+// checks whether the member may commit offsets; the generation of a
+// commit is the member epoch
+func (cg *kafkaConsumerGroup) validateCommit(memberId string, memberEpoch int32) kafkaErrorCode {
+	cg.mu.Lock()
+	defer cg.mu.Unlock()
+
+	if memberEpoch < 0 && memberId == "" {
+		return NoError
+	}
+	member := cg.members[memberId]
+	if member == nil || member.left {
+		return UnknownMemberId
+	}
+	if memberEpoch != member.memberEpoch {
+		return StaleMemberEpoch
+	}
+	return NoError
+}
+
+func (cg *kafkaConsumerGroup) removeMember(l lane.Lane, member *kafkaConsumerMember, reason string) {
+	delete(cg.members, member.memberId)
+	if member.instanceId != "" && cg.instances[member.instanceId] == member.memberId {
+		delete(cg.instances, member.instanceId)
+	}
+	member.session.Stop()
+	cg.bumpEpoch(l, reason)
+}
+
+func (cg *kafkaConsumerGroup) bumpEpoch(l lane.Lane, reason string) {
+	cg.groupEpoch++
+	l.Tracef("consumer group %s epoch %d: %s", cg.id, cg.groupEpoch, reason)
+}
+
+// expires the member when it stops heartbeating, or when it holds on to
+// partitions it was asked to revoke for longer than its rebalance timeout
+func (cg *kafkaConsumerGroup) startSession(l lane.Lane, member *kafkaConsumerMember) {
+	member.deadline = time.Now().Add(cg.sessionTimeout)
+	member.session = time.AfterFunc(cg.sessionTimeout, func() {
+		cg.mu.Lock()
+		defer cg.mu.Unlock()
+
+		if cg.members[member.memberId] != member {
+			return
+		}
+
+		remaining := time.Until(member.deadline)
+		reason := "session expired"
+		if len(member.revoking) > 0 {
+			if revokeRemaining := time.Until(member.revokeDeadline); revokeRemaining < remaining {
+				remaining = revokeRemaining
+				reason = "didn't revoke its partitions in time"
+			}
+		}
+		if remaining > 0 {
+			member.session.Reset(remaining)
+			return
+		}
+
+		cg.removeMember(l, member, "member "+member.memberId+" "+reason)
+	})
+}
+
+// takes the fields of the heartbeat that are set, returning true when
+// the change requires a new target assignment
+func (member *kafkaConsumerMember) update(req *kafkaConsumerHeartbeat) (changed bool) {
+	member.clientId = req.clientId
+	member.clientHost = req.clientHost
+	if req.rackId != "" {
+		member.rackId = req.rackId
+	}
+	if req.rebalanceTimeout >= 0 {
+		member.rebalanceTimeout = req.rebalanceTimeout
+	}
+
+	if req.subscribedTopics != nil {
+		topics := slices.Clone(req.subscribedTopics)
+		sort.Strings(topics)
+		topics = slices.Compact(topics)
+		if !slices.Equal(topics, member.subscribedTopics) {
+			member.subscribedTopics = topics
+			changed = true
+		}
+	}
+	if req.serverAssignor != nil && *req.serverAssignor != member.serverAssignor {
+		member.serverAssignor = *req.serverAssignor
+		changed = true
+	}
+	return
+}
+
+// the member's epoch must be current; a member that missed the response
+// moving it to its current epoch may still use the previous one
+func (member *kafkaConsumerMember) validateEpoch(memberEpoch int32, owned kafkaPartitionSet) kafkaErrorCode {
+	if memberEpoch == member.memberEpoch {
+		return NoError
+	}
+	if memberEpoch < member.memberEpoch && memberEpoch == member.previousEpoch && owned != nil && owned.subsetOf(member.assigned) {
+		return NoError
+	}
+	return FencedMemberEpoch
+}
+
+// takes a snapshot of the topics the members subscribe to, returning
+// true when it differs from the prior snapshot
+func (cg *kafkaConsumerGroup) refreshTopics() bool {
+	names := []string{}
+	for _, member := range cg.members {
+		names = append(names, member.subscribedTopics...)
+	}
+	sort.Strings(names)
+	names = slices.Compact(names)
+
+	topics := []*kafkaAssignorTopic{}
+	for _, name := range names {
+		kt := cg.ds.getTopic(name)
+		if kt == nil {
+			continue
+		}
+		at := &kafkaAssignorTopic{name: name, id: kt.Id, partitions: []int32{}}
+		for _, kp := range kt.sortedPartitions() {
+			at.partitions = append(at.partitions, kp.Index)
+		}
+		topics = append(topics, at)
+	}
+
+	if reflect.DeepEqual(topics, cg.topics) {
+		return false
+	}
+	cg.topics = topics
+	return true
+}
+
+// computes a new target assignment when the group epoch has moved past it
+func (cg *kafkaConsumerGroup) updateTarget(l lane.Lane) {
+	if cg.assignmentEpoch == cg.groupEpoch {
+		return
+	}
+
+	name := cg.selectAssignor()
+	members := make([]*kafkaAssignorMember, 0, len(cg.members))
+	for _, id := range cg.sortedMemberIds() {
+		member := cg.members[id]
+		members = append(members, &kafkaAssignorMember{memberId: id, topics: member.subscribedTopics, current: member.target})
+	}
+
+	targets := consumerAssignors[name](members, cg.topics)
+	for id, member := range cg.members {
+		member.target = targets[id]
+	}
+	cg.assignmentEpoch = cg.groupEpoch
+	l.Tracef("consumer group %s target assignment epoch %d by %s assignor", cg.id, cg.assignmentEpoch, name)
+}
+
+// moves the member toward its target: partitions it must give up are revoked
+// first, then it advances to the assignment epoch and receives the target
+// partitions that other members have released
+func (cg *kafkaConsumerGroup) reconcile(member *kafkaConsumerMember) {
+	if len(member.revoking) > 0 {
+		return
+	}
+
+	if revoke := member.assigned.minus(member.target); len(revoke) > 0 {
+		member.revoking = revoke
+		member.revokeDeadline = time.Now().Add(member.rebalanceTimeout)
+		member.assigned = member.assigned.minus(revoke)
+		member.sendAssignment = true
+		member.session.Reset(member.rebalanceTimeout)
+		return
+	}
+
+	if member.memberEpoch != cg.assignmentEpoch {
+		member.previousEpoch = member.memberEpoch
+		member.memberEpoch = cg.assignmentEpoch
+		member.sendAssignment = true
+	}
+
+	next := kafkaPartitionSet{}
+	for tp := range member.target {
+		if owner := cg.owner(tp); owner == nil || owner == member {
+			next[tp] = true
+		}
+	}
+	if !next.equal(member.assigned) {
+		member.assigned = next
+		member.sendAssignment = true
+	}
+}
+
+// finds the member that owns or is still revoking a partition
+func (cg *kafkaConsumerGroup) owner(tp kafkaTopicPartition) *kafkaConsumerMember {
+	for _, member := range cg.members {
+		if member.assigned[tp] || member.revoking[tp] {
+			return member
+		}
+	}
+	return nil
+}
+
+// picks the assignor preferred by the most members
+func (cg *kafkaConsumerGroup) selectAssignor() string {
+	votes := map[string]int{}
+	for _, member := range cg.members {
+		if member.serverAssignor != "" {
+			votes[member.serverAssignor]++
+		}
+	}
+
+	names := make([]string, 0, len(votes))
+	for name := range votes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	selected := kDefaultAssignor
+	for _, name := range names {
+		if votes[name] > votes[selected] {
+			selected = name
+		}
+	}
+	return selected
+}
+
+func (cg *kafkaConsumerGroup) sortedMemberIds() []string {
+	ids := make([]string, 0, len(cg.members))
+	for id := range cg.members {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (ps kafkaPartitionSet) clone() kafkaPartitionSet {
+	c := make(kafkaPartitionSet, len(ps))
+	for tp := range ps {
+		c[tp] = true
+	}
+	return c
+}
+
+func (ps kafkaPartitionSet) minus(other kafkaPartitionSet) kafkaPartitionSet {
+	result := kafkaPartitionSet{}
+	for tp := range ps {
+		if !other[tp] {
+			result[tp] = true
+		}
+	}
+	return result
+}
+
+func (ps kafkaPartitionSet) intersects(other kafkaPartitionSet) bool {
+	for tp := range ps {
+		if other[tp] {
+			return true
+		}
+	}
+	return false
+}
+
+func (ps kafkaPartitionSet) subsetOf(other kafkaPartitionSet) bool {
+	return len(ps.minus(other)) == 0
+}
+
+func (ps kafkaPartitionSet) equal(other kafkaPartitionSet) bool {
+	return len(ps) == len(other) && ps.subsetOf(other)
+}
+
+// returns the partitions grouped by topic, in topic id and partition order
+func (ps kafkaPartitionSet) byTopic() (ids []uuid.UUID, partitions map[uuid.UUID][]int32) {
+	partitions = map[uuid.UUID][]int32{}
+	for tp := range ps {
+		if _, exists := partitions[tp.topicId]; !exists {
+			ids = append(ids, tp.topicId)
+		}
+		partitions[tp.topicId] = append(partitions[tp.topicId], tp.partition)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+	for _, id := range ids {
+		slices.Sort(partitions[id])
+	}
+	return
+}
@@ -0,0 +1,259 @@
+package kafkamock
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jimsnab/go-lane"
+)
+
+func testConsumerJoin(topics ...string) *kafkaConsumerHeartbeat {
+	return &kafkaConsumerHeartbeat{
+		clientId:         "test",
+		memberEpoch:      kJoinEpoch,
+		rebalanceTimeout: time.Second * 10,
+		subscribedTopics: topics,
+	}
+}
+
+func testConsumerHeartbeat(result *kafkaConsumerHeartbeatResult, owned kafkaPartitionSet) *kafkaConsumerHeartbeat {
+	return &kafkaConsumerHeartbeat{
+		memberId:         result.memberId,
+		clientId:         "test",
+		memberEpoch:      result.memberEpoch,
+		rebalanceTimeout: -1,
+		owned:            owned,
+	}
+}
+
+func testPartitionSet(topicId uuid.UUID, partitions ...int32) kafkaPartitionSet {
+	ps := kafkaPartitionSet{}
+	for _, p := range partitions {
+		ps[kafkaTopicPartition{topicId: topicId, partition: p}] = true
+	}
+	return ps
+}
+
+func TestConsumerGroupReconciliation(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	ds := testGroupDataStore(4)
+	topicId := ds.getTopic("topic-a").Id
+	cg, ec := ds.getConsumerGroup("group-a")
+	if ec != NoError {
+		t.Fatalf("unexpected error %d", ec)
+	}
+
+	// the first member owns everything
+	r1 := cg.heartbeat(tl, testConsumerJoin("topic-a"))
+	if r1.ec != NoError || r1.memberEpoch != 1 || !r1.assignment.equal(testPartitionSet(topicId, 0, 1, 2, 3)) {
+		t.Fatalf("unexpected first join %+v", r1)
+	}
+
+	// the second member moves to the new epoch, but its partitions are still owned
+	r2 := cg.heartbeat(tl, testConsumerJoin("topic-a"))
+	if r2.ec != NoError || r2.memberEpoch != 2 || len(r2.assignment) != 0 {
+		t.Fatalf("unexpected second join %+v", r2)
+	}
+
+	// the first member is asked to revoke half, and stays in its epoch
+	r1 = cg.heartbeat(tl, testConsumerHeartbeat(r1, nil))
+	if r1.ec != NoError || r1.memberEpoch != 1 || len(r1.assignment) != 2 {
+		t.Fatalf("expected a revocation %+v", r1)
+	}
+	kept := r1.assignment
+
+	// until it acknowledges the revocation, nothing changes
+	r1 = cg.heartbeat(tl, testConsumerHeartbeat(r1, nil))
+	if r1.memberEpoch != 1 || r1.assignment != nil {
+		t.Errorf("unexpected heartbeat %+v", r1)
+	}
+
+	r1 = cg.heartbeat(tl, testConsumerHeartbeat(r1, kept))
+	if r1.ec != NoError || r1.memberEpoch != 2 || !r1.assignment.equal(kept) {
+		t.Fatalf("expected the new epoch %+v", r1)
+	}
+
+	// the released partitions go to the second member
+	r2 = cg.heartbeat(tl, testConsumerHeartbeat(r2, kafkaPartitionSet{}))
+	if r2.ec != NoError || r2.memberEpoch != 2 || !r2.assignment.equal(testPartitionSet(topicId, 0, 1, 2, 3).minus(kept)) {
+		t.Fatalf("expected the released partitions %+v", r2)
+	}
+
+	// a stale epoch is fenced, unless the member missed its epoch change
+	stale := testConsumerHeartbeat(r2, kafkaPartitionSet{})
+	stale.memberEpoch = 1
+	if r := cg.heartbeat(tl, stale); r.ec != FencedMemberEpoch {
+		t.Errorf("expected fenced member epoch, got %d", r.ec)
+	}
+	stale.memberEpoch = 3
+	if r := cg.heartbeat(tl, stale); r.ec != FencedMemberEpoch {
+		t.Errorf("expected fenced member epoch, got %d", r.ec)
+	}
+	if ec := cg.validateCommit(r2.memberId, 1); ec != StaleMemberEpoch {
+		t.Errorf("expected stale member epoch, got %d", ec)
+	}
+	if ec := cg.validateCommit(r2.memberId, 2); ec != NoError {
+		t.Errorf("commit failed: %d", ec)
+	}
+
+	// when the second member leaves, the first gets everything back
+	leave := testConsumerHeartbeat(r2, nil)
+	leave.memberEpoch = kLeaveEpoch
+	if r := cg.heartbeat(tl, leave); r.ec != NoError || r.memberEpoch != kLeaveEpoch {
+		t.Errorf("unexpected leave %+v", r)
+	}
+	r1 = cg.heartbeat(tl, testConsumerHeartbeat(r1, kept))
+	if r1.memberEpoch != 3 || !r1.assignment.equal(testPartitionSet(topicId, 0, 1, 2, 3)) {
+		t.Errorf("expected all partitions %+v", r1)
+	}
+	if r := cg.heartbeat(tl, testConsumerHeartbeat(r2, nil)); r.ec != UnknownMemberId {
+		t.Errorf("expected unknown member, got %d", r.ec)
+	}
+}
+
+func TestConsumerGroupNewPartitions(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	ds := testGroupDataStore(1)
+	kt := ds.getTopic("topic-a")
+	cg, _ := ds.getConsumerGroup("group-a")
+
+	r := cg.heartbeat(tl, testConsumerJoin("topic-a", "topic-b"))
+	if r.memberEpoch != 1 || !r.assignment.equal(testPartitionSet(kt.Id, 0)) {
+		t.Fatalf("unexpected join %+v", r)
+	}
+
+	// topics created after the subscription are picked up
+	kt.createPartition(1)
+	kb := ds.createTopic("topic-b")
+	kb.createPartition(0)
+
+	r = cg.heartbeat(tl, testConsumerHeartbeat(r, nil))
+	expected := testPartitionSet(kt.Id, 0, 1)
+	expected[kafkaTopicPartition{topicId: kb.Id, partition: 0}] = true
+	if r.memberEpoch != 2 || !r.assignment.equal(expected) {
+		t.Errorf("expected new partitions %+v", r)
+	}
+}
+
+func TestConsumerGroupStaticMember(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	ds := testGroupDataStore(2)
+	cg, _ := ds.getConsumerGroup("group-a")
+
+	join := testConsumerJoin("topic-a")
+	join.instanceId = "instance-1"
+	r := cg.heartbeat(tl, join)
+	if r.ec != NoError || len(r.assignment) != 2 {
+		t.Fatalf("unexpected join %+v", r)
+	}
+
+	if dup := cg.heartbeat(tl, join); dup.ec != UnreleasedInstanceId {
+		t.Errorf("expected unreleased instance id, got %d", dup.ec)
+	}
+
+	leave := testConsumerHeartbeat(r, nil)
+	leave.instanceId = "instance-1"
+	leave.memberEpoch = kStaticLeaveEpoch
+	if lr := cg.heartbeat(tl, leave); lr.ec != NoError || lr.memberEpoch != kStaticLeaveEpoch {
+		t.Fatalf("unexpected static leave %+v", lr)
+	}
+
+	// the next instance takes over without a new epoch
+	rejoin := cg.heartbeat(tl, join)
+	if rejoin.ec != NoError || rejoin.memberId == r.memberId || rejoin.memberEpoch != r.memberEpoch || !rejoin.assignment.equal(r.assignment) {
+		t.Errorf("unexpected static rejoin %+v", rejoin)
+	}
+	if cg.groupEpoch != 1 {
+		t.Errorf("static rejoin changed the group epoch to %d", cg.groupEpoch)
+	}
+}
+
+func TestConsumerAssignors(t *testing.T) {
+	a := &kafkaAssignorTopic{name: "topic-a", id: uuid.New(), partitions: []int32{0, 1, 2, 3, 4}}
+	b := &kafkaAssignorTopic{name: "topic-b", id: uuid.New(), partitions: []int32{0, 1}}
+	members := []*kafkaAssignorMember{
+		{memberId: "m1", topics: []string{"topic-a", "topic-b"}},
+		{memberId: "m2", topics: []string{"topic-a", "topic-b"}},
+	}
+
+	targets := rangeAssignor(members, []*kafkaAssignorTopic{a, b})
+	expected := testPartitionSet(a.id, 0, 1, 2)
+	expected[kafkaTopicPartition{topicId: b.id, partition: 0}] = true
+	if !targets["m1"].equal(expected) {
+		t.Errorf("unexpected range assignment %v", targets["m1"])
+	}
+
+	// the uniform assignor balances, and keeps prior partitions where it can
+	members[0].current = testPartitionSet(a.id, 0, 1, 2, 3, 4)
+	targets = uniformAssignor(members, []*kafkaAssignorTopic{a, b})
+	if len(targets["m1"]) != 4 || len(targets["m2"]) != 3 {
+		t.Errorf("unbalanced uniform assignment %v", targets)
+	}
+	if !targets["m1"].subsetOf(members[0].current) {
+		t.Errorf("uniform assignment isn't sticky %v", targets["m1"])
+	}
+}
+
+func TestConsumerGroupHeartbeatHandler(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	ds := testGroupDataStore(2)
+	kc := &kafkaClient{l: tl, ds: ds}
+
+	call := func(request *consumerGroupHeartbeatRequest) *consumerGroupHeartbeatResponse {
+		kmh := &kafkaMessageHeader{RequestApiKey: ApiKeyConsumerGroupHeartbeat, RequestApiVersion: 0}
+		var buf bytes.Buffer
+		w := bufio.NewWriter(&buf)
+		encodeVersionedObject(w, request, kmh.codecVersion())
+		w.Flush()
+
+		response, _, err := consumerGroupHeartbeat(bufio.NewReader(&buf), kc, kmh)
+		if err != nil {
+			t.Fatalf("consumer group heartbeat handler error: %v", err)
+		}
+
+		// the response must be codable, including a null assignment
+		buf.Reset()
+		encodeVersionedObject(w, response, kmh.codecVersion())
+		w.Flush()
+		_, v := peekVersionedObject(bufio.NewReader(&buf), 0, reflect.TypeOf(consumerGroupHeartbeatResponse{}), kmh.codecVersion())
+		decoded := v.(consumerGroupHeartbeatResponse)
+		return &decoded
+	}
+
+	assignor := "range"
+	r := call(&consumerGroupHeartbeatRequest{
+		GroupId:              "group-a",
+		RebalanceTimeoutMs:   1000,
+		SubscribedTopicNames: []string{"topic-a"},
+		ServerAssignor:       &assignor,
+	})
+	if r.ErrorCode != 0 || r.MemberId == nil || r.MemberEpoch != 1 || r.HeartbeatIntervalMs != 5000 {
+		t.Fatalf("unexpected join response %+v", r)
+	}
+	if r.Assignment == nil || len(r.Assignment.TopicPartitions) != 1 || len(r.Assignment.TopicPartitions[0].Partitions) != 2 {
+		t.Fatalf("unexpected assignment %+v", r.Assignment)
+	}
+
+	r = call(&consumerGroupHeartbeatRequest{GroupId: "group-a", MemberId: *r.MemberId, MemberEpoch: 1, RebalanceTimeoutMs: -1})
+	if r.ErrorCode != 0 || r.Assignment != nil {
+		t.Errorf("unexpected heartbeat response %+v", r)
+	}
+
+	unsupported := "sticky"
+	r = call(&consumerGroupHeartbeatRequest{GroupId: "group-a", SubscribedTopicNames: []string{"topic-a"}, ServerAssignor: &unsupported})
+	if r.ErrorCode != int16(UnsupportedAssignor) || r.ErrorMessage == nil {
+		t.Errorf("expected unsupported assignor %+v", r)
+	}
+
+	// a classic group's id can't be used
+	ds.getGroup("classic").join(tl, testJoinRequest("", "topic-a"))
+	r = call(&consumerGroupHeartbeatRequest{GroupId: "classic", SubscribedTopicNames: []string{"topic-a"}})
+	if r.ErrorCode != int16(GroupIdNotFound) {
+		t.Errorf("expected group id not found %+v", r)
+	}
+}
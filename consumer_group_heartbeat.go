@@ -0,0 +1,115 @@
+package kafkamock
+
+import (
+	"bufio"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type (
+	consumerGroupHeartbeatRequest struct {
+		GroupId              string
+		MemberId             string
+		MemberEpoch          int32
+		InstanceId           NullableString
+		RackId               NullableString
+		RebalanceTimeoutMs   int32
+		SubscribedTopicNames []string
+		ServerAssignor       NullableString
+		TopicPartitions      []consumerGroupHeartbeatTopicPartitions
+	}
+
+	consumerGroupHeartbeatTopicPartitions struct {
+		TopicId    uuid.UUID
+		Partitions []int32
+	}
+
+	consumerGroupHeartbeatResponse struct {
+		ThrottleTimeMs      int32
+		ErrorCode           int16
+		ErrorMessage        NullableString
+		MemberId            NullableString
+		MemberEpoch         int32
+		HeartbeatIntervalMs int32
+		Assignment          *consumerGroupHeartbeatAssignment `kafka:"nullable"`
+	}
+
+	consumerGroupHeartbeatAssignment struct {
+		TopicPartitions []consumerGroupHeartbeatTopicPartitions
+	}
+)
+
+func consumerGroupHeartbeat(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeader) (response any, rtags map[int]any, err error) {
+	request, err := readVersionedRequest[consumerGroupHeartbeatRequest](reader, kmh)
+	if err != nil {
+		return
+	}
+
+	// the member must be allowed to read the group and to describe what it subscribes to
+	if ec := kc.authorizationError(aclOpRead, aclResourceGroup, request.GroupId); ec != NoError {
+		message := "group " + request.GroupId + " authorization failed"
+		response = &consumerGroupHeartbeatResponse{ErrorCode: int16(ec), ErrorMessage: &message}
+		return
+	}
+	for _, topic := range request.SubscribedTopicNames {
+		if ec := kc.authorizationError(aclOpDescribe, aclResourceTopic, topic); ec != NoError {
+			message := "topic " + topic + " authorization failed"
+			response = &consumerGroupHeartbeatResponse{ErrorCode: int16(ec), ErrorMessage: &message}
+			return
+		}
+	}
+
+	cg, ec := kc.ds.getConsumerGroup(request.GroupId)
+	if ec != NoError {
+		message := "group " + request.GroupId + " is a classic group"
+		response = &consumerGroupHeartbeatResponse{ErrorCode: int16(ec), ErrorMessage: &message}
+		return
+	}
+
+	hb := &kafkaConsumerHeartbeat{
+		memberId:         request.MemberId,
+		instanceId:       stringOrEmpty(request.InstanceId),
+		rackId:           stringOrEmpty(request.RackId),
+		clientId:         kmh.Client,
+		memberEpoch:      request.MemberEpoch,
+		rebalanceTimeout: time.Duration(request.RebalanceTimeoutMs) * time.Millisecond,
+		subscribedTopics: request.SubscribedTopicNames,
+		serverAssignor:   request.ServerAssignor,
+	}
+	if kc.conn != nil {
+		hb.clientHost = kc.String()
+	}
+	if request.TopicPartitions != nil {
+		hb.owned = kafkaPartitionSet{}
+		for _, tps := range request.TopicPartitions {
+			for _, p := range tps.Partitions {
+				hb.owned[kafkaTopicPartition{topicId: tps.TopicId, partition: p}] = true
+			}
+		}
+	}
+
+	result := cg.heartbeat(kc.l, hb)
+
+	cghr := &consumerGroupHeartbeatResponse{
+		ErrorCode:           int16(result.ec),
+		MemberEpoch:         result.memberEpoch,
+		HeartbeatIntervalMs: int32(result.heartbeatInterval / time.Millisecond),
+	}
+	if result.message != "" {
+		cghr.ErrorMessage = &result.message
+	}
+	if result.memberId != "" {
+		cghr.MemberId = &result.memberId
+	}
+	if result.assignment != nil {
+		cghr.Assignment = &consumerGroupHeartbeatAssignment{TopicPartitions: []consumerGroupHeartbeatTopicPartitions{}}
+		ids, partitions := result.assignment.byTopic()
+		for _, id := range ids {
+			cghr.Assignment.TopicPartitions = append(cghr.Assignment.TopicPartitions, consumerGroupHeartbeatTopicPartitions{TopicId: id, Partitions: partitions[id]})
+		}
+	}
+
+	response = cghr
+	return
+}
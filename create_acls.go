@@ -0,0 +1,79 @@
+package kafkamock
+
+import (
+	"bufio"
+)
+
+type (
+	createAclsRequest struct {
+		Creations []createAclsCreation
+	}
+
+	createAclsCreation struct {
+		ResourceType        int8
+		ResourceName        string
+		ResourcePatternType int8 `kafka:"min=1"`
+		Principal           string
+		Host                string
+		Operation           int8
+		PermissionType      int8
+	}
+
+	createAclsResponse struct {
+		ThrottleTimeMs int32
+		Results        []createAclsResult
+	}
+
+	createAclsResult struct {
+		ErrorCode    int16
+		ErrorMessage NullableString
+	}
+)
+
+func createAcls(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeader) (response any, rtags map[int]any, err error) {
+	request, err := readVersionedRequest[createAclsRequest](reader, kmh)
+	if err != nil {
+		return
+	}
+
+	// the acls of every creation are changed by a cluster admin
+	var ec kafkaErrorCode
+	var message string
+	if !kc.ds.Acls.isEnabled() {
+		ec, message = SecurityDisabled, "no authorizer is configured on the broker"
+	} else if !kc.authorized(aclOpAlter, aclResourceCluster, kClusterResourceName) {
+		ec, message = ClusterAuthorizationFailed, "cluster authorization failed"
+	}
+
+	car := &createAclsResponse{Results: make([]createAclsResult, 0, len(request.Creations))}
+	for _, creation := range request.Creations {
+		acl := kafkaAcl{
+			resourceType: kafkaAclResourceType(creation.ResourceType),
+			resourceName: creation.ResourceName,
+			patternType:  kafkaAclPatternType(creation.ResourcePatternType),
+			principal:    creation.Principal,
+			host:         creation.Host,
+			operation:    kafkaAclOperation(creation.Operation),
+			permission:   kafkaAclPermission(creation.PermissionType),
+		}
+		if kmh.RequestApiVersion < 1 {
+			acl.patternType = aclPatternLiteral
+		}
+
+		result := createAclsResult{ErrorCode: int16(ec)}
+		if ec != NoError {
+			result.ErrorMessage = &message
+		} else if reason := acl.validate(); reason != "" {
+			result.ErrorCode = int16(InvalidRequest)
+			result.ErrorMessage = &reason
+			kc.l.Tracef("create acl for %s failed: %s", creation.Principal, reason)
+		} else {
+			kc.ds.Acls.addAcl(acl)
+			kc.l.Tracef("acl created for %s on %s", creation.Principal, creation.ResourceName)
+		}
+		car.Results = append(car.Results, result)
+	}
+
+	response = car
+	return
+}
@@ -0,0 +1,111 @@
+package kafkamock
+
+import (
+	"bufio"
+	"fmt"
+)
+
+type (
+	createPartitionsRequest struct {
+		Topics       []createPartitionsTopic
+		TimeoutMs    int32
+		ValidateOnly bool
+	}
+
+	createPartitionsTopic struct {
+		Name        string
+		Count       int32
+		Assignments []createPartitionsAssignment
+	}
+
+	createPartitionsAssignment struct {
+		BrokerIds []int32
+	}
+
+	createPartitionsResponse struct {
+		ThrottleTimeMs int32
+		Results        []createPartitionsResult
+	}
+
+	createPartitionsResult struct {
+		Name         string
+		ErrorCode    int16
+		ErrorMessage NullableString
+	}
+)
+
+func createPartitions(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeader) (response any, rtags map[int]any, err error) {
+	request, err := readVersionedRequest[createPartitionsRequest](reader, kmh)
+	if err != nil {
+		return
+	}
+
+	counts := map[string]int{}
+	for _, topic := range request.Topics {
+		counts[topic.Name]++
+	}
+
+	cpr := &createPartitionsResponse{Results: make([]createPartitionsResult, 0, len(request.Topics))}
+	for _, topic := range request.Topics {
+		var ec kafkaErrorCode
+		var message string
+		if counts[topic.Name] > 1 {
+			ec, message = InvalidRequest, "topic "+topic.Name+" is listed more than once"
+		} else if !kc.authorized(aclOpAlter, aclResourceTopic, topic.Name) {
+			ec, message = TopicAuthorizationFailed, "topic "+topic.Name+" authorization failed"
+		} else {
+			ec, message = growTopic(kc.ds, &topic, request.ValidateOnly)
+		}
+
+		result := createPartitionsResult{Name: topic.Name, ErrorCode: int16(ec)}
+		if ec != NoError {
+			result.ErrorMessage = &message
+			kc.l.Tracef("create partitions for %s failed: %s", topic.Name, message)
+		}
+		cpr.Results = append(cpr.Results, result)
+	}
+
+	response = cpr
+	return
+}
+
+// validates and adds the partitions of a create partitions request
+func growTopic(ds *kafkaDataStore, topic *createPartitionsTopic, validateOnly bool) (ec kafkaErrorCode, message string) {
+	kt := ds.getTopic(topic.Name)
+	if kt == nil {
+		return UnknownTopicOrPartition, "topic " + topic.Name + " does not exist"
+	}
+
+	kt.mu.Lock()
+	current := kt.partitionCount()
+	kt.mu.Unlock()
+	if topic.Count <= current {
+		return InvalidPartitions, fmt.Sprintf("topic currently has %d partitions, which is higher than or equal to the requested %d", current, topic.Count)
+	}
+
+	var assignments [][]int32
+	if len(topic.Assignments) > 0 {
+		if int32(len(topic.Assignments)) != topic.Count-current {
+			return InvalidReplicaAssignment, fmt.Sprintf("%d replica assignments were given for %d new partitions", len(topic.Assignments), topic.Count-current)
+		}
+		kt.mu.Lock()
+		replicationFactor := kt.ReplicationFactor
+		kt.mu.Unlock()
+		for _, a := range topic.Assignments {
+			if len(a.BrokerIds) != int(replicationFactor) {
+				return InvalidReplicaAssignment, "the replication factor of new partitions must match the existing partitions"
+			}
+			if reason := ds.validateReplicaAssignment(a.BrokerIds); reason != "" {
+				return InvalidReplicaAssignment, reason
+			}
+			assignments = append(assignments, a.BrokerIds)
+		}
+	}
+
+	if !validateOnly {
+		if _, grown := kt.growPartitions(topic.Count, assignments); !grown {
+			return InvalidPartitions, "the partitions were changed concurrently"
+		}
+	}
+	return
+}
@@ -0,0 +1,205 @@
+package kafkamock
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+
+	"github.com/google/uuid"
+)
+
+type (
+	createTopicsRequest struct {
+		Topics       []createTopicsTopic
+		TimeoutMs    int32
+		ValidateOnly bool `kafka:"min=1"`
+	}
+
+	createTopicsTopic struct {
+		Name              string
+		NumPartitions     int32
+		ReplicationFactor int16
+		Assignments       []createTopicsAssignment
+		Configs           []createTopicsConfig
+	}
+
+	createTopicsAssignment struct {
+		PartitionIndex int32
+		BrokerIds      []int32
+	}
+
+	createTopicsConfig struct {
+		Name  string
+		Value NullableString
+	}
+
+	createTopicsResponse struct {
+		ThrottleTimeMs int32 `kafka:"min=2"`
+		Topics         []createTopicsResponseTopic
+	}
+
+	createTopicsResponseTopic struct {
+		Name                 string
+		TopicId              uuid.UUID `kafka:"min=7"`
+		ErrorCode            int16
+		ErrorMessage         NullableString               `kafka:"min=1"`
+		TopicConfigErrorCode int16                        `kafka:"min=5,tag=0"`
+		NumPartitions        int32                        `kafka:"min=5"`
+		ReplicationFactor    int16                        `kafka:"min=5"`
+		Configs              []createTopicsResponseConfig `kafka:"min=5"`
+	}
+
+	createTopicsResponseConfig struct {
+		Name         string
+		Value        NullableString
+		ReadOnly     bool
+		ConfigSource int8
+		IsSensitive  bool
+	}
+)
+
+const (
+	kDefaultNumPartitions     = 1
+	kDefaultReplicationFactor = 1
+	kMaxTopicNameLength       = 249
+)
+
+var legalTopicName = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+
+func createTopics(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeader) (response any, rtags map[int]any, err error) {
+	request, err := readVersionedRequest[createTopicsRequest](reader, kmh)
+	if err != nil {
+		return
+	}
+
+	counts := map[string]int{}
+	for _, topic := range request.Topics {
+		counts[topic.Name]++
+	}
+
+	ctr := &createTopicsResponse{Topics: make([]createTopicsResponseTopic, 0, len(request.Topics))}
+	for _, topic := range request.Topics {
+		rtopic := createTopicsResponseTopic{
+			Name:              topic.Name,
+			NumPartitions:     -1,
+			ReplicationFactor: -1,
+			Configs:           []createTopicsResponseConfig{},
+		}
+
+		var ec kafkaErrorCode
+		var message string
+		if counts[topic.Name] > 1 {
+			ec, message = InvalidRequest, "topic "+topic.Name+" is listed more than once"
+		} else if !kc.authorized(aclOpCreate, aclResourceCluster, kClusterResourceName) && !kc.authorized(aclOpCreate, aclResourceTopic, topic.Name) {
+			ec, message = TopicAuthorizationFailed, "topic "+topic.Name+" authorization failed"
+		} else {
+			ec, message = createTopic(kc.ds, &topic, request.ValidateOnly, &rtopic)
+		}
+
+		rtopic.ErrorCode = int16(ec)
+		if ec != NoError {
+			rtopic.ErrorMessage = &message
+			kc.l.Tracef("create topic %s failed: %s", topic.Name, message)
+		}
+		ctr.Topics = append(ctr.Topics, rtopic)
+	}
+
+	response = ctr
+	return
+}
+
+// validates and creates a topic, filling in the response with what was created
+func createTopic(ds *kafkaDataStore, topic *createTopicsTopic, validateOnly bool, rtopic *createTopicsResponseTopic) (ec kafkaErrorCode, message string) {
+	if reason := validateTopicName(topic.Name); reason != "" {
+		return InvalidTopicException, reason
+	}
+
+	numPartitions := topic.NumPartitions
+	replicationFactor := topic.ReplicationFactor
+	var assignments [][]int32
+	if len(topic.Assignments) > 0 {
+		// the assignments determine the partitions
+		if numPartitions != -1 || replicationFactor != -1 {
+			return InvalidRequest, "both the replica assignments and the partition count or replication factor were given"
+		}
+		numPartitions = int32(len(topic.Assignments))
+		replicationFactor = -1
+		assignments = make([][]int32, numPartitions)
+		for _, a := range topic.Assignments {
+			if a.PartitionIndex < 0 || a.PartitionIndex >= numPartitions || assignments[a.PartitionIndex] != nil {
+				return InvalidReplicaAssignment, "replica assignment partitions must be consecutive and start at 0"
+			}
+			assignments[a.PartitionIndex] = a.BrokerIds
+			if replicationFactor == -1 {
+				replicationFactor = int16(len(a.BrokerIds))
+			}
+			if len(a.BrokerIds) == 0 || int16(len(a.BrokerIds)) != replicationFactor {
+				return InvalidReplicaAssignment, "all partitions must have the same number of replicas"
+			}
+			if reason := ds.validateReplicaAssignment(a.BrokerIds); reason != "" {
+				return InvalidReplicaAssignment, reason
+			}
+		}
+	}
+
+	if numPartitions == -1 {
+		numPartitions = kDefaultNumPartitions
+	} else if numPartitions <= 0 {
+		return InvalidPartitions, "number of partitions must be larger than 0"
+	}
+	if replicationFactor == -1 {
+		replicationFactor = kDefaultReplicationFactor
+	} else if replicationFactor <= 0 {
+		return InvalidReplicationFactor, "replication factor must be larger than 0"
+	} else if brokers := len(ds.brokerIds()); int(replicationFactor) > brokers {
+		return InvalidReplicationFactor, fmt.Sprintf("replication factor: %d larger than available brokers: %d", replicationFactor, brokers)
+	}
+
+	configs := map[string]string{}
+	for _, config := range topic.Configs {
+		if config.Value != nil {
+			configs[config.Name] = *config.Value
+		}
+	}
+	if ec, message = validateTopicConfigs(configs); ec != NoError {
+		return
+	}
+
+	if ds.getTopic(topic.Name) != nil {
+		return TopicAlreadyExists, "topic " + topic.Name + " already exists"
+	}
+	kt := newKafkaTopic(topic.Name)
+	kt.Configs = configs
+	if !validateOnly {
+		var added bool
+		if kt, added = ds.addTopic(topic.Name, numPartitions, replicationFactor, assignments, configs); !added {
+			return TopicAlreadyExists, "topic " + topic.Name + " already exists"
+		}
+		rtopic.TopicId = kt.Id
+	}
+
+	rtopic.NumPartitions = numPartitions
+	rtopic.ReplicationFactor = replicationFactor
+
+	// the response describes every config in effect, not only the ones that were given
+	for _, entry := range ds.describeTopicConfigs(kt) {
+		value := entry.value
+		rtopic.Configs = append(rtopic.Configs, createTopicsResponseConfig{Name: entry.def.name, Value: &value, ReadOnly: entry.def.readOnly, ConfigSource: int8(entry.source)})
+	}
+	return
+}
+
+// returns the reason a topic name is invalid, or an empty string if it is valid
+func validateTopicName(name string) string {
+	switch {
+	case name == "":
+		return "topic name is empty"
+	case name == "." || name == "..":
+		return "topic name cannot be \".\" or \"..\""
+	case len(name) > kMaxTopicNameLength:
+		return fmt.Sprintf("topic name is longer than %d characters", kMaxTopicNameLength)
+	case !legalTopicName.MatchString(name):
+		return "topic name " + name + " contains characters other than ASCII alphanumerics, '.', '_' and '-'"
+	}
+	return ""
+}
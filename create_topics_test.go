@@ -0,0 +1,194 @@
+package kafkamock
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/jimsnab/go-lane"
+	"github.com/segmentio/kafka-go"
+)
+
+func TestKafkaCreateTopics(t *testing.T) {
+	tl, mock := testCreateKafkaMockServer(t, 21001, []string{"topic-a"})
+	defer testStopMockServer(t, mock)
+
+	client := testKafkaClient(21001)
+	resp, err := client.CreateTopics(tl, &kafka.CreateTopicsRequest{
+		Topics: []kafka.TopicConfig{
+			{Topic: "topic-new", NumPartitions: 3, ReplicationFactor: 1, ConfigEntries: []kafka.ConfigEntry{{ConfigName: "retention.ms", ConfigValue: "1000"}}},
+			{Topic: "topic-default", NumPartitions: -1, ReplicationFactor: -1},
+			{Topic: "topic-a", NumPartitions: 1, ReplicationFactor: 1},
+			{Topic: "bad topic!", NumPartitions: 1, ReplicationFactor: 1},
+			{Topic: "topic-zero", NumPartitions: 0, ReplicationFactor: 1},
+			{Topic: "topic-replicated", NumPartitions: 1, ReplicationFactor: 3},
+		},
+	})
+	if err != nil {
+		t.Fatalf("create topics error: %v", err)
+	}
+
+	expected := map[string]error{
+		"topic-new":        nil,
+		"topic-default":    nil,
+		"topic-a":          kafka.TopicAlreadyExists,
+		"bad topic!":       kafka.InvalidTopic,
+		"topic-zero":       kafka.InvalidPartitionNumber,
+		"topic-replicated": kafka.InvalidReplicationFactor,
+	}
+	for name, expectedErr := range expected {
+		if err := resp.Errors[name]; !errors.Is(err, expectedErr) {
+			t.Errorf("topic %s: expected %v, got %v", name, expectedErr, err)
+		}
+	}
+
+	if kt := mock.ds.getTopic("topic-new"); kt == nil || len(kt.Partitions) != 3 || kt.Configs["retention.ms"] != "1000" {
+		t.Error("topic-new not created as requested")
+	}
+	if kt := mock.ds.getTopic("topic-default"); kt == nil || len(kt.Partitions) != kDefaultNumPartitions {
+		t.Error("topic-default not created with the default partitions")
+	}
+
+}
+
+func TestCreateTopicsValidateOnly(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	ds := newKafkaDataStore()
+
+	value := "compact"
+	request := &createTopicsRequest{
+		Topics: []createTopicsTopic{{
+			Name:              "topic-a",
+			NumPartitions:     -1,
+			ReplicationFactor: -1,
+			Assignments:       []createTopicsAssignment{{PartitionIndex: 0, BrokerIds: []int32{kFirstBrokerId}}, {PartitionIndex: 1, BrokerIds: []int32{kFirstBrokerId}}},
+			Configs:           []createTopicsConfig{{Name: "cleanup.policy", Value: &value}},
+		}},
+		ValidateOnly: true,
+	}
+
+	response := testHandlerRequest[*createTopicsResponse](t, tl, ds, createTopics, ApiKeyCreateTopics, 7, request)
+
+	// validation describes the topic without creating it
+	rtopic := response.Topics[0]
+	if rtopic.ErrorCode != 0 || rtopic.NumPartitions != 2 || rtopic.ReplicationFactor != 1 {
+		t.Errorf("unexpected response %+v", rtopic)
+	}
+	if len(rtopic.Configs) != len(topicConfigDefs) {
+		t.Errorf("unexpected configs %+v", rtopic.Configs)
+	}
+	for _, config := range rtopic.Configs {
+		if config.Name == "cleanup.policy" && (*config.Value != "compact" || config.ConfigSource != int8(configSourceDynamicTopic)) {
+			t.Errorf("unexpected cleanup policy %+v", config)
+		} else if config.Name == "retention.ms" && config.ConfigSource != int8(configSourceDefault) {
+			t.Errorf("unexpected retention %+v", config)
+		}
+	}
+	if ds.getTopic("topic-a") != nil {
+		t.Error("validate only created the topic")
+	}
+}
+
+func TestKafkaCreatePartitions(t *testing.T) {
+	tl, mock := testCreateKafkaMockServer(t, 21001, []string{})
+	defer testStopMockServer(t, mock)
+	mock.CreatePartitionTopics([]string{"topic-a"}, 0)
+
+	client := testKafkaClient(21001)
+	resp, err := client.CreatePartitions(tl, &kafka.CreatePartitionsRequest{
+		Topics: []kafka.TopicPartitionsConfig{
+			{Name: "topic-a", Count: 4},
+			{Name: "topic-b", Count: 2},
+		},
+	})
+	if err != nil {
+		t.Fatalf("create partitions error: %v", err)
+	}
+	if resp.Errors["topic-a"] != nil || !errors.Is(resp.Errors["topic-b"], kafka.UnknownTopicOrPartition) {
+		t.Errorf("unexpected errors %v", resp.Errors)
+	}
+	if parts := mock.ds.getTopic("topic-a").sortedPartitions(); len(parts) != 4 || parts[3].Index != 3 {
+		t.Errorf("expected 4 partitions, got %d", len(parts))
+	}
+
+	// partitions can't be removed
+	resp, err = client.CreatePartitions(tl, &kafka.CreatePartitionsRequest{
+		Topics: []kafka.TopicPartitionsConfig{{Name: "topic-a", Count: 2}},
+	})
+	if err != nil || !errors.Is(resp.Errors["topic-a"], kafka.InvalidPartitionNumber) {
+		t.Errorf("expected invalid partitions: %v %v", err, resp.Errors)
+	}
+}
+
+func TestCreatePartitionsAfterGap(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	ds := newKafkaDataStore()
+	ds.Brokers = append(ds.Brokers, &kafkaBroker{NodeId: kFirstBrokerId + 1, Host: "localhost"})
+
+	// a partition posted to directly counts the partitions below it
+	kt := ds.createTopic("topic-a")
+	kt.createPartition(2)
+
+	grow := func(count int32, assignments ...[]int32) createPartitionsResult {
+		topic := createPartitionsTopic{Name: "topic-a", Count: count}
+		for _, a := range assignments {
+			topic.Assignments = append(topic.Assignments, createPartitionsAssignment{BrokerIds: a})
+		}
+		response := testHandlerRequest[*createPartitionsResponse](t, tl, ds, createPartitions, ApiKeyCreatePartitions, 3, &createPartitionsRequest{Topics: []createPartitionsTopic{topic}})
+		return response.Results[0]
+	}
+
+	if result := grow(3); result.ErrorCode != int16(InvalidPartitions) || result.ErrorMessage == nil || !strings.Contains(*result.ErrorMessage, "currently has 3 partitions") {
+		t.Errorf("expected invalid partitions %+v", result)
+	}
+	if result := grow(5, []int32{kFirstBrokerId + 1}, []int32{kFirstBrokerId}); result.ErrorCode != 0 {
+		t.Fatalf("unexpected create partitions error %+v", result)
+	}
+
+	// the assignments apply to the new partitions, and the gap is filled
+	if parts := kt.sortedPartitions(); len(parts) != 5 {
+		t.Fatalf("expected 5 partitions, got %d", len(parts))
+	}
+	if kp := kt.getPartition(3); kp.Leader != kFirstBrokerId+1 || !slices.Equal(kp.Replicas, []int32{kFirstBrokerId + 1}) {
+		t.Errorf("unexpected partition 3 replicas %v", kp.Replicas)
+	}
+	if kp := kt.getPartition(4); kp.Leader != kFirstBrokerId || !slices.Equal(kp.Replicas, []int32{kFirstBrokerId}) {
+		t.Errorf("unexpected partition 4 replicas %v", kp.Replicas)
+	}
+	if kp := kt.getPartition(0); kp.Replicas != nil {
+		t.Errorf("unexpected partition 0 replicas %v", kp.Replicas)
+	}
+}
+
+func TestKafkaDeleteTopics(t *testing.T) {
+	tl, mock := testCreateKafkaMockServer(t, 21001, []string{"topic-a", "topic-b"})
+	defer testStopMockServer(t, mock)
+
+	client := testKafkaClient(21001)
+	resp, err := client.DeleteTopics(tl, &kafka.DeleteTopicsRequest{Topics: []string{"topic-a", "topic-c"}})
+	if err != nil {
+		t.Fatalf("delete topics error: %v", err)
+	}
+	if resp.Errors["topic-a"] != nil || !errors.Is(resp.Errors["topic-c"], kafka.UnknownTopicOrPartition) {
+		t.Errorf("unexpected errors %v", resp.Errors)
+	}
+	if mock.ds.getTopic("topic-a") != nil || mock.ds.getTopic("topic-b") == nil {
+		t.Error("wrong topics deleted")
+	}
+}
+
+func TestDeleteTopicsById(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	ds := newKafkaDataStore()
+	kt := ds.createTopic("topic-a")
+
+	dtr := testHandlerRequest[*deleteTopicsResponse](t, tl, ds, deleteTopics, ApiKeyDeleteTopics, 6, &deleteTopicsRequest{Topics: []deleteTopicsTopic{{TopicId: kt.Id}}})
+	if len(dtr.Responses) != 1 || dtr.Responses[0].ErrorCode != 0 || dtr.Responses[0].Name == nil || *dtr.Responses[0].Name != "topic-a" {
+		t.Errorf("unexpected response %+v", dtr.Responses)
+	}
+	if ds.getTopic("topic-a") != nil {
+		t.Error("topic not deleted")
+	}
+}
@@ -1,19 +1,46 @@
 package kafkamock
 
 import (
+	"slices"
+	"sort"
 	"sync"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 type (
 	kafkaDataStore struct {
-		mu     sync.Mutex
-		Topics map[string]*kafkaTopic
+		mu             sync.Mutex
+		Topics         map[string]*kafkaTopic
+		Groups         map[string]*kafkaGroup
+		ConsumerGroups map[string]*kafkaConsumerGroup
+		BrokerConfigs  map[string]map[string]string // dynamic configs by broker id, with "" for the cluster defaults
+		Brokers        []*kafkaBroker               // the nodes of the cluster, ordered by node id
+
+		Producers        map[int64]*kafkaProducer  // by producer id
+		TransactionalIds map[string]*kafkaProducer // the producers that have a transactional id
+		NextProducerId   int64
+
+		Users       map[string]*kafkaUser // the users that clients can authenticate as
+		OAuthBearer *kafkaOAuthBearerValidator
+		Acls        *kafkaAuthorizer
+	}
+
+	kafkaBroker struct {
+		NodeId  int32
+		Host    string
+		Port    uint
+		Stopped bool // a stopped broker serves no clients and leads no partitions
 	}
 
 	kafkaTopic struct {
-		mu         sync.Mutex
-		Partitions map[int32]*kafkaPartition
+		mu                sync.Mutex
+		Name              string
+		Id                uuid.UUID
+		Partitions        map[int32]*kafkaPartition
+		Configs           map[string]string // the dynamic topic configs that override the broker's
+		ReplicationFactor int16             // the number of replicas of partitions that aren't assigned explicitly
 	}
 
 	kafkaPartition struct {
@@ -22,17 +49,43 @@ type (
 		ErrorCode             int16
 		Timestamp             int64
 		Offset                int64
-		Records               []*kafkaRecord
+		LogStartOffset        int64          // the offset of Records[0]
+		Records               []*kafkaRecord // the log, from the log start offset to the high watermark
 		GroupCommittedOffsets map[string]int64
 		Metadata              NullableString
+		Leader                int32                             // the node id of the broker that serves the partition
+		LeaderEpoch           int32                             // incremented whenever the leader changes
+		LeaderEpochs          []kafkaLeaderEpoch                // the epochs of the log, ordered by epoch
+		Replicas              []int32                           // the brokers that have the partition, starting with the preferred leader; nil until placed
+		Producers             map[int64]*kafkaPartitionProducer // the idempotent producers that wrote to the partition
+		OngoingTxns           map[int64]int64                   // the first offset of each producer's ongoing transaction
+		AbortedTxns           []kafkaAbortedTxn                 // ordered by the offsets of their abort markers
+	}
+
+	// the records of an aborted transaction, which read_committed consumers skip
+	kafkaAbortedTxn struct {
+		producerId  int64
+		firstOffset int64
+		lastOffset  int64 // the offset of the abort marker
+	}
+
+	// a leader epoch and the offset of its first record
+	kafkaLeaderEpoch struct {
+		epoch       int32
+		startOffset int64
 	}
 
 	kafkaRecord struct {
-		Attributes int8
-		Timestamp  int64
-		Key        []byte
-		Value      []byte
-		Headers    []kafkaRecordHeader
+		Attributes      int8
+		Timestamp       int64
+		Key             []byte
+		Value           []byte
+		Headers         []kafkaRecordHeader
+		ProducerId      int64 // kNoProducerId unless the record came from an idempotent producer
+		ProducerEpoch   int16
+		Sequence        int32
+		BatchAttributes int16 // the transactional, control and timestamp type bits of the batch the record was written in
+		LeaderEpoch     int32 // the leader epoch the record was written in
 	}
 
 	kafkaRecordHeader struct {
@@ -43,7 +96,18 @@ type (
 
 func newKafkaDataStore() *kafkaDataStore {
 	return &kafkaDataStore{
-		Topics: map[string]*kafkaTopic{},
+		Topics:         map[string]*kafkaTopic{},
+		Groups:         map[string]*kafkaGroup{},
+		ConsumerGroups: map[string]*kafkaConsumerGroup{},
+		BrokerConfigs:  map[string]map[string]string{},
+		Brokers:        []*kafkaBroker{{NodeId: kFirstBrokerId, Host: "localhost"}},
+
+		Producers:        map[int64]*kafkaProducer{},
+		TransactionalIds: map[string]*kafkaProducer{},
+
+		Users:       map[string]*kafkaUser{},
+		OAuthBearer: newKafkaOAuthBearerValidator(),
+		Acls:        newKafkaAuthorizer(),
 	}
 }
 
@@ -53,14 +117,52 @@ func (ds *kafkaDataStore) createTopic(name string) *kafkaTopic {
 
 	topic, exists := ds.Topics[name]
 	if !exists {
-		topic = &kafkaTopic{
-			Partitions: map[int32]*kafkaPartition{},
-		}
+		topic = newKafkaTopic(name)
 		ds.Topics[name] = topic
 	}
 	return topic
 }
 
+// creates a topic, unless one by the same name already exists; the
+// assignments, if any, give the replicas of each partition
+func (ds *kafkaDataStore) addTopic(name string, partitions int32, replicationFactor int16, assignments [][]int32, configs map[string]string) (topic *kafkaTopic, added bool) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	if _, exists := ds.Topics[name]; exists {
+		return
+	}
+
+	topic = newKafkaTopic(name)
+	topic.Configs = configs
+	topic.ReplicationFactor = replicationFactor
+	topic.growPartitions(partitions, assignments)
+	ds.Topics[name] = topic
+	added = true
+	return
+}
+
+func (ds *kafkaDataStore) deleteTopic(name string) (deleted bool) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	if _, exists := ds.Topics[name]; exists {
+		delete(ds.Topics, name)
+		deleted = true
+	}
+	return
+}
+
+func newKafkaTopic(name string) *kafkaTopic {
+	return &kafkaTopic{
+		Name:              name,
+		Id:                uuid.New(),
+		Partitions:        map[int32]*kafkaPartition{},
+		Configs:           map[string]string{},
+		ReplicationFactor: kDefaultReplicationFactor,
+	}
+}
+
 func (ds *kafkaDataStore) getTopic(name string) *kafkaTopic {
 	ds.mu.Lock()
 	defer ds.mu.Unlock()
@@ -68,22 +170,90 @@ func (ds *kafkaDataStore) getTopic(name string) *kafkaTopic {
 	return ds.Topics[name]
 }
 
+func (ds *kafkaDataStore) getTopicById(id uuid.UUID) *kafkaTopic {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	for _, topic := range ds.Topics {
+		if topic.Id == id {
+			return topic
+		}
+	}
+	return nil
+}
+
+// returns a snapshot of the topics, ordered by name
+func (ds *kafkaDataStore) sortedTopics() []*kafkaTopic {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	topics := make([]*kafkaTopic, 0, len(ds.Topics))
+	for _, topic := range ds.Topics {
+		topics = append(topics, topic)
+	}
+	sort.Slice(topics, func(i, j int) bool { return topics[i].Name < topics[j].Name })
+	return topics
+}
+
 func (kp *kafkaTopic) createPartition(number int32) *kafkaPartition {
 	kp.mu.Lock()
 	defer kp.mu.Unlock()
 
 	partition, exists := kp.Partitions[number]
 	if !exists {
-		partition = &kafkaPartition{
-			Index:                 number,
-			Records:               []*kafkaRecord{},
-			GroupCommittedOffsets: map[string]int64{},
-		}
+		partition = newKafkaPartition(number)
 		kp.Partitions[number] = partition
 	}
 	return partition
 }
 
+// adds partitions until the topic has the requested count; the assignments,
+// if any, give the replicas of each new partition
+func (kp *kafkaTopic) growPartitions(count int32, assignments [][]int32) (previous int32, grown bool) {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+
+	previous = kp.partitionCount()
+	if count <= previous {
+		return
+	}
+	for n := int32(0); n < count; n++ {
+		if _, exists := kp.Partitions[n]; !exists {
+			partition := newKafkaPartition(n)
+			if a := n - previous; a >= 0 && int(a) < len(assignments) {
+				partition.Replicas = slices.Clone(assignments[a])
+				partition.Leader = partition.Replicas[0]
+			}
+			kp.Partitions[n] = partition
+		}
+	}
+	grown = true
+	return
+}
+
+// the number of partitions, which runs up to the highest partition index;
+// partitions that were posted to directly can leave gaps below it. The
+// caller holds the lock.
+func (kp *kafkaTopic) partitionCount() int32 {
+	count := int32(0)
+	for n := range kp.Partitions {
+		count = max(count, n+1)
+	}
+	return count
+}
+
+func newKafkaPartition(number int32) *kafkaPartition {
+	return &kafkaPartition{
+		Index:                 number,
+		Records:               []*kafkaRecord{},
+		GroupCommittedOffsets: map[string]int64{},
+		Producers:             map[int64]*kafkaPartitionProducer{},
+		OngoingTxns:           map[int64]int64{},
+		AbortedTxns:           []kafkaAbortedTxn{},
+		LeaderEpochs:          []kafkaLeaderEpoch{{}},
+	}
+}
+
 func (kp *kafkaTopic) getPartition(number int32) *kafkaPartition {
 	kp.mu.Lock()
 	defer kp.mu.Unlock()
@@ -91,6 +261,19 @@ func (kp *kafkaTopic) getPartition(number int32) *kafkaPartition {
 	return kp.Partitions[number]
 }
 
+// returns a snapshot of the partitions, ordered by index
+func (kp *kafkaTopic) sortedPartitions() []*kafkaPartition {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+
+	partitions := make([]*kafkaPartition, 0, len(kp.Partitions))
+	for _, partition := range kp.Partitions {
+		partitions = append(partitions, partition)
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i].Index < partitions[j].Index })
+	return partitions
+}
+
 func (kp *kafkaPartition) lock() {
 	kp.mu.Lock()
 }
@@ -99,23 +282,171 @@ func (kp *kafkaPartition) unlock() {
 	kp.mu.Unlock()
 }
 
-func (kp *kafkaPartition) postRecord(attribs int8, ts time.Time, key, value []byte, headers map[string][]byte) {
-	kp.mu.Lock()
-	defer kp.mu.Unlock()
-
+func (kp *kafkaPartition) postRecord(attribs int8, ts time.Time, key, value []byte, headers map[string][]byte) int64 {
 	flatHeaders := make([]kafkaRecordHeader, 0, len(headers))
 	for k, v := range headers {
 		flatHeaders = append(flatHeaders, kafkaRecordHeader{HeaderKey: k, HeaderValue: v})
 	}
 
 	record := &kafkaRecord{
-		Attributes: attribs,
-		Timestamp:  ts.UnixMilli(),
-		Key:        key,
-		Value:      value,
-		Headers:    flatHeaders,
+		Attributes:    attribs,
+		Timestamp:     ts.UnixMilli(),
+		Key:           key,
+		Value:         value,
+		Headers:       flatHeaders,
+		ProducerId:    kNoProducerId,
+		ProducerEpoch: kNoProducerEpoch,
+		Sequence:      kNoSequence,
+	}
+	return kp.postRecords([]*kafkaRecord{record})
+}
+
+// appends records with contiguous offsets, returning the offset of the first
+func (kp *kafkaPartition) postRecords(records []*kafkaRecord) int64 {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+
+	baseOffset := kp.endOffset()
+	kp.appendRecords(records...)
+	return baseOffset
+}
+
+// appends the records of produced batches, returning the offset of the first
+// batch. A batch from an idempotent producer must continue the producer's
+// sequence, and one that was already written isn't appended again. A
+// transactional batch must also have a producer id and pass verifyTxn, which
+// is called with the lock held.
+// Every batch is checked before any is appended, so a request that fails
+// leaves the log and the producers as they were.
+func (kp *kafkaPartition) postBatches(batches []*decodedBatch, verifyTxn func(header *recordBatchV2) kafkaErrorCode) (baseOffset int64, ec kafkaErrorCode) {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+
+	// the producers' states as the batches checked so far would leave them
+	producers := map[int64]*kafkaPartitionProducer{}
+	appends := make([]*decodedBatch, 0, len(batches))
+
+	baseOffset = -1
+	offset := kp.endOffset()
+	for n, batch := range batches {
+		batchOffset := offset
+		var duplicate *kafkaProducerBatch
+		if batch.header.ProducerId == kNoProducerId && batch.header.Attributes&kBatchTransactional != 0 {
+			// a transaction needs a producer to end it
+			return -1, InvalidRecord
+		}
+		if batch.header.ProducerId != kNoProducerId {
+			pp, checked := producers[batch.header.ProducerId]
+			if !checked {
+				pp = kp.Producers[batch.header.ProducerId]
+			}
+
+			if duplicate, ec = pp.checkBatch(&batch.header); ec != NoError {
+				return -1, ec
+			}
+			if duplicate == nil && batch.header.Attributes&kBatchTransactional != 0 {
+				if ec = verifyTxn(&batch.header); ec != NoError {
+					return -1, ec
+				}
+			}
+			if duplicate != nil {
+				batchOffset = duplicate.baseOffset
+			} else {
+				producers[batch.header.ProducerId] = pp.withBatch(&batch.header, offset)
+			}
+		}
+
+		if duplicate == nil {
+			appends = append(appends, batch)
+			offset += int64(len(batch.records))
+		}
+		if n == 0 {
+			baseOffset = batchOffset
+		}
+	}
+
+	for producerId, pp := range producers {
+		kp.Producers[producerId] = pp
+	}
+	for _, batch := range appends {
+		if _, ongoing := kp.OngoingTxns[batch.header.ProducerId]; !ongoing && batch.header.Attributes&kBatchTransactional != 0 {
+			kp.OngoingTxns[batch.header.ProducerId] = kp.endOffset()
+		}
+		kp.appendRecords(batch.records...)
+	}
+	return
+}
+
+// the offset that the next record will get; the caller holds the lock
+func (kp *kafkaPartition) endOffset() int64 {
+	return kp.LogStartOffset + int64(len(kp.Records))
+}
+
+// the offset before which every transaction in the log is complete, which
+// read_committed consumers can't read past; the caller holds the lock
+func (kp *kafkaPartition) lastStableOffset() int64 {
+	lso := kp.endOffset()
+	for _, firstOffset := range kp.OngoingTxns {
+		if firstOffset < lso {
+			lso = firstOffset
+		}
+	}
+	if lso < kp.LogStartOffset {
+		lso = kp.LogStartOffset
+	}
+	return lso
+}
+
+// returns the aborted transactions that have records from startOffset up to
+// endOffset; the caller holds the lock
+func (kp *kafkaPartition) abortedTxnsBetween(startOffset, endOffset int64) []kafkaAbortedTxn {
+	aborted := []kafkaAbortedTxn{}
+	for _, txn := range kp.AbortedTxns {
+		if txn.lastOffset >= startOffset && txn.firstOffset < endOffset {
+			aborted = append(aborted, txn)
+		}
+	}
+	return aborted
+}
+
+// returns the record at an offset, or nil if the offset isn't in the log or
+// its record was compacted away; the caller holds the lock
+func (kp *kafkaPartition) recordAt(offset int64) *kafkaRecord {
+	if offset < kp.LogStartOffset || offset >= kp.endOffset() {
+		return nil
+	}
+	return kp.Records[offset-kp.LogStartOffset]
+}
+
+// returns the first record at or after an offset, skipping the gaps that
+// compaction leaves, or nil if there are no more records; the caller holds the lock
+func (kp *kafkaPartition) nextRecord(offset int64) (int64, *kafkaRecord) {
+	if offset < kp.LogStartOffset {
+		offset = kp.LogStartOffset
+	}
+	for ; offset < kp.endOffset(); offset++ {
+		if record := kp.recordAt(offset); record != nil {
+			return offset, record
+		}
+	}
+	return offset, nil
+}
+
+// discards the records before an offset, moving the log start offset up to it;
+// the caller holds the lock
+func (kp *kafkaPartition) truncateBefore(offset int64) {
+	if offset <= kp.LogStartOffset {
+		return
+	}
+	if offset > kp.endOffset() {
+		offset = kp.endOffset()
+	}
+	kp.Records = kp.Records[offset-kp.LogStartOffset:]
+	kp.LogStartOffset = offset
+
+	for len(kp.AbortedTxns) > 0 && kp.AbortedTxns[0].lastOffset < offset {
+		kp.AbortedTxns = kp.AbortedTxns[1:]
 	}
-	kp.Records = append(kp.Records, record)
 }
 
 func (kp *kafkaPartition) groupCommittedOffset(group string) int64 {
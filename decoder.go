@@ -2,15 +2,51 @@ package kafkamock
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"math"
 	"reflect"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/google/uuid"
 )
 
+type (
+	// describes the api version a message is coded for; fields tagged
+	// with min/max versions are skipped outside of their range, and
+	// flexible versions use compact encodings and tagged fields
+	codecVersion struct {
+		version  int
+		flexible bool
+	}
+
+	kafkaFieldOptions struct {
+		nullable   bool
+		compact    bool
+		minVersion int
+		maxVersion int
+		tag        int
+	}
+)
+
+// coding without an api version includes every untagged field
+var unversioned = codecVersion{version: -1}
+
+var fieldOptionsCache sync.Map
+
+func (cv codecVersion) includes(opts *kafkaFieldOptions) bool {
+	if opts.tag >= 0 && !cv.flexible {
+		return false
+	}
+	if cv.version < 0 {
+		return true
+	}
+	return cv.version >= opts.minVersion && (opts.maxVersion < 0 || cv.version <= opts.maxVersion)
+}
+
 // worker to read the variable-length data that has a 16-bit length
 func peekNullableData16(reader *bufio.Reader, offset int) (next int, data []byte) {
 	next = offset + 2
@@ -70,12 +106,12 @@ func peekVarInt(reader *bufio.Reader, offset int) (next int, varint VarInt) {
 }
 
 func peekCompactNullableBytes(reader *bufio.Reader, offset int) (next int, data CompactNullableBytes) {
-	next, count := peekVarInt(reader, offset)
+	next, count := peekVarUint(reader, offset)
 	if next < 0 {
 		return
 	}
 
-	if count <= 0 {
+	if count == 0 {
 		return
 	}
 
@@ -86,7 +122,9 @@ func peekCompactNullableBytes(reader *bufio.Reader, offset int) (next int, data
 		return
 	}
 
-	data = inbound[next:]
+	// a copy, because a later peek past the end of the message moves the
+	// reader's buffered data
+	data = bytes.Clone(inbound[next:])
 	next += int(count)
 	return
 }
@@ -179,7 +217,9 @@ func peekNullableBytes(reader *bufio.Reader, offset int) (next int, data Nullabl
 		return
 	}
 
-	data = data[start:]
+	// a copy, because a later peek past the end of the message moves the
+	// reader's buffered data
+	data = bytes.Clone(data[start:])
 	return
 }
 
@@ -343,10 +383,15 @@ func peekFloat64(reader *bufio.Reader, offset int) (next int, f float64) {
 }
 
 func peekObject(reader *bufio.Reader, offset int, tt reflect.Type) (next int, obj any) {
-	return peekObjectWorker(reader, offset, tt, false, false)
+	return peekObjectWorker(reader, offset, tt, false, false, unversioned)
+}
+
+// decodes an object as it is laid out for a specific api version
+func peekVersionedObject(reader *bufio.Reader, offset int, tt reflect.Type, cv codecVersion) (next int, obj any) {
+	return peekObjectWorker(reader, offset, tt, false, false, cv)
 }
 
-func peekObjectWorker(reader *bufio.Reader, offset int, tt reflect.Type, nullable, compact bool) (next int, obj any) {
+func peekObjectWorker(reader *bufio.Reader, offset int, tt reflect.Type, nullable, compact bool, cv codecVersion) (next int, obj any) {
 	switch tt.Kind() {
 	case reflect.Bool:
 		return peekBool(reader, offset)
@@ -422,64 +467,98 @@ func peekObjectWorker(reader *bufio.Reader, offset int, tt reflect.Type, nullabl
 		} else {
 			// This is a trick to be able to distinguish kafka's normal arrays from its compact arrays.
 			// Use a fixed array type for a compact array, and a slice type for a normal array.
-			return peekObjectCompactVarArray(reader, offset, tt.Elem())
+			return peekObjectCompactVarArray(reader, offset, tt.Elem(), cv)
 		}
 
 	case reflect.Slice:
 		if tt.Name() == "NullableBytes" {
+			if cv.flexible {
+				var data CompactNullableBytes
+				next, data = peekCompactNullableBytes(reader, offset)
+				if data != nil {
+					obj = NullableBytes(data)
+				}
+				return
+			}
 			return peekNullableBytes(reader, offset)
 		} else if tt.Name() == "CompactNullableBytes" {
 			return peekCompactNullableBytes(reader, offset)
+		} else if tt.Elem().Kind() == reflect.Uint8 && (compact || cv.flexible) {
+			var data CompactNullableBytes
+			next, data = peekCompactNullableBytes(reader, offset)
+			if data != nil {
+				obj = reflect.ValueOf([]byte(data)).Convert(tt).Interface()
+			}
+			return
 		} else {
-			if compact {
-				return peekObjectCompactVarArray(reader, offset, tt.Elem())
+			if compact || cv.flexible {
+				return peekObjectCompactVarArray(reader, offset, tt.Elem(), cv)
 			} else {
-				return peekObjectVarArray(reader, offset, tt.Elem())
+				return peekObjectVarArray(reader, offset, tt.Elem(), cv)
 			}
 		}
 
 	case reflect.String:
-		if tt.Name() == "CompactString" {
-			var s CompactNullableString
-			next, s = peekCompactNullableString(reader, offset)
+		var s string
+		if tt.Name() == "CompactString" || compact || cv.flexible {
+			var cs CompactNullableString
+			next, cs = peekCompactNullableString(reader, offset)
 			if next < 0 {
 				return
 			}
-			if s == nil {
-				obj = CompactString("")
-			} else {
-				obj = *s
+			if cs != nil {
+				s = string(*cs)
 			}
 		} else {
-			var s NullableString
-			next, s = peekNullableString(reader, offset)
+			var ns NullableString
+			next, ns = peekNullableString(reader, offset)
 			if next < 0 {
 				return
 			}
-			if s == nil {
-				obj = ""
-			} else {
-				obj = *s
+			if ns != nil {
+				s = *ns
 			}
 		}
+		obj = reflect.ValueOf(s).Convert(tt).Interface()
 		return
 
 	case reflect.Struct:
-		return peekObjectStruct(reader, offset, tt)
+		return peekObjectStruct(reader, offset, tt, cv)
 
 	case reflect.Pointer:
 		switch tt.Name() {
 		case "CompactNullableString":
 			return peekCompactNullableString(reader, offset)
 		case "NullableString":
+			if cv.flexible {
+				var cs CompactNullableString
+				next, cs = peekCompactNullableString(reader, offset)
+				if cs == nil {
+					obj = NullableString(nil)
+				} else {
+					s := string(*cs)
+					obj = NullableString(&s)
+				}
+				return
+			}
 			return peekNullableString(reader, offset)
 		default:
 			// pointer to something
 			elem := tt.Elem()
 
 			if elem.Kind() == reflect.Struct {
+				if nullable {
+					// a nullable struct is preceded by a presence marker
+					var present int8
+					offset, present = peekInt8(reader, offset)
+					if offset < 0 || present < 0 {
+						next = offset
+						return
+					}
+				}
+
 				var v any
-				next, v = peekObject(reader, offset, elem)
+				next, v = peekObjectWorker(reader, offset, elem, false, false, cv)
 				if next < 0 {
 					return
 				}
@@ -499,27 +578,61 @@ func peekObjectWorker(reader *bufio.Reader, offset int, tt reflect.Type, nullabl
 }
 
 func kafkaTags(ft reflect.StructField) (nullable, compact bool) {
+	opts := parseFieldOptions(ft)
+	return opts.nullable, opts.compact
+}
+
+// parses the `kafka:"..."` struct tag; in addition to compact and nullable,
+// the tag can carry min=N and max=N to limit the api versions that include
+// the field, and tag=N to make it a tagged field of flexible versions
+func parseFieldOptions(ft reflect.StructField) (opts kafkaFieldOptions) {
+	opts.maxVersion = -1
+	opts.tag = -1
+
 	tag := ft.Tag.Get("kafka")
 	if tag != "" {
 		parts := strings.Split(tag, ",")
 		for _, part := range parts {
-			if part == "compact" {
-				compact = true
-			} else if part == "nullable" {
-				nullable = true
+			name, value, _ := strings.Cut(part, "=")
+			switch name {
+			case "compact":
+				opts.compact = true
+			case "nullable":
+				opts.nullable = true
+			case "min":
+				opts.minVersion, _ = strconv.Atoi(value)
+			case "max":
+				opts.maxVersion, _ = strconv.Atoi(value)
+			case "tag":
+				opts.tag, _ = strconv.Atoi(value)
 			}
 		}
 	}
 	return
 }
 
-func peekObjectFixedArray(reader *bufio.Reader, offset, length int, elem reflect.Type) (next int, obj any) {
+// returns the parsed field options of a struct type, cached since
+// the same message types are coded over and over
+func structFieldOptions(tt reflect.Type) []kafkaFieldOptions {
+	if cached, exists := fieldOptionsCache.Load(tt); exists {
+		return cached.([]kafkaFieldOptions)
+	}
+
+	opts := make([]kafkaFieldOptions, tt.NumField())
+	for i := 0; i < tt.NumField(); i++ {
+		opts[i] = parseFieldOptions(tt.Field(i))
+	}
+	fieldOptionsCache.Store(tt, opts)
+	return opts
+}
+
+func peekObjectFixedArray(reader *bufio.Reader, offset, length int, elem reflect.Type, cv codecVersion) (next int, obj any) {
 	next = offset
 	if length >= 0 {
 		a := reflect.MakeSlice(reflect.SliceOf(elem), 0, length)
 		for i := 0; i < int(length); i++ {
 			var item any
-			next, item = peekObject(reader, next, elem)
+			next, item = peekObjectWorker(reader, next, elem, false, false, cv)
 			if next < 0 {
 				return
 			}
@@ -531,17 +644,17 @@ func peekObjectFixedArray(reader *bufio.Reader, offset, length int, elem reflect
 	return
 }
 
-func peekObjectVarArray(reader *bufio.Reader, offset int, elem reflect.Type) (next int, obj any) {
+func peekObjectVarArray(reader *bufio.Reader, offset int, elem reflect.Type, cv codecVersion) (next int, obj any) {
 	next, length := peekInt32(reader, offset)
 	if next < 0 {
 		return
 	}
 
-	return peekObjectFixedArray(reader, next, int(length), elem)
+	return peekObjectFixedArray(reader, next, int(length), elem, cv)
 }
 
-func peekObjectCompactVarArray(reader *bufio.Reader, offset int, elem reflect.Type) (next int, obj any) {
-	next, length := peekVarInt(reader, offset)
+func peekObjectCompactVarArray(reader *bufio.Reader, offset int, elem reflect.Type, cv codecVersion) (next int, obj any) {
+	next, length := peekVarUint(reader, offset)
 	if next < 0 {
 		return
 	}
@@ -550,25 +663,80 @@ func peekObjectCompactVarArray(reader *bufio.Reader, offset int, elem reflect.Ty
 		return
 	}
 
-	return peekObjectFixedArray(reader, next, int(length-1), elem)
+	return peekObjectFixedArray(reader, next, int(length-1), elem, cv)
 }
 
-func peekObjectStruct(reader *bufio.Reader, offset int, tt reflect.Type) (next int, obj any) {
+func peekObjectStruct(reader *bufio.Reader, offset int, tt reflect.Type, cv codecVersion) (next int, obj any) {
 	next = offset
 	o := reflect.New(tt)
+	opts := structFieldOptions(tt)
 	for i := 0; i < tt.NumField(); i++ {
-		ft := tt.Field(i)
-
-		nullable, compact := kafkaTags(ft)
+		if !cv.includes(&opts[i]) || opts[i].tag >= 0 {
+			continue
+		}
 
 		var v any
-		next, v = peekObjectWorker(reader, next, ft.Type, nullable, compact)
+		next, v = peekObjectWorker(reader, next, tt.Field(i).Type, opts[i].nullable, opts[i].compact, cv)
 		if next < 0 {
 			return
 		}
-		f := o.Elem().Field(i)
-		f.Set(reflect.ValueOf(v))
+		if v != nil {
+			f := o.Elem().Field(i)
+			f.Set(reflect.ValueOf(v))
+		}
 	}
+
+	if cv.flexible {
+		next = peekTaggedFields(reader, next, tt, o.Elem(), cv)
+		if next < 0 {
+			return
+		}
+	}
+
 	obj = o.Elem().Interface()
 	return
 }
+
+// reads the tagged fields that end each structure of a flexible version,
+// storing the ones that are known to the struct and skipping the others
+func peekTaggedFields(reader *bufio.Reader, offset int, tt reflect.Type, o reflect.Value, cv codecVersion) (next int) {
+	next, count := peekVarUint(reader, offset)
+	if next < 0 {
+		return
+	}
+
+	opts := structFieldOptions(tt)
+	for n := 0; n < int(count); n++ {
+		var tag, length VarUint
+		next, tag = peekVarUint(reader, next)
+		if next < 0 {
+			return
+		}
+		next, length = peekVarUint(reader, next)
+		if next < 0 {
+			return
+		}
+
+		end := next + int(length)
+		for i := range opts {
+			if opts[i].tag == int(tag) && cv.includes(&opts[i]) {
+				var v any
+				next, v = peekObjectWorker(reader, next, tt.Field(i).Type, opts[i].nullable, opts[i].compact, cv)
+				if next < 0 {
+					return
+				}
+				if v != nil {
+					o.Field(i).Set(reflect.ValueOf(v))
+				}
+				break
+			}
+		}
+
+		if _, unfilled := reader.Peek(end); unfilled != nil {
+			next = -1
+			return
+		}
+		next = end
+	}
+	return
+}
@@ -0,0 +1,101 @@
+package kafkamock
+
+import (
+	"bufio"
+)
+
+type (
+	deleteAclsRequest struct {
+		Filters []deleteAclsFilter
+	}
+
+	deleteAclsFilter struct {
+		ResourceTypeFilter        int8
+		ResourceNameFilter        NullableString
+		ResourcePatternTypeFilter int8 `kafka:"min=1"`
+		PrincipalFilter           NullableString
+		HostFilter                NullableString
+		Operation                 int8
+		PermissionType            int8
+	}
+
+	deleteAclsResponse struct {
+		ThrottleTimeMs int32
+		FilterResults  []deleteAclsFilterResult
+	}
+
+	deleteAclsFilterResult struct {
+		ErrorCode    int16
+		ErrorMessage NullableString
+		MatchingAcls []deleteAclsMatchingAcl
+	}
+
+	deleteAclsMatchingAcl struct {
+		ErrorCode           int16
+		ErrorMessage        NullableString
+		ResourceType        int8
+		ResourceName        string
+		ResourcePatternType int8 `kafka:"min=1"`
+		Principal           string
+		Host                string
+		Operation           int8
+		PermissionType      int8
+	}
+)
+
+func deleteAcls(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeader) (response any, rtags map[int]any, err error) {
+	request, err := readVersionedRequest[deleteAclsRequest](reader, kmh)
+	if err != nil {
+		return
+	}
+
+	var ec kafkaErrorCode
+	var message string
+	if !kc.ds.Acls.isEnabled() {
+		ec, message = SecurityDisabled, "no authorizer is configured on the broker"
+	} else if !kc.authorized(aclOpAlter, aclResourceCluster, kClusterResourceName) {
+		ec, message = ClusterAuthorizationFailed, "cluster authorization failed"
+	}
+
+	dar := &deleteAclsResponse{FilterResults: make([]deleteAclsFilterResult, 0, len(request.Filters))}
+	for _, f := range request.Filters {
+		filter := kafkaAclFilter{
+			resourceType: kafkaAclResourceType(f.ResourceTypeFilter),
+			resourceName: f.ResourceNameFilter,
+			patternType:  kafkaAclPatternType(f.ResourcePatternTypeFilter),
+			principal:    f.PrincipalFilter,
+			host:         f.HostFilter,
+			operation:    kafkaAclOperation(f.Operation),
+			permission:   kafkaAclPermission(f.PermissionType),
+		}
+		if kmh.RequestApiVersion < 1 {
+			filter.patternType = aclPatternLiteral
+		}
+
+		result := deleteAclsFilterResult{ErrorCode: int16(ec), MatchingAcls: []deleteAclsMatchingAcl{}}
+		if ec != NoError {
+			result.ErrorMessage = &message
+		} else if reason := filter.validate(); reason != "" {
+			result.ErrorCode = int16(InvalidRequest)
+			result.ErrorMessage = &reason
+			kc.l.Tracef("delete acls failed: %s", reason)
+		} else {
+			for _, acl := range kc.ds.Acls.deleteAcls(&filter) {
+				result.MatchingAcls = append(result.MatchingAcls, deleteAclsMatchingAcl{
+					ResourceType:        int8(acl.resourceType),
+					ResourceName:        acl.resourceName,
+					ResourcePatternType: int8(acl.patternType),
+					Principal:           acl.principal,
+					Host:                acl.host,
+					Operation:           int8(acl.operation),
+					PermissionType:      int8(acl.permission),
+				})
+			}
+			kc.l.Tracef("deleted %d acls", len(result.MatchingAcls))
+		}
+		dar.FilterResults = append(dar.FilterResults, result)
+	}
+
+	response = dar
+	return
+}
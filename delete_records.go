@@ -0,0 +1,113 @@
+package kafkamock
+
+import (
+	"bufio"
+	"slices"
+)
+
+type (
+	deleteRecordsRequest struct {
+		Topics    []deleteRecordsTopic
+		TimeoutMs int32
+	}
+
+	deleteRecordsTopic struct {
+		Name       string
+		Partitions []deleteRecordsPartition
+	}
+
+	deleteRecordsPartition struct {
+		PartitionIndex int32
+		Offset         int64
+	}
+
+	deleteRecordsResponse struct {
+		ThrottleTimeMs int32
+		Topics         []deleteRecordsResponseTopic
+	}
+
+	deleteRecordsResponseTopic struct {
+		Name       string
+		Partitions []deleteRecordsResponsePartition
+	}
+
+	deleteRecordsResponsePartition struct {
+		PartitionIndex int32
+		LowWatermark   int64
+		ErrorCode      int16
+	}
+)
+
+// the offset that means up to the high watermark
+const kDeleteToHighWatermark = -1
+
+func deleteRecords(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeader) (response any, rtags map[int]any, err error) {
+	request, err := readVersionedRequest[deleteRecordsRequest](reader, kmh)
+	if err != nil {
+		return
+	}
+
+	drr := &deleteRecordsResponse{Topics: make([]deleteRecordsResponseTopic, 0, len(request.Topics))}
+	for _, topic := range request.Topics {
+		rtopic := deleteRecordsResponseTopic{
+			Name:       topic.Name,
+			Partitions: make([]deleteRecordsResponsePartition, 0, len(topic.Partitions)),
+		}
+
+		authEc := kc.authorizationError(aclOpDelete, aclResourceTopic, topic.Name)
+		for _, par := range topic.Partitions {
+			lowWatermark, ec := int64(-1), authEc
+			if ec == NoError {
+				lowWatermark, ec = kc.ds.deleteRecordsBefore(topic.Name, par.PartitionIndex, par.Offset)
+			}
+			if ec != NoError {
+				kc.l.Tracef("delete records of %s:%d before %d failed: %d", topic.Name, par.PartitionIndex, par.Offset, ec)
+			} else {
+				kc.l.Tracef("deleted records of %s:%d, the log now starts at %d", topic.Name, par.PartitionIndex, lowWatermark)
+			}
+			rtopic.Partitions = append(rtopic.Partitions, deleteRecordsResponsePartition{PartitionIndex: par.PartitionIndex, LowWatermark: lowWatermark, ErrorCode: int16(ec)})
+		}
+
+		drr.Topics = append(drr.Topics, rtopic)
+	}
+
+	response = drr
+	return
+}
+
+// moves the log start offset of a partition up to an offset, discarding the
+// records before it, and returns the new log start offset
+func (ds *kafkaDataStore) deleteRecordsBefore(topic string, partition int32, offset int64) (lowWatermark int64, ec kafkaErrorCode) {
+	lowWatermark = -1
+
+	kt := ds.getTopic(topic)
+	var kp *kafkaPartition
+	if kt != nil {
+		kp = kt.getPartition(partition)
+	}
+	if kp == nil {
+		ec = UnknownTopicOrPartition
+		return
+	}
+
+	// only logs that are cleaned by deletion can be truncated
+	if !slices.Contains(splitConfigList(ds.topicConfig(kt, "cleanup.policy")), "delete") {
+		ec = PolicyViolation
+		return
+	}
+
+	kp.lock()
+	defer kp.unlock()
+
+	if offset == kDeleteToHighWatermark {
+		offset = kp.endOffset()
+	}
+	if offset < 0 || offset > kp.endOffset() {
+		ec = OffsetOutOfRange
+		return
+	}
+
+	kp.truncateBefore(offset)
+	lowWatermark = kp.LogStartOffset
+	return
+}
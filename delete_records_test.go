@@ -0,0 +1,95 @@
+package kafkamock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jimsnab/go-lane"
+	"github.com/segmentio/kafka-go"
+)
+
+func TestDeleteRecords(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	ds := newKafkaDataStore()
+	kp := ds.createTopic("topic-a").createPartition(0)
+	for i := 0; i < 5; i++ {
+		kp.postRecord(0, time.Now(), nil, []byte("test"), nil)
+	}
+	compacted := ds.createTopic("topic-c")
+	compacted.createPartition(0)
+	compacted.Configs["cleanup.policy"] = "compact"
+
+	for _, version := range []int{0, 2} {
+		request := &deleteRecordsRequest{
+			Topics: []deleteRecordsTopic{
+				{Name: "topic-a", Partitions: []deleteRecordsPartition{{PartitionIndex: 0, Offset: 3}, {PartitionIndex: 1, Offset: 0}}},
+				{Name: "topic-c", Partitions: []deleteRecordsPartition{{PartitionIndex: 0, Offset: 0}}},
+			},
+		}
+
+		response := testHandlerRequest[*deleteRecordsResponse](t, tl, ds, deleteRecords, ApiKeyDeleteRecords, version, request)
+
+		// deleting again before the same offset leaves the log as it is
+		rtopics := response.Topics
+		if p := rtopics[0].Partitions[0]; p.ErrorCode != 0 || p.LowWatermark != 3 {
+			t.Errorf("v%d: unexpected partition response %+v", version, p)
+		}
+		if p := rtopics[0].Partitions[1]; p.ErrorCode != int16(UnknownTopicOrPartition) || p.LowWatermark != -1 {
+			t.Errorf("v%d: expected unknown partition %+v", version, p)
+		}
+		if p := rtopics[1].Partitions[0]; p.ErrorCode != int16(PolicyViolation) {
+			t.Errorf("v%d: expected policy violation %+v", version, p)
+		}
+	}
+
+	if _, ec := ds.deleteRecordsBefore("topic-a", 0, 6); ec != OffsetOutOfRange {
+		t.Errorf("expected offset out of range, got %d", ec)
+	}
+	if lw, ec := ds.deleteRecordsBefore("topic-a", 0, kDeleteToHighWatermark); ec != NoError || lw != 5 || len(kp.Records) != 0 {
+		t.Errorf("unexpected delete to the high watermark %d: %d", lw, ec)
+	}
+}
+
+func TestKafkaDeleteRecordsBefore(t *testing.T) {
+	topics := []string{"topic-a"}
+	tl, mock := testCreateKafkaMockServer(t, 21001, topics)
+	defer testStopMockServer(t, mock)
+
+	for i := 0; i < 5; i++ {
+		mock.SimplePost("topic-a", 0, nil, []byte{byte('0' + i)})
+	}
+	if lw, err := mock.DeleteRecordsBefore("topic-a", 0, 3); err != nil || lw != 3 {
+		t.Fatalf("unexpected delete records %d: %v", lw, err)
+	}
+	if _, err := mock.DeleteRecordsBefore("topic-b", 0, 3); err == nil {
+		t.Error("expected an unknown topic error")
+	}
+
+	// the log start moved, and a consumer positioned before it gets an error
+	conn := testDialLeader(t, 21001, "topic-a", 0)
+	defer conn.Close()
+	if first, last, err := conn.ReadOffsets(); err != nil || first != 3 || last != 5 {
+		t.Errorf("unexpected offsets %d-%d: %v", first, last, err)
+	}
+	conn.Seek(1, kafka.SeekAbsolute|kafka.SeekDontCheck)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.ReadMessage(1000); !errors.Is(err, kafka.OffsetOutOfRange) {
+		t.Errorf("expected offset out of range, got %v", err)
+	}
+
+	// a group that committed an offset that was deleted resumes at the log start
+	mock.SetConsumerGroupOffset("topic-a", 0, "kafka-mock", 1)
+	r := testKafkaConnect(t, 21001, topics)
+	defer testCloseKafkaReader(t, tl, r)
+	defer mock.FinishRequests()
+
+	m, err := r.FetchMessage(tl)
+	if err != nil {
+		t.Fatalf("read message error: %v", err)
+	}
+	if m.Offset != 3 || string(m.Value) != "3" {
+		t.Errorf("unexpected message %d %s", m.Offset, m.Value)
+	}
+}
@@ -0,0 +1,88 @@
+package kafkamock
+
+import (
+	"bufio"
+
+	"github.com/google/uuid"
+)
+
+type (
+	deleteTopicsRequest struct {
+		TopicNames []string            `kafka:"max=5"`
+		Topics     []deleteTopicsTopic `kafka:"min=6"`
+		TimeoutMs  int32
+	}
+
+	deleteTopicsTopic struct {
+		Name    NullableString
+		TopicId uuid.UUID
+	}
+
+	deleteTopicsResponse struct {
+		ThrottleTimeMs int32 `kafka:"min=1"`
+		Responses      []deleteTopicsResponseTopic
+	}
+
+	deleteTopicsResponseTopic struct {
+		Name         NullableString
+		TopicId      uuid.UUID `kafka:"min=6"`
+		ErrorCode    int16
+		ErrorMessage NullableString `kafka:"min=5"`
+	}
+)
+
+func deleteTopics(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeader) (response any, rtags map[int]any, err error) {
+	request, err := readVersionedRequest[deleteTopicsRequest](reader, kmh)
+	if err != nil {
+		return
+	}
+
+	// before v6, topics are only named; later they may be given by name or by id
+	topics := request.Topics
+	for i := range request.TopicNames {
+		topics = append(topics, deleteTopicsTopic{Name: &request.TopicNames[i]})
+	}
+
+	dtr := &deleteTopicsResponse{Responses: make([]deleteTopicsResponseTopic, 0, len(topics))}
+	for _, topic := range topics {
+		rtopic := deleteTopicsResponseTopic{Name: topic.Name, TopicId: topic.TopicId}
+
+		var kt *kafkaTopic
+		if topic.Name != nil {
+			kt = kc.ds.getTopic(*topic.Name)
+		} else {
+			kt = kc.ds.getTopicById(topic.TopicId)
+		}
+
+		var ec kafkaErrorCode
+		var message string
+		if kt != nil && !kc.authorized(aclOpDelete, aclResourceTopic, kt.Name) {
+			ec, message = TopicAuthorizationFailed, "topic "+kt.Name+" authorization failed"
+		} else if kt == nil && topic.Name != nil && !kc.authorized(aclOpDelete, aclResourceTopic, *topic.Name) {
+			ec, message = TopicAuthorizationFailed, "topic "+*topic.Name+" authorization failed"
+		} else if kt == nil {
+			if topic.Name != nil {
+				ec, message = UnknownTopicOrPartition, "topic "+*topic.Name+" does not exist"
+			} else {
+				ec, message = UnknownTopicId, "topic id "+topic.TopicId.String()+" does not exist"
+			}
+		} else {
+			rtopic.Name = &kt.Name
+			rtopic.TopicId = kt.Id
+			if !kc.ds.deleteTopic(kt.Name) {
+				ec, message = UnknownTopicOrPartition, "topic "+kt.Name+" does not exist"
+			} else {
+				kc.l.Tracef("topic %s deleted", kt.Name)
+			}
+		}
+
+		rtopic.ErrorCode = int16(ec)
+		if ec != NoError {
+			rtopic.ErrorMessage = &message
+		}
+		dtr.Responses = append(dtr.Responses, rtopic)
+	}
+
+	response = dtr
+	return
+}
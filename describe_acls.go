@@ -0,0 +1,106 @@
+package kafkamock
+
+import (
+	"bufio"
+)
+
+type (
+	describeAclsRequest struct {
+		ResourceTypeFilter        int8
+		ResourceNameFilter        NullableString
+		ResourcePatternTypeFilter int8 `kafka:"min=1"`
+		PrincipalFilter           NullableString
+		HostFilter                NullableString
+		Operation                 int8
+		PermissionType            int8
+	}
+
+	describeAclsResponse struct {
+		ThrottleTimeMs int32
+		ErrorCode      int16
+		ErrorMessage   NullableString
+		Resources      []describeAclsResource
+	}
+
+	describeAclsResource struct {
+		ResourceType int8
+		ResourceName string
+		PatternType  int8 `kafka:"min=1"`
+		Acls         []describeAclsAcl
+	}
+
+	describeAclsAcl struct {
+		Principal      string
+		Host           string
+		Operation      int8
+		PermissionType int8
+	}
+
+	// the resource pattern that acls are grouped by
+	kafkaAclResource struct {
+		resourceType kafkaAclResourceType
+		resourceName string
+		patternType  kafkaAclPatternType
+	}
+)
+
+func describeAcls(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeader) (response any, rtags map[int]any, err error) {
+	request, err := readVersionedRequest[describeAclsRequest](reader, kmh)
+	if err != nil {
+		return
+	}
+
+	filter := kafkaAclFilter{
+		resourceType: kafkaAclResourceType(request.ResourceTypeFilter),
+		resourceName: request.ResourceNameFilter,
+		patternType:  kafkaAclPatternType(request.ResourcePatternTypeFilter),
+		principal:    request.PrincipalFilter,
+		host:         request.HostFilter,
+		operation:    kafkaAclOperation(request.Operation),
+		permission:   kafkaAclPermission(request.PermissionType),
+	}
+	if kmh.RequestApiVersion < 1 {
+		filter.patternType = aclPatternLiteral
+	}
+
+	dar := &describeAclsResponse{Resources: []describeAclsResource{}}
+	var message string
+	if !kc.ds.Acls.isEnabled() {
+		dar.ErrorCode, message = int16(SecurityDisabled), "no authorizer is configured on the broker"
+	} else if !kc.authorized(aclOpDescribe, aclResourceCluster, kClusterResourceName) {
+		dar.ErrorCode, message = int16(ClusterAuthorizationFailed), "cluster authorization failed"
+	} else if reason := filter.validate(); reason != "" {
+		dar.ErrorCode, message = int16(InvalidRequest), reason
+	} else {
+		// the matching acls are listed under their resource patterns, in order of creation
+		indexes := map[kafkaAclResource]int{}
+		for _, acl := range kc.ds.Acls.describeAcls(&filter) {
+			key := kafkaAclResource{resourceType: acl.resourceType, resourceName: acl.resourceName, patternType: acl.patternType}
+			n, exists := indexes[key]
+			if !exists {
+				n = len(dar.Resources)
+				indexes[key] = n
+				dar.Resources = append(dar.Resources, describeAclsResource{
+					ResourceType: int8(acl.resourceType),
+					ResourceName: acl.resourceName,
+					PatternType:  int8(acl.patternType),
+					Acls:         []describeAclsAcl{},
+				})
+			}
+			dar.Resources[n].Acls = append(dar.Resources[n].Acls, describeAclsAcl{
+				Principal:      acl.principal,
+				Host:           acl.host,
+				Operation:      int8(acl.operation),
+				PermissionType: int8(acl.permission),
+			})
+		}
+	}
+
+	if dar.ErrorCode != int16(NoError) {
+		dar.ErrorMessage = &message
+		kc.l.Tracef("describe acls failed: %s", message)
+	}
+
+	response = dar
+	return
+}
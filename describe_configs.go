@@ -0,0 +1,133 @@
+package kafkamock
+
+import (
+	"bufio"
+	"slices"
+)
+
+type (
+	describeConfigsRequest struct {
+		Resources            []describeConfigsResource
+		IncludeSynonyms      bool `kafka:"min=1"`
+		IncludeDocumentation bool `kafka:"min=3"`
+	}
+
+	describeConfigsResource struct {
+		ResourceType int8
+		ResourceName string
+		ConfigNames  []string
+	}
+
+	describeConfigsResponse struct {
+		ThrottleTimeMs int32
+		Results        []describeConfigsResult
+	}
+
+	describeConfigsResult struct {
+		ErrorCode    int16
+		ErrorMessage NullableString
+		ResourceType int8
+		ResourceName string
+		Configs      []describeConfigsResultConfig
+	}
+
+	describeConfigsResultConfig struct {
+		Name          string
+		Value         NullableString
+		ReadOnly      bool
+		IsDefault     bool `kafka:"max=0"`
+		ConfigSource  int8 `kafka:"min=1"`
+		IsSensitive   bool
+		Synonyms      []describeConfigsSynonym `kafka:"min=1"`
+		ConfigType    int8                     `kafka:"min=3"`
+		Documentation NullableString           `kafka:"min=3"`
+	}
+
+	describeConfigsSynonym struct {
+		Name   string
+		Value  NullableString
+		Source int8
+	}
+)
+
+func describeConfigs(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeader) (response any, rtags map[int]any, err error) {
+	request, err := readVersionedRequest[describeConfigsRequest](reader, kmh)
+	if err != nil {
+		return
+	}
+
+	dcr := &describeConfigsResponse{Results: make([]describeConfigsResult, 0, len(request.Resources))}
+	for _, resource := range request.Resources {
+		result := describeConfigsResult{
+			ResourceType: resource.ResourceType,
+			ResourceName: resource.ResourceName,
+			Configs:      []describeConfigsResultConfig{},
+		}
+
+		var entries []*kafkaConfigEntry
+		ec := kc.configAuthorizationError(aclOpDescribeConfigs, kafkaResourceType(resource.ResourceType), resource.ResourceName)
+		var message string
+		switch {
+		case ec != NoError:
+			message = "authorization failed"
+
+		case kafkaResourceType(resource.ResourceType) == resourceTopic:
+			kt := kc.ds.getTopic(resource.ResourceName)
+			if kt == nil {
+				ec, message = UnknownTopicOrPartition, "topic "+resource.ResourceName+" does not exist"
+			} else {
+				entries = kc.ds.describeTopicConfigs(kt)
+			}
+
+		case kafkaResourceType(resource.ResourceType) == resourceBroker:
+			if key, ok := kc.ds.brokerConfigKey(resource.ResourceName); !ok {
+				ec, message = InvalidRequest, "unexpected broker id "+resource.ResourceName
+			} else {
+				entries = kc.ds.describeBrokerConfigs(key)
+			}
+
+		default:
+			ec, message = InvalidRequest, "unsupported resource type"
+		}
+
+		for _, entry := range entries {
+			if resource.ConfigNames != nil && !slices.Contains(resource.ConfigNames, entry.def.name) {
+				continue
+			}
+			result.Configs = append(result.Configs, describeConfigEntry(entry, request.IncludeSynonyms, request.IncludeDocumentation))
+		}
+
+		result.ErrorCode = int16(ec)
+		if ec != NoError {
+			result.ErrorMessage = &message
+			kc.l.Tracef("describe configs of %s failed: %s", resource.ResourceName, message)
+		}
+		dcr.Results = append(dcr.Results, result)
+	}
+
+	response = dcr
+	return
+}
+
+func describeConfigEntry(entry *kafkaConfigEntry, includeSynonyms, includeDocumentation bool) describeConfigsResultConfig {
+	value := entry.value
+	config := describeConfigsResultConfig{
+		Name:         entry.def.name,
+		Value:        &value,
+		ReadOnly:     entry.def.readOnly,
+		IsDefault:    entry.source == configSourceDefault,
+		ConfigSource: int8(entry.source),
+		Synonyms:     []describeConfigsSynonym{},
+		ConfigType:   int8(entry.def.configType),
+	}
+	if includeSynonyms {
+		for _, synonym := range entry.synonyms {
+			value := synonym.value
+			config.Synonyms = append(config.Synonyms, describeConfigsSynonym{Name: synonym.name, Value: &value, Source: int8(synonym.source)})
+		}
+	}
+	if includeDocumentation {
+		config.Documentation = &entry.def.documentation
+	}
+	return config
+}
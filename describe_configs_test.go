@@ -0,0 +1,179 @@
+package kafkamock
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/jimsnab/go-lane"
+	"github.com/segmentio/kafka-go"
+)
+
+func testDescribeConfigs(t *testing.T, tl lane.TestingLane, client *kafka.Client, resourceType kafka.ResourceType, name string) map[string]kafka.DescribeConfigResponseConfigEntry {
+	resp, err := client.DescribeConfigs(tl, &kafka.DescribeConfigsRequest{
+		Resources:       []kafka.DescribeConfigRequestResource{{ResourceType: resourceType, ResourceName: name}},
+		IncludeSynonyms: true,
+	})
+	if err != nil {
+		t.Fatalf("describe configs error: %v", err)
+	}
+	if len(resp.Resources) != 1 || resp.Resources[0].Error != nil {
+		t.Fatalf("unexpected describe configs response %+v", resp.Resources)
+	}
+
+	entries := map[string]kafka.DescribeConfigResponseConfigEntry{}
+	for _, entry := range resp.Resources[0].ConfigEntries {
+		entries[entry.ConfigName] = entry
+	}
+	return entries
+}
+
+func TestKafkaDescribeConfigs(t *testing.T) {
+	tl, mock := testCreateKafkaMockServer(t, 21001, []string{"topic-a"})
+	defer testStopMockServer(t, mock)
+
+	if err := mock.SetTopicConfigs("topic-a", map[string]string{"cleanup.policy": "compact"}); err != nil {
+		t.Fatalf("set topic configs error: %v", err)
+	}
+	if err := mock.SetBrokerConfigs(map[string]string{"log.retention.ms": "5000"}); err != nil {
+		t.Fatalf("set broker configs error: %v", err)
+	}
+	if err := mock.SetTopicConfigs("topic-a", map[string]string{"retention.ms": "soon"}); err == nil {
+		t.Error("expected an invalid config error")
+	}
+	if err := mock.SetTopicConfigs("topic-b", map[string]string{"retention.ms": "1"}); err == nil {
+		t.Error("expected an unknown topic error")
+	}
+
+	client := testKafkaClient(21001)
+	entries := testDescribeConfigs(t, tl, client, kafka.ResourceTypeTopic, "topic-a")
+
+	if e := entries["cleanup.policy"]; e.ConfigValue != "compact" || e.ConfigSource != int8(configSourceDynamicTopic) || e.IsDefault {
+		t.Errorf("unexpected cleanup policy %+v", e)
+	}
+
+	// the broker setting is inherited by the topic
+	if e := entries["retention.ms"]; e.ConfigValue != "5000" || e.ConfigSource != int8(configSourceDynamicBroker) {
+		t.Errorf("unexpected retention %+v", e)
+	}
+
+	// kafka-go drops the synonyms flag for topics, so the synonyms are checked in the store
+	for _, entry := range mock.ds.describeTopicConfigs(mock.ds.getTopic("topic-a")) {
+		if entry.def.name != "retention.ms" {
+			continue
+		}
+		expected := []kafkaConfigSynonym{
+			{name: "log.retention.ms", value: "5000", source: configSourceDynamicBroker},
+			{name: "log.retention.ms", value: "604800000", source: configSourceDefault},
+		}
+		if !reflect.DeepEqual(entry.synonyms, expected) {
+			t.Errorf("unexpected retention synonyms %+v", entry.synonyms)
+		}
+	}
+
+	if e := entries["max.message.bytes"]; e.ConfigValue != "1048588" || e.ConfigSource != int8(configSourceDefault) {
+		t.Errorf("unexpected max message bytes %+v", e)
+	}
+
+	broker := testDescribeConfigs(t, tl, client, kafka.ResourceTypeBroker, "100")
+	if e := broker["broker.id"]; e.ConfigValue != "100" || !e.ReadOnly {
+		t.Errorf("unexpected broker id %+v", e)
+	}
+	if e := broker["log.retention.ms"]; e.ConfigValue != "5000" || e.ConfigSource != int8(configSourceDynamicBroker) {
+		t.Errorf("unexpected broker retention %+v", e)
+	}
+
+	resp, err := client.DescribeConfigs(tl, &kafka.DescribeConfigsRequest{
+		Resources: []kafka.DescribeConfigRequestResource{{ResourceType: kafka.ResourceTypeTopic, ResourceName: "topic-b"}},
+	})
+	if err != nil {
+		t.Fatalf("describe configs error: %v", err)
+	}
+	if !errors.Is(resp.Resources[0].Error, kafka.UnknownTopicOrPartition) {
+		t.Errorf("expected unknown topic, got %v", resp.Resources[0].Error)
+	}
+}
+
+func TestKafkaAlterConfigs(t *testing.T) {
+	tl, mock := testCreateKafkaMockServer(t, 21001, []string{"topic-a"})
+	defer testStopMockServer(t, mock)
+	mock.SetTopicConfigs("topic-a", map[string]string{"cleanup.policy": "compact"})
+
+	client := testKafkaClient(21001)
+	resp, err := client.AlterConfigs(tl, &kafka.AlterConfigsRequest{
+		Resources: []kafka.AlterConfigRequestResource{
+			{ResourceType: kafka.ResourceTypeTopic, ResourceName: "topic-a", Configs: []kafka.AlterConfigRequestConfig{{Name: "retention.ms", Value: "1000"}}},
+			{ResourceType: kafka.ResourceTypeTopic, ResourceName: "topic-b", Configs: []kafka.AlterConfigRequestConfig{{Name: "retention.ms", Value: "1000"}}},
+			{ResourceType: kafka.ResourceTypeBroker, ResourceName: "100", Configs: []kafka.AlterConfigRequestConfig{{Name: "broker.id", Value: "1"}}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("alter configs error: %v", err)
+	}
+	expected := map[string]error{"topic-a": nil, "topic-b": kafka.UnknownTopicOrPartition, "100": kafka.InvalidRequest}
+	for resource, err := range resp.Errors {
+		if !errors.Is(err, expected[resource.Name]) {
+			t.Errorf("unexpected alter result for %s: %v", resource.Name, err)
+		}
+	}
+
+	// the configs that weren't listed are removed
+	entries := testDescribeConfigs(t, tl, client, kafka.ResourceTypeTopic, "topic-a")
+	if e := entries["retention.ms"]; e.ConfigValue != "1000" || e.ConfigSource != int8(configSourceDynamicTopic) {
+		t.Errorf("unexpected retention %+v", e)
+	}
+	if e := entries["cleanup.policy"]; e.ConfigValue != "delete" || e.ConfigSource != int8(configSourceDefault) {
+		t.Errorf("unexpected cleanup policy %+v", e)
+	}
+}
+
+func TestKafkaIncrementalAlterConfigs(t *testing.T) {
+	tl, mock := testCreateKafkaMockServer(t, 21001, []string{"topic-a"})
+	defer testStopMockServer(t, mock)
+	mock.SetTopicConfigs("topic-a", map[string]string{"retention.ms": "1000", "max.message.bytes": "2048"})
+
+	alter := func(validateOnly bool, configs ...kafka.IncrementalAlterConfigsRequestConfig) error {
+		resp, err := testKafkaClient(21001).IncrementalAlterConfigs(tl, &kafka.IncrementalAlterConfigsRequest{
+			Resources:    []kafka.IncrementalAlterConfigsRequestResource{{ResourceType: kafka.ResourceTypeTopic, ResourceName: "topic-a", Configs: configs}},
+			ValidateOnly: validateOnly,
+		})
+		if err != nil {
+			t.Fatalf("incremental alter configs error: %v", err)
+		}
+		return resp.Resources[0].Error
+	}
+
+	err := alter(false,
+		kafka.IncrementalAlterConfigsRequestConfig{Name: "cleanup.policy", Value: "compact", ConfigOperation: kafka.ConfigOperationAppend},
+		kafka.IncrementalAlterConfigsRequestConfig{Name: "retention.ms", ConfigOperation: kafka.ConfigOperationDelete},
+		kafka.IncrementalAlterConfigsRequestConfig{Name: "message.timestamp.type", Value: "LogAppendTime", ConfigOperation: kafka.ConfigOperationSet},
+	)
+	if err != nil {
+		t.Fatalf("incremental alter error: %v", err)
+	}
+
+	if err = alter(false, kafka.IncrementalAlterConfigsRequestConfig{Name: "retention.ms", Value: "1", ConfigOperation: kafka.ConfigOperationAppend}); !errors.Is(err, kafka.InvalidConfiguration) {
+		t.Errorf("expected invalid config for append to a scalar, got %v", err)
+	}
+	if err = alter(false, kafka.IncrementalAlterConfigsRequestConfig{Name: "no.such.config", Value: "1"}); !errors.Is(err, kafka.InvalidConfiguration) {
+		t.Errorf("expected invalid config for an unknown name, got %v", err)
+	}
+	if err = alter(true, kafka.IncrementalAlterConfigsRequestConfig{Name: "max.message.bytes", Value: "4096"}); err != nil {
+		t.Errorf("validate only error: %v", err)
+	}
+
+	// the append starts from the inherited value
+	entries := testDescribeConfigs(t, tl, testKafkaClient(21001), kafka.ResourceTypeTopic, "topic-a")
+	if e := entries["cleanup.policy"]; e.ConfigValue != "delete,compact" || e.ConfigSource != int8(configSourceDynamicTopic) {
+		t.Errorf("unexpected cleanup policy %+v", e)
+	}
+	if e := entries["retention.ms"]; e.ConfigSource != int8(configSourceDefault) {
+		t.Errorf("unexpected retention %+v", e)
+	}
+	if e := entries["message.timestamp.type"]; e.ConfigValue != "LogAppendTime" {
+		t.Errorf("unexpected timestamp type %+v", e)
+	}
+	if e := entries["max.message.bytes"]; e.ConfigValue != "2048" {
+		t.Errorf("validate only changed max message bytes %+v", e)
+	}
+}
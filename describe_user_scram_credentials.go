@@ -0,0 +1,74 @@
+package kafkamock
+
+import (
+	"bufio"
+)
+
+type (
+	describeUserScramCredentialsRequest struct {
+		Users []describeUserScramCredentialsUser
+	}
+
+	describeUserScramCredentialsUser struct {
+		Name string
+	}
+
+	describeUserScramCredentialsResponse struct {
+		ThrottleTimeMs int32
+		ErrorCode      int16
+		ErrorMessage   NullableString
+		Results        []describeUserScramCredentialsResult
+	}
+
+	describeUserScramCredentialsResult struct {
+		User            string
+		ErrorCode       int16
+		ErrorMessage    NullableString
+		CredentialInfos []describeUserScramCredentialsInfo
+	}
+
+	describeUserScramCredentialsInfo struct {
+		Mechanism  int8
+		Iterations int32
+	}
+)
+
+func describeUserScramCredentials(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeader) (response any, rtags map[int]any, err error) {
+	request, err := readVersionedRequest[describeUserScramCredentialsRequest](reader, kmh)
+	if err != nil {
+		return
+	}
+
+	if ec := kc.authorizationError(aclOpDescribe, aclResourceCluster, kClusterResourceName); ec != NoError {
+		message := "cluster authorization failed"
+		response = &describeUserScramCredentialsResponse{ErrorCode: int16(ec), ErrorMessage: &message, Results: []describeUserScramCredentialsResult{}}
+		return
+	}
+
+	// no users describes them all
+	users := make([]string, 0, len(request.Users))
+	for _, user := range request.Users {
+		users = append(users, user.Name)
+	}
+
+	described := kc.ds.describeScramCredentials(users)
+	results := make([]describeUserScramCredentialsResult, 0, len(described))
+	for _, d := range described {
+		result := describeUserScramCredentialsResult{
+			User:            d.user,
+			ErrorCode:       int16(d.ec),
+			CredentialInfos: []describeUserScramCredentialsInfo{},
+		}
+		if d.ec != NoError {
+			message := d.message
+			result.ErrorMessage = &message
+		}
+		for _, kind := range sortedScramKinds(d.credentials) {
+			result.CredentialInfos = append(result.CredentialInfos, describeUserScramCredentialsInfo{Mechanism: kind, Iterations: int32(d.credentials[kind])})
+		}
+		results = append(results, result)
+	}
+
+	response = &describeUserScramCredentialsResponse{Results: results}
+	return
+}
@@ -45,10 +45,15 @@ type (
 )
 
 func encodeObject(writer *bufio.Writer, obj any) {
-	encodeObjectWorker(writer, reflect.TypeOf(obj), obj, false, false)
+	encodeObjectWorker(writer, reflect.TypeOf(obj), obj, false, false, unversioned)
 }
 
-func encodeObjectWorker(writer *bufio.Writer, tt reflect.Type, obj any, nullable, compact bool) {
+// encodes an object as it is laid out for a specific api version
+func encodeVersionedObject(writer *bufio.Writer, obj any, cv codecVersion) {
+	encodeObjectWorker(writer, reflect.TypeOf(obj), obj, false, false, cv)
+}
+
+func encodeObjectWorker(writer *bufio.Writer, tt reflect.Type, obj any, nullable, compact bool, cv codecVersion) {
 	switch tt.Kind() {
 	case reflect.Bool:
 		encodeBool(writer, obj.(bool))
@@ -109,28 +114,27 @@ func encodeObjectWorker(writer *bufio.Writer, tt reflect.Type, obj any, nullable
 	case reflect.String:
 		if tt.Name() == "CompactString" {
 			encodeCompactString(writer, obj.(CompactString))
-		} else if compact {
+		} else if compact || cv.flexible {
 			if nullable {
 				if obj == nil {
 					encodeCompactNullableString(writer, nil)
 				} else {
-					s := obj.(*string)
-					s2 := CompactString(*s)
-					encodeCompactNullableString(writer, &s2)
+					s := CompactString(reflect.ValueOf(obj).String())
+					encodeCompactNullableString(writer, &s)
 				}
 			} else {
-				encodeCompactString(writer, CompactString(obj.(string)))
+				encodeCompactString(writer, CompactString(reflect.ValueOf(obj).String()))
 			}
 		} else {
 			if nullable {
 				if obj == nil {
 					encodeNullableString(writer, nil)
 				} else {
-					s := obj.(string)
+					s := reflect.ValueOf(obj).String()
 					encodeNullableString(writer, NullableString(&s))
 				}
 			} else {
-				encodeString(writer, obj.(string))
+				encodeString(writer, reflect.ValueOf(obj).String())
 			}
 		}
 	case reflect.Struct:
@@ -138,60 +142,71 @@ func encodeObjectWorker(writer *bufio.Writer, tt reflect.Type, obj any, nullable
 			msv1 := obj.(messageSetV1)
 			encodeMessageSetV1(writer, &msv1)
 		} else {
-			encodeStruct(writer, reflect.ValueOf(obj))
+			encodeStruct(writer, reflect.ValueOf(obj), cv)
 		}
 	case reflect.Slice:
 		et := tt.Elem()
 		if et.Kind() == reflect.Uint8 {
-			// byte slice
-			if compact {
-				if nullable {
-					encodeCompactNullableBytes(writer, obj.([]byte))
-				} else {
-					encodeCompactBytes(writer, obj.([]byte))
-				}
+			// byte slice - nil is always coded as null
+			data := reflect.ValueOf(obj).Bytes()
+			if compact || cv.flexible || tt.Name() == "CompactNullableBytes" || tt.Name() == "CompactBytes" {
+				encodeCompactNullableBytes(writer, data)
 			} else {
-				if nullable {
-					encodeNullableBytes(writer, obj.([]byte))
-				} else if tt.Name() == "NullableBytes" {
-					encodeNullableBytes(writer, obj.(NullableBytes))
-				} else if tt.Name() == "CompactNullableBytes" {
-					encodeCompactNullableBytes(writer, obj.(CompactNullableBytes))
-				} else if tt.Name() == "CompactBytes" {
-					encodeCompactBytes(writer, obj.(CompactBytes))
-				} else {
-					encodeBytes(writer, obj.([]byte))
-				}
+				encodeNullableBytes(writer, data)
 			}
 		} else {
 			// object slice
-			if compact {
-				encodeCompactArray(writer, reflect.ValueOf(obj))
+			if compact || cv.flexible {
+				encodeCompactArray(writer, reflect.ValueOf(obj), cv)
 			} else {
-				encodeArray(writer, reflect.ValueOf(obj))
+				encodeArray(writer, reflect.ValueOf(obj), cv)
 			}
 		}
 	case reflect.Pointer:
 		if tt.Name() == "CompactNullableString" {
 			encodeCompactNullableString(writer, obj.(CompactNullableString))
 		} else if tt.Name() == "NullableString" {
-			encodeNullableString(writer, obj.(NullableString))
+			if cv.flexible {
+				var cs CompactNullableString
+				if ns := obj.(NullableString); ns != nil {
+					s := CompactString(*ns)
+					cs = &s
+				}
+				encodeCompactNullableString(writer, cs)
+			} else {
+				encodeNullableString(writer, obj.(NullableString))
+			}
 		} else if tt.Name() == "messageSetV1" {
 			// message sets deviate from the normal wire data protocol
 			encodeMessageSetV1(writer, obj.(*messageSetV1))
+		} else if tt.Elem().Name() == "recordSetV2" {
+			// so do record batches
+			encodeRecordSetV2(writer, obj.(*recordSetV2), cv)
+		} else if nullable && tt.Elem().Kind() == reflect.Struct {
+			// a nullable struct is preceded by a presence marker
+			v := reflect.ValueOf(obj)
+			if v.IsNil() {
+				encodeInt8(writer, -1)
+			} else {
+				encodeInt8(writer, 1)
+				encodeStruct(writer, v.Elem(), cv)
+			}
 		} else {
 			v := reflect.ValueOf(obj)
 			if v.Kind() > 0 {
 				if v.IsNil() {
-					encodeObjectWorker(writer, targetType(obj), nil, true, compact)
+					encodeObjectWorker(writer, targetType(obj), nil, true, compact, cv)
 				} else {
 					target := reflect.Indirect(v)
-					encodeObjectWorker(writer, target.Type(), target.Interface(), true, compact)
+					encodeObjectWorker(writer, target.Type(), target.Interface(), true, compact, cv)
 				}
 			} else {
 				panic(fmt.Sprintf("unsupported pointer type %T", obj))
 			}
 		}
+	case reflect.Interface:
+		// a field that holds one of several types is coded by its content
+		encodeObjectWorker(writer, reflect.TypeOf(obj), obj, nullable, compact, cv)
 	default:
 		switch tt.Name() {
 		case "UUID":
@@ -230,18 +245,55 @@ func targetType(obj any) reflect.Type {
 	panic(fmt.Sprintf("unsupported target type %T", obj))
 }
 
-func encodeStruct(writer *bufio.Writer, v reflect.Value) {
+func encodeStruct(writer *bufio.Writer, v reflect.Value, cv codecVersion) {
+	tt := v.Type()
+	opts := structFieldOptions(tt)
 	for i := 0; i < v.NumField(); i++ {
-		tt := v.Type()
-		tf := tt.Field(i)
-
-		nullable, compact := kafkaTags(tf)
+		if !cv.includes(&opts[i]) || opts[i].tag >= 0 {
+			continue
+		}
 
 		f := v.Field(i)
 		if f.CanInterface() {
-			encodeObjectWorker(writer, f.Type(), f.Interface(), nullable, compact)
+			encodeObjectWorker(writer, f.Type(), f.Interface(), opts[i].nullable, opts[i].compact, cv)
 		}
 	}
+
+	if cv.flexible {
+		encodeTaggedFields(writer, v, cv)
+	}
+}
+
+// writes the tagged fields that end each structure of a flexible version;
+// fields holding their zero value are left out
+func encodeTaggedFields(writer *bufio.Writer, v reflect.Value, cv codecVersion) {
+	tt := v.Type()
+	opts := structFieldOptions(tt)
+
+	ids := []int{}
+	fields := map[int]int{}
+	for i := range opts {
+		if opts[i].tag >= 0 && cv.includes(&opts[i]) && !v.Field(i).IsZero() {
+			ids = append(ids, opts[i].tag)
+			fields[opts[i].tag] = i
+		}
+	}
+	sort.Ints(ids)
+
+	encodeVarUint(writer, VarUint(len(ids)))
+	for _, id := range ids {
+		i := fields[id]
+		f := v.Field(i)
+
+		var buf bytes.Buffer
+		w := bufio.NewWriter(&buf)
+		encodeObjectWorker(w, f.Type(), f.Interface(), opts[i].nullable, opts[i].compact, cv)
+		w.Flush()
+
+		encodeVarUint(writer, VarUint(id))
+		encodeVarUint(writer, VarUint(buf.Len()))
+		writer.Write(buf.Bytes())
+	}
 }
 
 func encodeBool(writer *bufio.Writer, v bool) {
@@ -392,9 +444,9 @@ func encodeNullableString(writer *bufio.Writer, v NullableString) {
 
 func encodeCompactNullableString(writer *bufio.Writer, v CompactNullableString) {
 	if v == nil {
-		encodeVarInt(writer, 0)
+		encodeVarUint(writer, 0)
 	} else {
-		encodeVarInt(writer, VarInt(len(*v)+1))
+		encodeVarUint(writer, VarUint(len(*v)+1))
 		writer.Write([]byte(*v))
 	}
 }
@@ -418,14 +470,14 @@ func encodeNullableBytes(writer *bufio.Writer, v NullableBytes) {
 
 func encodeCompactNullableBytes(writer *bufio.Writer, v CompactNullableBytes) {
 	if v == nil {
-		encodeVarInt(writer, 0)
+		encodeVarUint(writer, 0)
 	} else {
-		encodeVarInt(writer, VarInt(len(v)+1))
+		encodeVarUint(writer, VarUint(len(v)+1))
 		writer.Write(v)
 	}
 }
 
-func encodeArray(writer *bufio.Writer, v reflect.Value) {
+func encodeArray(writer *bufio.Writer, v reflect.Value, cv codecVersion) {
 	if v.IsNil() {
 		encodeInt32(writer, -1)
 	} else {
@@ -433,20 +485,20 @@ func encodeArray(writer *bufio.Writer, v reflect.Value) {
 
 		for i := 0; i < v.Len(); i++ {
 			f := v.Index(i)
-			encodeObject(writer, f.Interface())
+			encodeVersionedObject(writer, f.Interface(), cv)
 		}
 	}
 }
 
-func encodeCompactArray(writer *bufio.Writer, v reflect.Value) {
+func encodeCompactArray(writer *bufio.Writer, v reflect.Value, cv codecVersion) {
 	if v.IsNil() {
-		encodeVarInt(writer, 0)
+		encodeVarUint(writer, 0)
 	} else {
-		encodeVarInt(writer, VarInt(v.Len())+1)
+		encodeVarUint(writer, VarUint(v.Len())+1)
 
 		for i := 0; i < v.Len(); i++ {
 			f := v.Index(i)
-			encodeObject(writer, f.Interface())
+			encodeVersionedObject(writer, f.Interface(), cv)
 		}
 	}
 }
@@ -490,9 +542,19 @@ func newMessageSetV1(offset int64) *messageSetV1 {
 	}
 }
 
-func (msv1 *messageSetV1) appendMessage(record *kafkaRecord, maxSize int) bool {
+func (msv1 *messageSetV1) size() int {
+	return msv1.totalSize
+}
+
+func (msv1 *messageSetV1) appendRecord(record *kafkaRecord, offset int64, maxSize int) bool {
+	if record.isControl() {
+		// the legacy format has no transaction markers, so consumers skip over them
+		msv1.offset = offset + 1
+		return true
+	}
+
 	mv1 := messageV1{
-		Offset:     msv1.offset,
+		Offset:     offset,
 		MagicByte:  1,
 		Attributes: 0,
 		Timestamp:  record.Timestamp,
@@ -508,7 +570,7 @@ func (msv1 *messageSetV1) appendMessage(record *kafkaRecord, maxSize int) bool {
 	}
 
 	msv1.totalSize += int(mv1.MessageSize)
-	msv1.offset++
+	msv1.offset = offset + 1
 
 	msv1.msgs = append(msv1.msgs, mv1)
 	return true
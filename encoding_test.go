@@ -60,6 +60,27 @@ type (
 	compactNullableBytes struct {
 		A []byte `kafka:"compact"`
 	}
+
+	versionedStruct struct {
+		A int32
+		B string         `kafka:"min=2"`
+		C []int16        `kafka:"max=3"`
+		D NullableString `kafka:"min=1"`
+		E NullableBytes
+		F []versionedValue
+		G int64  `kafka:"tag=0"`
+		H string `kafka:"tag=2,min=5"`
+	}
+
+	versionedValue struct {
+		X string
+		Y int16 `kafka:"min=4"`
+	}
+
+	nullableStruct struct {
+		A *versionedValue `kafka:"nullable"`
+		B int8
+	}
 )
 
 func TestEncodeDecodeBool(t *testing.T) {
@@ -2070,7 +2091,7 @@ func TestEncodeDecodeTagsForeign(t *testing.T) {
 	}
 	expected := map[int]any{
 		1: []byte{0, 4, 116, 101, 115, 116},
-		2: []byte{12, 116, 101, 115, 116, 50},
+		2: []byte{6, 116, 101, 115, 116, 50},
 	}
 	if !reflect.DeepEqual(expected, tg) {
 		t.Error("expected tag match")
@@ -2106,3 +2127,117 @@ func TestEncodeDecodeApiVersions(t *testing.T) {
 	}
 
 }
+
+func testEncodeDecodeVersioned[T any](t *testing.T, ref T, cv codecVersion) (decoded T, size int) {
+	var buf bytes.Buffer
+	writer := bufio.NewWriter(&buf)
+	encodeVersionedObject(writer, ref, cv)
+	encodeVersionedObject(writer, &ref, cv)
+	writer.Flush()
+	size = buf.Len() / 2
+
+	reader := bufio.NewReader(&buf)
+	next, v := peekVersionedObject(reader, 0, reflect.TypeOf(ref), cv)
+	if next != size {
+		t.Fatalf("expected %d bytes, decoded %d", size, next)
+	}
+	decoded = v.(T)
+
+	next, v = peekVersionedObject(reader, next, reflect.TypeOf(ref), cv)
+	if next != size*2 {
+		t.Fatalf("expected second value")
+	}
+	if !reflect.DeepEqual(decoded, v) {
+		t.Error("expected repeat value match")
+	}
+	return
+}
+
+func TestEncodeDecodeVersioned(t *testing.T) {
+	d := "d"
+	ref := versionedStruct{
+		A: 1,
+		B: "b",
+		C: []int16{3, 4},
+		D: &d,
+		E: []byte{5},
+		F: []versionedValue{{X: "x", Y: 6}},
+		G: 7,
+		H: "h",
+	}
+
+	v0, _ := testEncodeDecodeVersioned(t, ref, codecVersion{version: 0})
+	expected := versionedStruct{A: 1, C: []int16{3, 4}, E: []byte{5}, F: []versionedValue{{X: "x"}}}
+	if !reflect.DeepEqual(expected, v0) {
+		t.Errorf("v0 mismatch: %+v", v0)
+	}
+
+	v4, _ := testEncodeDecodeVersioned(t, ref, codecVersion{version: 4})
+	expected = versionedStruct{A: 1, B: "b", D: &d, E: []byte{5}, F: []versionedValue{{X: "x", Y: 6}}}
+	if !reflect.DeepEqual(expected, v4) {
+		t.Errorf("v4 mismatch: %+v", v4)
+	}
+}
+
+func TestEncodeDecodeFlexible(t *testing.T) {
+	d := "d"
+	ref := versionedStruct{
+		A: 1,
+		B: "b",
+		D: &d,
+		E: []byte{5},
+		F: []versionedValue{{X: "x", Y: 6}},
+		G: 7,
+		H: "h",
+	}
+
+	v5, size := testEncodeDecodeVersioned(t, ref, codecVersion{version: 5, flexible: true})
+	if !reflect.DeepEqual(ref, v5) {
+		t.Errorf("v5 mismatch: %+v", v5)
+	}
+
+	// A(4) B(2) D(2) E(2) F(1 + X(2) Y(2) tags(1)) tags(1 + G(2+8) H(2+2))
+	if size != 4+2+2+2+1+2+2+1+1+10+4 {
+		t.Errorf("unexpected flexible size %d", size)
+	}
+
+	// an unknown tag is skipped
+	v4, _ := testEncodeDecodeVersioned(t, ref, codecVersion{version: 4, flexible: true})
+	expected := ref
+	expected.H = ""
+	if !reflect.DeepEqual(expected, v4) {
+		t.Errorf("v4 mismatch: %+v", v4)
+	}
+
+	// null values use the compact encoding
+	ref = versionedStruct{A: 2}
+	v5, size = testEncodeDecodeVersioned(t, ref, codecVersion{version: 5, flexible: true})
+	if !reflect.DeepEqual(ref, v5) {
+		t.Errorf("null mismatch: %+v", v5)
+	}
+	if size != 4+1+1+1+1+1 {
+		t.Errorf("unexpected null size %d", size)
+	}
+}
+
+func TestEncodeDecodeNullableStruct(t *testing.T) {
+	ref := nullableStruct{A: &versionedValue{X: "x", Y: 1}, B: 2}
+	v, size := testEncodeDecodeVersioned(t, ref, codecVersion{version: 4, flexible: true})
+	if !reflect.DeepEqual(ref, v) {
+		t.Errorf("mismatch: %+v", v)
+	}
+
+	// marker(1) X(2) Y(2) tags(1) B(1) tags(1)
+	if size != 1+2+2+1+1+1 {
+		t.Errorf("unexpected size %d", size)
+	}
+
+	ref = nullableStruct{B: 3}
+	v, size = testEncodeDecodeVersioned(t, ref, codecVersion{version: 4, flexible: true})
+	if !reflect.DeepEqual(ref, v) {
+		t.Errorf("null mismatch: %+v", v)
+	}
+	if size != 1+1+1 {
+		t.Errorf("unexpected null size %d", size)
+	}
+}
@@ -0,0 +1,37 @@
+package kafkamock
+
+import (
+	"bufio"
+)
+
+type (
+	endTxnRequest struct {
+		TransactionalId string
+		ProducerId      int64
+		ProducerEpoch   int16
+		Committed       bool
+	}
+
+	endTxnResponse struct {
+		ThrottleTimeMs int32
+		ErrorCode      int16
+	}
+)
+
+func endTxn(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeader) (response any, rtags map[int]any, err error) {
+	request, err := readVersionedRequest[endTxnRequest](reader, kmh)
+	if err != nil {
+		return
+	}
+
+	ec := kc.authorizationError(aclOpWrite, aclResourceTransactionalId, request.TransactionalId)
+	if ec == NoError {
+		ec = kc.ds.endTxn(kc.l, request.TransactionalId, request.ProducerId, request.ProducerEpoch, request.Committed)
+	}
+	if ec != NoError {
+		kc.l.Tracef("end of the transaction of %s failed: %d", request.TransactionalId, ec)
+	}
+
+	response = &endTxnResponse{ErrorCode: int16(fencedError(ec, kmh.RequestApiVersion, 2))}
+	return
+}
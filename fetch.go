@@ -3,149 +3,284 @@ package kafkamock
 import (
 	"bufio"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 type (
-	fetchRequestV2 struct {
-		ReplicaId int32
-		MaxWaitMs int32
-		MinBytes  int32
-		Topics    []fetchTopic
+	fetchRequest struct {
+		ClusterId           NullableString `kafka:"min=12,tag=0"`
+		ReplicaId           int32
+		MaxWaitMs           int32
+		MinBytes            int32
+		MaxBytes            int32 `kafka:"min=3"`
+		IsolationLevel      int8  `kafka:"min=4"`
+		SessionId           int32 `kafka:"min=7"`
+		SessionEpoch        int32 `kafka:"min=7"`
+		Topics              []fetchTopic
+		ForgottenTopicsData []fetchForgottenTopic `kafka:"min=7"`
+		RackId              string                `kafka:"min=11"`
 	}
 
 	fetchTopic struct {
-		Topic      string
+		Topic      string    `kafka:"max=12"`
+		TopicId    uuid.UUID `kafka:"min=13"`
 		Partitions []fetchPartition
 	}
 
 	fetchPartition struct {
-		Partition         int32
-		FetchOffset       int64
-		PartitionMaxBytes int32
+		Partition          int32
+		CurrentLeaderEpoch int32 `kafka:"min=9"`
+		FetchOffset        int64
+		LastFetchedEpoch   int32 `kafka:"min=12"`
+		LogStartOffset     int64 `kafka:"min=5"`
+		PartitionMaxBytes  int32
 	}
 
-	fetchResponseV2 struct {
-		ThrottleTimeMs int32
-		Responses      []fetchResponse
+	fetchForgottenTopic struct {
+		Topic      string    `kafka:"max=12"`
+		TopicId    uuid.UUID `kafka:"min=13"`
+		Partitions []int32
 	}
 
 	fetchResponse struct {
-		Topic      string
+		ThrottleTimeMs int32 `kafka:"min=1"`
+		ErrorCode      int16 `kafka:"min=7"`
+		SessionId      int32 `kafka:"min=7"`
+		Responses      []fetchTopicResponse
+	}
+
+	fetchTopicResponse struct {
+		Topic      string    `kafka:"max=12"`
+		TopicId    uuid.UUID `kafka:"min=13"`
 		Partitions []fetchResponsePartition
 	}
 
 	fetchResponsePartition struct {
-		PartitionIndex int32
-		ErrorCode      int16
-		HighWatermark  int64
-		Records        *messageSetV1
+		PartitionIndex       int32
+		ErrorCode            int16
+		HighWatermark        int64
+		LastStableOffset     int64                     `kafka:"min=4"`
+		LogStartOffset       int64                     `kafka:"min=5"`
+		AbortedTransactions  []fetchAbortedTransaction `kafka:"min=4"`
+		PreferredReadReplica int32                     `kafka:"min=11"`
+		Records              any
+	}
+
+	fetchAbortedTransaction struct {
+		ProducerId  int64
+		FirstOffset int64
+	}
+
+	// the legacy message set or the v2 record batches
+	fetchRecordSet interface {
+		appendRecord(record *kafkaRecord, offset int64, maxSize int) bool
+		size() int
 	}
 
 	fetchData struct {
-		kp      *kafkaPartition
-		offset  int
-		maxSize int
-		ms      *messageSetV1
+		kp            *kafkaPartition
+		ec            kafkaErrorCode
+		offset        int64
+		maxSize       int
+		full          bool
+		readCommitted bool // only records before the last stable offset are served
+		rs            fetchRecordSet
 	}
 )
 
-func fetchV2(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeader) (response any, rtags map[int]any, err error) {
-	request, err := readRequest[fetchRequestV2](reader)
+const (
+	isolationReadUncommitted = 0
+	isolationReadCommitted   = 1
+)
+
+func fetch(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeader) (response any, rtags map[int]any, err error) {
+	request, err := readVersionedRequest[fetchRequest](reader, kmh)
 	if err != nil {
 		return
 	}
 
+	// fetch sessions are not cached - every response is a full response
+	// with session id 0, which tells the client to keep sending full requests
+	if kmh.RequestApiVersion >= 7 && request.SessionId != 0 {
+		response = &fetchResponse{ErrorCode: int16(FetchSessionIdNotFound), Responses: []fetchTopicResponse{}}
+		return
+	}
+
 	// establish which topics/partitions to fetch
 	// the client assumes they pre-exist
-	fds := []*fetchData{}
+	fr := &fetchResponse{
+		Responses: make([]fetchTopicResponse, 0, len(request.Topics)),
+	}
+	fds := make([][]*fetchData, 0, len(request.Topics))
 	for _, topic := range request.Topics {
-		kt := kc.ds.getTopic((topic.Topic))
+		var kt *kafkaTopic
+		missing := UnknownTopicOrPartition
+		if kmh.RequestApiVersion >= 13 {
+			kt = kc.ds.getTopicById(topic.TopicId)
+			missing = UnknownTopicId
+		} else {
+			kt = kc.ds.getTopic(topic.Topic)
+		}
+
+		rtopic := fetchTopicResponse{
+			Topic:      topic.Topic,
+			TopicId:    topic.TopicId,
+			Partitions: make([]fetchResponsePartition, 0, len(topic.Partitions)),
+		}
+		if kt != nil {
+			rtopic.Topic = kt.Name
+		}
+		fr.Responses = append(fr.Responses, rtopic)
+
+		// a topic named by id is only known by name when it exists
+		var authEc kafkaErrorCode
+		if kt != nil || kmh.RequestApiVersion < 13 {
+			authEc = kc.authorizationError(aclOpRead, aclResourceTopic, rtopic.Topic)
+		}
 
+		tfds := make([]*fetchData, 0, len(topic.Partitions))
 		for _, par := range topic.Partitions {
-			var kp *kafkaPartition
+			currentLeaderEpoch := par.CurrentLeaderEpoch
+			if kmh.RequestApiVersion < 9 {
+				currentLeaderEpoch = kNoLeaderEpoch
+			}
+
+			fd := &fetchData{offset: par.FetchOffset, maxSize: int(par.PartitionMaxBytes), readCommitted: request.IsolationLevel == isolationReadCommitted}
+			if kmh.RequestApiVersion < 4 {
+				fd.rs = newMessageSetV1(par.FetchOffset)
+			} else {
+				fd.rs = newRecordSetV2(par.FetchOffset)
+			}
+
 			if kt != nil {
-				kp = kt.getPartition(par.Partition)
+				fd.kp = kt.getPartition(par.Partition)
 			}
+			if authEc != NoError {
+				fd.kp = nil
+				fd.ec = authEc
+			} else if kt == nil {
+				fd.ec = missing
+			} else if fd.kp == nil {
+				fd.ec = UnknownTopicOrPartition
+			} else if fd.ec = kc.leaderError(kt, fd.kp, currentLeaderEpoch); fd.ec != NoError {
+				fd.kp = nil
+			} else {
+				kc.ds.enforceRetention(kt, fd.kp)
 
-			fd := &fetchData{kp: kp, offset: int(par.FetchOffset), maxSize: int(par.PartitionMaxBytes), ms: newMessageSetV1(par.FetchOffset)}
-			fds = append(fds, fd)
+				// the fetch can start anywhere from the log start offset to the high watermark
+				fd.kp.lock()
+				if fd.offset < fd.kp.LogStartOffset || fd.offset > fd.kp.endOffset() {
+					fd.ec = OffsetOutOfRange
+				}
+				fd.kp.unlock()
+			}
+			tfds = append(tfds, fd)
 		}
+		fds = append(fds, tfds)
+	}
+
+	maxBytes := int(request.MaxBytes)
+	if kmh.RequestApiVersion < 3 || maxBytes <= 0 {
+		maxBytes = int(^uint32(0) >> 1)
 	}
 
-	expiration := time.Now().Add(time.Duration(request.MaxWaitMs) * time.Millisecond)
-	hasOne := false
+	fetchRecords(kc, fds, time.Duration(request.MaxWaitMs)*time.Millisecond, int(request.MinBytes), maxBytes)
+
+	for n, topic := range request.Topics {
+		rtopic := &fr.Responses[n]
+		for m, par := range topic.Partitions {
+			fd := fds[n][m]
+			fp := fetchResponsePartition{
+				PartitionIndex:       par.Partition,
+				ErrorCode:            int16(fd.ec),
+				HighWatermark:        -1,
+				LastStableOffset:     -1,
+				LogStartOffset:       -1,
+				AbortedTransactions:  []fetchAbortedTransaction{},
+				PreferredReadReplica: -1,
+				Records:              fd.rs,
+			}
+
+			if fd.kp != nil {
+				fd.kp.lock()
+				fp.HighWatermark = fd.kp.endOffset()
+				fp.LastStableOffset = fd.kp.lastStableOffset()
+				fp.LogStartOffset = fd.kp.LogStartOffset
+
+				// read_committed consumers filter out the records of aborted transactions
+				if fd.readCommitted && fd.ec == NoError {
+					for _, txn := range fd.kp.abortedTxnsBetween(par.FetchOffset, fd.offset) {
+						fp.AbortedTransactions = append(fp.AbortedTransactions, fetchAbortedTransaction{ProducerId: txn.producerId, FirstOffset: txn.firstOffset})
+					}
+				}
+				fd.kp.unlock()
+			}
+
+			rtopic.Partitions = append(rtopic.Partitions, fp)
+		}
+	}
+
+	response = fr
+	return
+}
+
+// collects records into the fetch data until there is enough to respond,
+// the wait time expires or the client goes away
+func fetchRecords(kc *kafkaClient, fds [][]*fetchData, maxWait time.Duration, minBytes, maxBytes int) {
+	expiration := time.Now().Add(maxWait)
+	total := 0
 	for {
 		more := false
-		for _, fd := range fds {
-			if fd.kp != nil {
-				var rec *kafkaRecord
+		for _, tfds := range fds {
+			for _, fd := range tfds {
+				if fd.kp == nil || fd.ec != NoError || fd.full {
+					continue
+				}
+
 				fd.kp.lock()
-				if fd.offset < len(fd.kp.Records) {
-					rec = fd.kp.Records[fd.offset]
+				offset, rec := fd.kp.nextRecord(fd.offset)
+				if fd.readCommitted && offset >= fd.kp.lastStableOffset() {
+					rec = nil
 				}
 				fd.kp.unlock()
 
-				if rec != nil {
+				if rec == nil {
+					continue
+				}
+
+				// the partition limit applies unless this is the first data in the response
+				limit := fd.maxSize
+				if total > 0 && maxBytes-total+fd.rs.size() < limit {
+					limit = maxBytes - total + fd.rs.size()
+				}
+
+				before := fd.rs.size()
+				if fd.rs.appendRecord(rec, offset, limit) {
+					fd.offset = offset + 1
+					total += fd.rs.size() - before
 					more = true
-					hasOne = true
-					if fd.ms.appendMessage(rec, fd.maxSize) {
-						fd.offset++
-					}
+				} else {
+					fd.full = true
 				}
 			}
 		}
 
 		if !more {
-			// is wait time expiring?
-			if hasOne || time.Now().After(expiration) {
+			// is there enough data, or is wait time expiring?
+			if (total > 0 && total >= minBytes) || time.Now().After(expiration) {
 				break
 			}
 
 			// is client getting closed?
-			isClosed := false
 			select {
 			case <-kc.l.Done():
-				isClosed = true
-				break
+				return
 			default:
-				break
-			}
-			if isClosed {
-				break
 			}
 
 			// check for messages again in a moment
 			time.Sleep(time.Millisecond * 20)
 		}
 	}
-
-	frv2 := fetchResponseV2{
-		Responses: make([]fetchResponse, 0, len(request.Topics)),
-	}
-
-	n := 0
-	for _, topic := range request.Topics {
-		fd := fds[n]
-		n++
-
-		fr := &fetchResponse{
-			Topic:      topic.Topic,
-			Partitions: []fetchResponsePartition{},
-		}
-
-		for _, par := range topic.Partitions {
-			fp := fetchResponsePartition{
-				PartitionIndex: par.Partition,
-				HighWatermark:  int64(fd.offset),
-				Records:        fd.ms,
-			}
-
-			fr.Partitions = append(fr.Partitions, fp)
-		}
-
-		frv2.Responses = append(frv2.Responses, *fr)
-	}
-
-	response = frv2
-	return
 }
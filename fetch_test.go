@@ -0,0 +1,483 @@
+package kafkamock
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jimsnab/go-lane"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+)
+
+// decodes the record batches of a fetch response partition the way a consumer would
+func testFetchedRecords(t *testing.T, fp *fetchResponsePartition) []*kafkaRecord {
+	rs, ok := fp.Records.(*recordSetV2)
+	if !ok {
+		t.Fatalf("expected v2 record set, got %T", fp.Records)
+	}
+
+	var data []byte
+	for _, batch := range rs.batches {
+		data = append(data, batch.bytes()...)
+	}
+	if len(data) != rs.size() {
+		t.Errorf("record set size %d does not match encoded size %d", rs.size(), len(data))
+	}
+
+	batches, ec := decodeRecordBatches(data)
+	if ec != NoError {
+		t.Fatalf("fetched batches are invalid: %d", ec)
+	}
+
+	records := []*kafkaRecord{}
+	for _, batch := range batches {
+		records = append(records, batch.records...)
+	}
+	return records
+}
+
+func TestKafkaFetchHeaders(t *testing.T) {
+	topics := []string{"topic-a"}
+	tl, mock := testCreateKafkaMockServer(t, 21001, topics)
+	defer testStopMockServer(t, mock)
+
+	mock.ExtendedPost("topic-a", 2, []byte("key"), []byte("test"), map[string][]byte{"trace-id": []byte("1234")}, time.Now())
+
+	r := testKafkaConnect(t, 21001, topics)
+	defer testCloseKafkaReader(t, tl, r)
+	defer mock.FinishRequests()
+
+	m, err := r.FetchMessage(tl)
+	if err != nil {
+		t.Fatalf("kafka-feed: read message error: %v", err)
+	}
+
+	if string(m.Key) != "key" || string(m.Value) != "test" {
+		t.Error("message mismatch")
+	}
+	if len(m.Headers) != 1 || m.Headers[0].Key != "trace-id" || string(m.Headers[0].Value) != "1234" {
+		t.Errorf("header mismatch: %v", m.Headers)
+	}
+}
+
+func TestFetchRecordBatch(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	ds := newKafkaDataStore()
+	kp := ds.createTopic("topic-a").createPartition(0)
+	ts := time.UnixMilli(1700000000000)
+	kp.postRecord(0, ts, nil, []byte("first"), nil)
+	kp.postRecord(0, ts.Add(time.Second), []byte("k"), []byte("second"), map[string][]byte{"h": []byte("v")})
+
+	fr := testHandlerRequest[*fetchResponse](t, tl, ds, fetch, ApiKeyFetch, 5, &fetchRequest{
+		ReplicaId: -1,
+		MaxBytes:  1000,
+		Topics:    []fetchTopic{{Topic: "topic-a", Partitions: []fetchPartition{{Partition: 0, FetchOffset: 0, PartitionMaxBytes: 1000}}}},
+	})
+
+	fp := &fr.Responses[0].Partitions[0]
+	if fp.ErrorCode != 0 || fp.HighWatermark != 2 || fp.LastStableOffset != 2 {
+		t.Errorf("unexpected partition response %+v", fp)
+	}
+
+	records := testFetchedRecords(t, fp)
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+	if records[0].Key != nil || string(records[0].Value) != "first" || records[0].Timestamp != ts.UnixMilli() {
+		t.Error("first record mismatch")
+	}
+	if string(records[1].Key) != "k" || records[1].Timestamp != ts.UnixMilli()+1000 {
+		t.Error("second record mismatch")
+	}
+	if len(records[1].Headers) != 1 || records[1].Headers[0].HeaderKey != "h" || string(records[1].Headers[0].HeaderValue) != "v" {
+		t.Error("header mismatch")
+	}
+}
+
+func TestFetchFirstRecordTooLarge(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	ds := newKafkaDataStore()
+	kp := ds.createTopic("topic-a").createPartition(0)
+	kp.postRecord(0, time.Now(), nil, bytes.Repeat([]byte("x"), 500), nil)
+	kp.postRecord(0, time.Now(), nil, []byte("next"), nil)
+
+	fr := testHandlerRequest[*fetchResponse](t, tl, ds, fetch, ApiKeyFetch, 4, &fetchRequest{
+		ReplicaId: -1,
+		MaxBytes:  100,
+		Topics:    []fetchTopic{{Topic: "topic-a", Partitions: []fetchPartition{{Partition: 0, FetchOffset: 0, PartitionMaxBytes: 100}}}},
+	})
+
+	// the oversized record is served alone so the consumer can make progress
+	records := testFetchedRecords(t, &fr.Responses[0].Partitions[0])
+	if len(records) != 1 || len(records[0].Value) != 500 {
+		t.Errorf("expected only the oversized record, got %d records", len(records))
+	}
+}
+
+func TestFetchErrors(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	ds := newKafkaDataStore()
+	kp := ds.createTopic("topic-a").createPartition(0)
+	kp.postRecord(0, time.Now(), nil, []byte("test"), nil)
+
+	fr := testHandlerRequest[*fetchResponse](t, tl, ds, fetch, ApiKeyFetch, 6, &fetchRequest{
+		ReplicaId: -1,
+		MaxWaitMs: 10,
+		Topics: []fetchTopic{
+			{Topic: "topic-a", Partitions: []fetchPartition{{Partition: 0, FetchOffset: 5}, {Partition: 1}}},
+			{Topic: "topic-b", Partitions: []fetchPartition{{Partition: 0}}},
+		},
+	})
+
+	if fr.Responses[0].Partitions[0].ErrorCode != int16(OffsetOutOfRange) {
+		t.Error("expected offset out of range")
+	}
+	if fr.Responses[0].Partitions[1].ErrorCode != int16(UnknownTopicOrPartition) {
+		t.Error("expected unknown partition")
+	}
+	if fr.Responses[1].Partitions[0].ErrorCode != int16(UnknownTopicOrPartition) {
+		t.Error("expected unknown topic")
+	}
+}
+
+func TestFetchLegacyMessageSet(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	ds := newKafkaDataStore()
+	kp := ds.createTopic("topic-a").createPartition(0)
+	kp.postRecord(0, time.Now(), nil, []byte("test"), nil)
+
+	fr := testHandlerRequest[*fetchResponse](t, tl, ds, fetch, ApiKeyFetch, 2, &fetchRequest{
+		ReplicaId: -1,
+		Topics:    []fetchTopic{{Topic: "topic-a", Partitions: []fetchPartition{{Partition: 0, PartitionMaxBytes: 1000}}}},
+	})
+
+	ms, ok := fr.Responses[0].Partitions[0].Records.(*messageSetV1)
+	if !ok || len(ms.msgs) != 1 || string(ms.msgs[0].Value) != "test" {
+		t.Errorf("expected a v1 message set, got %T", fr.Responses[0].Partitions[0].Records)
+	}
+}
+
+func TestFetchByTopicId(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	ds := newKafkaDataStore()
+	kt := ds.createTopic("topic-a")
+	kt.createPartition(0).postRecord(0, time.Now(), nil, []byte("test"), nil)
+
+	fr := testHandlerRequest[*fetchResponse](t, tl, ds, fetch, ApiKeyFetch, 13, &fetchRequest{
+		ReplicaId: -1,
+		MaxBytes:  1000,
+		Topics: []fetchTopic{
+			{TopicId: kt.Id, Partitions: []fetchPartition{{Partition: 0, PartitionMaxBytes: 1000}}},
+			{TopicId: uuid.New(), Partitions: []fetchPartition{{Partition: 0}}},
+		},
+	})
+
+	if fr.Responses[0].TopicId != kt.Id || fr.Responses[0].Topic != "topic-a" {
+		t.Error("topic id mismatch")
+	}
+	fp := &fr.Responses[0].Partitions[0]
+	if fp.PreferredReadReplica != -1 || fp.LogStartOffset != 0 {
+		t.Errorf("unexpected partition response %+v", fp)
+	}
+	if records := testFetchedRecords(t, fp); len(records) != 1 || string(records[0].Value) != "test" {
+		t.Error("record mismatch")
+	}
+	if fr.Responses[1].Partitions[0].ErrorCode != int16(UnknownTopicId) {
+		t.Error("expected unknown topic id")
+	}
+
+	// the flexible response must be codable
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	encodeVersionedObject(w, fr, codecVersion{version: 13, flexible: true})
+	w.Flush()
+	if buf.Len() == 0 {
+		t.Error("response not encoded")
+	}
+}
+
+func TestFetchResponseMaxBytes(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	ds := newKafkaDataStore()
+	kt := ds.createTopic("topic-a")
+	for n := int32(0); n < 2; n++ {
+		kp := kt.createPartition(n)
+		for i := 0; i < 10; i++ {
+			kp.postRecord(0, time.Now(), nil, bytes.Repeat([]byte("x"), 100), nil)
+		}
+	}
+
+	fr := testHandlerRequest[*fetchResponse](t, tl, ds, fetch, ApiKeyFetch, 11, &fetchRequest{
+		ReplicaId: -1,
+		MaxBytes:  600,
+		Topics:    []fetchTopic{{Topic: "topic-a", Partitions: []fetchPartition{{Partition: 0, PartitionMaxBytes: 10000}, {Partition: 1, PartitionMaxBytes: 10000}}}},
+	})
+
+	total := 0
+	for _, fp := range fr.Responses[0].Partitions {
+		total += fp.Records.(*recordSetV2).size()
+	}
+	if total == 0 || total > 600 {
+		t.Errorf("response size %d does not honor max bytes", total)
+	}
+}
+
+func TestFetchSessionNotFound(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	ds := newKafkaDataStore()
+
+	fr := testHandlerRequest[*fetchResponse](t, tl, ds, fetch, ApiKeyFetch, 7, &fetchRequest{ReplicaId: -1, SessionId: 12, SessionEpoch: 1})
+	if fr.ErrorCode != int16(FetchSessionIdNotFound) || fr.SessionId != 0 {
+		t.Error("expected fetch session id not found")
+	}
+}
+
+func TestFetchAfterRetention(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	ds := newKafkaDataStore()
+	kt := ds.createTopic("topic-a")
+	kp := kt.createPartition(0)
+
+	old := time.Now().Add(-time.Hour)
+	for i := 0; i < 3; i++ {
+		kp.postRecord(0, old, nil, []byte("expired"), nil)
+	}
+	kp.postRecord(0, time.Now(), nil, []byte("kept"), nil)
+	retentionMs := "60000"
+	ds.alterTopicConfigs(kt, []kafkaConfigChange{{name: "retention.ms", value: &retentionMs}}, false, false)
+
+	fr := testHandlerRequest[*fetchResponse](t, tl, ds, fetch, ApiKeyFetch, 6, &fetchRequest{
+		ReplicaId: -1,
+		MaxWaitMs: 10,
+		Topics:    []fetchTopic{{Topic: "topic-a", Partitions: []fetchPartition{{Partition: 0, FetchOffset: 1, PartitionMaxBytes: 1000}}}},
+	})
+	fp := fr.Responses[0].Partitions[0]
+	if fp.ErrorCode != int16(OffsetOutOfRange) || fp.LogStartOffset != 3 || fp.HighWatermark != 4 {
+		t.Fatalf("expected offset out of range after retention %+v", fp)
+	}
+
+	fr = testHandlerRequest[*fetchResponse](t, tl, ds, fetch, ApiKeyFetch, 6, &fetchRequest{
+		ReplicaId: -1,
+		MaxWaitMs: 10,
+		Topics:    []fetchTopic{{Topic: "topic-a", Partitions: []fetchPartition{{Partition: 0, FetchOffset: 3, PartitionMaxBytes: 1000}}}},
+	})
+	fp = fr.Responses[0].Partitions[0]
+	records := testFetchedRecords(t, &fp)
+	if fp.ErrorCode != 0 || len(records) != 1 || string(records[0].Value) != "kept" {
+		t.Fatalf("unexpected fetch at the log start %+v", fp)
+	}
+	if rs := fp.Records.(*recordSetV2); rs.batches[0].header.BaseOffset != 3 {
+		t.Errorf("unexpected base offset %d", rs.batches[0].header.BaseOffset)
+	}
+
+	// the size limit keeps the newest records that fit
+	for i := 0; i < 3; i++ {
+		kp.postRecord(0, time.Now(), nil, []byte("kept"), nil)
+	}
+	retentionBytes := strconv.FormatInt(recordSize(kp.recordAt(3))*2, 10)
+	ds.alterTopicConfigs(kt, []kafkaConfigChange{{name: "retention.bytes", value: &retentionBytes}}, false, false)
+	ds.enforceRetention(kt, kp)
+	if kp.LogStartOffset != 5 || kp.endOffset() != 7 {
+		t.Errorf("unexpected log after size retention: %d-%d", kp.LogStartOffset, kp.endOffset())
+	}
+}
+
+func TestKafkaRetentionOffsetReset(t *testing.T) {
+	topics := []string{"topic-a"}
+	tl, mock := testCreateKafkaMockServer(t, 21001, topics)
+	defer testStopMockServer(t, mock)
+
+	old := time.Now().Add(-time.Hour)
+	mock.ExtendedPost("topic-a", 0, nil, []byte("expired-1"), nil, old)
+	mock.ExtendedPost("topic-a", 0, nil, []byte("expired-2"), nil, old)
+	mock.ExtendedPost("topic-a", 0, nil, []byte("kept"), nil, time.Now())
+	if err := mock.SetTopicConfigs("topic-a", map[string]string{"retention.ms": "60000"}); err != nil {
+		t.Fatalf("set topic configs error: %v", err)
+	}
+
+	conn := testDialLeader(t, 21001, "topic-a", 0)
+	defer conn.Close()
+	first, last, err := conn.ReadOffsets()
+	if err != nil || first != 2 || last != 3 {
+		t.Fatalf("unexpected offsets %d-%d: %v", first, last, err)
+	}
+
+	// the group's committed offset is gone, so the consumer starts at the log start
+	mock.SetConsumerGroupOffset("topic-a", 0, "kafka-mock", 0)
+	r := testKafkaConnect(t, 21001, topics)
+	defer testCloseKafkaReader(t, tl, r)
+	defer mock.FinishRequests()
+
+	m, err := r.FetchMessage(tl)
+	if err != nil {
+		t.Fatalf("read message error: %v", err)
+	}
+	if m.Offset != 2 || string(m.Value) != "kept" {
+		t.Errorf("unexpected message %d %s", m.Offset, m.Value)
+	}
+}
+
+func TestCompaction(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	ds := newKafkaDataStore()
+	kt := ds.createTopic("topic-a")
+	kp := kt.createPartition(0)
+
+	old := time.Now().Add(-time.Hour)
+	kp.postRecord(0, old, []byte("a"), []byte("a1"), nil)
+	kp.postRecord(0, old, []byte("b"), []byte("b1"), nil)
+	kp.postRecord(0, old, []byte("a"), []byte("a2"), nil)
+	kp.postRecord(0, old, nil, []byte("no key"), nil)
+	kp.postRecord(0, old, []byte("b"), nil, nil)
+	kp.postRecord(0, time.Now(), []byte("c"), nil, nil)
+
+	// nothing happens until the cleanup policy asks for compaction
+	if removed := ds.compactTopic(kt); removed != 0 {
+		t.Fatalf("compacted a topic with the delete policy: %d", removed)
+	}
+
+	policy, deleteRetention := "compact", "60000"
+	ds.alterTopicConfigs(kt, []kafkaConfigChange{{name: "cleanup.policy", value: &policy}, {name: "delete.retention.ms", value: &deleteRetention}}, false, false)
+	if removed := ds.compactTopic(kt); removed != 3 {
+		t.Errorf("expected 3 records removed, got %d", removed)
+	}
+
+	// the old tombstone is gone, the recent one remains, and offsets are kept
+	fr := testHandlerRequest[*fetchResponse](t, tl, ds, fetch, ApiKeyFetch, 6, &fetchRequest{
+		ReplicaId: -1,
+		MaxWaitMs: 10,
+		Topics:    []fetchTopic{{Topic: "topic-a", Partitions: []fetchPartition{{Partition: 0, FetchOffset: 0, PartitionMaxBytes: 1000}}}},
+	})
+	fp := fr.Responses[0].Partitions[0]
+	if fp.HighWatermark != 6 || fp.LogStartOffset != 0 {
+		t.Errorf("unexpected offsets %+v", fp)
+	}
+
+	batch := fp.Records.(*recordSetV2).batches[0].header
+	if batch.BaseOffset != 2 || batch.LastOffsetDelta != 3 || batch.RecordCount != 3 {
+		t.Errorf("unexpected batch header %+v", batch)
+	}
+
+	records := testFetchedRecords(t, &fp)
+	values := []string{}
+	for _, record := range records {
+		values = append(values, string(record.Key)+"="+string(record.Value))
+	}
+	if strings.Join(values, ",") != "a=a2,=no key,c=" {
+		t.Errorf("unexpected compacted records %v", values)
+	}
+}
+
+func TestCompactionTransactions(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	ds := newKafkaDataStore()
+	kt := ds.createTopic("topic-a")
+	kp := kt.createPartition(0)
+	policy := "compact"
+	ds.alterTopicConfigs(kt, []kafkaConfigChange{{name: "cleanup.policy", value: &policy}}, false, false)
+
+	old := time.Now().Add(-time.Hour)
+	postTxn := func(producerId int64, value string) {
+		batches, ec := decodeRecordBatches(testTxnBatch(t, producerId, 0, 0, protocol.Record{Time: old, Key: protocol.NewBytes([]byte("a")), Value: protocol.NewBytes([]byte(value))}))
+		if ec != NoError {
+			t.Fatalf("invalid batch: %d", ec)
+		}
+		if _, ec := kp.postBatches(batches, func(*recordBatchV2) kafkaErrorCode { return NoError }); ec != NoError {
+			t.Fatalf("post batch error: %d", ec)
+		}
+	}
+
+	kp.postRecord(0, old, []byte("a"), []byte("committed"), nil)
+	postTxn(1, "aborted")
+	kp.postTxnMarker(1, 0, false)
+	postTxn(2, "ongoing")
+
+	// the aborted value is removed without replacing the committed one, and
+	// the ongoing transaction is left alone
+	if removed := ds.compactTopic(kt); removed != 1 {
+		t.Errorf("expected 1 record removed, got %d", removed)
+	}
+	kp.lock()
+	if kp.recordAt(0) == nil || kp.recordAt(1) != nil || kp.recordAt(2) == nil || kp.recordAt(3) == nil {
+		t.Errorf("unexpected compaction of transactions %v", kp.Records)
+	}
+	kp.unlock()
+
+	// once committed, the transaction's value replaces the older one
+	kp.postTxnMarker(2, 0, true)
+	if removed := ds.compactTopic(kt); removed != 1 {
+		t.Errorf("expected 1 record removed, got %d", removed)
+	}
+	fr := testHandlerRequest[*fetchResponse](t, tl, ds, fetch, ApiKeyFetch, 11, &fetchRequest{
+		ReplicaId:      -1,
+		MaxBytes:       1000,
+		IsolationLevel: isolationReadCommitted,
+		Topics:         []fetchTopic{{Topic: "topic-a", Partitions: []fetchPartition{{Partition: 0, PartitionMaxBytes: 1000}}}},
+	})
+	values := []string{}
+	for _, record := range testFetchedRecords(t, &fr.Responses[0].Partitions[0]) {
+		if !record.isControl() {
+			values = append(values, string(record.Value))
+		}
+	}
+	if strings.Join(values, ",") != "ongoing" {
+		t.Errorf("unexpected compacted records %v", values)
+	}
+}
+
+func TestKafkaCompactedBootstrap(t *testing.T) {
+	topics := []string{"topic-a"}
+	tl, mock := testCreateKafkaMockServer(t, 21001, topics)
+	defer testStopMockServer(t, mock)
+
+	if err := mock.SetTopicConfigs("topic-a", map[string]string{"cleanup.policy": "compact", "delete.retention.ms": "0"}); err != nil {
+		t.Fatalf("set topic configs error: %v", err)
+	}
+	mock.SimplePost("topic-a", 0, []byte("a"), []byte("1"))
+	mock.SimplePost("topic-a", 0, []byte("b"), []byte("1"))
+	mock.SimplePost("topic-a", 0, []byte("a"), []byte("2"))
+	mock.SimplePost("topic-a", 0, []byte("b"), nil)
+
+	// the background cleaner compacts once its backoff is short enough
+	if err := mock.SetBrokerConfigs(map[string]string{"log.cleaner.backoff.ms": "0"}); err != nil {
+		t.Fatalf("set broker configs error: %v", err)
+	}
+	kp := mock.ds.getTopic("topic-a").getPartition(0)
+	for {
+		kp.lock()
+		_, record := kp.nextRecord(0)
+		kp.unlock()
+		if record != nil && string(record.Value) == "2" {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	r := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   []string{"localhost:21001"},
+		Topic:     "topic-a",
+		Partition: 0,
+		MaxWait:   time.Millisecond * 100,
+	})
+	defer testCloseKafkaReader(t, tl, r)
+	defer mock.FinishRequests()
+
+	m, err := r.FetchMessage(tl)
+	if err != nil {
+		t.Fatalf("read message error: %v", err)
+	}
+	if m.Offset != 2 || string(m.Key) != "a" || string(m.Value) != "2" {
+		t.Errorf("unexpected message %d %s=%s", m.Offset, m.Key, m.Value)
+	}
+
+	lag, err := r.ReadLag(tl)
+	if err != nil || lag != 1 {
+		t.Errorf("unexpected lag %d: %v", lag, err)
+	}
+}
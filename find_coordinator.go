@@ -5,28 +5,83 @@ import (
 )
 
 type (
-	findCoordinatorRequestV0 struct {
-		Key string
+	findCoordinatorRequest struct {
+		Key             string   `kafka:"max=3"`
+		KeyType         int8     `kafka:"min=1"`
+		CoordinatorKeys []string `kafka:"min=4"`
 	}
 
-	findCoordinatorResponseV0 struct {
-		ErrorCode int16
-		NodeId    int32
-		Host      string
-		Port      int32
+	findCoordinatorResponse struct {
+		ThrottleTimeMs int32                        `kafka:"min=1"`
+		ErrorCode      int16                        `kafka:"max=3"`
+		ErrorMessage   NullableString               `kafka:"min=1,max=3"`
+		NodeId         int32                        `kafka:"max=3"`
+		Host           string                       `kafka:"max=3"`
+		Port           int32                        `kafka:"max=3"`
+		Coordinators   []findCoordinatorCoordinator `kafka:"min=4"`
 	}
+
+	findCoordinatorCoordinator struct {
+		Key          string
+		NodeId       int32
+		Host         string
+		Port         int32
+		ErrorCode    int16
+		ErrorMessage NullableString
+	}
+)
+
+const (
+	coordinatorKeyGroup       = 0
+	coordinatorKeyTransaction = 1
 )
 
-func findCoordinatorV0(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeader) (response any, rtags map[int]any, err error) {
-	_, err = readRequest[findCoordinatorRequestV0](reader)
+func findCoordinator(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeader) (response any, rtags map[int]any, err error) {
+	request, err := readVersionedRequest[findCoordinatorRequest](reader, kmh)
 	if err != nil {
 		return
 	}
 
-	response = &findCoordinatorResponseV0{
-		NodeId: kLeaderNode,
-		Host:   "localhost",
-		Port:   int32(kc.serverPort),
+	// the brokers share every group and transactional id, but each key
+	// has one coordinator, as it would in a kafka cluster
+	var ec kafkaErrorCode
+	if request.KeyType != coordinatorKeyGroup && request.KeyType != coordinatorKeyTransaction {
+		ec = InvalidRequest
+	}
+
+	// the client must be allowed to describe the group or transactional id
+	keyError := func(key string) kafkaErrorCode {
+		if ec != NoError {
+			return ec
+		}
+		if request.KeyType == coordinatorKeyTransaction {
+			return kc.authorizationError(aclOpDescribe, aclResourceTransactionalId, key)
+		}
+		return kc.authorizationError(aclOpDescribe, aclResourceGroup, key)
 	}
+
+	coordinator := kc.ds.coordinator(request.Key)
+	fcr := &findCoordinatorResponse{
+		ErrorCode: int16(keyError(request.Key)),
+		NodeId:    coordinator.NodeId,
+		Host:      coordinator.Host,
+		Port:      int32(coordinator.Port),
+	}
+
+	if kmh.RequestApiVersion >= 4 {
+		fcr.Coordinators = make([]findCoordinatorCoordinator, 0, len(request.CoordinatorKeys))
+		for _, key := range request.CoordinatorKeys {
+			coordinator := kc.ds.coordinator(key)
+			fcr.Coordinators = append(fcr.Coordinators, findCoordinatorCoordinator{
+				Key:       key,
+				NodeId:    coordinator.NodeId,
+				Host:      coordinator.Host,
+				Port:      int32(coordinator.Port),
+				ErrorCode: int16(keyError(key)),
+			})
+		}
+	}
+
+	response = fcr
 	return
 }
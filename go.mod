@@ -7,6 +7,8 @@ toolchain go1.21.6
 require (
 	github.com/google/uuid v1.5.0
 	github.com/jimsnab/go-lane v1.10.1
+	github.com/klauspost/compress v1.17.4
+	github.com/pierrec/lz4/v4 v4.1.21
 	github.com/segmentio/kafka-go v0.4.47
 )
 
@@ -21,11 +23,9 @@ require (
 	github.com/google/go-dap v0.11.0 // indirect
 	github.com/hashicorp/golang-lru v1.0.2 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
-	github.com/klauspost/compress v1.17.4 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/mattn/go-runewidth v0.0.15 // indirect
-	github.com/pierrec/lz4/v4 v4.1.21 // indirect
 	github.com/rivo/uniseg v0.4.4 // indirect
 	github.com/russross/blackfriday/v2 v2.1.0 // indirect
 	github.com/sirupsen/logrus v1.9.3 // indirect
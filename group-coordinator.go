@@ -0,0 +1,719 @@
+package kafkamock
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jimsnab/go-lane"
+)
+
+type (
+	kafkaGroupState int
+
+	// a classic consumer group, coordinated the way a broker does it: members
+	// join, the group picks a protocol and a leader, then the members sync
+	// to receive their assignments
+	kafkaGroup struct {
+		mu           sync.Mutex
+		ds           *kafkaDataStore
+		id           string
+		state        kafkaGroupState
+		generationId int32
+		protocolType string
+		protocolName string
+		leaderId     string
+		members      map[string]*kafkaGroupMember
+		pending      map[string]bool   // member ids handed out with MEMBER_ID_REQUIRED
+		instances    map[string]string // static members: group instance id to member id
+		rebalanceSeq int
+		rebalance    *time.Timer // ends the join phase when members are too slow to rejoin
+	}
+
+	kafkaGroupMember struct {
+		memberId         string
+		instanceId       string // set for a static member
+		clientId         string
+		clientHost       string
+		sessionTimeout   time.Duration
+		rebalanceTimeout time.Duration
+		protocolType     string
+		protocols        []kafkaGroupProtocol
+		assignment       []byte
+		joining          bool
+		joinWait         chan *kafkaJoinResult
+		syncWait         chan *kafkaSyncResult
+		deadline         time.Time   // the member is expired if it doesn't heartbeat by then
+		session          *time.Timer // checks the deadline
+	}
+
+	kafkaGroupProtocol struct {
+		name     string
+		metadata []byte
+	}
+
+	kafkaJoinRequest struct {
+		memberId             string
+		instanceId           string
+		clientId             string
+		clientHost           string
+		sessionTimeout       time.Duration
+		rebalanceTimeout     time.Duration
+		protocolType         string
+		protocols            []kafkaGroupProtocol
+		requireKnownMemberId bool
+	}
+
+	kafkaJoinResult struct {
+		ec             kafkaErrorCode
+		generationId   int32
+		protocolName   string
+		leaderId       string
+		memberId       string
+		members        []kafkaJoinMember // only given to the leader
+		skipAssignment bool              // the leader rejoined statically and must keep the current assignment
+	}
+
+	kafkaJoinMember struct {
+		memberId   string
+		instanceId string
+		metadata   []byte
+	}
+
+	kafkaSyncResult struct {
+		ec         kafkaErrorCode
+		assignment []byte
+	}
+)
+
+const (
+	groupEmpty kafkaGroupState = iota
+	groupPreparingRebalance
+	groupCompletingRebalance
+	groupStable
+)
+
+var groupStateNames = map[kafkaGroupState]string{
+	groupEmpty:               "Empty",
+	groupPreparingRebalance:  "PreparingRebalance",
+	groupCompletingRebalance: "CompletingRebalance",
+	groupStable:              "Stable",
+}
+
+func (gs kafkaGroupState) String() string {
+	return groupStateNames[gs]
+}
+
+// finds a group, creating it if necessary
+func (ds *kafkaDataStore) getGroup(groupId string) *kafkaGroup {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	kg, exists := ds.Groups[groupId]
+	if !exists {
+		kg = &kafkaGroup{
+			ds:        ds,
+			id:        groupId,
+			members:   map[string]*kafkaGroupMember{},
+			pending:   map[string]bool{},
+			instances: map[string]string{},
+		}
+		ds.Groups[groupId] = kg
+	}
+	return kg
+}
+
+// forgets the members of every group; committed offsets are kept
+func (ds *kafkaDataStore) resetGroupMembership() {
+	ds.mu.Lock()
+	groups := make([]*kafkaGroup, 0, len(ds.Groups))
+	for _, kg := range ds.Groups {
+		groups = append(groups, kg)
+	}
+	consumerGroups := make([]*kafkaConsumerGroup, 0, len(ds.ConsumerGroups))
+	for _, cg := range ds.ConsumerGroups {
+		consumerGroups = append(consumerGroups, cg)
+	}
+	ds.mu.Unlock()
+
+	for _, cg := range consumerGroups {
+		cg.reset()
+	}
+	for _, kg := range groups {
+		kg.mu.Lock()
+		for _, member := range kg.members {
+			member.session.Stop()
+		}
+		if kg.rebalance != nil {
+			kg.rebalance.Stop()
+		}
+		kg.members = map[string]*kafkaGroupMember{}
+		kg.pending = map[string]bool{}
+		kg.instances = map[string]string{}
+		kg.leaderId = ""
+		kg.protocolType = ""
+		kg.protocolName = ""
+		kg.state = groupEmpty
+		kg.mu.Unlock()
+	}
+}
+
+// adds or updates a member, then waits for the join phase of the rebalance to complete
+func (kg *kafkaGroup) join(l lane.Lane, req *kafkaJoinRequest) *kafkaJoinResult {
+	kg.mu.Lock()
+
+	if req.sessionTimeout <= 0 {
+		kg.mu.Unlock()
+		return &kafkaJoinResult{ec: InvalidSessionTimeout, generationId: -1, memberId: req.memberId}
+	}
+	if !kg.supportsProtocols(req) {
+		kg.mu.Unlock()
+		return &kafkaJoinResult{ec: InconsistentGroupProtocol, generationId: -1, memberId: req.memberId}
+	}
+
+	memberId := req.memberId
+	var member *kafkaGroupMember
+	if req.instanceId != "" {
+		if ec := kg.checkInstance(memberId, req.instanceId); ec != NoError {
+			kg.mu.Unlock()
+			return &kafkaJoinResult{ec: ec, generationId: -1, memberId: memberId}
+		}
+	}
+	if memberId == "" && req.instanceId != "" {
+		// a static member gets a new member id; a restarted instance replaces
+		// its old member and keeps its assignment
+		memberId = req.clientId + "-" + uuid.NewString()
+		if oldId, exists := kg.instances[req.instanceId]; exists {
+			member = kg.replaceStaticMember(l, oldId, memberId)
+			if member.sameProtocols(req.protocols) && (kg.state == groupStable || kg.state == groupCompletingRebalance) {
+				member.clientId = req.clientId
+				member.clientHost = req.clientHost
+				member.sessionTimeout = req.sessionTimeout
+				member.rebalanceTimeout = req.rebalanceTimeout
+				member.deadline = time.Now().Add(member.sessionTimeout)
+				result := kg.joinResult(member)
+				result.skipAssignment = memberId == kg.leaderId
+				kg.mu.Unlock()
+				return result
+			}
+		}
+	} else if memberId == "" {
+		memberId = req.clientId + "-" + uuid.NewString()
+		if req.requireKnownMemberId {
+			kg.pending[memberId] = true
+			kg.mu.Unlock()
+			return &kafkaJoinResult{ec: MemberIdRequired, generationId: -1, memberId: memberId}
+		}
+	} else {
+		member = kg.members[memberId]
+		if member == nil {
+			if !kg.pending[memberId] {
+				kg.mu.Unlock()
+				return &kafkaJoinResult{ec: UnknownMemberId, generationId: -1, memberId: memberId}
+			}
+			delete(kg.pending, memberId)
+		}
+	}
+
+	isNew := member == nil
+	changed := isNew || !member.sameProtocols(req.protocols)
+	if isNew {
+		member = &kafkaGroupMember{memberId: memberId, instanceId: req.instanceId, sessionTimeout: req.sessionTimeout}
+		kg.members[memberId] = member
+		if req.instanceId != "" {
+			kg.instances[req.instanceId] = memberId
+		}
+		if kg.leaderId == "" {
+			kg.leaderId = memberId
+		}
+		kg.startSession(l, member)
+	}
+
+	member.clientId = req.clientId
+	member.clientHost = req.clientHost
+	member.sessionTimeout = req.sessionTimeout
+	member.rebalanceTimeout = req.rebalanceTimeout
+	member.protocolType = req.protocolType
+	member.protocols = req.protocols
+	member.deadline = time.Now().Add(member.sessionTimeout)
+
+	// a member that has nothing new to say gets the current generation again
+	if !changed && memberId != kg.leaderId && (kg.state == groupStable || kg.state == groupCompletingRebalance) {
+		result := kg.joinResult(member)
+		kg.mu.Unlock()
+		return result
+	}
+
+	if member.joinWait != nil {
+		// the member sent another join before the prior one completed
+		member.joinWait <- &kafkaJoinResult{ec: RebalanceInProgress, generationId: -1, memberId: memberId}
+	}
+	member.joinWait = make(chan *kafkaJoinResult, 1)
+	member.joining = true
+	wait := member.joinWait
+
+	if kg.state != groupPreparingRebalance {
+		kg.prepareRebalance(l, "member "+memberId+" joined")
+	}
+	kg.tryCompleteJoin(l)
+	kg.mu.Unlock()
+
+	select {
+	case result := <-wait:
+		return result
+	case <-l.Done():
+		return &kafkaJoinResult{ec: CoordinatorNotAvailable, generationId: -1, memberId: memberId}
+	}
+}
+
+// waits for the member's assignment from the leader; the leader's sync
+// carries the assignments of every member, computed by the client's assignor
+func (kg *kafkaGroup) sync(l lane.Lane, memberId, instanceId string, generationId int32, assignments map[string][]byte) *kafkaSyncResult {
+	kg.mu.Lock()
+
+	if ec := kg.checkInstance(memberId, instanceId); ec != NoError {
+		kg.mu.Unlock()
+		return &kafkaSyncResult{ec: ec}
+	}
+	member := kg.members[memberId]
+	if member == nil {
+		kg.mu.Unlock()
+		return &kafkaSyncResult{ec: UnknownMemberId}
+	}
+	if generationId != kg.generationId {
+		kg.mu.Unlock()
+		return &kafkaSyncResult{ec: IllegalGeneration}
+	}
+	member.deadline = time.Now().Add(member.sessionTimeout)
+
+	switch kg.state {
+	case groupPreparingRebalance:
+		kg.mu.Unlock()
+		return &kafkaSyncResult{ec: RebalanceInProgress}
+
+	case groupStable:
+		kg.mu.Unlock()
+		return &kafkaSyncResult{assignment: member.assignment}
+	}
+
+	// completing the rebalance - the leader's sync finishes it
+	if memberId == kg.leaderId {
+		for id, m := range kg.members {
+			m.assignment = assignments[id]
+			if m.assignment == nil {
+				m.assignment = []byte{}
+			}
+		}
+
+		kg.state = groupStable
+		l.Tracef("group %s generation %d is stable", kg.id, kg.generationId)
+
+		for _, m := range kg.members {
+			if m.syncWait != nil {
+				m.syncWait <- &kafkaSyncResult{assignment: m.assignment}
+				m.syncWait = nil
+			}
+		}
+
+		kg.mu.Unlock()
+		return &kafkaSyncResult{assignment: member.assignment}
+	}
+
+	if member.syncWait != nil {
+		member.syncWait <- &kafkaSyncResult{ec: RebalanceInProgress}
+	}
+	member.syncWait = make(chan *kafkaSyncResult, 1)
+	wait := member.syncWait
+	kg.mu.Unlock()
+
+	select {
+	case result := <-wait:
+		return result
+	case <-l.Done():
+		return &kafkaSyncResult{ec: CoordinatorNotAvailable}
+	}
+}
+
+// keeps the member alive, and checks that it belongs to the current generation
+func (kg *kafkaGroup) heartbeat(memberId, instanceId string, generationId int32) kafkaErrorCode {
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+
+	if ec := kg.checkInstance(memberId, instanceId); ec != NoError {
+		return ec
+	}
+	member := kg.members[memberId]
+	if member == nil {
+		return UnknownMemberId
+	}
+	if generationId != kg.generationId {
+		return IllegalGeneration
+	}
+	member.deadline = time.Now().Add(member.sessionTimeout)
+	if kg.state == groupPreparingRebalance {
+		return RebalanceInProgress
+	}
+	return NoError
+}
+
+// removes the member, making the others rebalance; a static member
+// may leave by its instance id alone
+func (kg *kafkaGroup) leave(l lane.Lane, memberId, instanceId string) kafkaErrorCode {
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+
+	if instanceId != "" {
+		if ec := kg.checkInstance(memberId, instanceId); ec != NoError {
+			return ec
+		}
+		if memberId == "" {
+			memberId = kg.instances[instanceId]
+		}
+	}
+	if kg.members[memberId] == nil {
+		return UnknownMemberId
+	}
+
+	kg.removeMember(l, memberId, "member "+memberId+" left")
+	return NoError
+}
+
+// checks whether the member may commit offsets for the group; a commit
+// with no member and a negative generation comes from a standalone consumer
+func (kg *kafkaGroup) validateCommit(memberId string, generationId int32) kafkaErrorCode {
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+
+	if generationId < 0 && memberId == "" {
+		return NoError
+	}
+	if kg.members[memberId] == nil {
+		return UnknownMemberId
+	}
+	if generationId != kg.generationId {
+		return IllegalGeneration
+	}
+	if kg.state == groupCompletingRebalance {
+		return RebalanceInProgress
+	}
+	return NoError
+}
+
+func (kg *kafkaGroup) removeMember(l lane.Lane, memberId string, reason string) {
+	kg.dropMember(memberId)
+
+	if kg.state != groupPreparingRebalance {
+		kg.prepareRebalance(l, reason)
+	}
+	kg.tryCompleteJoin(l)
+}
+
+// takes the member out of the group without starting a rebalance
+func (kg *kafkaGroup) dropMember(memberId string) {
+	member := kg.members[memberId]
+	delete(kg.members, memberId)
+	member.session.Stop()
+	if member.instanceId != "" && kg.instances[member.instanceId] == memberId {
+		delete(kg.instances, member.instanceId)
+	}
+
+	if member.joinWait != nil {
+		member.joinWait <- &kafkaJoinResult{ec: UnknownMemberId, generationId: -1, memberId: memberId}
+		member.joinWait = nil
+	}
+	if member.syncWait != nil {
+		member.syncWait <- &kafkaSyncResult{ec: UnknownMemberId}
+		member.syncWait = nil
+	}
+
+	if kg.leaderId == memberId {
+		kg.leaderId = ""
+		for id := range kg.members {
+			kg.leaderId = id
+			break
+		}
+	}
+}
+
+// gets the protocol type and name selected for the current generation
+func (kg *kafkaGroup) protocol() (protocolType, protocolName string) {
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+
+	return kg.protocolType, kg.protocolName
+}
+
+// verifies that a static member's instance id belongs to the member id;
+// a member id that has been replaced by a newer instance is fenced
+func (kg *kafkaGroup) checkInstance(memberId, instanceId string) kafkaErrorCode {
+	if instanceId == "" {
+		return NoError
+	}
+	current, exists := kg.instances[instanceId]
+	if !exists {
+		if memberId == "" {
+			return NoError
+		}
+		return UnknownMemberId
+	}
+	if memberId != "" && memberId != current {
+		return FencedInstanceId
+	}
+	return NoError
+}
+
+// moves a static member to a new member id, fencing requests made with the old one
+func (kg *kafkaGroup) replaceStaticMember(l lane.Lane, oldId, newId string) *kafkaGroupMember {
+	member := kg.members[oldId]
+	delete(kg.members, oldId)
+	member.memberId = newId
+	kg.members[newId] = member
+	kg.instances[member.instanceId] = newId
+	if kg.leaderId == oldId {
+		kg.leaderId = newId
+	}
+
+	if member.joinWait != nil {
+		member.joinWait <- &kafkaJoinResult{ec: FencedInstanceId, generationId: -1, memberId: oldId}
+		member.joinWait = nil
+	}
+	if member.syncWait != nil {
+		member.syncWait <- &kafkaSyncResult{ec: FencedInstanceId}
+		member.syncWait = nil
+	}
+
+	l.Tracef("group %s static member %s replaced %s with %s", kg.id, member.instanceId, oldId, newId)
+	return member
+}
+
+// expires the member when it stops heartbeating; a member that is waiting
+// for the join phase is covered by the rebalance timeout instead
+func (kg *kafkaGroup) startSession(l lane.Lane, member *kafkaGroupMember) {
+	member.deadline = time.Now().Add(member.sessionTimeout)
+	member.session = time.AfterFunc(member.sessionTimeout, func() {
+		kg.mu.Lock()
+		defer kg.mu.Unlock()
+
+		if kg.members[member.memberId] != member {
+			return
+		}
+
+		remaining := time.Until(member.deadline)
+		if member.joinWait != nil {
+			remaining = member.sessionTimeout
+		}
+		if remaining > 0 {
+			member.session.Reset(remaining)
+			return
+		}
+
+		kg.removeMember(l, member.memberId, "member "+member.memberId+" session expired")
+	})
+}
+
+func (kg *kafkaGroup) prepareRebalance(l lane.Lane, reason string) {
+	l.Tracef("group %s preparing rebalance: %s", kg.id, reason)
+	kg.state = groupPreparingRebalance
+
+	// members that don't rejoin within the rebalance timeout are left out
+	timeout := time.Duration(0)
+	for _, member := range kg.members {
+		if member.rebalanceTimeout > timeout {
+			timeout = member.rebalanceTimeout
+		}
+	}
+	if kg.rebalance != nil {
+		kg.rebalance.Stop()
+	}
+	kg.rebalanceSeq++
+	seq := kg.rebalanceSeq
+	kg.rebalance = time.AfterFunc(timeout, func() {
+		kg.mu.Lock()
+		defer kg.mu.Unlock()
+
+		if kg.state != groupPreparingRebalance || kg.rebalanceSeq != seq {
+			return
+		}
+		for _, id := range kg.sortedMemberIds() {
+			if !kg.members[id].joining {
+				l.Tracef("group %s member %s missed the rebalance", kg.id, id)
+				kg.dropMember(id)
+			}
+		}
+		kg.tryCompleteJoin(l)
+	})
+
+	for _, member := range kg.members {
+		// members waiting for an assignment need to rejoin
+		if member.syncWait != nil {
+			member.syncWait <- &kafkaSyncResult{ec: RebalanceInProgress}
+			member.syncWait = nil
+		}
+		if member.joinWait == nil {
+			member.joining = false
+		}
+	}
+}
+
+// finishes the join phase once every known member has rejoined
+func (kg *kafkaGroup) tryCompleteJoin(l lane.Lane) {
+	if kg.state != groupPreparingRebalance {
+		return
+	}
+	for _, member := range kg.members {
+		if !member.joining {
+			return
+		}
+	}
+
+	kg.rebalance.Stop()
+	kg.generationId++
+	if len(kg.members) == 0 {
+		kg.state = groupEmpty
+		kg.protocolType = ""
+		kg.protocolName = ""
+		l.Tracef("group %s generation %d is empty", kg.id, kg.generationId)
+		return
+	}
+
+	kg.state = groupCompletingRebalance
+	if kg.members[kg.leaderId] == nil {
+		kg.leaderId = kg.sortedMemberIds()[0]
+	}
+	kg.protocolName = kg.selectProtocol()
+	for _, member := range kg.members {
+		kg.protocolType = member.protocolType
+		break
+	}
+	l.Tracef("group %s generation %d: %d members, leader %s, protocol %s", kg.id, kg.generationId, len(kg.members), kg.leaderId, kg.protocolName)
+
+	for _, member := range kg.members {
+		member.joining = false
+		member.assignment = []byte{}
+		member.deadline = time.Now().Add(member.sessionTimeout)
+		if member.joinWait != nil {
+			member.joinWait <- kg.joinResult(member)
+			member.joinWait = nil
+		}
+	}
+}
+
+func (kg *kafkaGroup) joinResult(member *kafkaGroupMember) *kafkaJoinResult {
+	result := &kafkaJoinResult{
+		generationId: kg.generationId,
+		protocolName: kg.protocolName,
+		leaderId:     kg.leaderId,
+		memberId:     member.memberId,
+		members:      []kafkaJoinMember{},
+	}
+
+	if member.memberId == kg.leaderId {
+		for _, id := range kg.sortedMemberIds() {
+			m := kg.members[id]
+			metadata, _ := m.protocolMetadata(kg.protocolName)
+			result.members = append(result.members, kafkaJoinMember{memberId: id, instanceId: m.instanceId, metadata: metadata})
+		}
+	}
+
+	return result
+}
+
+// picks the protocol that all members support, by vote of each member's preference
+func (kg *kafkaGroup) selectProtocol() string {
+	votes := map[string]int{}
+	for _, member := range kg.members {
+		for _, p := range member.protocols {
+			if kg.allSupport(p.name) {
+				votes[p.name]++
+				break
+			}
+		}
+	}
+
+	best := ""
+	for name, count := range votes {
+		if best == "" || count > votes[best] || (count == votes[best] && name < best) {
+			best = name
+		}
+	}
+	return best
+}
+
+func (kg *kafkaGroup) allSupport(protocolName string) bool {
+	for _, member := range kg.members {
+		if _, supported := member.protocolMetadata(protocolName); !supported {
+			return false
+		}
+	}
+	return true
+}
+
+// a joining member must share the group's protocol type and at least one protocol
+func (kg *kafkaGroup) supportsProtocols(req *kafkaJoinRequest) bool {
+	if req.protocolType == "" || len(req.protocols) == 0 {
+		return false
+	}
+
+	others := 0
+	for id, member := range kg.members {
+		if id == req.memberId {
+			continue
+		}
+		others++
+		if member.protocolType != req.protocolType {
+			return false
+		}
+	}
+	if others == 0 {
+		return true
+	}
+
+	for _, p := range req.protocols {
+		supported := true
+		for id, member := range kg.members {
+			if id == req.memberId {
+				continue
+			}
+			if _, ok := member.protocolMetadata(p.name); !ok {
+				supported = false
+				break
+			}
+		}
+		if supported {
+			return true
+		}
+	}
+	return false
+}
+
+func (kg *kafkaGroup) sortedMemberIds() []string {
+	ids := make([]string, 0, len(kg.members))
+	for id := range kg.members {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (member *kafkaGroupMember) protocolMetadata(name string) (metadata []byte, supported bool) {
+	for _, p := range member.protocols {
+		if p.name == name {
+			return p.metadata, true
+		}
+	}
+	return nil, false
+}
+
+func (member *kafkaGroupMember) sameProtocols(protocols []kafkaGroupProtocol) bool {
+	if len(member.protocols) != len(protocols) {
+		return false
+	}
+	for i, p := range protocols {
+		if member.protocols[i].name != p.name || !bytes.Equal(member.protocols[i].metadata, p.metadata) {
+			return false
+		}
+	}
+	return true
+}
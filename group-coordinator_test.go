@@ -0,0 +1,493 @@
+package kafkamock
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jimsnab/go-lane"
+	"github.com/segmentio/kafka-go"
+)
+
+type (
+	// the consumer protocol's subscription and assignment, as a client encodes them
+	testConsumerSubscription struct {
+		Version  int16
+		Topics   []string
+		UserData []byte
+	}
+
+	testConsumerAssignment struct {
+		Version  int16
+		Topics   []testTopicAssignment
+		UserData []byte
+	}
+
+	testTopicAssignment struct {
+		Topic      string
+		Partitions []int32
+	}
+)
+
+func testSubscription(topics ...string) []byte {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	encodeObject(w, testConsumerSubscription{Topics: topics, UserData: []byte{}})
+	w.Flush()
+	return buf.Bytes()
+}
+
+func testAssignment(topic string, partitions ...int32) []byte {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	encodeObject(w, testConsumerAssignment{Topics: []testTopicAssignment{{Topic: topic, Partitions: partitions}}, UserData: []byte{}})
+	w.Flush()
+	return buf.Bytes()
+}
+
+func testJoinRequest(memberId string, topics ...string) *kafkaJoinRequest {
+	return &kafkaJoinRequest{
+		memberId:         memberId,
+		clientId:         "test",
+		sessionTimeout:   time.Second * 10,
+		rebalanceTimeout: time.Second * 10,
+		protocolType:     "consumer",
+		protocols:        []kafkaGroupProtocol{{name: "range", metadata: testSubscription(topics...)}},
+	}
+}
+
+func testAssignedPartitions(t *testing.T, assignment []byte) map[string][]int32 {
+	reader := bufio.NewReaderSize(bytes.NewReader(assignment), len(assignment)+16)
+	next, obj := peekObject(reader, 0, reflect.TypeOf(testConsumerAssignment{}))
+	if next < 0 {
+		t.Fatal("invalid assignment")
+	}
+
+	partitions := map[string][]int32{}
+	for _, ta := range obj.(testConsumerAssignment).Topics {
+		partitions[ta.Topic] = ta.Partitions
+	}
+	return partitions
+}
+
+func testGroupDataStore(partitions int) *kafkaDataStore {
+	ds := newKafkaDataStore()
+	kt := ds.createTopic("topic-a")
+	for n := 0; n < partitions; n++ {
+		kt.createPartition(int32(n))
+	}
+	return ds
+}
+
+func TestGroupRebalance(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	kg := testGroupDataStore(4).getGroup("group")
+
+	// the first member forms the group by itself
+	jr1 := kg.join(tl, testJoinRequest("", "topic-a"))
+	if jr1.ec != NoError || jr1.generationId != 1 || jr1.leaderId != jr1.memberId || len(jr1.members) != 1 {
+		t.Fatalf("unexpected first join %+v", jr1)
+	}
+	sr1 := kg.sync(tl, jr1.memberId, "", 1, map[string][]byte{jr1.memberId: testAssignment("topic-a", 0, 1, 2, 3)})
+	if sr1.ec != NoError || len(testAssignedPartitions(t, sr1.assignment)["topic-a"]) != 4 {
+		t.Fatal("first member should own all partitions")
+	}
+	if kg.state != groupStable {
+		t.Errorf("unexpected state %s", kg.state)
+	}
+
+	// a second member causes a rebalance that waits for the first to rejoin
+	var wg sync.WaitGroup
+	var jr2 *kafkaJoinResult
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		jr2 = kg.join(tl, testJoinRequest("", "topic-a"))
+	}()
+
+	for {
+		if ec := kg.heartbeat(jr1.memberId, "", 1); ec == RebalanceInProgress {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	jr1 = kg.join(tl, testJoinRequest(jr1.memberId, "topic-a"))
+	wg.Wait()
+
+	if jr1.generationId != 2 || jr2.generationId != 2 || jr2.leaderId != jr1.memberId {
+		t.Fatalf("unexpected rebalance %+v %+v", jr1, jr2)
+	}
+	if len(jr1.members) != 2 || len(jr2.members) != 0 {
+		t.Error("only the leader receives the members")
+	}
+
+	// the follower's sync waits for the leader's assignments
+	var sr2 *kafkaSyncResult
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		sr2 = kg.sync(tl, jr2.memberId, "", 2, nil)
+	}()
+
+	for {
+		kg.mu.Lock()
+		waiting := kg.members[jr2.memberId].syncWait != nil
+		kg.mu.Unlock()
+		if waiting {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	sr1 = kg.sync(tl, jr1.memberId, "", 2, map[string][]byte{
+		jr1.memberId: testAssignment("topic-a", 0, 2),
+		jr2.memberId: testAssignment("topic-a", 1, 3),
+	})
+	wg.Wait()
+
+	p1 := testAssignedPartitions(t, sr1.assignment)["topic-a"]
+	p2 := testAssignedPartitions(t, sr2.assignment)["topic-a"]
+	if !reflect.DeepEqual(p1, []int32{0, 2}) || !reflect.DeepEqual(p2, []int32{1, 3}) {
+		t.Fatalf("leader's assignments not served: %v %v", p1, p2)
+	}
+
+	// a follower syncing late gets the same assignment
+	if sr := kg.sync(tl, jr2.memberId, "", 2, nil); !bytes.Equal(sr.assignment, sr2.assignment) {
+		t.Error("late sync assignment mismatch")
+	}
+
+	// the leader leaves and the remaining member takes over
+	if ec := kg.leave(tl, jr1.memberId, ""); ec != NoError {
+		t.Fatal("leave failed")
+	}
+	if ec := kg.heartbeat(jr2.memberId, "", 2); ec != RebalanceInProgress {
+		t.Errorf("expected rebalance in progress, got %d", ec)
+	}
+	jr2 = kg.join(tl, testJoinRequest(jr2.memberId, "topic-a"))
+	if jr2.generationId != 3 || jr2.leaderId != jr2.memberId {
+		t.Errorf("unexpected join after leave %+v", jr2)
+	}
+}
+
+func TestGroupErrors(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	kg := testGroupDataStore(1).getGroup("group")
+
+	if jr := kg.join(tl, testJoinRequest("unknown", "topic-a")); jr.ec != UnknownMemberId {
+		t.Error("expected unknown member id on join")
+	}
+
+	jr := kg.join(tl, testJoinRequest("", "topic-a"))
+	if ec := kg.heartbeat("unknown", "", jr.generationId); ec != UnknownMemberId {
+		t.Error("expected unknown member id on heartbeat")
+	}
+	if ec := kg.heartbeat(jr.memberId, "", jr.generationId+1); ec != IllegalGeneration {
+		t.Error("expected illegal generation on heartbeat")
+	}
+	if sr := kg.sync(tl, jr.memberId, "", jr.generationId-1, nil); sr.ec != IllegalGeneration {
+		t.Error("expected illegal generation on sync")
+	}
+	if ec := kg.validateCommit(jr.memberId, jr.generationId); ec != RebalanceInProgress {
+		t.Error("expected rebalance in progress on commit before sync")
+	}
+	if sr := kg.sync(tl, jr.memberId, "", jr.generationId, nil); sr.ec != NoError || sr.assignment == nil || len(sr.assignment) != 0 {
+		t.Error("a member left out by the leader gets an empty assignment")
+	}
+	if ec := kg.validateCommit(jr.memberId, jr.generationId); ec != NoError {
+		t.Error("expected commit to be accepted")
+	}
+	if ec := kg.validateCommit("", -1); ec != NoError {
+		t.Error("expected standalone commit to be accepted")
+	}
+	if ec := kg.leave(tl, "unknown", ""); ec != UnknownMemberId {
+		t.Error("expected unknown member id on leave")
+	}
+
+	other := testJoinRequest("", "topic-a")
+	other.protocols[0].name = "other"
+	if jr := kg.join(tl, other); jr.ec != InconsistentGroupProtocol {
+		t.Error("expected inconsistent group protocol")
+	}
+}
+
+func TestGroupMemberIdRequired(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	kg := testGroupDataStore(1).getGroup("group")
+
+	req := testJoinRequest("", "topic-a")
+	req.requireKnownMemberId = true
+	jr := kg.join(tl, req)
+	if jr.ec != MemberIdRequired || jr.memberId == "" {
+		t.Fatalf("expected member id required, got %+v", jr)
+	}
+
+	req.memberId = jr.memberId
+	jr = kg.join(tl, req)
+	if jr.ec != NoError || jr.memberId != req.memberId || jr.generationId != 1 {
+		t.Errorf("unexpected join %+v", jr)
+	}
+}
+
+func TestKafkaGroupSharesPartitions(t *testing.T) {
+	topics := []string{"topic-a"}
+	tl, mock := testCreateKafkaMockServer(t, 21001, topics)
+	defer testStopMockServer(t, mock)
+	mock.CreatePartitionTopics(topics, 0)
+	mock.CreatePartitionTopics(topics, 1)
+
+	r1 := testKafkaConnectEx(t, 21001, topics, 0, time.Millisecond*50, 1024, 0)
+	defer testCloseKafkaReader(t, tl, r1)
+	r2 := testKafkaConnectEx(t, 21001, topics, 0, time.Millisecond*50, 1024, 0)
+	defer testCloseKafkaReader(t, tl, r2)
+	defer mock.FinishRequests()
+
+	// spin until both readers are in a stable group - upon failure, the test times out
+	kg := mock.ds.getGroup("kafka-mock")
+	for {
+		kg.mu.Lock()
+		ready := len(kg.members) == 2 && kg.state == groupStable
+		kg.mu.Unlock()
+		if ready {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	for n := 0; n < 3; n++ {
+		mock.SimplePost("topic-a", n, nil, []byte("test"))
+	}
+
+	// both readers get a share of the three partitions
+	seen := map[int]int{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, r := range []*kafka.Reader{r1, r2} {
+		wg.Add(1)
+		go func(r *kafka.Reader) {
+			defer wg.Done()
+			m, err := r.FetchMessage(tl)
+			if err != nil {
+				t.Errorf("kafka-feed: read message error: %v", err)
+				return
+			}
+			mu.Lock()
+			seen[m.Partition]++
+			mu.Unlock()
+		}(r)
+	}
+	wg.Wait()
+
+	if len(seen) != 2 {
+		t.Errorf("expected messages from two partitions, got %v", seen)
+	}
+}
+
+func testWaitForGroupState(kg *kafkaGroup, state kafkaGroupState) {
+	for {
+		kg.mu.Lock()
+		current := kg.state
+		kg.mu.Unlock()
+		if current == state {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// forms a stable group of two members that each own one partition
+func testStableGroup(t *testing.T, tl lane.Lane, kg *kafkaGroup, first, second *kafkaJoinRequest) int32 {
+	first.memberId = kg.join(tl, first).memberId
+
+	var wg sync.WaitGroup
+	var jr2 *kafkaJoinResult
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		jr2 = kg.join(tl, second)
+	}()
+
+	testWaitForGroupState(kg, groupPreparingRebalance)
+	jr1 := kg.join(tl, first)
+	wg.Wait()
+	second.memberId = jr2.memberId
+
+	sr := kg.sync(tl, jr1.leaderId, "", jr1.generationId, map[string][]byte{
+		first.memberId:  testAssignment("topic-a", 0),
+		second.memberId: testAssignment("topic-a", 1),
+	})
+	if sr.ec != NoError {
+		t.Fatalf("leader sync failed: %d", sr.ec)
+	}
+	return jr1.generationId
+}
+
+func TestGroupSessionExpires(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	kg := testGroupDataStore(2).getGroup("group")
+
+	survivor := testJoinRequest("", "topic-a")
+	lapsed := testJoinRequest("", "topic-a")
+	lapsed.sessionTimeout = time.Millisecond * 100
+	generationId := testStableGroup(t, tl, kg, survivor, lapsed)
+
+	// only the survivor heartbeats, until it is told to rejoin
+	start := time.Now()
+	for {
+		ec := kg.heartbeat(survivor.memberId, "", generationId)
+		if ec == RebalanceInProgress {
+			break
+		}
+		if ec != NoError || time.Since(start) > time.Second*5 {
+			t.Fatalf("unexpected heartbeat result %d", ec)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if time.Since(start) < time.Millisecond*100 {
+		t.Error("member expired before its session timeout")
+	}
+
+	jr := kg.join(tl, survivor)
+	if jr.ec != NoError || len(jr.members) != 1 || jr.leaderId != survivor.memberId {
+		t.Fatalf("unexpected rejoin %+v", jr)
+	}
+	if ec := kg.heartbeat(lapsed.memberId, "", jr.generationId); ec != UnknownMemberId {
+		t.Errorf("expected the lapsed member to be unknown, got %d", ec)
+	}
+}
+
+func TestGroupSlowMemberMissesRebalance(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	kg := testGroupDataStore(2).getGroup("group")
+
+	fast := testJoinRequest("", "topic-a")
+	fast.rebalanceTimeout = time.Millisecond * 100
+	slow := testJoinRequest("", "topic-a")
+	slow.rebalanceTimeout = time.Millisecond * 100
+	testStableGroup(t, tl, kg, fast, slow)
+
+	// a new member starts a rebalance that the slow member doesn't rejoin
+	var wg sync.WaitGroup
+	var jr3 *kafkaJoinResult
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		req := testJoinRequest("", "topic-a")
+		req.rebalanceTimeout = time.Millisecond * 100
+		jr3 = kg.join(tl, req)
+	}()
+
+	testWaitForGroupState(kg, groupPreparingRebalance)
+
+	start := time.Now()
+	jr := kg.join(tl, fast)
+	wg.Wait()
+
+	if time.Since(start) < time.Millisecond*50 {
+		t.Error("join completed before the rebalance timeout")
+	}
+	if jr.ec != NoError || jr3.ec != NoError || jr.generationId != jr3.generationId {
+		t.Fatalf("unexpected joins %+v %+v", jr, jr3)
+	}
+
+	kg.mu.Lock()
+	_, slowIsMember := kg.members[slow.memberId]
+	count := len(kg.members)
+	kg.mu.Unlock()
+	if slowIsMember || count != 2 {
+		t.Errorf("the slow member should have been removed, %d members", count)
+	}
+
+	if jr := kg.join(tl, slow); jr.ec != UnknownMemberId {
+		t.Errorf("expected the slow member to be unknown, got %d", jr.ec)
+	}
+}
+
+func TestGroupStaticMemberRejoin(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	kg := testGroupDataStore(2).getGroup("group-a")
+
+	first := testJoinRequest("", "topic-a")
+	first.instanceId = "instance-1"
+	second := testJoinRequest("", "topic-a")
+	second.instanceId = "instance-2"
+	generationId := testStableGroup(t, tl, kg, first, second)
+
+	// the second instance restarts and rejoins without knowing its member id
+	restarted := testJoinRequest("", "topic-a")
+	restarted.instanceId = "instance-2"
+	jr := kg.join(tl, restarted)
+	if jr.ec != NoError || jr.generationId != generationId || jr.memberId == second.memberId {
+		t.Fatalf("unexpected static rejoin %+v", jr)
+	}
+	if kg.state != groupStable {
+		t.Errorf("static rejoin caused a rebalance: %s", kg.state)
+	}
+
+	sr := kg.sync(tl, jr.memberId, "instance-2", jr.generationId, nil)
+	if sr.ec != NoError || !reflect.DeepEqual(testAssignedPartitions(t, sr.assignment)["topic-a"], []int32{1}) {
+		t.Error("static member lost its assignment")
+	}
+
+	// the old member id is fenced
+	if ec := kg.heartbeat(second.memberId, "instance-2", generationId); ec != FencedInstanceId {
+		t.Errorf("expected fenced instance id, got %d", ec)
+	}
+	if jr := kg.join(tl, second); jr.ec != FencedInstanceId {
+		t.Errorf("expected fenced instance id on join, got %d", jr.ec)
+	}
+	if ec := kg.heartbeat(jr.memberId, "instance-2", generationId); ec != NoError {
+		t.Errorf("heartbeat failed: %d", ec)
+	}
+
+	// a static member leaves by its instance id
+	if ec := kg.leave(tl, "", "instance-2"); ec != NoError {
+		t.Errorf("static leave failed: %d", ec)
+	}
+	if ec := kg.leave(tl, "", "instance-2"); ec != UnknownMemberId {
+		t.Errorf("expected unknown member, got %d", ec)
+	}
+	if kg.state != groupPreparingRebalance || len(kg.instances) != 1 {
+		t.Errorf("unexpected group after leave: %s, %d instances", kg.state, len(kg.instances))
+	}
+}
+
+func TestLeaveGroupBatch(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	ds := testGroupDataStore(2)
+	kg := ds.getGroup("group-a")
+
+	first := testJoinRequest("", "topic-a")
+	first.instanceId = "instance-1"
+	second := testJoinRequest("", "topic-a")
+	testStableGroup(t, tl, kg, first, second)
+
+	instanceId := "instance-1"
+	wrongId := "instance-9"
+	request := &leaveGroupRequest{
+		GroupId: "group-a",
+		Members: []leaveGroupMember{
+			{MemberId: "stale", GroupInstanceId: &instanceId},
+			{MemberId: second.memberId},
+			{GroupInstanceId: &wrongId},
+		},
+	}
+
+	lgr := testHandlerRequest[*leaveGroupResponse](t, tl, ds, leaveGroup, ApiKeyLeaveGroup, 5, request)
+	expected := []kafkaErrorCode{FencedInstanceId, NoError, UnknownMemberId}
+	if len(lgr.Members) != len(expected) {
+		t.Fatalf("expected %d member responses, got %d", len(expected), len(lgr.Members))
+	}
+	for i, ec := range expected {
+		if lgr.Members[i].ErrorCode != int16(ec) {
+			t.Errorf("member %d: expected error %d, got %d", i, ec, lgr.Members[i].ErrorCode)
+		}
+	}
+	if len(kg.members) != 1 || kg.members[first.memberId] == nil {
+		t.Error("only the static member should remain")
+	}
+}
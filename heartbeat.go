@@ -5,23 +5,30 @@ import (
 )
 
 type (
-	heartbeatRequestV0 struct {
-		GroupId      string
-		GenerationId int32
-		MemberId     string
+	heartbeatRequest struct {
+		GroupId         string
+		GenerationId    int32
+		MemberId        string
+		GroupInstanceId NullableString `kafka:"min=3"`
 	}
 
-	heartbeatResponseV0 struct {
-		ErrorCode int16
+	heartbeatResponse struct {
+		ThrottleTimeMs int32 `kafka:"min=1"`
+		ErrorCode      int16
 	}
 )
 
-func heartbeatV0(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeader) (response any, rtags map[int]any, err error) {
-	_, err = readRequest[heartbeatRequestV0](reader)
+func heartbeat(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeader) (response any, rtags map[int]any, err error) {
+	request, err := readVersionedRequest[heartbeatRequest](reader, kmh)
 	if err != nil {
 		return
 	}
 
-	response = &heartbeatResponseV0{}
+	ec := kc.authorizationError(aclOpRead, aclResourceGroup, request.GroupId)
+	if ec == NoError {
+		ec = kc.ds.getGroup(request.GroupId).heartbeat(request.MemberId, stringOrEmpty(request.GroupInstanceId), request.GenerationId)
+	}
+
+	response = &heartbeatResponse{ErrorCode: int16(ec)}
 	return
 }
@@ -48,3 +48,29 @@ func readRequest[T any](reader *bufio.Reader) (obj *T, err error) {
 	obj = &request
 	return
 }
+
+// reads a request that is laid out according to the api version in the message header
+func readVersionedRequest[T any](reader *bufio.Reader, kmh *kafkaMessageHeader) (obj *T, err error) {
+	var request T
+	next, r := peekVersionedObject(reader, 0, reflect.TypeOf(request), kmh.codecVersion())
+	if next < 0 {
+		err = fmt.Errorf("bad request %T v%d", request, kmh.RequestApiVersion)
+		return
+	}
+	request, ok := r.(T)
+	if !ok {
+		panic("unexpected request object type")
+	}
+	reader.Discard(next)
+
+	obj = &request
+	return
+}
+
+// gets the value of a nullable request string, treating null as empty
+func stringOrEmpty(v NullableString) string {
+	if v == nil {
+		return ""
+	}
+	return *v
+}
@@ -0,0 +1,66 @@
+package kafkamock
+
+import (
+	"bufio"
+)
+
+type (
+	incrementalAlterConfigsRequest struct {
+		Resources    []incrementalAlterConfigsResource
+		ValidateOnly bool
+	}
+
+	incrementalAlterConfigsResource struct {
+		ResourceType int8
+		ResourceName string
+		Configs      []incrementalAlterConfigsConfig
+	}
+
+	incrementalAlterConfigsConfig struct {
+		Name            string
+		ConfigOperation int8
+		Value           NullableString
+	}
+
+	incrementalAlterConfigsResponse struct {
+		ThrottleTimeMs int32
+		Responses      []incrementalAlterConfigsResult
+	}
+
+	incrementalAlterConfigsResult struct {
+		ErrorCode    int16
+		ErrorMessage NullableString
+		ResourceType int8
+		ResourceName string
+	}
+)
+
+func incrementalAlterConfigs(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeader) (response any, rtags map[int]any, err error) {
+	request, err := readVersionedRequest[incrementalAlterConfigsRequest](reader, kmh)
+	if err != nil {
+		return
+	}
+
+	iacr := &incrementalAlterConfigsResponse{Responses: make([]incrementalAlterConfigsResult, 0, len(request.Resources))}
+	for _, resource := range request.Resources {
+		changes := make([]kafkaConfigChange, 0, len(resource.Configs))
+		for _, config := range resource.Configs {
+			changes = append(changes, kafkaConfigChange{name: config.Name, op: kafkaConfigOp(config.ConfigOperation), value: config.Value})
+		}
+
+		ec, message := kc.configAuthorizationError(aclOpAlterConfigs, kafkaResourceType(resource.ResourceType), resource.ResourceName), "authorization failed"
+		if ec == NoError {
+			ec, message = kc.ds.alterResourceConfigs(kafkaResourceType(resource.ResourceType), resource.ResourceName, changes, false, request.ValidateOnly)
+		}
+
+		result := incrementalAlterConfigsResult{ErrorCode: int16(ec), ResourceType: resource.ResourceType, ResourceName: resource.ResourceName}
+		if ec != NoError {
+			result.ErrorMessage = &message
+			kc.l.Tracef("incremental alter configs of %s failed: %s", resource.ResourceName, message)
+		}
+		iacr.Responses = append(iacr.Responses, result)
+	}
+
+	response = iacr
+	return
+}
@@ -0,0 +1,80 @@
+package kafkamock
+
+import (
+	"bufio"
+	"strconv"
+	"time"
+)
+
+type (
+	initProducerIdRequest struct {
+		TransactionalId      NullableString
+		TransactionTimeoutMs int32
+		ProducerId           int64 `kafka:"min=3"`
+		ProducerEpoch        int16 `kafka:"min=3"`
+	}
+
+	initProducerIdResponse struct {
+		ThrottleTimeMs int32
+		ErrorCode      int16
+		ProducerId     int64
+		ProducerEpoch  int16
+	}
+)
+
+func initProducerId(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeader) (response any, rtags map[int]any, err error) {
+	request, err := readVersionedRequest[initProducerIdRequest](reader, kmh)
+	if err != nil {
+		return
+	}
+
+	if kmh.RequestApiVersion < 3 {
+		request.ProducerId = kNoProducerId
+		request.ProducerEpoch = kNoProducerEpoch
+	}
+
+	var id int64 = kNoProducerId
+	var epoch int16 = kNoProducerEpoch
+	var ec kafkaErrorCode
+
+	// a transaction can't be allowed to run longer than the broker permits
+	transactionalId := stringOrEmpty(request.TransactionalId)
+	maxTimeoutMs, _ := strconv.ParseInt(kc.ds.brokerConfig("transaction.max.timeout.ms"), 10, 64)
+	if transactionalId != "" && !kc.authorized(aclOpWrite, aclResourceTransactionalId, transactionalId) {
+		ec = TransactionalIdAuthorizationFailed
+	} else if transactionalId == "" && !kc.idempotentWriteAuthorized() {
+		ec = ClusterAuthorizationFailed
+	} else if transactionalId != "" && (request.TransactionTimeoutMs <= 0 || int64(request.TransactionTimeoutMs) > maxTimeoutMs) {
+		ec = InvalidTransactionTimeout
+	} else {
+		timeout := time.Duration(request.TransactionTimeoutMs) * time.Millisecond
+		id, epoch, ec = kc.ds.initProducer(kc.l, transactionalId, timeout, request.ProducerId, request.ProducerEpoch)
+	}
+
+	if ec != NoError {
+		kc.l.Tracef("init producer id failed: %d", ec)
+	} else {
+		kc.l.Tracef("init producer id %d epoch %d", id, epoch)
+	}
+
+	response = &initProducerIdResponse{
+		ErrorCode:     int16(fencedError(ec, kmh.RequestApiVersion, 4)),
+		ProducerId:    id,
+		ProducerEpoch: epoch,
+	}
+	return
+}
+
+// whether the client may produce idempotently, which takes idempotent write
+// on the cluster, or write on any topic
+func (kc *kafkaClient) idempotentWriteAuthorized() bool {
+	if kc.authorized(aclOpIdempotentWrite, aclResourceCluster, kClusterResourceName) {
+		return true
+	}
+	for _, kt := range kc.ds.sortedTopics() {
+		if kc.authorized(aclOpWrite, aclResourceTopic, kt.Name) {
+			return true
+		}
+	}
+	return false
+}
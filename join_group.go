@@ -2,51 +2,109 @@ package kafkamock
 
 import (
 	"bufio"
+	"time"
 )
 
 type (
-	joinGroupRequestV1 struct {
+	joinGroupRequest struct {
 		GroupId            string
 		SessionTimeoutMs   int32
-		RebalanceTimeoutMs int32
+		RebalanceTimeoutMs int32 `kafka:"min=1"`
 		MemberId           string
+		GroupInstanceId    NullableString `kafka:"min=5"`
 		ProtocolType       string
-		Protocols          []joinGroupProtocolV1
+		Protocols          []joinGroupProtocol
+		Reason             NullableString `kafka:"min=8"`
 	}
 
-	joinGroupProtocolV1 struct {
+	joinGroupProtocol struct {
 		Name     string
 		Metadata []byte
 	}
 
-	joinGroupResponseV1 struct {
-		ErrorCode    int16
-		GenerationId int32
-		ProtocolName string
-		Leader       string
-		MemberId     string
-		Members      []joinGroupMemberV1
+	joinGroupResponse struct {
+		ThrottleTimeMs int32 `kafka:"min=2"`
+		ErrorCode      int16
+		GenerationId   int32
+		ProtocolType   NullableString `kafka:"min=7"`
+		ProtocolName   NullableString
+		Leader         string
+		SkipAssignment bool `kafka:"min=9"`
+		MemberId       string
+		Members        []joinGroupMember
 	}
 
-	joinGroupMemberV1 struct {
-		MemberId string
-		Metadata []byte
+	joinGroupMember struct {
+		MemberId        string
+		GroupInstanceId NullableString `kafka:"min=5"`
+		Metadata        []byte
 	}
 )
 
-func joinGroupV1(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeader) (response any, rtags map[int]any, err error) {
-	_, err = readRequest[joinGroupRequestV1](reader)
+func joinGroup(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeader) (response any, rtags map[int]any, err error) {
+	request, err := readVersionedRequest[joinGroupRequest](reader, kmh)
 	if err != nil {
 		return
 	}
 
-	response = &joinGroupResponseV1{
-		ProtocolName: "roundrobin",
-		Leader:       "me",
-		MemberId:     "1",
-		Members: []joinGroupMemberV1{
-			{MemberId: "you"},
-		},
+	if kmh.RequestApiVersion < 1 {
+		request.RebalanceTimeoutMs = request.SessionTimeoutMs
+	}
+
+	if ec := kc.authorizationError(aclOpRead, aclResourceGroup, request.GroupId); ec != NoError {
+		jgr := &joinGroupResponse{ErrorCode: int16(ec), GenerationId: -1, MemberId: request.MemberId, Members: []joinGroupMember{}}
+		if kmh.RequestApiVersion < 7 {
+			// a failed join has no protocol
+			noProtocol := ""
+			jgr.ProtocolName = &noProtocol
+		}
+		response = jgr
+		return
+	}
+
+	jr := &kafkaJoinRequest{
+		memberId:             request.MemberId,
+		instanceId:           stringOrEmpty(request.GroupInstanceId),
+		clientId:             kmh.Client,
+		sessionTimeout:       time.Duration(request.SessionTimeoutMs) * time.Millisecond,
+		rebalanceTimeout:     time.Duration(request.RebalanceTimeoutMs) * time.Millisecond,
+		protocolType:         request.ProtocolType,
+		protocols:            make([]kafkaGroupProtocol, 0, len(request.Protocols)),
+		requireKnownMemberId: kmh.RequestApiVersion >= 4,
+	}
+	if kc.conn != nil {
+		jr.clientHost = kc.String()
+	}
+	for _, p := range request.Protocols {
+		jr.protocols = append(jr.protocols, kafkaGroupProtocol{name: p.Name, metadata: p.Metadata})
+	}
+
+	kg := kc.ds.getGroup(request.GroupId)
+	result := kg.join(kc.l, jr)
+
+	jgr := &joinGroupResponse{
+		ErrorCode:      int16(result.ec),
+		GenerationId:   result.generationId,
+		ProtocolName:   &result.protocolName,
+		Leader:         result.leaderId,
+		SkipAssignment: result.skipAssignment,
+		MemberId:       result.memberId,
+		Members:        make([]joinGroupMember, 0, len(result.members)),
 	}
+	if result.ec == NoError {
+		jgr.ProtocolType = &request.ProtocolType
+	} else if kmh.RequestApiVersion >= 7 {
+		// a failed join has no protocol
+		jgr.ProtocolName = nil
+	}
+	for _, m := range result.members {
+		jm := joinGroupMember{MemberId: m.memberId, Metadata: m.metadata}
+		if m.instanceId != "" {
+			jm.GroupInstanceId = &m.instanceId
+		}
+		jgr.Members = append(jgr.Members, jm)
+	}
+
+	response = jgr
 	return
 }
@@ -3,6 +3,7 @@ package kafkamock
 import (
 	"bufio"
 	"bytes"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
@@ -20,7 +21,7 @@ type (
 	kafkaClient struct {
 		l          lane.Lane
 		conn       net.Conn
-		serverPort uint
+		broker     *kafkaBroker // the broker of the listener the client connected to
 		clientPort uint
 		oc         onClose
 		inbound    []byte
@@ -31,6 +32,13 @@ type (
 		wg         sync.WaitGroup // overall running state
 		requestWg  sync.WaitGroup // active api request
 		stopping   atomic.Bool
+
+		saslMechanisms []string // the mechanisms of the listener, which requires authentication when there are any
+		saslState      kafkaSaslState
+		saslMechanism  string
+		saslExchange   kafkaSaslExchange
+		principal      string
+		sessionExpiry  time.Time // when the client must have authenticated again, if it must
 	}
 
 	onClose func()
@@ -57,17 +65,20 @@ type (
 	}
 )
 
-func newKafkaClient(l lane.Lane, ds *kafkaDataStore, conn net.Conn, serverPort uint, latency time.Duration, oc onClose) *kafkaClient {
+func newKafkaClient(l lane.Lane, ds *kafkaDataStore, conn net.Conn, broker *kafkaBroker, latency time.Duration, saslMechanisms []string, oc onClose) *kafkaClient {
 	kc := &kafkaClient{
 		l:          l,
 		conn:       conn,
 		clientPort: netRemotePort(conn),
-		serverPort: serverPort,
+		broker:     broker,
 		oc:         oc,
 		inbound:    []byte{},
 		ds:         ds,
 		latency:    latency,
 		requests:   []*kafkaRequest{},
+
+		saslMechanisms: saslMechanisms,
+		principal:      kAnonymousPrincipal,
 	}
 
 	kc.wg.Add(1)
@@ -85,6 +96,11 @@ func (kc *kafkaClient) handle() {
 	kc.connected.Add(1)
 	defer kc.connected.Done()
 
+	if !kc.tlsHandshake() {
+		kc.conn.Close()
+		return
+	}
+
 	for {
 		if kc.latency != 0 {
 			time.Sleep(kc.latency) // test hook
@@ -110,7 +126,7 @@ func (kc *kafkaClient) handle() {
 				if errors.Is(err, os.ErrDeadlineExceeded) {
 					continue
 				}
-				if !wasSocketClosed(err) {
+				if _, isTLS := kc.conn.(*tls.Conn); !wasSocketClosed(err) && !(isTLS && wasTLSConnectionClosed(err)) {
 					panic(fmt.Sprintf("read error: %v", err))
 				}
 				return
@@ -129,7 +145,7 @@ func (kc *kafkaClient) handle() {
 		kc.inbound = kc.inbound[4+msg.Len():]
 
 		kc.requestWg.Add(1)
-		reader := bufio.NewReader(msg)
+		reader := bufio.NewReaderSize(msg, msg.Len()+16) // the decoder peeks at the entire message
 		func() {
 			defer kc.requestWg.Done()
 			if err := kc.dispatcher(reader, msg.Len()); err != nil {
@@ -211,6 +227,13 @@ func (kc *kafkaClient) dispatcher(reader *bufio.Reader, msgLength int) (err erro
 		return
 	}
 
+	if !kc.saslAllows(kmh.RequestApiKey) {
+		// the broker drops a client that doesn't follow the authentication sequence
+		kc.l.Warnf("kafka %d request %s v%d is unexpected during sasl authentication - closing the connection", kc.clientPort, apiName, hdr.RequestApiVersion)
+		kc.conn.Close()
+		return
+	}
+
 	reader.Discard(next)
 
 	var response any
@@ -226,7 +249,17 @@ func (kc *kafkaClient) dispatcher(reader *bufio.Reader, msgLength int) (err erro
 		return
 	}
 
-	encodeObject(w, response)
+	if response == nil {
+		// the api does not respond to this request (e.g., produce with acks=0)
+		return
+	}
+
+	if hasTags && kmh.RequestApiKey != ApiKeyApiVersions {
+		// flexible versions use a response header with tagged fields
+		encodeTags(w, nil)
+	}
+
+	encodeVersionedObject(w, response, kmh.codecVersion())
 	if rtags != nil {
 		encodeTags(w, rtags)
 	}
@@ -246,8 +279,43 @@ func (kc *kafkaClient) dispatcher(reader *bufio.Reader, msgLength int) (err erro
 	return
 }
 
+// the encoding used by the request's api version
+func (kmh *kafkaMessageHeader) codecVersion() codecVersion {
+	return codecVersion{
+		version:  kmh.RequestApiVersion,
+		flexible: apiHasTags[makeApiKey(kmh.RequestApiKey, kmh.RequestApiVersion)],
+	}
+}
+
+// completes the handshake of a TLS connection, taking the subject of the
+// client's certificate as its principal
+func (kc *kafkaClient) tlsHandshake() bool {
+	tc, is := kc.conn.(*tls.Conn)
+	if !is {
+		return true
+	}
+
+	tc.SetDeadline(time.Now().Add(10 * time.Second))
+	if err := tc.Handshake(); err != nil {
+		kc.l.Warnf("kafka %d tls handshake failed: %v", kc.clientPort, err)
+		return false
+	}
+	tc.SetDeadline(time.Time{})
+
+	if certs := tc.ConnectionState().PeerCertificates; len(certs) > 0 {
+		kc.principal = "User:" + certs[0].Subject.String()
+		kc.l.Tracef("kafka %d client certificate principal is %s", kc.clientPort, kc.principal)
+	}
+	return true
+}
+
 func (kc *kafkaClient) isConnected() bool {
-	f, err := kc.conn.(*net.TCPConn).File()
+	conn := kc.conn
+	if tc, is := conn.(*tls.Conn); is {
+		conn = tc.NetConn()
+	}
+
+	f, err := conn.(*net.TCPConn).File()
 	if err != nil {
 		return false
 	}
@@ -1,6 +1,8 @@
 package kafkamock
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"fmt"
 	"log"
@@ -28,6 +30,7 @@ func testCreateKafkaMockServer(t *testing.T, serverPort uint, topics []string) (
 	mock.CreatePartitionTopics(topics, 2)
 
 	mock.Start()
+	mock.initializing.Wait()
 	return
 }
 
@@ -58,6 +61,30 @@ func loadTestEvents(t *testing.T, file string, limit int) [][]byte {
 	return events
 }
 
+// calls a handler directly with a request encoded for a specific version, as
+// a client that only has the lane and data store
+func testHandlerRequest[Resp any](t *testing.T, tl lane.Lane, ds *kafkaDataStore, handler dispatchHandler, apiKey kafkaApiKey, version int, request any) Resp {
+	return testClientRequest[Resp](t, &kafkaClient{l: tl, ds: ds}, handler, apiKey, version, request)
+}
+
+// calls a handler directly with a request encoded for a specific version, as
+// the test's client; a handler that doesn't respond returns the zero response
+func testClientRequest[Resp any](t *testing.T, kc *kafkaClient, handler dispatchHandler, apiKey kafkaApiKey, version int, request any) Resp {
+	kmh := &kafkaMessageHeader{RequestApiKey: apiKey, RequestApiVersion: version}
+
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	encodeVersionedObject(w, request, kmh.codecVersion())
+	w.Flush()
+
+	response, _, err := handler(bufio.NewReader(&buf), kc, kmh)
+	if err != nil {
+		t.Fatalf("%s handler error: %v", apiNames[apiKey], err)
+	}
+	resp, _ := response.(Resp)
+	return resp
+}
+
 func testStopMockServer(t *testing.T, mock *KafkaMock) {
 	mock.RequestStop()
 	mock.WaitForTermination()
@@ -84,6 +111,15 @@ func testKafkaConnectEx(t *testing.T, serverPort uint, topics []string, commitIn
 	return
 }
 
+// makes an admin client with its own transport, so that it doesn't
+// reuse connections to a mock server of a prior test
+func testKafkaClient(serverPort uint) *kafka.Client {
+	return &kafka.Client{
+		Addr:      kafka.TCP(fmt.Sprintf("localhost:%d", serverPort)),
+		Transport: &kafka.Transport{},
+	}
+}
+
 func testCloseKafkaReader(t *testing.T, tl lane.Lane, reader *kafka.Reader) {
 	tl.Info("kafka-feed: closing kafka reader")
 
@@ -0,0 +1,187 @@
+package kafkamock
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/jimsnab/go-lane"
+)
+
+type (
+	// A cluster of kafka mock brokers, which share their topics, groups,
+	// users and acls. Each partition is led by one broker, and the others
+	// answer produce and fetch requests for it with NOT_LEADER_OR_FOLLOWER,
+	// so that clients must route by metadata.
+	KafkaCluster struct {
+		ds         *kafkaDataStore
+		brokers    []*KafkaMock // ordered by node id
+		parentLane lane.Lane
+		cancelFn   context.CancelFunc
+		wg         sync.WaitGroup
+	}
+)
+
+// Makes a cluster of brokers that listen on consecutive ports, starting with
+// firstPort. The node ids of the brokers count up from 100, and the first
+// broker is the controller.
+func NewKafkaCluster(l lane.Lane, firstPort uint, brokers int) *KafkaCluster {
+	if brokers < 1 {
+		panic("a kafka cluster needs at least one broker")
+	}
+
+	ds := newKafkaDataStore()
+	ds.Brokers = make([]*kafkaBroker, 0, brokers)
+	for n := 0; n < brokers; n++ {
+		ds.Brokers = append(ds.Brokers, &kafkaBroker{NodeId: kFirstBrokerId + int32(n), Host: "localhost", Port: firstPort + uint(n)})
+	}
+
+	kcl := &KafkaCluster{ds: ds, brokers: make([]*KafkaMock, 0, brokers), parentLane: l}
+	for _, broker := range ds.Brokers {
+		kcl.brokers = append(kcl.brokers, newKafkaMock(l, ds, broker))
+	}
+	return kcl
+}
+
+// Starts every broker of the cluster, and the log cleaner that the brokers
+// share, which keeps running while brokers are stopped and started
+func (kcl *KafkaCluster) Start() {
+	l, cancelFn := kcl.parentLane.DeriveWithCancel()
+	kcl.cancelFn = cancelFn
+	kcl.wg.Add(1)
+	go func() {
+		defer kcl.wg.Done()
+		kcl.ds.runLogCleaner(l)
+	}()
+
+	for _, km := range kcl.brokers {
+		km.Start()
+	}
+}
+
+func (kcl *KafkaCluster) RequestStop() {
+	for _, km := range kcl.brokers {
+		km.RequestStop()
+	}
+	if kcl.cancelFn != nil {
+		kcl.cancelFn()
+	}
+}
+
+func (kcl *KafkaCluster) WaitForTermination() {
+	for _, km := range kcl.brokers {
+		km.WaitForTermination()
+	}
+	kcl.wg.Wait()
+}
+
+// Returns the broker with a node id, or nil if there isn't one. The
+// brokers share their data, so the data helpers of any broker, such as
+// CreateTopic or SimplePost, change the whole cluster. The listener
+// settings, such as SetTLSConfig or the SASL mechanisms, are per broker.
+func (kcl *KafkaCluster) Broker(nodeId int32) *KafkaMock {
+	for _, km := range kcl.brokers {
+		if km.broker.NodeId == nodeId {
+			return km
+		}
+	}
+	return nil
+}
+
+// Returns the brokers, ordered by node id
+func (kcl *KafkaCluster) Brokers() []*KafkaMock {
+	return slices.Clone(kcl.brokers)
+}
+
+// Returns the host:port addresses of the brokers, for clients to bootstrap with
+func (kcl *KafkaCluster) BootstrapServers() []string {
+	servers := make([]string, 0, len(kcl.brokers))
+	for _, km := range kcl.brokers {
+		servers = append(servers, fmt.Sprintf("%s:%d", km.broker.Host, km.broker.Port))
+	}
+	return servers
+}
+
+// Returns the node id of the broker that leads a partition, or an error
+// if the partition doesn't exist
+func (kcl *KafkaCluster) PartitionLeader(topic string, partition int) (int32, error) {
+	kt := kcl.ds.getTopic(topic)
+	if kt == nil {
+		return 0, fmt.Errorf("topic %s does not exist", topic)
+	}
+	kp := kt.getPartition(int32(partition))
+	if kp == nil {
+		return 0, fmt.Errorf("partition %s:%d does not exist", topic, partition)
+	}
+
+	leader, _, _ := kcl.ds.partitionReplicas(kt, kp)
+	return leader, nil
+}
+
+// Stops a broker, as a broker roll or an outage would. The partitions it
+// led are taken over by their next running replicas, in new leader epochs,
+// and then its clients are disconnected, so that they refresh their metadata.
+// A partition without a running replica has no leader until one starts.
+func (kcl *KafkaCluster) StopBroker(nodeId int32) error {
+	km := kcl.Broker(nodeId)
+	if km == nil {
+		return fmt.Errorf("unknown broker %d", nodeId)
+	}
+	if kcl.ds.getBroker(nodeId).Stopped {
+		return fmt.Errorf("broker %d is already stopped", nodeId)
+	}
+
+	kcl.ds.setBrokerStopped(nodeId, true)
+	kcl.ds.electLeaders()
+
+	km.RequestStop()
+	km.WaitForTermination()
+	return nil
+}
+
+// Starts a broker that was stopped. It leads the partitions that have no
+// leader and that it has a replica of; the other partitions keep their
+// leaders, until MovePartitionLeader moves them back.
+func (kcl *KafkaCluster) StartBroker(nodeId int32) error {
+	km := kcl.Broker(nodeId)
+	if km == nil {
+		return fmt.Errorf("unknown broker %d", nodeId)
+	}
+	if !kcl.ds.getBroker(nodeId).Stopped {
+		return fmt.Errorf("broker %d is already running", nodeId)
+	}
+
+	km.Start()
+	km.initializing.Wait()
+
+	kcl.ds.setBrokerStopped(nodeId, false)
+	kcl.ds.electLeaders()
+	return nil
+}
+
+// Makes a running broker the leader of a partition, in a new leader epoch,
+// as a preferred leader election or a reassignment would. A broker without
+// a replica of the partition takes over the old leader's replica. The old
+// leader's clients are disconnected, so that they refresh their metadata.
+func (kcl *KafkaCluster) MovePartitionLeader(topic string, partition int, nodeId int32) error {
+	kt := kcl.ds.getTopic(topic)
+	if kt == nil {
+		return fmt.Errorf("topic %s does not exist", topic)
+	}
+	kp := kt.getPartition(int32(partition))
+	if kp == nil {
+		return fmt.Errorf("partition %s:%d does not exist", topic, partition)
+	}
+
+	previous, err := kcl.ds.moveLeader(kt, kp, nodeId)
+	if err != nil {
+		return err
+	}
+	if previous != nodeId {
+		if km := kcl.Broker(previous); km != nil {
+			km.dropClients()
+		}
+	}
+	return nil
+}
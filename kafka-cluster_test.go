@@ -0,0 +1,404 @@
+package kafkamock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jimsnab/go-lane"
+	"github.com/segmentio/kafka-go"
+)
+
+func testCreateKafkaCluster(t *testing.T, firstPort uint, brokers int) (tl lane.TestingLane, cluster *KafkaCluster) {
+	tl = lane.NewTestingLane(context.Background())
+	tl.WantDescendantEvents(true)
+
+	cluster = NewKafkaCluster(tl, firstPort, brokers)
+	cluster.Start()
+	for _, km := range cluster.Brokers() {
+		km.initializing.Wait()
+	}
+	return
+}
+
+func testStopKafkaCluster(t *testing.T, cluster *KafkaCluster) {
+	cluster.RequestStop()
+	cluster.WaitForTermination()
+}
+
+func TestKafkaClusterMetadata(t *testing.T) {
+	tl, cluster := testCreateKafkaCluster(t, 21001, 3)
+	defer testStopKafkaCluster(t, cluster)
+
+	if err := cluster.Broker(kFirstBrokerId).CreateTopic("topic-a", 4, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := cluster.Broker(kFirstBrokerId).CreateTopic("topic-b", 1, 4); err == nil {
+		t.Error("expected replication factor error")
+	}
+
+	client := &kafka.Client{Addr: kafka.TCP(cluster.BootstrapServers()...), Timeout: 5 * time.Second}
+	md, err := client.Metadata(tl, &kafka.MetadataRequest{Topics: []string{"topic-a"}})
+	if err != nil {
+		t.Fatalf("metadata error: %v", err)
+	}
+
+	if len(md.Brokers) != 3 || md.Controller.ID != kFirstBrokerId || md.Brokers[2].Port != 21003 {
+		t.Errorf("unexpected brokers %+v, controller %+v", md.Brokers, md.Controller)
+	}
+	if len(md.Topics) != 1 || len(md.Topics[0].Partitions) != 4 {
+		t.Fatalf("unexpected topics %+v", md.Topics)
+	}
+
+	// the partitions are spread round robin, with the replicas on the brokers that follow the leader
+	for _, p := range md.Topics[0].Partitions {
+		leader := kFirstBrokerId + p.ID%3
+		follower := kFirstBrokerId + (p.ID+1)%3
+		if p.Leader.ID != leader || len(p.Replicas) != 2 || p.Replicas[0].ID != leader || p.Replicas[1].ID != follower || len(p.Isr) != 2 {
+			t.Errorf("unexpected placement of partition %d: %+v", p.ID, p)
+		}
+		if id, err := cluster.PartitionLeader("topic-a", p.ID); err != nil || id != int32(leader) {
+			t.Errorf("unexpected leader %d of partition %d: %v", id, p.ID, err)
+		}
+	}
+
+	// groups are spread over the brokers by the hashes of their ids
+	for _, group := range []string{"group-a", "group-b", "group-c", "group-d"} {
+		fc, err := client.FindCoordinator(tl, &kafka.FindCoordinatorRequest{Key: group, KeyType: kafka.CoordinatorKeyTypeConsumer})
+		if err != nil || fc.Error != nil {
+			t.Fatalf("find coordinator error: %v %v", err, fc.Error)
+		}
+		coordinator := cluster.ds.coordinator(group)
+		if fc.Coordinator.NodeID != int(coordinator.NodeId) || fc.Coordinator.Port != int(coordinator.Port) {
+			t.Errorf("unexpected coordinator %+v of %s", fc.Coordinator, group)
+		}
+	}
+
+	// each broker describes its own id
+	cfgs, err := client.DescribeConfigs(tl, &kafka.DescribeConfigsRequest{
+		Resources: []kafka.DescribeConfigRequestResource{{ResourceType: kafka.ResourceTypeBroker, ResourceName: "102", ConfigNames: []string{"broker.id"}}},
+	})
+	if err != nil {
+		t.Fatalf("describe configs error: %v", err)
+	}
+	if len(cfgs.Resources) != 1 || cfgs.Resources[0].Error != nil || len(cfgs.Resources[0].ConfigEntries) != 1 || cfgs.Resources[0].ConfigEntries[0].ConfigValue != "102" {
+		t.Errorf("unexpected broker configs %+v", cfgs.Resources)
+	}
+}
+
+func TestKafkaClusterCreateTopics(t *testing.T) {
+	tl, cluster := testCreateKafkaCluster(t, 21001, 3)
+	defer testStopKafkaCluster(t, cluster)
+
+	client := &kafka.Client{Addr: kafka.TCP(cluster.BootstrapServers()[1]), Timeout: 5 * time.Second}
+	resp, err := client.CreateTopics(tl, &kafka.CreateTopicsRequest{
+		Topics: []kafka.TopicConfig{
+			{Topic: "topic-replicated", NumPartitions: 2, ReplicationFactor: 3},
+			{Topic: "topic-too-many", NumPartitions: 1, ReplicationFactor: 4},
+			{Topic: "topic-assigned", NumPartitions: -1, ReplicationFactor: -1, ReplicaAssignments: []kafka.ReplicaAssignment{{Partition: 0, Replicas: []int{102, 100}}}},
+			{Topic: "topic-unknown", NumPartitions: -1, ReplicationFactor: -1, ReplicaAssignments: []kafka.ReplicaAssignment{{Partition: 0, Replicas: []int{103}}}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("create topics error: %v", err)
+	}
+
+	expected := map[string]error{
+		"topic-replicated": nil,
+		"topic-too-many":   kafka.InvalidReplicationFactor,
+		"topic-assigned":   nil,
+		"topic-unknown":    kafka.InvalidReplicaAssignment,
+	}
+	for name, want := range expected {
+		if got := resp.Errors[name]; !errors.Is(got, want) && got != want {
+			t.Errorf("topic %s: expected %v, got %v", name, want, got)
+		}
+	}
+
+	if id, _ := cluster.PartitionLeader("topic-assigned", 0); id != 102 {
+		t.Errorf("expected the assigned leader, got %d", id)
+	}
+	if id, _ := cluster.PartitionLeader("topic-replicated", 1); id != 101 {
+		t.Errorf("expected the second broker to lead, got %d", id)
+	}
+}
+
+func TestKafkaClusterRouting(t *testing.T) {
+	tl, cluster := testCreateKafkaCluster(t, 21001, 3)
+	defer testStopKafkaCluster(t, cluster)
+
+	if err := cluster.Broker(kFirstBrokerId).CreateTopic("topic-a", 3, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// the writer bootstraps from one broker and sends each partition's records to its leader
+	w := &kafka.Writer{
+		Addr:         kafka.TCP(cluster.BootstrapServers()[0]),
+		Topic:        "topic-a",
+		Balancer:     &kafka.RoundRobin{},
+		BatchSize:    1,
+		RequiredAcks: kafka.RequireAll,
+	}
+	messages := make([]kafka.Message, 0, 6)
+	for n := 0; n < 6; n++ {
+		messages = append(messages, kafka.Message{Value: []byte(fmt.Sprintf("message %d", n))})
+	}
+	if err := w.WriteMessages(tl, messages...); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	w.Close()
+
+	kt := cluster.ds.getTopic("topic-a")
+	for n := int32(0); n < 3; n++ {
+		kp := kt.getPartition(n)
+		kp.lock()
+		count := len(kp.Records)
+		kp.unlock()
+		if count != 2 {
+			t.Errorf("expected 2 records in partition %d, got %d", n, count)
+		}
+	}
+
+	// a connection to a broker that doesn't lead the partition is refused
+	wrong := kafka.Partition{Topic: "topic-a", ID: 0, Leader: kafka.Broker{Host: "localhost", Port: 21002, ID: 101}}
+	conn, err := kafka.DefaultDialer.DialPartition(tl, "tcp", "", wrong)
+	if err != nil {
+		t.Fatalf("dial error: %v", err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err = conn.WriteMessages(kafka.Message{Value: []byte("misrouted")}); !errors.Is(err, kafka.NotLeaderForPartition) {
+		t.Errorf("expected not leader for produce, got %v", err)
+	}
+
+	// the first broker only serves fetches of the partitions it leads
+	fr := testHandlerRequest[*fetchResponse](t, tl, cluster.ds, fetch, ApiKeyFetch, 5, &fetchRequest{
+		ReplicaId: -1,
+		MaxBytes:  1000,
+		Topics:    []fetchTopic{{Topic: "topic-a", Partitions: []fetchPartition{{Partition: 0, PartitionMaxBytes: 1000}, {Partition: 1, PartitionMaxBytes: 1000}}}},
+	})
+	fps := fr.Responses[0].Partitions
+	if fps[0].ErrorCode != int16(NoError) || len(testFetchedRecords(t, &fps[0])) != 2 {
+		t.Errorf("unexpected fetch from the leader %+v", fps[0])
+	}
+	if fps[1].ErrorCode != int16(NotLeaderOrFollower) || fps[1].HighWatermark != -1 {
+		t.Errorf("expected not leader for fetch, got %+v", fps[1])
+	}
+
+	// the leader serves the partition
+	right := kafka.Partition{Topic: "topic-a", ID: 1, Leader: kafka.Broker{Host: "localhost", Port: 21002, ID: 101}}
+	leaderConn, err := kafka.DefaultDialer.DialPartition(tl, "tcp", "", right)
+	if err != nil {
+		t.Fatalf("dial error: %v", err)
+	}
+	defer leaderConn.Close()
+
+	leaderConn.SetDeadline(time.Now().Add(5 * time.Second))
+	batch := leaderConn.ReadBatch(1, 1e6)
+	msg, err := batch.ReadMessage()
+	if err != nil || string(msg.Value) != "message 1" {
+		t.Errorf("unexpected message %q: %v", msg.Value, err)
+	}
+	batch.Close()
+}
+
+func TestKafkaClusterFailover(t *testing.T) {
+	tl, cluster := testCreateKafkaCluster(t, 21001, 3)
+	defer testStopKafkaCluster(t, cluster)
+
+	if err := cluster.Broker(kFirstBrokerId).CreateTopic("topic-a", 3, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	w := &kafka.Writer{
+		Addr:         kafka.TCP(cluster.BootstrapServers()...),
+		Topic:        "topic-a",
+		Balancer:     &kafka.RoundRobin{},
+		BatchSize:    1,
+		RequiredAcks: kafka.RequireAll,
+		Transport:    &kafka.Transport{MetadataTTL: 50 * time.Millisecond},
+	}
+	defer w.Close()
+
+	write := func(count int) {
+		for n := 0; n < count; n++ {
+			if err := w.WriteMessages(tl, kafka.Message{Value: []byte(fmt.Sprintf("message %d", n))}); err != nil {
+				t.Fatalf("write error: %v", err)
+			}
+		}
+	}
+	write(3)
+
+	// a consumer of the first broker's partition
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     cluster.BootstrapServers(),
+		Topic:       "topic-a",
+		Partition:   0,
+		MaxWait:     50 * time.Millisecond,
+		MaxAttempts: 20,
+	})
+	defer reader.Close()
+
+	ctx, cancel := context.WithTimeout(tl, 10*time.Second)
+	defer cancel()
+	if msg, err := reader.ReadMessage(ctx); err != nil || msg.Partition != 0 {
+		t.Fatalf("unexpected read of %+v: %v", msg, err)
+	}
+
+	// the next replica of each partition the stopped broker led takes over
+	if err := cluster.StopBroker(kFirstBrokerId); err != nil {
+		t.Fatal(err)
+	}
+	if err := cluster.StopBroker(kFirstBrokerId); err == nil {
+		t.Error("expected already stopped error")
+	}
+	if n := len(cluster.Broker(kFirstBrokerId).clients); n != 0 {
+		t.Errorf("expected the stopped broker's clients to be disconnected, got %d", n)
+	}
+
+	topicName := "topic-a"
+	mr := testHandlerRequest[*metadataResponse](t, tl, cluster.ds, metadata, ApiKeyMetadata, 9, &metadataRequest{Topics: []metadataRequestTopic{{Name: &topicName}}})
+	if len(mr.Brokers) != 2 || mr.ControllerId != 101 {
+		t.Errorf("unexpected brokers %+v, controller %d", mr.Brokers, mr.ControllerId)
+	}
+	mps := mr.Topics[0].Partitions
+	if mps[0].LeaderId != 101 || mps[0].LeaderEpoch != 1 || len(mps[0].IsrNodes) != 1 || len(mps[0].OfflineReplicas) != 1 {
+		t.Errorf("unexpected failover of partition 0 %+v", mps[0])
+	}
+	if mps[2].LeaderId != 102 || mps[2].LeaderEpoch != 0 || len(mps[2].OfflineReplicas) != 1 || mps[2].OfflineReplicas[0] != kFirstBrokerId {
+		t.Errorf("unexpected partition 2 %+v", mps[2])
+	}
+
+	// the producer and consumer retry with the new leaders
+	write(3)
+	cluster.Broker(101).SimplePost("topic-a", 0, nil, []byte("after failover"))
+	for {
+		msg, err := reader.ReadMessage(ctx)
+		if err != nil {
+			t.Fatalf("read after failover error: %v", err)
+		}
+		if string(msg.Value) == "after failover" {
+			break
+		}
+	}
+
+	// a partition without a running replica has no leader
+	if err := cluster.StopBroker(101); err != nil {
+		t.Fatal(err)
+	}
+	mr = testHandlerRequest[*metadataResponse](t, tl, cluster.ds, metadata, ApiKeyMetadata, 9, &metadataRequest{Topics: []metadataRequestTopic{{Name: &topicName}}})
+	mps = mr.Topics[0].Partitions
+	if mps[0].ErrorCode != int16(LeaderNotAvailable) || mps[0].LeaderId != kNoLeader || mps[0].LeaderEpoch != 2 || len(mps[0].IsrNodes) != 0 {
+		t.Errorf("unexpected leaderless partition 0 %+v", mps[0])
+	}
+
+	// a restarted broker leads the partitions without leaders, but not the ones that moved
+	if err := cluster.StartBroker(kFirstBrokerId); err != nil {
+		t.Fatal(err)
+	}
+	if err := cluster.StartBroker(kFirstBrokerId); err == nil {
+		t.Error("expected already running error")
+	}
+	mr = testHandlerRequest[*metadataResponse](t, tl, cluster.ds, metadata, ApiKeyMetadata, 9, &metadataRequest{Topics: []metadataRequestTopic{{Name: &topicName}}})
+	mps = mr.Topics[0].Partitions
+	if mps[0].ErrorCode != int16(NoError) || mps[0].LeaderId != kFirstBrokerId || mps[0].LeaderEpoch != 3 {
+		t.Errorf("unexpected restarted partition 0 %+v", mps[0])
+	}
+	if mps[1].LeaderId != 102 || mps[1].LeaderEpoch != 1 {
+		t.Errorf("unexpected partition 1 %+v", mps[1])
+	}
+	if err := cluster.StartBroker(101); err != nil {
+		t.Fatal(err)
+	}
+
+	// moving a leader disconnects the old leader's clients
+	client := &kafka.Client{Addr: kafka.TCP(cluster.BootstrapServers()[2]), Timeout: 5 * time.Second}
+	if _, err := client.Metadata(tl, &kafka.MetadataRequest{}); err != nil {
+		t.Fatalf("metadata error: %v", err)
+	}
+	if err := cluster.MovePartitionLeader("topic-a", 2, kFirstBrokerId); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(cluster.Broker(102).clients); n != 0 {
+		t.Errorf("expected the old leader's clients to be disconnected, got %d", n)
+	}
+	if id, _ := cluster.PartitionLeader("topic-a", 2); id != kFirstBrokerId {
+		t.Errorf("expected the moved leader, got %d", id)
+	}
+
+	// a broker without a replica takes over the old leader's replica
+	if err := cluster.MovePartitionLeader("topic-a", 1, kFirstBrokerId); err != nil {
+		t.Fatal(err)
+	}
+	mr = testHandlerRequest[*metadataResponse](t, tl, cluster.ds, metadata, ApiKeyMetadata, 9, &metadataRequest{Topics: []metadataRequestTopic{{Name: &topicName}}})
+	mps = mr.Topics[0].Partitions
+	if mps[1].LeaderId != kFirstBrokerId || mps[1].LeaderEpoch != 2 || !slices.Equal(mps[1].ReplicaNodes, []int32{101, kFirstBrokerId}) {
+		t.Errorf("unexpected moved partition 1 %+v", mps[1])
+	}
+
+	if err := cluster.MovePartitionLeader("topic-a", 1, 103); err == nil {
+		t.Error("expected unknown broker error")
+	}
+	if err := cluster.StopBroker(102); err != nil {
+		t.Fatal(err)
+	}
+	if err := cluster.MovePartitionLeader("topic-a", 1, 102); err == nil {
+		t.Error("expected stopped broker error")
+	}
+
+	// the producer keeps up with the moves; kafka-go fails writes to partitions
+	// that had no leader, so its metadata must refresh first
+	time.Sleep(200 * time.Millisecond)
+	write(3)
+}
+
+func TestKafkaClusterLogCleaner(t *testing.T) {
+	_, cluster := testCreateKafkaCluster(t, 21001, 3)
+	defer testStopKafkaCluster(t, cluster)
+
+	// the brokers share the cluster's cleaner rather than each running one
+	for _, km := range cluster.Brokers() {
+		if km.logCleaner {
+			t.Errorf("broker %d runs its own log cleaner", km.broker.NodeId)
+		}
+	}
+
+	// the cleaner keeps running after the controller stops
+	for _, km := range cluster.Brokers()[:2] {
+		if err := km.SetBrokerConfigs(map[string]string{"log.cleaner.backoff.ms": "0"}); err != nil {
+			t.Fatalf("set broker configs error: %v", err)
+		}
+	}
+	if err := cluster.StopBroker(kFirstBrokerId); err != nil {
+		t.Fatal(err)
+	}
+
+	km := cluster.Broker(kFirstBrokerId + 1)
+	if err := km.CreateTopic("topic-a", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := km.SetTopicConfigs("topic-a", map[string]string{"cleanup.policy": "compact"}); err != nil {
+		t.Fatalf("set topic configs error: %v", err)
+	}
+	km.SimplePost("topic-a", 0, []byte("a"), []byte("1"))
+	km.SimplePost("topic-a", 0, []byte("a"), []byte("2"))
+
+	kp := cluster.ds.getTopic("topic-a").getPartition(0)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		kp.lock()
+		_, record := kp.nextRecord(0)
+		kp.unlock()
+		if record != nil && string(record.Value) == "2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the log cleaner didn't compact the topic")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
@@ -2,8 +2,12 @@ package kafkamock
 
 import (
 	"context"
+	"crypto"
+	"crypto/tls"
 	"fmt"
 	"net"
+	"slices"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -22,22 +26,37 @@ type (
 		stopped      atomic.Bool
 		initializing sync.WaitGroup
 		serverPort   uint
+		broker       *kafkaBroker // this server's node of the cluster
 		listener     net.Listener
 		clients      map[int]*kafkaClient
 		active       sync.WaitGroup
 		ds           *kafkaDataStore
 		latency      time.Duration
+		logCleaner   bool // runs the data store's log cleaner, unless a cluster runs it
+
+		saslMechanisms []string    // the mechanisms clients must authenticate with, if any
+		tlsConfig      *tls.Config // serves TLS rather than plaintext when set
 	}
 )
 
 func NewKafkaMock(l lane.Lane, serverPort uint) *KafkaMock {
+	ds := newKafkaDataStore()
+	broker := ds.Brokers[0]
+	broker.Port = serverPort
+	km := newKafkaMock(l, ds, broker)
+	km.logCleaner = true
+	return km
+}
+
+func newKafkaMock(l lane.Lane, ds *kafkaDataStore, broker *kafkaBroker) *KafkaMock {
 	initializeApis()
 
 	return &KafkaMock{
 		parentLane: l,
 		l:          l,
-		serverPort: serverPort,
-		ds:         newKafkaDataStore(),
+		serverPort: broker.Port,
+		broker:     broker,
+		ds:         ds,
 	}
 }
 
@@ -61,6 +80,14 @@ func (km *KafkaMock) Start() {
 	km.wg.Add(1)
 	km.initializing.Add(1)
 	go km.run()
+
+	if km.logCleaner {
+		km.wg.Add(1)
+		go func() {
+			defer km.wg.Done()
+			km.ds.runLogCleaner(l)
+		}()
+	}
 }
 
 func (km *KafkaMock) RequestStop() {
@@ -119,6 +146,13 @@ func (km *KafkaMock) run() {
 		panic(fmt.Sprintf("error opening kafka mock server socker: %v", err))
 	}
 
+	km.mu.Lock()
+	tlsConfig := km.tlsConfig
+	km.mu.Unlock()
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
 	km.listener = listener
 	cxnNumber := 0
 
@@ -147,15 +181,16 @@ func (km *KafkaMock) run() {
 
 		km.mu.Lock()
 		cxnNumber++
-		kc := newKafkaClient(km.l, km.ds, connection, km.serverPort, km.latency, func() {
+		id := cxnNumber
+		kc := newKafkaClient(km.l, km.ds, connection, km.broker, km.latency, slices.Clone(km.saslMechanisms), func() {
 			km.l.Tracef("client disconnected: %s <-> %s", connection.LocalAddr().String(), connection.RemoteAddr().String())
 			km.mu.Lock()
-			delete(km.clients, cxnNumber)
+			delete(km.clients, id)
 			km.active.Done()
 			km.wg.Done()
 			km.mu.Unlock()
 		})
-		km.clients[cxnNumber] = kc
+		km.clients[id] = kc
 		km.active.Add(1)
 		km.wg.Add(1)
 		km.mu.Unlock()
@@ -168,11 +203,27 @@ func (km *KafkaMock) FinishRequests() {
 	km.cancelFn()
 }
 
+// closes the connections of the connected clients, which must connect again
+func (km *KafkaMock) dropClients() {
+	km.mu.Lock()
+	clients := make([]*kafkaClient, 0, len(km.clients))
+	for _, kc := range km.clients {
+		clients = append(clients, kc)
+	}
+	km.mu.Unlock()
+
+	for _, kc := range clients {
+		kc.Close()
+	}
+}
+
 // Stops the server and gracefully closes clients, then starts
-// a new server with the same data store.
+// a new server with the same data store. Consumer group members
+// belonged to the old server's clients and must join again.
 func (km *KafkaMock) Restart() {
 	km.RequestStop()
 	km.WaitForTermination()
+	km.ds.resetGroupMembership()
 	km.Start()
 	km.initializing.Wait()
 }
@@ -185,6 +236,21 @@ func (km *KafkaMock) CreatePartitionTopics(topics []string, partition int) {
 	}
 }
 
+// Creates a topic with partitions 0 through partitions-1, unless the topic
+// already exists. The partitions are spread over the brokers of a cluster,
+// each with the given number of replicas.
+func (km *KafkaMock) CreateTopic(topic string, partitions, replicationFactor int) error {
+	if brokers := len(km.ds.brokerIds()); replicationFactor < 1 || replicationFactor > brokers {
+		return fmt.Errorf("replication factor %d is not between 1 and the %d brokers", replicationFactor, brokers)
+	}
+	if partitions < 1 {
+		return fmt.Errorf("topic %s must have at least one partition", topic)
+	}
+
+	km.ds.addTopic(topic, int32(partitions), int16(replicationFactor), nil, map[string]string{})
+	return nil
+}
+
 // Directly manipulate the offset of a consumer group
 func (km *KafkaMock) SetConsumerGroupOffset(topic string, partition int, group string, offset int64) {
 	kt := km.ds.getTopic(topic)
@@ -198,3 +264,161 @@ func (km *KafkaMock) SetConsumerGroupOffset(topic string, partition int, group s
 		}
 	}
 }
+
+// Directly set dynamic configs of a topic, as an admin client's incremental
+// alter would. An empty value removes the topic's override of the config.
+func (km *KafkaMock) SetTopicConfigs(topic string, configs map[string]string) error {
+	return km.setConfigs(resourceTopic, topic, configs)
+}
+
+// Directly set dynamic configs of the broker, which topics inherit when
+// they don't override them. In a cluster, topics inherit the configs of
+// the first broker. An empty value removes the broker's setting.
+func (km *KafkaMock) SetBrokerConfigs(configs map[string]string) error {
+	return km.setConfigs(resourceBroker, strconv.Itoa(int(km.broker.NodeId)), configs)
+}
+
+func (km *KafkaMock) setConfigs(resourceType kafkaResourceType, resourceName string, configs map[string]string) error {
+	changes := make([]kafkaConfigChange, 0, len(configs))
+	for _, name := range sortedKeys(configs) {
+		change := kafkaConfigChange{name: name, op: configOpDelete}
+		if value := configs[name]; value != "" {
+			change.op = configOpSet
+			change.value = &value
+		}
+		changes = append(changes, change)
+	}
+
+	if ec, message := km.ds.alterResourceConfigs(resourceType, resourceName, changes, false, false); ec != NoError {
+		return fmt.Errorf("error %d: %s", ec, message)
+	}
+	return nil
+}
+
+// Compacts a topic now, instead of waiting for the background log cleaner.
+// Only topics whose cleanup.policy includes compact are changed.
+func (km *KafkaMock) Compact(topic string) {
+	if kt := km.ds.getTopic(topic); kt != nil {
+		km.ds.compactTopic(kt)
+	}
+}
+
+// Discards the records of a partition before an offset, as an admin client's
+// delete records would, and returns the partition's new log start offset.
+// An offset of -1 discards everything up to the high watermark.
+func (km *KafkaMock) DeleteRecordsBefore(topic string, partition int, offset int64) (int64, error) {
+	lowWatermark, ec := km.ds.deleteRecordsBefore(topic, int32(partition), offset)
+	if ec != NoError {
+		return lowWatermark, fmt.Errorf("error %d deleting records of %s:%d before %d", ec, topic, partition, offset)
+	}
+	return lowWatermark, nil
+}
+
+// Simulates an unclean leader election, in which a replica that was behind
+// becomes the leader of a partition: the records from an offset on are lost,
+// and the partition continues in a new leader epoch. A transaction that
+// loses its commit or abort marker gets the marker again in the new epoch.
+// Consumers that read the lost records can detect the divergence with
+// OffsetForLeaderEpoch.
+func (km *KafkaMock) TruncatePartition(topic string, partition int, offset int64) error {
+	if ec := km.ds.truncatePartition(topic, int32(partition), offset); ec != NoError {
+		return fmt.Errorf("error %d truncating %s:%d at %d", ec, topic, partition, offset)
+	}
+	return nil
+}
+
+// Requires clients to authenticate with SASL/PLAIN as one of the users, which
+// are given with their passwords. A user that already exists gets the new
+// password. Clients that are already connected aren't affected.
+func (km *KafkaMock) SetSaslPlainUsers(users map[string]string) {
+	km.ds.setPlainUsers(users)
+	km.enableSaslMechanism(saslPlain)
+}
+
+func (km *KafkaMock) enableSaslMechanism(mechanism string) {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+
+	if !slices.Contains(km.saslMechanisms, mechanism) {
+		km.saslMechanisms = append(km.saslMechanisms, mechanism)
+	}
+}
+
+// Requires clients to authenticate with a SCRAM mechanism, SCRAM-SHA-256 or
+// SCRAM-SHA-512, as one of the users, which are given with their passwords.
+// Credentials can also be managed with an admin client once the mechanism
+// is enabled. Clients that are already connected aren't affected.
+func (km *KafkaMock) SetSaslScramUsers(mechanism string, users map[string]string) error {
+	sm := scramMechanisms[mechanism]
+	if sm == nil {
+		return fmt.Errorf("unsupported SCRAM mechanism %s", mechanism)
+	}
+
+	km.ds.setScramUsers(sm, users)
+	km.enableSaslMechanism(mechanism)
+	return nil
+}
+
+// Lets clients authenticate with SASL/OAUTHBEARER using unsecured JWTs,
+// which have the "none" algorithm and no signature. The token's sub claim
+// is the principal, and its exp claim ends the client's session.
+func (km *KafkaMock) SetSaslOAuthBearerUnsecured() {
+	km.ds.OAuthBearer.allowUnsecuredTokens()
+	km.enableSaslMechanism(saslOAuthBearer)
+}
+
+// Lets clients authenticate with SASL/OAUTHBEARER using JWTs signed by one
+// of the RSA or EC keys of a JSON web key set.
+func (km *KafkaMock) SetSaslOAuthBearerJwks(jwks []byte) error {
+	if err := km.ds.OAuthBearer.addJwks(jwks); err != nil {
+		return err
+	}
+	km.enableSaslMechanism(saslOAuthBearer)
+	return nil
+}
+
+// Lets clients authenticate with SASL/OAUTHBEARER using JWTs signed by an
+// *rsa.PublicKey or *ecdsa.PublicKey's private key.
+func (km *KafkaMock) SetSaslOAuthBearerPublicKey(key crypto.PublicKey) error {
+	if err := km.ds.OAuthBearer.addKey("", key); err != nil {
+		return err
+	}
+	km.enableSaslMechanism(saslOAuthBearer)
+	return nil
+}
+
+// Serves TLS rather than plaintext, starting with the next Start or Restart.
+// A config that requires client certificates makes the subject of the
+// client's certificate its principal, unless the client then authenticates
+// with SASL. See KafkaMockCA for making the configs of tests.
+func (km *KafkaMock) SetTLSConfig(config *tls.Config) {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+
+	km.tlsConfig = config
+}
+
+// Enforces ACLs, so that clients may only use the topics, groups and
+// transactional ids that ACLs allow their principals to. The super users,
+// such as "User:admin", are allowed everything. Without TLS client
+// certificates or SASL, every client is "User:ANONYMOUS".
+func (km *KafkaMock) EnableAcls(superUsers ...string) {
+	km.ds.Acls.enable(superUsers)
+}
+
+// Adds ACLs, as an admin client's create ACLs would.
+func (km *KafkaMock) AddAcls(acls ...KafkaAcl) error {
+	converted := make([]kafkaAcl, 0, len(acls))
+	for _, binding := range acls {
+		acl, err := binding.toAcl()
+		if err != nil {
+			return err
+		}
+		converted = append(converted, acl)
+	}
+
+	for _, acl := range converted {
+		km.ds.Acls.addAcl(acl)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package kafkamock
+
+const (
+	// the leader epoch of a request that doesn't know it, which skips the epoch check
+	kNoLeaderEpoch = -1
+)
+
+// appends records in the current leader epoch; the caller holds the lock
+func (kp *kafkaPartition) appendRecords(records ...*kafkaRecord) {
+	for _, record := range records {
+		record.LeaderEpoch = kp.LeaderEpoch
+	}
+	kp.Records = append(kp.Records, records...)
+}
+
+// starts a new leader epoch at the end of the log; an epoch that didn't
+// write any records is forgotten, as kafka's epoch cache forgets it. The
+// caller holds the lock.
+func (kp *kafkaPartition) newLeaderEpoch() {
+	kp.LeaderEpoch++
+	endOffset := kp.endOffset()
+	for len(kp.LeaderEpochs) > 0 && kp.LeaderEpochs[len(kp.LeaderEpochs)-1].startOffset >= endOffset {
+		kp.LeaderEpochs = kp.LeaderEpochs[:len(kp.LeaderEpochs)-1]
+	}
+	kp.LeaderEpochs = append(kp.LeaderEpochs, kafkaLeaderEpoch{epoch: kp.LeaderEpoch, startOffset: endOffset})
+}
+
+// returns the largest epoch at or below a requested epoch, and the offset
+// where the log moved past it, which is what a follower or consumer needs to
+// find where its log diverges from the leader's. An unknown epoch returns
+// -1 for both. The caller holds the lock.
+func (kp *kafkaPartition) endOffsetForEpoch(requested int32) (epoch int32, endOffset int64) {
+	if requested == kNoLeaderEpoch {
+		return kNoLeaderEpoch, -1
+	}
+	if requested == kp.LeaderEpoch {
+		return kp.LeaderEpoch, kp.endOffset()
+	}
+
+	// the epoch ends where the next higher epoch starts
+	n := len(kp.LeaderEpochs)
+	for n > 0 && kp.LeaderEpochs[n-1].epoch > requested {
+		n--
+	}
+	if n == len(kp.LeaderEpochs) {
+		return kNoLeaderEpoch, -1
+	}
+	endOffset = kp.LeaderEpochs[n].startOffset
+	if n == 0 {
+		return requested, endOffset
+	}
+	return kp.LeaderEpochs[n-1].epoch, endOffset
+}
+
+// discards the records from an offset on, as a broker that loses an unclean
+// leader election does, and starts a new leader epoch there. A transaction
+// that began before the offset and whose marker is lost gets its marker
+// again, since its coordinator won't write another one. The caller holds
+// the lock.
+func (kp *kafkaPartition) truncateAfter(offset int64) {
+	// a producer's first marker after the offset ends the transaction that
+	// is open at the offset, if there is one
+	lostMarkers := []*kafkaRecord{}
+	lostTxns := map[int64]int64{}
+	for o := offset; o < kp.endOffset(); o++ {
+		record := kp.recordAt(o)
+		if record == nil || !record.isControl() {
+			continue
+		}
+		if _, seen := lostTxns[record.ProducerId]; seen {
+			continue
+		}
+		lostTxns[record.ProducerId] = kp.txnStart(record.ProducerId, offset)
+		if lostTxns[record.ProducerId] < offset {
+			lostMarkers = append(lostMarkers, record)
+		}
+	}
+
+	kp.Records = kp.Records[:offset-kp.LogStartOffset]
+
+	for len(kp.LeaderEpochs) > 0 && kp.LeaderEpochs[len(kp.LeaderEpochs)-1].startOffset >= offset {
+		kp.LeaderEpochs = kp.LeaderEpochs[:len(kp.LeaderEpochs)-1]
+	}
+	kp.newLeaderEpoch()
+
+	// transactions that lost all their records are gone
+	for producerId, firstOffset := range kp.OngoingTxns {
+		if firstOffset >= offset {
+			delete(kp.OngoingTxns, producerId)
+		}
+	}
+	for len(kp.AbortedTxns) > 0 && kp.AbortedTxns[len(kp.AbortedTxns)-1].lastOffset >= offset {
+		kp.AbortedTxns = kp.AbortedTxns[:len(kp.AbortedTxns)-1]
+	}
+
+	// producers forget the lost batches, so that a retry writes them again
+	for producerId, pp := range kp.Producers {
+		retained := len(pp.batches)
+		batches := pp.batches[:0]
+		for _, batch := range pp.batches {
+			if batch.baseOffset < offset {
+				batches = append(batches, batch)
+			}
+		}
+		pp.batches = batches
+		if retained > 0 && len(pp.batches) == 0 {
+			delete(kp.Producers, producerId)
+		}
+	}
+
+	for _, marker := range lostMarkers {
+		kp.OngoingTxns[marker.ProducerId] = lostTxns[marker.ProducerId]
+		kp.appendTxnMarker(marker.ProducerId, marker.ProducerEpoch, marker.isCommitMarker())
+	}
+}
+
+// returns the offset of the first record of a producer's transaction that
+// is open at an offset, or the offset itself if there isn't one; the caller
+// holds the lock
+func (kp *kafkaPartition) txnStart(producerId int64, offset int64) int64 {
+	start := offset
+	for o := offset - 1; o >= kp.LogStartOffset; o-- {
+		record := kp.recordAt(o)
+		if record == nil || record.ProducerId != producerId {
+			continue
+		}
+		if record.isControl() || record.BatchAttributes&kBatchTransactional == 0 {
+			break
+		}
+		start = o
+	}
+	return start
+}
+
+// truncates a partition's log at an offset, in a new leader epoch
+func (ds *kafkaDataStore) truncatePartition(topic string, partition int32, offset int64) kafkaErrorCode {
+	kt := ds.getTopic(topic)
+	var kp *kafkaPartition
+	if kt != nil {
+		kp = kt.getPartition(partition)
+	}
+	if kp == nil {
+		return UnknownTopicOrPartition
+	}
+
+	kp.lock()
+	defer kp.unlock()
+
+	if offset < kp.LogStartOffset || offset > kp.endOffset() {
+		return OffsetOutOfRange
+	}
+	kp.truncateAfter(offset)
+	return NoError
+}
+
+// the error of a client request for a partition that the client's broker
+// doesn't lead, or that names a leader epoch other than the partition's;
+// a client that doesn't know the epoch passes kNoLeaderEpoch
+func (kc *kafkaClient) leaderError(kt *kafkaTopic, kp *kafkaPartition, currentLeaderEpoch int32) kafkaErrorCode {
+	leader, leaderEpoch, _ := kc.ds.partitionReplicas(kt, kp)
+	switch {
+	case currentLeaderEpoch == kNoLeaderEpoch:
+	case currentLeaderEpoch < leaderEpoch:
+		return FencedLeaderEpoch
+	case currentLeaderEpoch > leaderEpoch:
+		return UnknownLeaderEpoch
+	}
+	if leader != kc.nodeId() {
+		return NotLeaderOrFollower
+	}
+	return NoError
+}
@@ -5,21 +5,60 @@ import (
 )
 
 type (
-	leaveGroupRequestV0 struct {
+	leaveGroupRequest struct {
 		GroupId  string
-		MemberId string
+		MemberId string             `kafka:"max=2"`
+		Members  []leaveGroupMember `kafka:"min=3"`
 	}
 
-	leaveGroupResponseV0 struct {
-		ErrorCode int16
+	leaveGroupMember struct {
+		MemberId        string
+		GroupInstanceId NullableString
+		Reason          NullableString `kafka:"min=5"`
+	}
+
+	leaveGroupResponse struct {
+		ThrottleTimeMs int32 `kafka:"min=1"`
+		ErrorCode      int16
+		Members        []leaveGroupMemberResponse `kafka:"min=3"`
+	}
+
+	leaveGroupMemberResponse struct {
+		MemberId        string
+		GroupInstanceId NullableString
+		ErrorCode       int16
 	}
 )
 
-func leaveGroupV0(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeader) (response any, rtags map[int]any, err error) {
-	if _, err = readRequest[leaveGroupRequestV0](reader); err != nil {
+func leaveGroup(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeader) (response any, rtags map[int]any, err error) {
+	request, err := readVersionedRequest[leaveGroupRequest](reader, kmh)
+	if err != nil {
+		return
+	}
+
+	if ec := kc.authorizationError(aclOpRead, aclResourceGroup, request.GroupId); ec != NoError {
+		response = &leaveGroupResponse{ErrorCode: int16(ec), Members: []leaveGroupMemberResponse{}}
+		return
+	}
+
+	kg := kc.ds.getGroup(request.GroupId)
+
+	if kmh.RequestApiVersion < 3 {
+		ec := kg.leave(kc.l, request.MemberId, "")
+		response = &leaveGroupResponse{ErrorCode: int16(ec)}
 		return
 	}
 
-	response = &leaveGroupResponseV0{}
+	// v3 and later remove a batch of members, each with its own outcome
+	lgr := &leaveGroupResponse{Members: make([]leaveGroupMemberResponse, 0, len(request.Members))}
+	for _, m := range request.Members {
+		ec := kg.leave(kc.l, m.MemberId, stringOrEmpty(m.GroupInstanceId))
+		lgr.Members = append(lgr.Members, leaveGroupMemberResponse{
+			MemberId:        m.MemberId,
+			GroupInstanceId: m.GroupInstanceId,
+			ErrorCode:       int16(ec),
+		})
+	}
+	response = lgr
 	return
 }
@@ -6,23 +6,26 @@ import (
 )
 
 type (
-	listOffsetsRequestV1 struct {
-		ReplicaId int32
-		Topics    []listOffsetsRequestTopic
+	listOffsetsRequest struct {
+		ReplicaId      int32
+		IsolationLevel int8 `kafka:"min=2"`
+		Topics         []listOffsetsRequestTopic
 	}
 
 	listOffsetsRequestTopic struct {
-		Name        string
-		Parititions []listOffsetsRequestPartitions
+		Name       string
+		Partitions []listOffsetsRequestPartition
 	}
 
-	listOffsetsRequestPartitions struct {
-		PartitionIndex int32
-		Timestamp      int64
+	listOffsetsRequestPartition struct {
+		PartitionIndex     int32
+		CurrentLeaderEpoch int32 `kafka:"min=4"`
+		Timestamp          int64
 	}
 
-	listOffsetsResponseV1 struct {
-		Topics []listOffsetsResponseTopic
+	listOffsetsResponse struct {
+		ThrottleTimeMs int32 `kafka:"min=2"`
+		Topics         []listOffsetsResponseTopic
 	}
 
 	listOffsetsResponseTopic struct {
@@ -35,53 +38,51 @@ type (
 		ErrorCode      int16
 		Timestamp      int64
 		Offset         int64
+		LeaderEpoch    int32 `kafka:"min=4"`
 	}
 )
 
-func listOffsetsV1(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeader) (response any, rtags map[int]any, err error) {
-	request, err := readRequest[listOffsetsRequestV1](reader)
+// the special timestamps of a list offsets request
+const (
+	kListLatest       = -1
+	kListEarliest     = -2
+	kListMaxTimestamp = -3
+)
+
+func listOffsets(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeader) (response any, rtags map[int]any, err error) {
+	request, err := readVersionedRequest[listOffsetsRequest](reader, kmh)
 	if err != nil {
 		return
 	}
 
+	readCommitted := request.IsolationLevel == isolationReadCommitted
 	lo := make([]listOffsetsResponseTopic, 0, len(request.Topics))
 
 	for _, t := range request.Topics {
 		kt := kc.ds.getTopic(t.Name)
+		authEc := kc.authorizationError(aclOpDescribe, aclResourceTopic, t.Name)
 
-		rpars := make([]listOffsetResponsePartition, 0, len(t.Parititions))
-		for _, p := range t.Parititions {
+		rpars := make([]listOffsetResponsePartition, 0, len(t.Partitions))
+		for _, p := range t.Partitions {
 			var kp *kafkaPartition
 			if kt != nil {
 				kp = kt.getPartition(p.PartitionIndex)
 			}
 
-			if kp == nil {
-				rpars = append(rpars, listOffsetResponsePartition{PartitionIndex: p.PartitionIndex, ErrorCode: int16(UnknownTopicOrPartition)})
+			currentLeaderEpoch := p.CurrentLeaderEpoch
+			if kmh.RequestApiVersion < 4 {
+				currentLeaderEpoch = kNoLeaderEpoch
+			}
+
+			if authEc != NoError {
+				rpars = append(rpars, listOffsetResponsePartition{PartitionIndex: p.PartitionIndex, ErrorCode: int16(authEc), Timestamp: -1, Offset: -1, LeaderEpoch: -1})
+			} else if kp == nil {
+				rpars = append(rpars, listOffsetResponsePartition{PartitionIndex: p.PartitionIndex, ErrorCode: int16(UnknownTopicOrPartition), Timestamp: -1, Offset: -1, LeaderEpoch: -1})
+			} else if ec := kc.leaderError(kt, kp, currentLeaderEpoch); ec != NoError {
+				rpars = append(rpars, listOffsetResponsePartition{PartitionIndex: p.PartitionIndex, ErrorCode: int16(ec), Timestamp: -1, Offset: -1, LeaderEpoch: -1})
 			} else {
-				if p.Timestamp == -1 {
-					rpars = append(rpars, listOffsetResponsePartition{PartitionIndex: p.PartitionIndex, Timestamp: time.Now().UnixMilli(), Offset: int64(len(kp.Records))})
-				} else if p.Timestamp == -2 {
-					if len(kp.Records) == 0 {
-						rpars = append(rpars, listOffsetResponsePartition{PartitionIndex: p.PartitionIndex})
-					} else {
-						msg := kp.Records[0]
-						rpars = append(rpars, listOffsetResponsePartition{PartitionIndex: p.PartitionIndex, Timestamp: msg.Timestamp})
-					}
-				} else {
-					// slow but this is just a mock
-					offset := int64(0)
-					ts := int64(0)
-					for i := len(kp.Records) - 1; i >= 0; i-- {
-						msg := kp.Records[i]
-						ts = msg.Timestamp
-						if msg.Timestamp < p.Timestamp {
-							offset = int64(i + 1)
-							break
-						}
-					}
-					rpars = append(rpars, listOffsetResponsePartition{PartitionIndex: p.PartitionIndex, Timestamp: ts, Offset: offset})
-				}
+				kc.ds.enforceRetention(kt, kp)
+				rpars = append(rpars, listPartitionOffset(kp, p.PartitionIndex, p.Timestamp, readCommitted))
 			}
 		}
 
@@ -91,6 +92,59 @@ func listOffsetsV1(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeade
 		})
 	}
 
-	response = &listOffsetsResponseV1{Topics: lo}
+	response = &listOffsetsResponse{Topics: lo}
 	return
 }
+
+// finds the offset for a timestamp, or the latest (-1), earliest (-2) or max
+// timestamp (-3) offset, with the leader epoch of its record; a read_committed
+// client can't see past the last stable offset
+func listPartitionOffset(kp *kafkaPartition, index int32, timestamp int64, readCommitted bool) listOffsetResponsePartition {
+	kp.lock()
+	defer kp.unlock()
+
+	endOffset := kp.endOffset()
+	if readCommitted {
+		endOffset = kp.lastStableOffset()
+	}
+
+	switch timestamp {
+	case kListLatest:
+		return listOffsetResponsePartition{PartitionIndex: index, Timestamp: time.Now().UnixMilli(), Offset: endOffset, LeaderEpoch: kp.LeaderEpoch}
+
+	case kListEarliest:
+		if _, msg := kp.nextRecord(kp.LogStartOffset); msg != nil {
+			return listOffsetResponsePartition{PartitionIndex: index, Timestamp: msg.Timestamp, Offset: kp.LogStartOffset, LeaderEpoch: msg.LeaderEpoch}
+		}
+		return listOffsetResponsePartition{PartitionIndex: index, Offset: kp.LogStartOffset, LeaderEpoch: kp.LeaderEpoch}
+
+	case kListMaxTimestamp:
+		rpar := listOffsetResponsePartition{PartitionIndex: index, Timestamp: -1, Offset: -1, LeaderEpoch: -1}
+		for o := kp.LogStartOffset; o < endOffset; o++ {
+			if msg := kp.recordAt(o); msg != nil && !msg.isControl() && msg.Timestamp > rpar.Timestamp {
+				rpar.Timestamp = msg.Timestamp
+				rpar.Offset = o
+				rpar.LeaderEpoch = msg.LeaderEpoch
+			}
+		}
+		return rpar
+	}
+
+	// slow but this is just a mock
+	offset := kp.LogStartOffset
+	ts := int64(0)
+	epoch := int32(kNoLeaderEpoch)
+	for o := endOffset - 1; o >= kp.LogStartOffset; o-- {
+		msg := kp.recordAt(o)
+		if msg == nil {
+			continue
+		}
+		ts = msg.Timestamp
+		if msg.Timestamp < timestamp {
+			offset = o + 1
+			break
+		}
+		epoch = msg.LeaderEpoch
+	}
+	return listOffsetResponsePartition{PartitionIndex: index, Timestamp: ts, Offset: offset, LeaderEpoch: epoch}
+}
@@ -0,0 +1,122 @@
+package kafkamock
+
+import (
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/jimsnab/go-lane"
+)
+
+// how often the background log cleaner checks whether it's time to compact
+const kLogCleanerTick = time.Millisecond * 10
+
+// compacts the partitions of a topic whose cleanup policy includes compact
+func (ds *kafkaDataStore) compactTopic(kt *kafkaTopic) (removed int) {
+	if !slices.Contains(splitConfigList(ds.topicConfig(kt, "cleanup.policy")), "compact") {
+		return
+	}
+	deleteRetentionMs, _ := strconv.ParseInt(ds.topicConfig(kt, "delete.retention.ms"), 10, 64)
+	minLagMs, _ := strconv.ParseInt(ds.topicConfig(kt, "min.compaction.lag.ms"), 10, 64)
+
+	now := time.Now()
+	for _, kp := range kt.sortedPartitions() {
+		kp.mu.Lock()
+		removed += kp.compact(now, deleteRetentionMs, minLagMs)
+		kp.mu.Unlock()
+	}
+	return
+}
+
+// compacts all topics that have the compact cleanup policy
+func (ds *kafkaDataStore) compactTopics() (removed int) {
+	for _, kt := range ds.sortedTopics() {
+		removed += ds.compactTopic(kt)
+	}
+	return
+}
+
+// keeps only the latest record of each key, leaving gaps at the offsets of
+// the records it removes. Records newer than the minimum compaction lag are
+// left alone and don't replace older records. A tombstone (a record without
+// a value) is removed too, once it's older than the delete retention, so that
+// consumers that bootstrap in the meantime still see the delete. Records of
+// ongoing transactions are left alone, and records of aborted transactions
+// are removed without replacing older records. The caller holds the lock.
+func (kp *kafkaPartition) compact(now time.Time, deleteRetentionMs, minLagMs int64) (removed int) {
+	// find where the records that are too new to compact begin
+	lagCutoff := now.UnixMilli() - minLagMs
+	cleanableEnd := kp.lastStableOffset()
+	for offset := kp.LogStartOffset; offset < cleanableEnd; offset++ {
+		if record := kp.recordAt(offset); record != nil && record.Timestamp > lagCutoff {
+			cleanableEnd = offset
+			break
+		}
+	}
+
+	// records without a key can't be compacted, and transaction markers are kept
+	latest := map[string]int64{}
+	for offset := kp.LogStartOffset; offset < cleanableEnd; offset++ {
+		if record := kp.recordAt(offset); record != nil && record.Key != nil && !record.isControl() && !kp.isAborted(offset, record) {
+			latest[string(record.Key)] = offset
+		}
+	}
+
+	deleteCutoff := now.UnixMilli() - deleteRetentionMs
+	for offset := kp.LogStartOffset; offset < cleanableEnd; offset++ {
+		record := kp.recordAt(offset)
+		if record == nil || record.isControl() {
+			continue
+		}
+		if kp.isAborted(offset, record) {
+			kp.Records[offset-kp.LogStartOffset] = nil
+			removed++
+			continue
+		}
+		if record.Key == nil {
+			continue
+		}
+		if latest[string(record.Key)] != offset || (record.Value == nil && record.Timestamp <= deleteCutoff) {
+			kp.Records[offset-kp.LogStartOffset] = nil
+			removed++
+		}
+	}
+	return
+}
+
+// whether a record belongs to an aborted transaction; the caller holds the lock
+func (kp *kafkaPartition) isAborted(offset int64, record *kafkaRecord) bool {
+	if record.BatchAttributes&kBatchTransactional == 0 {
+		return false
+	}
+	for _, txn := range kp.AbortedTxns {
+		if txn.producerId == record.ProducerId && offset >= txn.firstOffset && offset < txn.lastOffset {
+			return true
+		}
+	}
+	return false
+}
+
+// compacts topics in the background, as often as the broker's
+// log.cleaner.backoff.ms allows, until the lane is done; a data store has
+// one cleaner, even when a cluster of brokers shares it
+func (ds *kafkaDataStore) runLogCleaner(l lane.Lane) {
+	last := time.Now()
+	for {
+		select {
+		case <-l.Done():
+			return
+		case <-time.After(kLogCleanerTick):
+		}
+
+		backoffMs, _ := strconv.ParseInt(ds.brokerConfig("log.cleaner.backoff.ms"), 10, 64)
+		if time.Since(last) < time.Duration(backoffMs)*time.Millisecond {
+			continue
+		}
+		last = time.Now()
+
+		if removed := ds.compactTopics(); removed > 0 {
+			l.Tracef("log cleaner removed %d records", removed)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package kafkamock
+
+import (
+	"slices"
+	"strconv"
+	"time"
+)
+
+// discards the records of a partition that are past the topic's retention
+// limits; the broker checks retention whenever the log is read or written,
+// instead of on a schedule, so that tests don't have to wait for a cleaner
+func (ds *kafkaDataStore) enforceRetention(kt *kafkaTopic, kp *kafkaPartition) {
+	if !slices.Contains(splitConfigList(ds.topicConfig(kt, "cleanup.policy")), "delete") {
+		return
+	}
+	retentionMs := ds.retentionLimit(kt, "retention.ms")
+	retentionBytes := ds.retentionLimit(kt, "retention.bytes")
+	if retentionMs < 0 && retentionBytes < 0 {
+		return
+	}
+
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+
+	kp.enforceRetention(time.Now(), retentionMs, retentionBytes)
+}
+
+// discards the oldest records while they are older than retentionMs or while
+// the log is larger than retentionBytes; a negative limit is unlimited.
+// The caller holds the lock.
+func (kp *kafkaPartition) enforceRetention(now time.Time, retentionMs, retentionBytes int64) {
+	offset := kp.LogStartOffset
+
+	if retentionMs >= 0 {
+		cutoff := now.UnixMilli() - retentionMs
+		for offset < kp.endOffset() {
+			// the gaps left by compaction go with the records around them
+			if record := kp.recordAt(offset); record != nil && record.Timestamp >= cutoff {
+				break
+			}
+			offset++
+		}
+	}
+
+	if retentionBytes >= 0 {
+		size := int64(0)
+		for o := offset; o < kp.endOffset(); o++ {
+			size += recordSize(kp.recordAt(o))
+		}
+		for offset < kp.endOffset() && size > retentionBytes {
+			size -= recordSize(kp.recordAt(offset))
+			offset++
+		}
+	}
+
+	kp.truncateBefore(offset)
+}
+
+// returns a retention limit that was configured for the topic or the broker,
+// or -1 when it wasn't; the static defaults aren't enforced, so that records
+// posted with old timestamps aren't discarded unless a test asks for it
+func (ds *kafkaDataStore) retentionLimit(kt *kafkaTopic, name string) int64 {
+	entry := ds.topicConfigEntry(kt, name)
+	if entry == nil || entry.source == configSourceDefault {
+		return -1
+	}
+	limit, err := strconv.ParseInt(entry.value, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return limit
+}
+
+// the size of a record in the log, without its batch overhead
+func recordSize(record *kafkaRecord) int64 {
+	if record == nil {
+		return 0
+	}
+	return int64(len(encodeRecordV2(record, 0, 0)))
+}
@@ -2,61 +2,163 @@ package kafkamock
 
 import (
 	"bufio"
+	"math"
+	"slices"
+
+	"github.com/google/uuid"
 )
 
 type (
-	metadataRequestV1 struct {
-		Topics []string
+	metadataRequest struct {
+		Topics                             []metadataRequestTopic
+		AllowAutoTopicCreation             bool `kafka:"min=4"`
+		IncludeClusterAuthorizedOperations bool `kafka:"min=8,max=10"`
+		IncludeTopicAuthorizedOperations   bool `kafka:"min=8"`
+	}
+
+	metadataRequestTopic struct {
+		TopicId uuid.UUID `kafka:"min=10"`
+		Name    NullableString
 	}
 
-	metadataResponseV1 struct {
-		Brokers      []brokersV1
-		ControllerId int32
-		Topics       []topicsV1
+	metadataResponse struct {
+		ThrottleTimeMs              int32 `kafka:"min=3"`
+		Brokers                     []metadataBroker
+		ClusterId                   NullableString `kafka:"min=2"`
+		ControllerId                int32          `kafka:"min=1"`
+		Topics                      []metadataTopic
+		ClusterAuthorizedOperations int32 `kafka:"min=8,max=10"`
 	}
 
-	brokersV1 struct {
+	metadataBroker struct {
 		NodeId int32
 		Host   string
 		Port   int32
-		Rack   NullableString
+		Rack   NullableString `kafka:"min=1"`
 	}
 
-	topicsV1 struct {
-		ErrorCode  int16
-		Name       string
-		IsInternal bool
-		Partitions []partitionV1
+	metadataTopic struct {
+		ErrorCode                 int16
+		Name                      string
+		TopicId                   uuid.UUID `kafka:"min=10"`
+		IsInternal                bool      `kafka:"min=1"`
+		Partitions                []metadataPartition
+		TopicAuthorizedOperations int32 `kafka:"min=8"`
 	}
 
-	partitionV1 struct {
-		ErrorCode      int16
-		PartitionIndex int32
-		LeaderId       int32
-		ReplicaNodes   []int32
-		IsrNodes       []int32
+	metadataPartition struct {
+		ErrorCode       int16
+		PartitionIndex  int32
+		LeaderId        int32
+		LeaderEpoch     int32 `kafka:"min=7"`
+		ReplicaNodes    []int32
+		IsrNodes        []int32
+		OfflineReplicas []int32 `kafka:"min=5"`
 	}
 )
 
-const kLeaderNode = 100
+const (
+	kClusterId = "kafka-mock"
+
+	// reported when authorized operations were not requested
+	kNoAuthorizedOperations = math.MinInt32
+)
 
-func metadataV1(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeader) (response any, rtags map[int]any, err error) {
-	request, err := readRequest[metadataRequestV1](reader)
+func metadata(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeader) (response any, rtags map[int]any, err error) {
+	request, err := readVersionedRequest[metadataRequest](reader, kmh)
 	if err != nil {
 		return
 	}
 
-	topics := make([]topicsV1, 0, len(request.Topics))
-	for _, t := range request.Topics {
-		topics = append(topics, topicsV1{Name: t, Partitions: []partitionV1{{PartitionIndex: 2, LeaderId: kLeaderNode, ReplicaNodes: []int32{1}, IsrNodes: []int32{1}}}})
+	// v0 asks for all topics with an empty list; later versions use a null list
+	var topics []metadataTopic
+	if request.Topics == nil || (kmh.RequestApiVersion == 0 && len(request.Topics) == 0) {
+		sorted := kc.ds.sortedTopics()
+		topics = make([]metadataTopic, 0, len(sorted))
+		for _, kt := range sorted {
+			// the topics the client can't describe are left out
+			if kc.authorized(aclOpDescribe, aclResourceTopic, kt.Name) {
+				topics = append(topics, makeMetadataTopic(kc.ds, kt))
+			}
+		}
+	} else {
+		topics = make([]metadataTopic, 0, len(request.Topics))
+		for _, t := range request.Topics {
+			var kt *kafkaTopic
+			if t.Name != nil {
+				kt = kc.ds.getTopic(*t.Name)
+			} else {
+				kt = kc.ds.getTopicById(t.TopicId)
+			}
+
+			name := t.Name
+			if kt != nil {
+				name = &kt.Name
+			}
+			if name != nil && !kc.authorized(aclOpDescribe, aclResourceTopic, *name) {
+				topics = append(topics, metadataTopic{ErrorCode: int16(TopicAuthorizationFailed), Name: *name, TopicId: t.TopicId, Partitions: []metadataPartition{}, TopicAuthorizedOperations: kNoAuthorizedOperations})
+			} else if kt != nil {
+				topics = append(topics, makeMetadataTopic(kc.ds, kt))
+			} else if t.Name != nil {
+				topics = append(topics, metadataTopic{ErrorCode: int16(UnknownTopicOrPartition), Name: *t.Name, Partitions: []metadataPartition{}, TopicAuthorizedOperations: kNoAuthorizedOperations})
+			} else {
+				topics = append(topics, metadataTopic{ErrorCode: int16(UnknownTopicId), TopicId: t.TopicId, Partitions: []metadataPartition{}, TopicAuthorizedOperations: kNoAuthorizedOperations})
+			}
+		}
+	}
+
+	// stopped brokers are left out, as they would be by a kafka controller
+	brokers := kc.ds.liveBrokers()
+	mbrokers := make([]metadataBroker, 0, len(brokers))
+	for _, broker := range brokers {
+		mbrokers = append(mbrokers, metadataBroker{NodeId: broker.NodeId, Host: broker.Host, Port: int32(broker.Port)})
 	}
 
-	response = &metadataResponseV1{
-		Brokers: []brokersV1{
-			{NodeId: kLeaderNode, Host: "localhost", Port: int32(kc.serverPort)},
-		},
-		ControllerId: 500,
-		Topics:       topics,
+	clusterId := kClusterId
+	response = &metadataResponse{
+		Brokers:                     mbrokers,
+		ClusterId:                   &clusterId,
+		ControllerId:                kc.ds.controllerId(),
+		Topics:                      topics,
+		ClusterAuthorizedOperations: kNoAuthorizedOperations,
 	}
 	return
 }
+
+func makeMetadataTopic(ds *kafkaDataStore, kt *kafkaTopic) metadataTopic {
+	partitions := kt.sortedPartitions()
+
+	mt := metadataTopic{
+		Name:                      kt.Name,
+		TopicId:                   kt.Id,
+		Partitions:                make([]metadataPartition, 0, len(partitions)),
+		TopicAuthorizedOperations: kNoAuthorizedOperations,
+	}
+
+	// the running replicas are in sync, since the brokers share one log
+	live := ds.liveBrokerIds()
+	for _, kp := range partitions {
+		leader, leaderEpoch, replicas := ds.partitionReplicas(kt, kp)
+		mp := metadataPartition{
+			PartitionIndex:  kp.Index,
+			LeaderId:        leader,
+			LeaderEpoch:     leaderEpoch,
+			ReplicaNodes:    replicas,
+			IsrNodes:        []int32{},
+			OfflineReplicas: []int32{},
+		}
+		if leader == kNoLeader {
+			mp.ErrorCode = int16(LeaderNotAvailable)
+		}
+		for _, id := range replicas {
+			if slices.Contains(live, id) {
+				mp.IsrNodes = append(mp.IsrNodes, id)
+			} else {
+				mp.OfflineReplicas = append(mp.OfflineReplicas, id)
+			}
+		}
+		mt.Partitions = append(mt.Partitions, mp)
+	}
+
+	return mt
+}
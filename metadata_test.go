@@ -0,0 +1,85 @@
+package kafkamock
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jimsnab/go-lane"
+	"github.com/segmentio/kafka-go"
+)
+
+func TestKafkaMetadataAllTopics(t *testing.T) {
+	topics := []string{"topic-a", "topic-b"}
+	tl, mock := testCreateKafkaMockServer(t, 21001, topics)
+	defer testStopMockServer(t, mock)
+	mock.CreatePartitionTopics([]string{"topic-b"}, 0)
+
+	client := testKafkaClient(21001)
+	md, err := client.Metadata(tl, &kafka.MetadataRequest{})
+	if err != nil {
+		t.Fatalf("metadata error: %v", err)
+	}
+
+	if md.ClusterID != kClusterId || md.Controller.ID != kFirstBrokerId {
+		t.Errorf("unexpected cluster %s controller %d", md.ClusterID, md.Controller.ID)
+	}
+	if len(md.Topics) != 2 || md.Topics[0].Name != "topic-a" || md.Topics[1].Name != "topic-b" {
+		t.Fatalf("unexpected topics %+v", md.Topics)
+	}
+	if len(md.Topics[0].Partitions) != 1 || md.Topics[0].Partitions[0].ID != 2 {
+		t.Errorf("unexpected topic-a partitions %+v", md.Topics[0].Partitions)
+	}
+	if len(md.Topics[1].Partitions) != 2 || md.Topics[1].Partitions[0].ID != 0 || md.Topics[1].Partitions[1].ID != 2 {
+		t.Errorf("unexpected topic-b partitions %+v", md.Topics[1].Partitions)
+	}
+}
+
+func TestKafkaMetadataUnknownTopic(t *testing.T) {
+	topics := []string{"topic-a"}
+	tl, mock := testCreateKafkaMockServer(t, 21001, topics)
+	defer testStopMockServer(t, mock)
+
+	client := testKafkaClient(21001)
+	md, err := client.Metadata(tl, &kafka.MetadataRequest{Topics: []string{"topic-a", "missing"}})
+	if err != nil {
+		t.Fatalf("metadata error: %v", err)
+	}
+
+	if len(md.Topics) != 2 || md.Topics[0].Error != nil || md.Topics[1].Error != kafka.UnknownTopicOrPartition {
+		t.Errorf("unexpected topics %+v", md.Topics)
+	}
+}
+
+func TestMetadataVersions(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	ds := newKafkaDataStore()
+	ds.Brokers[0].Port = 21001
+	kt := ds.createTopic("topic-a")
+	kt.createPartition(0)
+	kt.createPartition(1)
+
+	// v0 uses an empty list for all topics
+	mr := testHandlerRequest[*metadataResponse](t, tl, ds, metadata, ApiKeyMetadata, 0, &metadataRequest{Topics: []metadataRequestTopic{}})
+	if len(mr.Topics) != 1 || len(mr.Topics[0].Partitions) != 2 {
+		t.Errorf("v0 unexpected topics %+v", mr.Topics)
+	}
+
+	// later versions use an empty list for no topics
+	mr = testHandlerRequest[*metadataResponse](t, tl, ds, metadata, ApiKeyMetadata, 4, &metadataRequest{Topics: []metadataRequestTopic{}})
+	if len(mr.Topics) != 0 {
+		t.Errorf("v4 unexpected topics %+v", mr.Topics)
+	}
+
+	// flexible versions can look up topics by id
+	mr = testHandlerRequest[*metadataResponse](t, tl, ds, metadata, ApiKeyMetadata, 12, &metadataRequest{Topics: []metadataRequestTopic{{TopicId: kt.Id}, {TopicId: uuid.New()}}})
+	if len(mr.Topics) != 2 || mr.Topics[0].Name != "topic-a" || mr.Topics[0].TopicId != kt.Id {
+		t.Fatalf("v12 unexpected topics %+v", mr.Topics)
+	}
+	if mr.Topics[1].ErrorCode != int16(UnknownTopicId) {
+		t.Error("expected unknown topic id")
+	}
+	if mr.Brokers[0].Port != 21001 || mr.Topics[0].Partitions[1].LeaderId != kFirstBrokerId {
+		t.Error("unexpected leader")
+	}
+}
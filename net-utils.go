@@ -1,6 +1,7 @@
 package kafkamock
 
 import (
+	"crypto/tls"
 	"errors"
 	"io"
 	"net"
@@ -27,3 +28,19 @@ func wasSocketClosed(err error) bool {
 
 	return false
 }
+
+// a tls peer that goes away in the middle of a record, or whose connection
+// ends with an alert, has disconnected too
+func wasTLSConnectionClosed(err error) bool {
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && (opErr.Op == "remote error" || opErr.Op == "local error") {
+		return true
+	}
+
+	var alertErr tls.AlertError
+	return errors.As(err, &alertErr)
+}
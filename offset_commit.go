@@ -43,10 +43,22 @@ func offsetCommitV2(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHead
 		return
 	}
 
+	// commits from group members must be from the current generation,
+	// or the current member epoch of a consumer group
+	ec := kc.authorizationError(aclOpRead, aclResourceGroup, request.GroupId)
+	if ec != NoError {
+		kc.l.Tracef("kafka offset commit for group %s is not authorized", request.GroupId)
+	} else if cg := kc.ds.findConsumerGroup(request.GroupId); cg != nil {
+		ec = cg.validateCommit(request.MemberId, request.GenerationIdOrMemberEpoch)
+	} else {
+		ec = kc.ds.getGroup(request.GroupId).validateCommit(request.MemberId, request.GenerationIdOrMemberEpoch)
+	}
+
 	rtopics := make([]offsetCommitResponseTopic, 0, len(request.Topics))
 
 	for _, topic := range request.Topics {
 		kt := kc.ds.getTopic(topic.Name)
+		authEc := kc.authorizationError(aclOpRead, aclResourceTopic, topic.Name)
 
 		rtopic := offsetCommitResponseTopic{
 			Name:       topic.Name,
@@ -62,7 +74,11 @@ func offsetCommitV2(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHead
 			if kt != nil {
 				kp = kt.getPartition(par.PartitionIndex)
 			}
-			if kp != nil {
+			if ec != NoError {
+				rpar.ErrorCode = int16(ec)
+			} else if authEc != NoError {
+				rpar.ErrorCode = int16(authEc)
+			} else if kp != nil {
 				kp.lock()
 				offset := kp.GroupCommittedOffsets[request.GroupId]
 				if offset < par.CommittedOffset {
@@ -38,8 +38,23 @@ func offsetFetchV1(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeade
 		return
 	}
 
+	// partitions the client may not see are answered with the authorization error
+	groupEc := kc.authorizationError(aclOpDescribe, aclResourceGroup, request.GroupId)
+	denied := map[string][]offsetFetchPartitionV1{}
+
 	data := map[string][]*kafkaPartition{}
 	for _, rt := range request.Topics {
+		ec := groupEc
+		if ec == NoError {
+			ec = kc.authorizationError(aclOpDescribe, aclResourceTopic, rt.Name)
+		}
+		if ec != NoError {
+			for _, pi := range rt.PartitionIndexes {
+				denied[rt.Name] = append(denied[rt.Name], offsetFetchPartitionV1{PartitionIndex: pi, CommittedOffset: -1, ErrorCode: int16(ec)})
+			}
+			continue
+		}
+
 		kt := kc.ds.getTopic(rt.Name)
 		if kt == nil {
 			continue
@@ -58,7 +73,7 @@ func offsetFetchV1(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeade
 		}
 	}
 
-	rtopics := make([]offsetFetchTopicV1, 0, len(data))
+	rtopics := make([]offsetFetchTopicV1, 0, len(data)+len(denied))
 	for name, kps := range data {
 		rpars := make([]offsetFetchPartitionV1, 0, len(kps))
 		for _, kp := range kps {
@@ -71,6 +86,9 @@ func offsetFetchV1(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeade
 		}
 		rtopics = append(rtopics, offsetFetchTopicV1{Name: name, Partitions: rpars})
 	}
+	for name, rpars := range denied {
+		rtopics = append(rtopics, offsetFetchTopicV1{Name: name, Partitions: rpars})
+	}
 
 	response = &offsetFetchResponseV1{Topics: rtopics}
 	return
@@ -0,0 +1,91 @@
+package kafkamock
+
+import (
+	"bufio"
+)
+
+type (
+	offsetForLeaderEpochRequest struct {
+		ReplicaId int32 `kafka:"min=3"`
+		Topics    []offsetForLeaderTopic
+	}
+
+	offsetForLeaderTopic struct {
+		Topic      string
+		Partitions []offsetForLeaderPartition
+	}
+
+	offsetForLeaderPartition struct {
+		Partition          int32
+		CurrentLeaderEpoch int32 `kafka:"min=2"`
+		LeaderEpoch        int32
+	}
+
+	offsetForLeaderEpochResponse struct {
+		ThrottleTimeMs int32 `kafka:"min=2"`
+		Topics         []offsetForLeaderTopicResult
+	}
+
+	offsetForLeaderTopicResult struct {
+		Topic      string
+		Partitions []epochEndOffset
+	}
+
+	epochEndOffset struct {
+		ErrorCode   int16
+		Partition   int32
+		LeaderEpoch int32 `kafka:"min=1"`
+		EndOffset   int64
+	}
+)
+
+// answers where each requested leader epoch ended in the log, which a
+// consumer that saw a leader change uses to detect that the log it read
+// was truncated
+func offsetForLeaderEpoch(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeader) (response any, rtags map[int]any, err error) {
+	request, err := readVersionedRequest[offsetForLeaderEpochRequest](reader, kmh)
+	if err != nil {
+		return
+	}
+
+	ofler := &offsetForLeaderEpochResponse{Topics: make([]offsetForLeaderTopicResult, 0, len(request.Topics))}
+	for _, topic := range request.Topics {
+		rtopic := offsetForLeaderTopicResult{
+			Topic:      topic.Topic,
+			Partitions: make([]epochEndOffset, 0, len(topic.Partitions)),
+		}
+
+		kt := kc.ds.getTopic(topic.Topic)
+		authEc := kc.authorizationError(aclOpDescribe, aclResourceTopic, topic.Topic)
+		for _, par := range topic.Partitions {
+			var kp *kafkaPartition
+			if kt != nil {
+				kp = kt.getPartition(par.Partition)
+			}
+
+			currentLeaderEpoch := par.CurrentLeaderEpoch
+			if kmh.RequestApiVersion < 2 {
+				currentLeaderEpoch = kNoLeaderEpoch
+			}
+
+			eo := epochEndOffset{Partition: par.Partition, LeaderEpoch: kNoLeaderEpoch, EndOffset: -1}
+			if authEc != NoError {
+				eo.ErrorCode = int16(authEc)
+			} else if kp == nil {
+				eo.ErrorCode = int16(UnknownTopicOrPartition)
+			} else if ec := kc.leaderError(kt, kp, currentLeaderEpoch); ec != NoError {
+				eo.ErrorCode = int16(ec)
+			} else {
+				kp.lock()
+				eo.LeaderEpoch, eo.EndOffset = kp.endOffsetForEpoch(par.LeaderEpoch)
+				kp.unlock()
+			}
+			rtopic.Partitions = append(rtopic.Partitions, eo)
+		}
+
+		ofler.Topics = append(ofler.Topics, rtopic)
+	}
+
+	response = ofler
+	return
+}
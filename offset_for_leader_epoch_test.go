@@ -0,0 +1,280 @@
+package kafkamock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jimsnab/go-lane"
+	"github.com/segmentio/kafka-go/protocol"
+)
+
+// makes a partition of two replicas whose log was written in three leader
+// epochs: offsets 0-2 in epoch 0, 3-4 in epoch 1 and 5 in epoch 2
+func testLeaderEpochPartition(t *testing.T) (ds *kafkaDataStore, kt *kafkaTopic, kp *kafkaPartition) {
+	ds = newKafkaDataStore()
+	ds.Brokers = append(ds.Brokers, &kafkaBroker{NodeId: kFirstBrokerId + 1, Host: "localhost"})
+	kt = ds.createTopic("topic-a")
+	kt.ReplicationFactor = 2
+	kp = kt.createPartition(0)
+
+	post := func(count int) {
+		for i := 0; i < count; i++ {
+			kp.postRecord(0, time.Now(), nil, []byte("test"), nil)
+		}
+	}
+
+	post(3)
+	if _, err := ds.moveLeader(kt, kp, kFirstBrokerId+1); err != nil {
+		t.Fatalf("move leader error: %v", err)
+	}
+	post(2)
+	if _, err := ds.moveLeader(kt, kp, kFirstBrokerId); err != nil {
+		t.Fatalf("move leader error: %v", err)
+	}
+	post(1)
+	return
+}
+
+func TestOffsetForLeaderEpoch(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	ds, _, _ := testLeaderEpochPartition(t)
+
+	type expected struct {
+		ec          kafkaErrorCode
+		leaderEpoch int32
+		endOffset   int64
+	}
+
+	for _, version := range []int{0, 2, 4} {
+		request := &offsetForLeaderEpochRequest{
+			ReplicaId: -1,
+			Topics: []offsetForLeaderTopic{
+				{Topic: "topic-a", Partitions: []offsetForLeaderPartition{
+					{Partition: 0, CurrentLeaderEpoch: kNoLeaderEpoch, LeaderEpoch: 0},
+					{Partition: 0, CurrentLeaderEpoch: 2, LeaderEpoch: 1},
+					{Partition: 0, CurrentLeaderEpoch: 2, LeaderEpoch: 2},
+					{Partition: 0, CurrentLeaderEpoch: 2, LeaderEpoch: 3},
+					{Partition: 0, CurrentLeaderEpoch: 1, LeaderEpoch: 1},
+					{Partition: 0, CurrentLeaderEpoch: 3, LeaderEpoch: 1},
+					{Partition: 1, CurrentLeaderEpoch: kNoLeaderEpoch, LeaderEpoch: 0},
+				}},
+			},
+		}
+		expectations := []expected{
+			{NoError, 0, 3},
+			{NoError, 1, 5},
+			{NoError, 2, 6},
+			{NoError, -1, -1},
+			{FencedLeaderEpoch, -1, -1},
+			{UnknownLeaderEpoch, -1, -1},
+			{UnknownTopicOrPartition, -1, -1},
+		}
+
+		// the current leader epoch is only checked from v2
+		if version < 2 {
+			expectations[4] = expected{NoError, 1, 5}
+			expectations[5] = expected{NoError, 1, 5}
+		}
+
+		response := testHandlerRequest[*offsetForLeaderEpochResponse](t, tl, ds, offsetForLeaderEpoch, ApiKeyOffsetForLeaderEpoch, version, request)
+		partitions := response.Topics[0].Partitions
+		if len(partitions) != len(expectations) {
+			t.Fatalf("v%d: unexpected partitions %+v", version, partitions)
+		}
+		for n, p := range partitions {
+			want := expectations[n]
+			if p.ErrorCode != int16(want.ec) || p.LeaderEpoch != want.leaderEpoch || p.EndOffset != want.endOffset {
+				t.Errorf("v%d: request %d: unexpected end offset %+v, expected %+v", version, n, p, want)
+			}
+		}
+	}
+}
+
+func TestLeaderEpochsInFetchedBatches(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	ds, _, _ := testLeaderEpochPartition(t)
+
+	request := &fetchRequest{
+		ReplicaId: -1,
+		MaxBytes:  1 << 20,
+		Topics: []fetchTopic{
+			{Topic: "topic-a", Partitions: []fetchPartition{{Partition: 0, CurrentLeaderEpoch: 2, FetchOffset: 0, PartitionMaxBytes: 1 << 20}}},
+		},
+	}
+	fp := testHandlerRequest[*fetchResponse](t, tl, ds, fetch, ApiKeyFetch, 12, request).Responses[0].Partitions[0]
+	if fp.ErrorCode != 0 {
+		t.Fatalf("unexpected fetch error %d", fp.ErrorCode)
+	}
+
+	// a batch doesn't span leader epochs
+	rs := fp.Records.(*recordSetV2)
+	epochs := []int32{}
+	for _, batch := range rs.batches {
+		epochs = append(epochs, batch.header.PartitionLeaderEpoch)
+	}
+	if len(epochs) != 3 || epochs[0] != 0 || epochs[1] != 1 || epochs[2] != 2 {
+		t.Errorf("unexpected batch leader epochs %v", epochs)
+	}
+	if records := testFetchedRecords(t, &fp); len(records) != 6 {
+		t.Errorf("unexpected fetched records %d", len(records))
+	}
+
+	// a consumer that missed the last leader change is fenced
+	request.Topics[0].Partitions[0].CurrentLeaderEpoch = 1
+	if fp := testHandlerRequest[*fetchResponse](t, tl, ds, fetch, ApiKeyFetch, 12, request).Responses[0].Partitions[0]; fp.ErrorCode != int16(FencedLeaderEpoch) {
+		t.Errorf("expected fenced leader epoch, got %d", fp.ErrorCode)
+	}
+
+	// before v9 the request has no leader epoch to check
+	if fp := testHandlerRequest[*fetchResponse](t, tl, ds, fetch, ApiKeyFetch, 8, request).Responses[0].Partitions[0]; fp.ErrorCode != 0 {
+		t.Errorf("unexpected v8 fetch error %d", fp.ErrorCode)
+	}
+}
+
+func TestTruncatePartitionDivergence(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	ds, _, kp := testLeaderEpochPartition(t)
+
+	if ec := ds.truncatePartition("topic-a", 0, 7); ec != OffsetOutOfRange {
+		t.Errorf("expected offset out of range, got %d", ec)
+	}
+	if ec := ds.truncatePartition("topic-a", 1, 0); ec != UnknownTopicOrPartition {
+		t.Errorf("expected unknown partition, got %d", ec)
+	}
+
+	// the new leader lost offsets 4 and 5, and continues in epoch 3
+	if ec := ds.truncatePartition("topic-a", 0, 4); ec != NoError {
+		t.Fatalf("truncate error %d", ec)
+	}
+	kp.postRecord(0, time.Now(), nil, []byte("new"), nil)
+	if kp.LeaderEpoch != 3 || kp.endOffset() != 5 {
+		t.Errorf("unexpected partition epoch %d end offset %d", kp.LeaderEpoch, kp.endOffset())
+	}
+
+	// a consumer that read offset 5 in epoch 2 learns that the log diverged at 4
+	request := &offsetForLeaderEpochRequest{
+		ReplicaId: -1,
+		Topics: []offsetForLeaderTopic{
+			{Topic: "topic-a", Partitions: []offsetForLeaderPartition{
+				{Partition: 0, CurrentLeaderEpoch: 3, LeaderEpoch: 2},
+				{Partition: 0, CurrentLeaderEpoch: 3, LeaderEpoch: 3},
+			}},
+		},
+	}
+	partitions := testHandlerRequest[*offsetForLeaderEpochResponse](t, tl, ds, offsetForLeaderEpoch, ApiKeyOffsetForLeaderEpoch, 4, request).Topics[0].Partitions
+	if p := partitions[0]; p.ErrorCode != 0 || p.LeaderEpoch != 1 || p.EndOffset != 4 {
+		t.Errorf("unexpected end offset of the lost epoch %+v", p)
+	}
+	if p := partitions[1]; p.ErrorCode != 0 || p.LeaderEpoch != 3 || p.EndOffset != 5 {
+		t.Errorf("unexpected end offset of the current epoch %+v", p)
+	}
+
+	// list offsets reports the epoch of the offset it finds
+	response := testHandlerRequest[*listOffsetsResponse](t, tl, ds, listOffsets, ApiKeyListOffsets, 4, &listOffsetsRequest{
+		ReplicaId: -1,
+		Topics: []listOffsetsRequestTopic{
+			{Name: "topic-a", Partitions: []listOffsetsRequestPartition{
+				{PartitionIndex: 0, CurrentLeaderEpoch: 3, Timestamp: kListLatest},
+				{PartitionIndex: 0, CurrentLeaderEpoch: 3, Timestamp: kListEarliest},
+				{PartitionIndex: 0, CurrentLeaderEpoch: 2, Timestamp: kListLatest},
+			}},
+		},
+	})
+	rpars := response.Topics[0].Partitions
+	if p := rpars[0]; p.ErrorCode != 0 || p.Offset != 5 || p.LeaderEpoch != 3 {
+		t.Errorf("unexpected latest offset %+v", p)
+	}
+	if p := rpars[1]; p.ErrorCode != 0 || p.Offset != 0 || p.LeaderEpoch != 0 {
+		t.Errorf("unexpected earliest offset %+v", p)
+	}
+	if p := rpars[2]; p.ErrorCode != int16(FencedLeaderEpoch) {
+		t.Errorf("expected fenced leader epoch %+v", p)
+	}
+}
+
+func TestKafkaTruncatePartition(t *testing.T) {
+	topics := []string{"topic-a"}
+	_, mock := testCreateKafkaMockServer(t, 21001, topics)
+	defer testStopMockServer(t, mock)
+
+	for i := 0; i < 5; i++ {
+		mock.SimplePost("topic-a", 0, nil, []byte{byte('0' + i)})
+	}
+	if err := mock.TruncatePartition("topic-a", 0, 3); err != nil {
+		t.Fatalf("truncate partition error: %v", err)
+	}
+	if err := mock.TruncatePartition("topic-b", 0, 0); err == nil {
+		t.Error("expected an unknown topic error")
+	}
+	mock.SimplePost("topic-a", 0, nil, []byte("x"))
+
+	// the lost records are replaced by the ones written after the election
+	conn := testDialLeader(t, 21001, "topic-a", 0)
+	defer conn.Close()
+	if first, last, err := conn.ReadOffsets(); err != nil || first != 0 || last != 4 {
+		t.Errorf("unexpected offsets %d-%d: %v", first, last, err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	values := ""
+	for i := 0; i < 4; i++ {
+		m, err := conn.ReadMessage(1000)
+		if err != nil {
+			t.Fatalf("read message error: %v", err)
+		}
+		values += string(m.Value)
+	}
+	if values != "012x" {
+		t.Errorf("unexpected values %s", values)
+	}
+}
+
+func TestTruncatePartitionTransactions(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	ds := newKafkaDataStore()
+	kp := ds.createTopic("topic-a").createPartition(0)
+
+	postTxn := func(producerId int64, value string) {
+		batches, ec := decodeRecordBatches(testTxnBatch(t, producerId, 0, 0, protocol.Record{Value: protocol.NewBytes([]byte(value))}))
+		if ec != NoError {
+			t.Fatalf("invalid batch: %d", ec)
+		}
+		if _, ec := kp.postBatches(batches, func(*recordBatchV2) kafkaErrorCode { return NoError }); ec != NoError {
+			t.Fatalf("post batch error: %d", ec)
+		}
+	}
+
+	// producer 1 aborts and producer 2 commits, and both markers are lost
+	postTxn(1, "aborted")
+	postTxn(2, "committed")
+	kp.postTxnMarker(1, 0, false)
+	kp.postTxnMarker(2, 0, true)
+	kp.postRecord(0, time.Now(), nil, []byte("plain"), nil)
+	if ec := ds.truncatePartition("topic-a", 0, 2); ec != NoError {
+		t.Fatalf("truncate error %d", ec)
+	}
+
+	// the markers are written again in the new epoch, so a read_committed
+	// consumer isn't held back and still skips the aborted transaction
+	fr := testHandlerRequest[*fetchResponse](t, tl, ds, fetch, ApiKeyFetch, 11, &fetchRequest{
+		ReplicaId:      -1,
+		MaxBytes:       1000,
+		IsolationLevel: isolationReadCommitted,
+		Topics:         []fetchTopic{{Topic: "topic-a", Partitions: []fetchPartition{{Partition: 0, CurrentLeaderEpoch: 1, PartitionMaxBytes: 1000}}}},
+	})
+	fp := fr.Responses[0].Partitions[0]
+	if fp.ErrorCode != 0 || fp.HighWatermark != 4 || fp.LastStableOffset != 4 {
+		t.Fatalf("unexpected read_committed fetch %+v", fp)
+	}
+	if len(fp.AbortedTransactions) != 1 || fp.AbortedTransactions[0].ProducerId != 1 || fp.AbortedTransactions[0].FirstOffset != 0 {
+		t.Errorf("unexpected aborted transactions %+v", fp.AbortedTransactions)
+	}
+
+	records := testFetchedRecords(t, &fp)
+	if len(records) != 4 || records[2].isCommitMarker() || !records[3].isCommitMarker() || records[2].ProducerId != 1 || records[3].ProducerId != 2 {
+		t.Fatalf("unexpected fetched records %+v", records)
+	}
+	if kp.LeaderEpoch != 1 || kp.recordAt(2).LeaderEpoch != 1 || !kp.recordAt(2).isControl() {
+		t.Errorf("markers not written in the new epoch")
+	}
+}
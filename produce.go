@@ -0,0 +1,172 @@
+package kafkamock
+
+import (
+	"bufio"
+	"strconv"
+	"time"
+)
+
+type (
+	produceRequest struct {
+		TransactionalId NullableString `kafka:"min=3"`
+		Acks            int16
+		TimeoutMs       int32
+		TopicData       []produceTopicData
+	}
+
+	produceTopicData struct {
+		Name          string
+		PartitionData []producePartitionData
+	}
+
+	producePartitionData struct {
+		Index   int32
+		Records NullableBytes
+	}
+
+	produceResponse struct {
+		Responses      []produceTopicResponse
+		ThrottleTimeMs int32 `kafka:"min=1"`
+	}
+
+	produceTopicResponse struct {
+		Name               string
+		PartitionResponses []producePartitionResponse
+	}
+
+	producePartitionResponse struct {
+		Index           int32
+		ErrorCode       int16
+		BaseOffset      int64
+		LogAppendTimeMs int64                `kafka:"min=2"`
+		LogStartOffset  int64                `kafka:"min=5"`
+		RecordErrors    []produceRecordError `kafka:"min=8"`
+		ErrorMessage    NullableString       `kafka:"min=8"`
+	}
+
+	produceRecordError struct {
+		BatchIndex             int32
+		BatchIndexErrorMessage NullableString
+	}
+)
+
+func produce(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeader) (response any, rtags map[int]any, err error) {
+	request, err := readVersionedRequest[produceRequest](reader, kmh)
+	if err != nil {
+		return
+	}
+
+	// a transactional producer must be allowed to write with its transactional id
+	var txnAuthEc kafkaErrorCode
+	if request.TransactionalId != nil {
+		txnAuthEc = kc.authorizationError(aclOpWrite, aclResourceTransactionalId, *request.TransactionalId)
+	}
+
+	rtopics := make([]produceTopicResponse, 0, len(request.TopicData))
+	for _, td := range request.TopicData {
+		kt := kc.ds.getTopic(td.Name)
+
+		authEc := txnAuthEc
+		if authEc == NoError {
+			authEc = kc.authorizationError(aclOpWrite, aclResourceTopic, td.Name)
+		}
+
+		rtopic := produceTopicResponse{
+			Name:               td.Name,
+			PartitionResponses: make([]producePartitionResponse, 0, len(td.PartitionData)),
+		}
+
+		for _, pd := range td.PartitionData {
+			rpar := producePartitionResponse{
+				Index:           pd.Index,
+				BaseOffset:      -1,
+				LogAppendTimeMs: -1,
+				LogStartOffset:  -1,
+				RecordErrors:    []produceRecordError{},
+			}
+
+			var kp *kafkaPartition
+			if kt != nil {
+				kp = kt.getPartition(pd.Index)
+			}
+
+			if request.Acks != 0 && request.Acks != 1 && request.Acks != -1 {
+				rpar.ErrorCode = int16(InvalidRequiredAcks)
+			} else if authEc != NoError {
+				rpar.ErrorCode = int16(authEc)
+			} else if kp == nil {
+				rpar.ErrorCode = int16(UnknownTopicOrPartition)
+			} else if ec := kc.leaderError(kt, kp, kNoLeaderEpoch); ec != NoError {
+				rpar.ErrorCode = int16(ec)
+				kc.l.Tracef("kafka produce to %s:%d on broker %d, which is not the leader", td.Name, pd.Index, kc.nodeId())
+			} else {
+				batches, ec := decodeRecordBatches(pd.Records)
+				if ec == NoError {
+					ec = kc.ds.batchSizeError(kt, batches)
+				}
+				if ec == NoError {
+					rpar.LogAppendTimeMs = kc.ds.stampLogAppendTime(kt, batches)
+					rpar.BaseOffset, ec = kp.postBatches(batches, func(header *recordBatchV2) kafkaErrorCode {
+						return kc.ds.verifyTxnWrite(header.ProducerId, header.ProducerEpoch, td.Name, pd.Index)
+					})
+				}
+				if ec != NoError {
+					rpar.ErrorCode = int16(ec)
+					rpar.LogAppendTimeMs = -1
+					kc.l.Tracef("kafka produce to %s:%d failed: %d", td.Name, pd.Index, ec)
+				} else {
+					kc.ds.enforceRetention(kt, kp)
+					kp.lock()
+					rpar.LogStartOffset = kp.LogStartOffset
+					kp.unlock()
+					kc.l.Tracef("kafka produced %d batches to %s:%d at offset %d", len(batches), td.Name, pd.Index, rpar.BaseOffset)
+				}
+			}
+
+			rtopic.PartitionResponses = append(rtopic.PartitionResponses, rpar)
+		}
+
+		rtopics = append(rtopics, rtopic)
+	}
+
+	if request.Acks == 0 {
+		// the producer does not wait for a response
+		return
+	}
+
+	response = &produceResponse{Responses: rtopics}
+	return
+}
+
+// the error for a batch that is larger than the topic's max.message.bytes,
+// which counts the whole batch, including its offset and length
+func (ds *kafkaDataStore) batchSizeError(kt *kafkaTopic, batches []*decodedBatch) kafkaErrorCode {
+	maxBytes, err := strconv.ParseInt(ds.topicConfig(kt, "max.message.bytes"), 10, 64)
+	if err != nil {
+		return NoError
+	}
+	for _, batch := range batches {
+		if kBatchLengthOffset+4+int64(batch.header.BatchLength) > maxBytes {
+			return MessageTooLarge
+		}
+	}
+	return NoError
+}
+
+// replaces the producer's timestamps with the time of the append when the
+// topic's message.timestamp.type is LogAppendTime, returning that time, or -1
+// when the topic keeps the producer's timestamps
+func (ds *kafkaDataStore) stampLogAppendTime(kt *kafkaTopic, batches []*decodedBatch) int64 {
+	if ds.topicConfig(kt, "message.timestamp.type") != "LogAppendTime" {
+		return -1
+	}
+	now := time.Now().UnixMilli()
+	for _, batch := range batches {
+		batch.header.MaxTimestamp = now
+		for _, record := range batch.records {
+			record.Timestamp = now
+			record.BatchAttributes |= kBatchTimestampLogAppend
+		}
+	}
+	return now
+}
@@ -0,0 +1,245 @@
+package kafkamock
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jimsnab/go-lane"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+)
+
+func testDialLeader(t *testing.T, serverPort uint, topic string, partition int) *kafka.Conn {
+	conn, err := kafka.DialLeader(context.Background(), "tcp", fmt.Sprintf("localhost:%d", serverPort), topic, partition)
+	if err != nil {
+		t.Fatalf("dial leader error: %v", err)
+	}
+	return conn
+}
+
+// makes a v2 record batch the way a producer would
+func testRecordBatch(t *testing.T, records ...protocol.Record) []byte {
+	rs := protocol.RecordSet{Version: 2, Records: protocol.NewRecordReader(records...)}
+
+	var buf bytes.Buffer
+	if _, err := rs.WriteTo(&buf); err != nil {
+		t.Fatalf("record set error: %v", err)
+	}
+
+	// strip the size prefix
+	return buf.Bytes()[4:]
+}
+
+func TestKafkaProduce(t *testing.T) {
+	topics := []string{"topic-a"}
+	_, mock := testCreateKafkaMockServer(t, 21001, topics)
+	defer testStopMockServer(t, mock)
+
+	conn := testDialLeader(t, 21001, "topic-a", 2)
+	defer conn.Close()
+
+	_, _, offset, _, err := conn.WriteCompressedMessagesAt(nil,
+		kafka.Message{Key: []byte("k1"), Value: []byte("v1"), Headers: []kafka.Header{{Key: "h1", Value: []byte("hv1")}, {Key: "h2", Value: []byte("hv2")}}},
+		kafka.Message{Key: []byte("k2"), Value: []byte("v2")},
+	)
+	if err != nil {
+		t.Fatalf("produce error: %v", err)
+	}
+	if offset != 0 {
+		t.Errorf("unexpected base offset %d", offset)
+	}
+
+	_, _, offset, _, err = conn.WriteCompressedMessagesAt(nil, kafka.Message{Value: []byte("v3")})
+	if err != nil || offset != 2 {
+		t.Fatalf("second produce failed: %v offset %d", err, offset)
+	}
+
+	kp := mock.ds.getTopic("topic-a").getPartition(2)
+	kp.lock()
+	defer kp.unlock()
+
+	if len(kp.Records) != 3 {
+		t.Fatalf("expected 3 records, got %d", len(kp.Records))
+	}
+	rec := kp.Records[0]
+	if string(rec.Key) != "k1" || string(rec.Value) != "v1" {
+		t.Error("first record mismatch")
+	}
+	if len(rec.Headers) != 2 || rec.Headers[0].HeaderKey != "h1" || string(rec.Headers[1].HeaderValue) != "hv2" {
+		t.Error("header mismatch")
+	}
+	if kp.Records[2].Key != nil || string(kp.Records[2].Value) != "v3" {
+		t.Error("third record mismatch")
+	}
+}
+
+func TestKafkaProduceCompressed(t *testing.T) {
+	topics := []string{"topic-a"}
+	_, mock := testCreateKafkaMockServer(t, 21001, topics)
+	defer testStopMockServer(t, mock)
+
+	conn := testDialLeader(t, 21001, "topic-a", 2)
+	defer conn.Close()
+
+	codecs := []kafka.Compression{kafka.Gzip, kafka.Snappy, kafka.Lz4, kafka.Zstd}
+	for n, codec := range codecs {
+		_, _, offset, _, err := conn.WriteCompressedMessagesAt(codec.Codec(),
+			kafka.Message{Value: []byte(fmt.Sprintf("%s first", codec))},
+			kafka.Message{Value: []byte(fmt.Sprintf("%s second", codec))},
+		)
+		if err != nil {
+			t.Fatalf("%s produce failed: %v", codec, err)
+		}
+		if offset != int64(n*2) {
+			t.Errorf("%s unexpected base offset %d", codec, offset)
+		}
+	}
+
+	kp := mock.ds.getTopic("topic-a").getPartition(2)
+	kp.lock()
+	defer kp.unlock()
+
+	for n, codec := range codecs {
+		if string(kp.Records[n*2+1].Value) != fmt.Sprintf("%s second", codec) {
+			t.Errorf("%s record mismatch", codec)
+		}
+	}
+}
+
+func TestKafkaProduceUnknownPartition(t *testing.T) {
+	topics := []string{"topic-a"}
+	_, mock := testCreateKafkaMockServer(t, 21001, topics)
+	defer testStopMockServer(t, mock)
+
+	conn := testDialLeader(t, 21001, "topic-a", 2)
+	defer conn.Close()
+
+	// remove the partition behind the client's back
+	kt := mock.ds.getTopic("topic-a")
+	kt.mu.Lock()
+	delete(kt.Partitions, 2)
+	kt.mu.Unlock()
+
+	_, err := conn.WriteMessages(kafka.Message{Value: []byte("test")})
+	if err != kafka.UnknownTopicOrPartition {
+		t.Errorf("expected unknown topic or partition, got %v", err)
+	}
+}
+
+func TestProduceFlexibleVersion(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	ds := newKafkaDataStore()
+	ds.createTopic("topic-a").createPartition(0)
+
+	batch := testRecordBatch(t, protocol.Record{Value: protocol.NewBytes([]byte("flexible"))})
+	txnId := "txn"
+	pr := testHandlerRequest[*produceResponse](t, tl, ds, produce, ApiKeyProduce, 9, &produceRequest{
+		TransactionalId: &txnId,
+		Acks:            -1,
+		TopicData: []produceTopicData{
+			{Name: "topic-a", PartitionData: []producePartitionData{{Index: 0, Records: batch}, {Index: 1, Records: batch}}},
+		},
+	})
+
+	if pr == nil || len(pr.Responses) != 1 || len(pr.Responses[0].PartitionResponses) != 2 {
+		t.Fatalf("unexpected response %+v", pr)
+	}
+	if pr.Responses[0].PartitionResponses[0].ErrorCode != 0 || pr.Responses[0].PartitionResponses[0].BaseOffset != 0 {
+		t.Error("expected successful produce")
+	}
+	if pr.Responses[0].PartitionResponses[1].ErrorCode != int16(UnknownTopicOrPartition) {
+		t.Error("expected unknown partition")
+	}
+
+	kp := ds.getTopic("topic-a").getPartition(0)
+	if len(kp.Records) != 1 || string(kp.Records[0].Value) != "flexible" {
+		t.Error("record not stored")
+	}
+}
+
+func TestProduceNoAcks(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	ds := newKafkaDataStore()
+	ds.createTopic("topic-a").createPartition(0)
+
+	batch := testRecordBatch(t, protocol.Record{Value: protocol.NewBytes([]byte("fire and forget"))})
+	response := testHandlerRequest[any](t, tl, ds, produce, ApiKeyProduce, 8, &produceRequest{
+		Acks: 0,
+		TopicData: []produceTopicData{
+			{Name: "topic-a", PartitionData: []producePartitionData{{Index: 0, Records: batch}}},
+		},
+	})
+	if response != nil {
+		t.Error("acks=0 must not respond")
+	}
+
+	kp := ds.getTopic("topic-a").getPartition(0)
+	if len(kp.Records) != 1 {
+		t.Error("record not stored")
+	}
+}
+
+func TestProduceCorruptBatch(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	ds := newKafkaDataStore()
+	ds.createTopic("topic-a").createPartition(0)
+
+	batch := testRecordBatch(t, protocol.Record{Value: protocol.NewBytes([]byte("damaged"))})
+	batch[len(batch)-1] ^= 0xFF
+
+	pr := testHandlerRequest[*produceResponse](t, tl, ds, produce, ApiKeyProduce, 7, &produceRequest{
+		Acks: 1,
+		TopicData: []produceTopicData{
+			{Name: "topic-a", PartitionData: []producePartitionData{{Index: 0, Records: batch}}},
+		},
+	})
+
+	if pr.Responses[0].PartitionResponses[0].ErrorCode != int16(CorruptMessage) {
+		t.Error("expected corrupt message")
+	}
+}
+
+func TestProduceTopicConfigs(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	ds := newKafkaDataStore()
+	kt := ds.createTopic("topic-a")
+	kp := kt.createPartition(0)
+
+	// by default the producer's timestamps are kept
+	created := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	record := func(size int) protocol.Record {
+		return protocol.Record{Time: created, Value: protocol.NewBytes(bytes.Repeat([]byte("x"), size))}
+	}
+	if rpar := testProduceBatch(t, tl, ds, testRecordBatch(t, record(10))); rpar.ErrorCode != 0 || rpar.LogAppendTimeMs != -1 {
+		t.Errorf("unexpected produce %+v", rpar)
+	}
+	if kp.Records[0].Timestamp != created.UnixMilli() {
+		t.Errorf("expected the create time, got %d", kp.Records[0].Timestamp)
+	}
+
+	maxBytes, timestampType := "200", "LogAppendTime"
+	ds.alterTopicConfigs(kt, []kafkaConfigChange{{name: "max.message.bytes", value: &maxBytes}, {name: "message.timestamp.type", value: &timestampType}}, false, false)
+
+	// a batch over max.message.bytes isn't written
+	if rpar := testProduceBatch(t, tl, ds, testRecordBatch(t, record(200))); rpar.ErrorCode != int16(MessageTooLarge) || rpar.LogAppendTimeMs != -1 {
+		t.Errorf("expected message too large, got %+v", rpar)
+	}
+	if len(kp.Records) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(kp.Records))
+	}
+
+	// the broker stamps the records with the time they are appended
+	before := time.Now().UnixMilli()
+	rpar := testProduceBatch(t, tl, ds, testRecordBatch(t, record(10), record(10)))
+	if rpar.ErrorCode != 0 || rpar.LogAppendTimeMs < before || rpar.LogAppendTimeMs > time.Now().UnixMilli() {
+		t.Fatalf("unexpected produce %+v", rpar)
+	}
+	for _, r := range kp.Records[1:] {
+		if r.Timestamp != rpar.LogAppendTimeMs || r.BatchAttributes&kBatchTimestampLogAppend == 0 {
+			t.Errorf("expected the log append time, got %d", r.Timestamp)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package kafkamock
+
+import (
+	"math"
+	"slices"
+	"time"
+
+	"github.com/jimsnab/go-lane"
+)
+
+type (
+	// a producer id handed out by InitProducerId, with its current epoch
+	kafkaProducer struct {
+		id              int64
+		epoch           int16
+		transactionalId string
+		txn             *kafkaTxn // set for a transactional producer
+	}
+
+	// what a partition remembers about an idempotent producer, so that it
+	// can drop retried batches and detect lost ones
+	kafkaPartitionProducer struct {
+		epoch   int16
+		batches []kafkaProducerBatch // the most recent batches, oldest first
+	}
+
+	kafkaProducerBatch struct {
+		firstSequence int32
+		lastSequence  int32
+		baseOffset    int64
+	}
+)
+
+const (
+	kNoProducerId    = -1
+	kNoProducerEpoch = -1
+	kNoSequence      = -1
+
+	// a partition recognizes a retry of any of the producer's last five batches,
+	// which is as many as a producer may have in flight
+	kProducerBatchesRetained = 5
+)
+
+// allocates a new producer id, or bumps the epoch of a producer that is
+// reinitializing; a transactional id keeps its producer id across sessions,
+// and a transaction that its previous session left ongoing is aborted
+func (ds *kafkaDataStore) initProducer(l lane.Lane, transactionalId string, txnTimeout time.Duration, producerId int64, producerEpoch int16) (id int64, epoch int16, ec kafkaErrorCode) {
+	ds.mu.Lock()
+	kp, outcome, ec := ds.initProducerSession(transactionalId, txnTimeout, producerId, producerEpoch)
+	id, epoch = kNoProducerId, kNoProducerEpoch
+	if ec == NoError {
+		id, epoch = kp.id, kp.epoch
+	}
+	ds.mu.Unlock()
+
+	if outcome != nil {
+		ds.completeTxn(l, kp, outcome)
+	}
+	return
+}
+
+// the caller holds the data store lock
+func (ds *kafkaDataStore) initProducerSession(transactionalId string, txnTimeout time.Duration, producerId int64, producerEpoch int16) (kp *kafkaProducer, outcome *kafkaTxnOutcome, ec kafkaErrorCode) {
+	if transactionalId != "" {
+		kp = ds.TransactionalIds[transactionalId]
+	} else if producerId != kNoProducerId {
+		kp = ds.Producers[producerId]
+		if kp == nil {
+			ec = InvalidProducerIdMapping
+			return
+		}
+	}
+
+	if kp != nil && producerId != kNoProducerId {
+		// the producer is recovering its own session, which must be current
+		if kp.id != producerId || kp.epoch != producerEpoch {
+			ec = ProducerFenced
+			return
+		}
+	}
+
+	if kp != nil && kp.txn != nil {
+		switch kp.txn.state {
+		case txnPrepareCommit, txnPrepareAbort:
+			ec = ConcurrentTransactions
+			return
+		case txnOngoing:
+			// the abort markers carry a new epoch, which fences the old session
+			if kp.epoch < math.MaxInt16 {
+				kp.epoch++
+			}
+			outcome = kp.prepareTxnEnd(false)
+		}
+		kp.txn.timeout = txnTimeout
+	}
+
+	switch {
+	case kp == nil:
+		kp = &kafkaProducer{id: ds.allocateProducerId(), transactionalId: transactionalId}
+		if transactionalId != "" {
+			kp.txn = newKafkaTxn(txnTimeout)
+			ds.TransactionalIds[transactionalId] = kp
+		}
+
+	case kp.epoch == math.MaxInt16:
+		// the epoch is exhausted, so the producer continues under a new id
+		delete(ds.Producers, kp.id)
+		kp.id = ds.allocateProducerId()
+		kp.epoch = 0
+
+	default:
+		kp.epoch++
+	}
+
+	ds.Producers[kp.id] = kp
+	return
+}
+
+// the caller holds the data store lock
+func (ds *kafkaDataStore) allocateProducerId() int64 {
+	id := ds.NextProducerId
+	ds.NextProducerId++
+	return id
+}
+
+// checks a batch from an idempotent producer against what the partition has
+// already written from it, which is nil before the producer's first batch; a
+// retried batch is reported as a duplicate, with the offset it was first
+// written at. The caller holds the partition lock.
+func (pp *kafkaPartitionProducer) checkBatch(header *recordBatchV2) (duplicate *kafkaProducerBatch, ec kafkaErrorCode) {
+	if pp == nil {
+		// the partition has no state for the producer, so it must be starting out
+		if header.BaseSequence != 0 {
+			ec = UnknownProducerId
+		}
+		return
+	}
+
+	if header.ProducerEpoch < pp.epoch {
+		return nil, InvalidProducerEpoch
+	}
+	if header.ProducerEpoch > pp.epoch {
+		// a new epoch starts its sequence over
+		if header.BaseSequence != 0 {
+			ec = OutOfOrderSequenceNumber
+		}
+		return
+	}
+
+	lastSequence := addSequence(header.BaseSequence, header.LastOffsetDelta)
+	for n := range pp.batches {
+		if pp.batches[n].firstSequence == header.BaseSequence && pp.batches[n].lastSequence == lastSequence {
+			return &pp.batches[n], NoError
+		}
+	}
+
+	if len(pp.batches) > 0 && header.BaseSequence != addSequence(pp.batches[len(pp.batches)-1].lastSequence, 1) {
+		ec = OutOfOrderSequenceNumber
+	}
+	return
+}
+
+// returns the producer's state after a batch is written from it, leaving the
+// current state unchanged; a new epoch starts a new state
+func (pp *kafkaPartitionProducer) withBatch(header *recordBatchV2, baseOffset int64) *kafkaPartitionProducer {
+	next := &kafkaPartitionProducer{epoch: header.ProducerEpoch}
+	if pp != nil && pp.epoch == header.ProducerEpoch {
+		next.batches = slices.Clone(pp.batches)
+	}
+
+	next.batches = append(next.batches, kafkaProducerBatch{
+		firstSequence: header.BaseSequence,
+		lastSequence:  addSequence(header.BaseSequence, header.LastOffsetDelta),
+		baseOffset:    baseOffset,
+	})
+	if len(next.batches) > kProducerBatchesRetained {
+		next.batches = next.batches[1:]
+	}
+	return next
+}
+
+// sequence numbers wrap around to 0 after the largest int32
+func addSequence(sequence, delta int32) int32 {
+	return int32((int64(sequence) + int64(delta)) % (math.MaxInt32 + 1))
+}
@@ -0,0 +1,179 @@
+package kafkamock
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"testing"
+	"time"
+
+	"github.com/jimsnab/go-lane"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+)
+
+// makes a v2 record batch the way an idempotent producer would
+func testProducerBatch(t *testing.T, producerId int64, producerEpoch int16, baseSequence int32, records ...protocol.Record) []byte {
+	batch := testRecordBatch(t, records...)
+
+	binary.BigEndian.PutUint64(batch[43:], uint64(producerId))
+	binary.BigEndian.PutUint16(batch[51:], uint16(producerEpoch))
+	binary.BigEndian.PutUint32(batch[53:], uint32(baseSequence))
+	binary.BigEndian.PutUint32(batch[kBatchCrcStart-4:], crc32.Checksum(batch[kBatchCrcStart:], crcTable))
+	return batch
+}
+
+func testProduceBatch(t *testing.T, tl lane.Lane, ds *kafkaDataStore, batch []byte) producePartitionResponse {
+	response := testHandlerRequest[*produceResponse](t, tl, ds, produce, ApiKeyProduce, 9, &produceRequest{
+		Acks:      -1,
+		TopicData: []produceTopicData{{Name: "topic-a", PartitionData: []producePartitionData{{Index: 0, Records: batch}}}},
+	})
+	return response.Responses[0].PartitionResponses[0]
+}
+
+func TestKafkaInitProducerId(t *testing.T) {
+	tl, mock := testCreateKafkaMockServer(t, 21001, []string{"topic-a"})
+	defer testStopMockServer(t, mock)
+
+	client := testKafkaClient(21001)
+	first, err := client.InitProducerID(tl, &kafka.InitProducerIDRequest{ProducerID: -1, ProducerEpoch: -1})
+	if err != nil || first.Error != nil {
+		t.Fatalf("init producer id error: %v %v", err, first.Error)
+	}
+	second, err := client.InitProducerID(tl, &kafka.InitProducerIDRequest{ProducerID: -1, ProducerEpoch: -1})
+	if err != nil || second.Error != nil {
+		t.Fatalf("init producer id error: %v %v", err, second.Error)
+	}
+	if first.Producer.ProducerID == second.Producer.ProducerID || second.Producer.ProducerEpoch != 0 {
+		t.Errorf("expected distinct producers, got %+v and %+v", first.Producer, second.Producer)
+	}
+
+	// a producer that reinitializes its own session gets a new epoch
+	resp, err := client.InitProducerID(tl, &kafka.InitProducerIDRequest{ProducerID: first.Producer.ProducerID, ProducerEpoch: 0})
+	if err != nil || resp.Error != nil || resp.Producer.ProducerID != first.Producer.ProducerID || resp.Producer.ProducerEpoch != 1 {
+		t.Errorf("unexpected reinitialization %+v: %v", resp, err)
+	}
+
+	resp, err = client.InitProducerID(tl, &kafka.InitProducerIDRequest{ProducerID: 999, ProducerEpoch: 0})
+	if err != nil || !errors.Is(resp.Error, kafka.InvalidProducerIDMapping) {
+		t.Errorf("expected an invalid producer id mapping, got %v %v", err, resp.Error)
+	}
+}
+
+func TestInitProducerIdTransactional(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	ds := newKafkaDataStore()
+
+	id, epoch, ec := ds.initProducer(tl, "txn", time.Minute, kNoProducerId, kNoProducerEpoch)
+	if ec != NoError || epoch != 0 {
+		t.Fatalf("unexpected producer %d epoch %d: %d", id, epoch, ec)
+	}
+
+	// the transactional id keeps its producer id, and the older session is fenced
+	if id2, epoch2, ec := ds.initProducer(tl, "txn", time.Minute, kNoProducerId, kNoProducerEpoch); ec != NoError || id2 != id || epoch2 != 1 {
+		t.Errorf("unexpected producer %d epoch %d: %d", id2, epoch2, ec)
+	}
+	if _, _, ec := ds.initProducer(tl, "txn", time.Minute, id, 0); ec != ProducerFenced {
+		t.Errorf("expected producer fenced, got %d", ec)
+	}
+
+	// an exhausted epoch moves the transactional id to a new producer id
+	ds.TransactionalIds["txn"].epoch = 32767
+	if id2, epoch2, ec := ds.initProducer(tl, "txn", time.Minute, kNoProducerId, kNoProducerEpoch); ec != NoError || id2 == id || epoch2 != 0 {
+		t.Errorf("unexpected producer %d epoch %d: %d", id2, epoch2, ec)
+	}
+}
+
+func TestProduceIdempotent(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	ds := newKafkaDataStore()
+	ds.createTopic("topic-a").createPartition(0)
+
+	value := func(s string) protocol.Record { return protocol.Record{Value: protocol.NewBytes([]byte(s))} }
+
+	id, _, _ := ds.initProducer(tl, "", 0, kNoProducerId, kNoProducerEpoch)
+	if rpar := testProduceBatch(t, tl, ds, testProducerBatch(t, id, 0, 0, value("a"), value("b"))); rpar.ErrorCode != 0 || rpar.BaseOffset != 0 {
+		t.Fatalf("unexpected first produce %+v", rpar)
+	}
+	if rpar := testProduceBatch(t, tl, ds, testProducerBatch(t, id, 0, 2, value("c"))); rpar.ErrorCode != 0 || rpar.BaseOffset != 2 {
+		t.Fatalf("unexpected second produce %+v", rpar)
+	}
+
+	// a retry of the first batch isn't written again
+	if rpar := testProduceBatch(t, tl, ds, testProducerBatch(t, id, 0, 0, value("a"), value("b"))); rpar.ErrorCode != 0 || rpar.BaseOffset != 0 {
+		t.Errorf("unexpected retried produce %+v", rpar)
+	}
+
+	// a gap in the sequence means a batch was lost
+	if rpar := testProduceBatch(t, tl, ds, testProducerBatch(t, id, 0, 5, value("d"))); rpar.ErrorCode != int16(OutOfOrderSequenceNumber) {
+		t.Errorf("expected out of order sequence, got %+v", rpar)
+	}
+
+	// a producer that the partition doesn't know must start at sequence 0
+	if rpar := testProduceBatch(t, tl, ds, testProducerBatch(t, id+1, 0, 3, value("e"))); rpar.ErrorCode != int16(UnknownProducerId) {
+		t.Errorf("expected unknown producer id, got %+v", rpar)
+	}
+
+	// a new epoch starts the sequence over, and fences the old epoch
+	if rpar := testProduceBatch(t, tl, ds, testProducerBatch(t, id, 1, 0, value("f"))); rpar.ErrorCode != 0 || rpar.BaseOffset != 3 {
+		t.Errorf("unexpected produce with a new epoch %+v", rpar)
+	}
+	if rpar := testProduceBatch(t, tl, ds, testProducerBatch(t, id, 0, 3, value("g"))); rpar.ErrorCode != int16(InvalidProducerEpoch) {
+		t.Errorf("expected invalid producer epoch, got %+v", rpar)
+	}
+
+	// fetched batches keep the producer's sequence numbers
+	kp := ds.getTopic("topic-a").getPartition(0)
+	if len(kp.Records) != 4 {
+		t.Fatalf("expected 4 records, got %d", len(kp.Records))
+	}
+	fr := testHandlerRequest[*fetchResponse](t, tl, ds, fetch, ApiKeyFetch, 11, &fetchRequest{
+		ReplicaId: -1,
+		MaxBytes:  1000,
+		Topics:    []fetchTopic{{Topic: "topic-a", Partitions: []fetchPartition{{Partition: 0, PartitionMaxBytes: 1000}}}},
+	})
+	batches := fr.Responses[0].Partitions[0].Records.(*recordSetV2).batches
+	if len(batches) != 2 {
+		t.Fatalf("expected 2 batches, got %d", len(batches))
+	}
+	if h := batches[0].header; h.ProducerId != id || h.ProducerEpoch != 0 || h.BaseSequence != 0 || h.RecordCount != 3 {
+		t.Errorf("unexpected first batch %+v", h)
+	}
+	if h := batches[1].header; h.ProducerEpoch != 1 || h.BaseSequence != 0 || h.BaseOffset != 3 {
+		t.Errorf("unexpected second batch %+v", h)
+	}
+}
+
+func TestProduceBatchesAllOrNothing(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	ds := newKafkaDataStore()
+	ds.createTopic("topic-a").createPartition(0)
+
+	value := func(s string) protocol.Record { return protocol.Record{Value: protocol.NewBytes([]byte(s))} }
+
+	// the second batch of the request skips a sequence, so neither is written
+	id, _, _ := ds.initProducer(tl, "", 0, kNoProducerId, kNoProducerEpoch)
+	var records []byte
+	records = append(records, testProducerBatch(t, id, 0, 0, value("a"))...)
+	records = append(records, testProducerBatch(t, id, 0, 2, value("b"))...)
+	if rpar := testProduceBatch(t, tl, ds, records); rpar.ErrorCode != int16(OutOfOrderSequenceNumber) || rpar.BaseOffset != -1 {
+		t.Errorf("expected out of order sequence, got %+v", rpar)
+	}
+	kp := ds.getTopic("topic-a").getPartition(0)
+	if len(kp.Records) != 0 || kp.Producers[id] != nil {
+		t.Fatalf("failed request was partly written: %d records", len(kp.Records))
+	}
+
+	// batches of one request continue each other's sequences
+	records = append(testProducerBatch(t, id, 0, 0, value("a")), testProducerBatch(t, id, 0, 1, value("b"), value("c"))...)
+	if rpar := testProduceBatch(t, tl, ds, records); rpar.ErrorCode != 0 || rpar.BaseOffset != 0 {
+		t.Errorf("unexpected produce %+v", rpar)
+	}
+	if rpar := testProduceBatch(t, tl, ds, testProducerBatch(t, id, 0, 1, value("b"), value("c"))); rpar.ErrorCode != 0 || rpar.BaseOffset != 1 {
+		t.Errorf("unexpected retried produce %+v", rpar)
+	}
+	if len(kp.Records) != 3 {
+		t.Errorf("expected 3 records, got %d", len(kp.Records))
+	}
+}
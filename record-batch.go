@@ -0,0 +1,401 @@
+package kafkamock
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"reflect"
+
+	"github.com/klauspost/compress/snappy/xerial"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+type (
+	// the fixed part of a record batch (magic 2)
+	recordBatchV2 struct {
+		BaseOffset           int64
+		BatchLength          int32
+		PartitionLeaderEpoch int32
+		Magic                int8
+		Crc                  uint32
+		Attributes           int16
+		LastOffsetDelta      int32
+		BaseTimestamp        int64
+		MaxTimestamp         int64
+		ProducerId           int64
+		ProducerEpoch        int16
+		BaseSequence         int32
+		RecordCount          int32
+	}
+
+	decodedBatch struct {
+		header  recordBatchV2
+		records []*kafkaRecord
+	}
+)
+
+const (
+	kBatchCompressionMask    = 0x07
+	kBatchTimestampLogAppend = 0x08
+	kBatchTransactional      = 0x10
+	kBatchControl            = 0x20
+
+	kCompressionNone   = 0
+	kCompressionGzip   = 1
+	kCompressionSnappy = 2
+	kCompressionLz4    = 3
+	kCompressionZstd   = 4
+
+	kBatchLengthOffset = 8  // batch length follows the base offset
+	kBatchMagicOffset  = 16 // magic follows the partition leader epoch
+	kBatchCrcStart     = 21 // the crc covers everything after the crc field
+	kBatchHeaderSize   = 61
+)
+
+var errUnsupportedCompression = errors.New("unsupported compression type")
+
+// splits the records of a produce request into batches, validating each batch
+func decodeRecordBatches(data []byte) (batches []*decodedBatch, ec kafkaErrorCode) {
+	batches = []*decodedBatch{}
+
+	for len(data) > 0 {
+		if len(data) < kBatchHeaderSize {
+			ec = CorruptMessage
+			return
+		}
+
+		size := kBatchLengthOffset + 4 + int(int32(binary.BigEndian.Uint32(data[kBatchLengthOffset:])))
+		if size < kBatchHeaderSize || size > len(data) {
+			ec = CorruptMessage
+			return
+		}
+
+		if data[kBatchMagicOffset] != 2 {
+			ec = UnsupportedForMessageFormat
+			return
+		}
+
+		batch, err := decodeRecordBatch(data[:size])
+		if err != nil {
+			if errors.Is(err, errUnsupportedCompression) {
+				ec = UnsupportedCompressionType
+			} else {
+				ec = CorruptMessage
+			}
+			return
+		}
+
+		batches = append(batches, batch)
+		data = data[size:]
+	}
+
+	return
+}
+
+func decodeRecordBatch(data []byte) (batch *decodedBatch, err error) {
+	reader := bufio.NewReaderSize(bytes.NewReader(data), len(data))
+	next, obj := peekObject(reader, 0, reflect.TypeOf(recordBatchV2{}))
+	if next < 0 {
+		err = errors.New("record batch header truncated")
+		return
+	}
+	header := obj.(recordBatchV2)
+
+	if crc32.Checksum(data[kBatchCrcStart:], crcTable) != header.Crc {
+		err = errors.New("record batch crc mismatch")
+		return
+	}
+
+	payload, err := decompressRecords(int(header.Attributes&kBatchCompressionMask), data[kBatchHeaderSize:])
+	if err != nil {
+		return
+	}
+
+	batch = &decodedBatch{
+		header:  header,
+		records: make([]*kafkaRecord, 0, header.RecordCount),
+	}
+
+	reader = bufio.NewReaderSize(bytes.NewReader(payload), len(payload)+16)
+	next = 0
+	for n := 0; n < int(header.RecordCount); n++ {
+		var record *kafkaRecord
+		next, record = peekRecordV2(reader, next, &header)
+		if next < 0 {
+			err = errors.New("record truncated")
+			return
+		}
+		batch.records = append(batch.records, record)
+	}
+
+	return
+}
+
+func peekRecordV2(reader *bufio.Reader, offset int, header *recordBatchV2) (next int, record *kafkaRecord) {
+	next, length := peekVarInt(reader, offset)
+	if next < 0 {
+		return
+	}
+	end := next + int(length)
+
+	next, attribs := peekInt8(reader, next)
+	if next < 0 {
+		return
+	}
+	next, tsDelta := peekVarInt64(reader, next)
+	if next < 0 {
+		return
+	}
+	next, offsetDelta := peekVarInt(reader, next) // the records are contiguous, so only the sequence uses it
+	if next < 0 {
+		return
+	}
+	next, key := peekVarBytes(reader, next)
+	if next < 0 {
+		return
+	}
+	next, value := peekVarBytes(reader, next)
+	if next < 0 {
+		return
+	}
+	next, headerCount := peekVarInt(reader, next)
+	if next < 0 {
+		return
+	}
+
+	headers := make([]kafkaRecordHeader, 0, headerCount)
+	for i := 0; i < int(headerCount); i++ {
+		var hk, hv []byte
+		next, hk = peekVarBytes(reader, next)
+		if next < 0 {
+			return
+		}
+		next, hv = peekVarBytes(reader, next)
+		if next < 0 {
+			return
+		}
+		headers = append(headers, kafkaRecordHeader{HeaderKey: string(hk), HeaderValue: hv})
+	}
+
+	if next != end {
+		next = -1
+		return
+	}
+
+	record = &kafkaRecord{
+		Attributes:      attribs,
+		Timestamp:       header.BaseTimestamp + int64(tsDelta),
+		Key:             key,
+		Value:           value,
+		Headers:         headers,
+		ProducerId:      header.ProducerId,
+		ProducerEpoch:   header.ProducerEpoch,
+		Sequence:        kNoSequence,
+		BatchAttributes: header.Attributes & (kBatchTransactional | kBatchControl),
+	}
+	if header.BaseSequence != kNoSequence {
+		record.Sequence = addSequence(header.BaseSequence, int32(offsetDelta))
+	}
+	return
+}
+
+// reads the varint-length bytes used within records; the data is copied
+// because records outlive the request buffer
+func peekVarBytes(reader *bufio.Reader, offset int) (next int, data []byte) {
+	next, length := peekVarInt(reader, offset)
+	if next < 0 || length < 0 {
+		return
+	}
+
+	next, inbound := peekFixedData(reader, next, int(length))
+	if next < 0 {
+		return
+	}
+
+	data = make([]byte, length)
+	copy(data, inbound)
+	return
+}
+
+func decompressRecords(codec int, data []byte) (payload []byte, err error) {
+	switch codec {
+	case kCompressionNone:
+		return data, nil
+
+	case kCompressionGzip:
+		var zr *gzip.Reader
+		if zr, err = gzip.NewReader(bytes.NewReader(data)); err != nil {
+			return
+		}
+		defer zr.Close()
+		return io.ReadAll(zr)
+
+	case kCompressionSnappy:
+		return xerial.Decode(data)
+
+	case kCompressionLz4:
+		return io.ReadAll(lz4.NewReader(bytes.NewReader(data)))
+
+	case kCompressionZstd:
+		var zr *zstd.Decoder
+		if zr, err = zstd.NewReader(bytes.NewReader(data)); err != nil {
+			return
+		}
+		defer zr.Close()
+		return io.ReadAll(zr)
+
+	default:
+		err = errUnsupportedCompression
+		return
+	}
+}
+
+type (
+	// record batches (magic 2) that are built up for a fetch response
+	recordSetV2 struct {
+		batches   []*recordBatchBuilder
+		offset    int64
+		totalSize int
+	}
+
+	recordBatchBuilder struct {
+		header  recordBatchV2
+		records bytes.Buffer
+	}
+)
+
+func newRecordSetV2(offset int64) *recordSetV2 {
+	return &recordSetV2{
+		offset:  offset,
+		batches: []*recordBatchBuilder{},
+	}
+}
+
+func (rs *recordSetV2) size() int {
+	return rs.totalSize
+}
+
+// adds the record at an offset past the set's last record, which leaves
+// a gap in the batch when records between them were compacted away; the
+// first record is always accepted, even if it exceeds maxSize, so that
+// consumers can make progress
+func (rs *recordSetV2) appendRecord(record *kafkaRecord, offset int64, maxSize int) bool {
+	var batch *recordBatchBuilder
+	growth := 0
+	if len(rs.batches) > 0 {
+		batch = rs.batches[len(rs.batches)-1]
+	}
+	isNew := batch == nil || !batch.accepts(record, offset)
+	if isNew {
+		batch = &recordBatchBuilder{
+			header: recordBatchV2{
+				BaseOffset:           offset,
+				PartitionLeaderEpoch: record.LeaderEpoch,
+				Magic:                2,
+				BaseTimestamp:        record.Timestamp,
+				MaxTimestamp:         record.Timestamp,
+				Attributes:           record.BatchAttributes,
+				ProducerId:           record.ProducerId,
+				ProducerEpoch:        record.ProducerEpoch,
+				BaseSequence:         record.Sequence,
+			},
+		}
+		growth = kBatchHeaderSize
+	}
+
+	encoded := encodeRecordV2(record, offset-batch.header.BaseOffset, record.Timestamp-batch.header.BaseTimestamp)
+	growth += len(encoded)
+
+	if rs.totalSize > 0 && rs.totalSize+growth > maxSize {
+		return false
+	}
+
+	if isNew {
+		rs.batches = append(rs.batches, batch)
+	}
+
+	batch.records.Write(encoded)
+	batch.header.LastOffsetDelta = int32(offset - batch.header.BaseOffset)
+	batch.header.RecordCount++
+	if record.Timestamp > batch.header.MaxTimestamp {
+		batch.header.MaxTimestamp = record.Timestamp
+	}
+
+	rs.totalSize += growth
+	rs.offset = offset + 1
+	return true
+}
+
+// whether a record can join the batch; the records of an idempotent producer
+// are batched by producer, with sequence numbers that advance with the offsets,
+// a batch doesn't span leader epochs, and a transaction marker is always in a
+// batch of its own
+func (bb *recordBatchBuilder) accepts(record *kafkaRecord, offset int64) bool {
+	if record.ProducerId != bb.header.ProducerId || record.ProducerEpoch != bb.header.ProducerEpoch {
+		return false
+	}
+	if record.BatchAttributes != bb.header.Attributes || record.isControl() {
+		return false
+	}
+	if record.LeaderEpoch != bb.header.PartitionLeaderEpoch {
+		return false
+	}
+	return record.ProducerId == kNoProducerId || record.Sequence == addSequence(bb.header.BaseSequence, int32(offset-bb.header.BaseOffset))
+}
+
+func encodeRecordV2(record *kafkaRecord, offsetDelta, timestampDelta int64) []byte {
+	var body bytes.Buffer
+	body.WriteByte(byte(record.Attributes))
+	body.Write(makeZigzag64(VarInt64(timestampDelta)))
+	body.Write(makeZigzag32(VarInt(offsetDelta)))
+	appendVarBytes(&body, record.Key)
+	appendVarBytes(&body, record.Value)
+	body.Write(makeZigzag32(VarInt(len(record.Headers))))
+	for _, header := range record.Headers {
+		appendVarBytes(&body, []byte(header.HeaderKey))
+		appendVarBytes(&body, header.HeaderValue)
+	}
+
+	encoded := makeZigzag32(VarInt(body.Len()))
+	return append(encoded, body.Bytes()...)
+}
+
+func appendVarBytes(buf *bytes.Buffer, data []byte) {
+	if data == nil {
+		buf.Write(makeZigzag32(-1))
+	} else {
+		buf.Write(makeZigzag32(VarInt(len(data))))
+		buf.Write(data)
+	}
+}
+
+func (bb *recordBatchBuilder) bytes() []byte {
+	bb.header.BatchLength = int32(kBatchHeaderSize - kBatchLengthOffset - 4 + bb.records.Len())
+
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	encodeObject(w, bb.header)
+	w.Write(bb.records.Bytes())
+	w.Flush()
+
+	data := buf.Bytes()
+	binary.BigEndian.PutUint32(data[kBatchCrcStart-4:], crc32.Checksum(data[kBatchCrcStart:], crcTable))
+	return data
+}
+
+func encodeRecordSetV2(writer *bufio.Writer, rs *recordSetV2, cv codecVersion) {
+	if cv.flexible {
+		encodeVarUint(writer, VarUint(rs.totalSize+1))
+	} else {
+		encodeInt32(writer, int32(rs.totalSize))
+	}
+
+	for _, batch := range rs.batches {
+		writer.Write(batch.bytes())
+	}
+}
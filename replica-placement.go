@@ -0,0 +1,208 @@
+package kafkamock
+
+import (
+	"fmt"
+	"hash/fnv"
+	"slices"
+)
+
+const (
+	// the node id of a standalone mock, and of the first broker of a cluster
+	kFirstBrokerId = 100
+
+	// the leader of a partition that has no running replica
+	kNoLeader = -1
+)
+
+// returns a snapshot of the brokers of the cluster, ordered by node id
+func (ds *kafkaDataStore) sortedBrokers() []kafkaBroker {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	brokers := make([]kafkaBroker, 0, len(ds.Brokers))
+	for _, broker := range ds.Brokers {
+		brokers = append(brokers, *broker)
+	}
+	return brokers
+}
+
+// returns a snapshot of the brokers that aren't stopped, ordered by node id
+func (ds *kafkaDataStore) liveBrokers() []kafkaBroker {
+	brokers := ds.sortedBrokers()
+	live := make([]kafkaBroker, 0, len(brokers))
+	for _, broker := range brokers {
+		if !broker.Stopped {
+			live = append(live, broker)
+		}
+	}
+	return live
+}
+
+func (ds *kafkaDataStore) brokerIds() []int32 {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	ids := make([]int32, 0, len(ds.Brokers))
+	for _, broker := range ds.Brokers {
+		ids = append(ids, broker.NodeId)
+	}
+	return ids
+}
+
+func (ds *kafkaDataStore) liveBrokerIds() []int32 {
+	brokers := ds.liveBrokers()
+	ids := make([]int32, 0, len(brokers))
+	for _, broker := range brokers {
+		ids = append(ids, broker.NodeId)
+	}
+	return ids
+}
+
+func (ds *kafkaDataStore) getBroker(nodeId int32) *kafkaBroker {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	for _, broker := range ds.Brokers {
+		if broker.NodeId == nodeId {
+			return broker
+		}
+	}
+	return nil
+}
+
+func (ds *kafkaDataStore) setBrokerStopped(nodeId int32, stopped bool) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	for _, broker := range ds.Brokers {
+		if broker.NodeId == nodeId {
+			broker.Stopped = stopped
+		}
+	}
+}
+
+// the first running broker is the controller of the cluster
+func (ds *kafkaDataStore) controllerId() int32 {
+	if live := ds.liveBrokerIds(); len(live) > 0 {
+		return live[0]
+	}
+	return ds.brokerIds()[0]
+}
+
+// the broker that coordinates a group or transactional id, which is
+// chosen by the key's hash among the running brokers, as kafka chooses
+// an internal topic partition
+func (ds *kafkaDataStore) coordinator(key string) kafkaBroker {
+	brokers := ds.liveBrokers()
+	if len(brokers) == 0 {
+		brokers = ds.sortedBrokers()
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return brokers[h.Sum32()%uint32(len(brokers))]
+}
+
+// returns the reason a replica assignment is invalid, or an empty string if it is valid
+func (ds *kafkaDataStore) validateReplicaAssignment(brokerIds []int32) string {
+	ids := ds.brokerIds()
+	for n, id := range brokerIds {
+		if !slices.Contains(ids, id) {
+			return fmt.Sprintf("unknown broker %d in replica assignment", id)
+		}
+		if slices.Contains(brokerIds[:n], id) {
+			return fmt.Sprintf("duplicate broker %d in replica assignment", id)
+		}
+	}
+	return ""
+}
+
+// returns the leader, leader epoch and replicas of a partition, placing the
+// partition on the brokers when it hasn't been yet; partition n is led by the
+// n-th broker, and its other replicas are on the brokers that follow
+func (ds *kafkaDataStore) partitionReplicas(kt *kafkaTopic, kp *kafkaPartition) (leader, leaderEpoch int32, replicas []int32) {
+	ids := ds.brokerIds()
+	live := ds.liveBrokerIds()
+
+	kt.mu.Lock()
+	replicationFactor := int(kt.ReplicationFactor)
+	kt.mu.Unlock()
+
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+
+	if kp.Replicas == nil {
+		replicationFactor = max(1, min(replicationFactor, len(ids)))
+		kp.Replicas = make([]int32, 0, replicationFactor)
+		for n := 0; n < replicationFactor; n++ {
+			kp.Replicas = append(kp.Replicas, ids[(int(kp.Index)+n)%len(ids)])
+		}
+		kp.Leader = kp.Replicas[0]
+	}
+	kp.electLeader(live)
+	return kp.Leader, kp.LeaderEpoch, slices.Clone(kp.Replicas)
+}
+
+// elects the first running replica when the leader isn't running, which
+// starts a new leader epoch; the caller holds the partition's lock
+func (kp *kafkaPartition) electLeader(live []int32) {
+	if kp.Leader != kNoLeader && slices.Contains(live, kp.Leader) {
+		return
+	}
+
+	leader := int32(kNoLeader)
+	for _, id := range kp.Replicas {
+		if slices.Contains(live, id) {
+			leader = id
+			break
+		}
+	}
+	if leader != kp.Leader {
+		kp.Leader = leader
+		kp.newLeaderEpoch()
+	}
+}
+
+// elects new leaders for the partitions whose leaders stopped
+func (ds *kafkaDataStore) electLeaders() {
+	for _, kt := range ds.sortedTopics() {
+		for _, kp := range kt.sortedPartitions() {
+			ds.partitionReplicas(kt, kp)
+		}
+	}
+}
+
+// makes a running broker the leader of a partition, starting a new leader
+// epoch; a broker without a replica takes over the replica of the old leader
+func (ds *kafkaDataStore) moveLeader(kt *kafkaTopic, kp *kafkaPartition, nodeId int32) (previous int32, err error) {
+	broker := ds.getBroker(nodeId)
+	if broker == nil {
+		return kNoLeader, fmt.Errorf("unknown broker %d", nodeId)
+	}
+	if !slices.Contains(ds.liveBrokerIds(), nodeId) {
+		return kNoLeader, fmt.Errorf("broker %d is stopped", nodeId)
+	}
+
+	previous, _, _ = ds.partitionReplicas(kt, kp)
+
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+
+	if !slices.Contains(kp.Replicas, nodeId) {
+		n := max(0, slices.Index(kp.Replicas, kp.Leader))
+		kp.Replicas[n] = nodeId
+	}
+	if kp.Leader != nodeId {
+		kp.Leader = nodeId
+		kp.newLeaderEpoch()
+	}
+	return
+}
+
+// the node id of the broker the client is connected to; clients that
+// tests make without a listener belong to the first broker
+func (kc *kafkaClient) nodeId() int32 {
+	if kc.broker == nil {
+		return kFirstBrokerId
+	}
+	return kc.broker.NodeId
+}
@@ -0,0 +1,186 @@
+package kafkamock
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+type (
+	// the progress of a client's SASL authentication
+	kafkaSaslState int
+
+	// the server side of one SASL mechanism's authentication exchange
+	kafkaSaslExchange interface {
+		// processes the client's auth bytes, returning the reply to send back
+		// and, once the client has proven its identity, its principal
+		next(authBytes []byte) (reply []byte, principal string, err error)
+	}
+
+	// an exchange whose credential expires, which ends the client's session
+	// unless it authenticates again
+	kafkaSaslExpiringExchange interface {
+		sessionExpiry() time.Time
+	}
+
+	// a user that clients can authenticate as
+	kafkaUser struct {
+		password string                         // the SASL/PLAIN password
+		scram    map[int8]*kafkaScramCredential // by SCRAM mechanism type
+	}
+)
+
+const (
+	saslInitial       kafkaSaslState = iota // the client must handshake before anything else
+	saslHandshaken                          // a mechanism was chosen, and SaslAuthenticate is expected
+	saslAuthenticated                       // the client may use every api
+	saslFailed                              // the client failed to authenticate, and gets nothing more
+)
+
+const (
+	saslPlain = "PLAIN"
+
+	kAnonymousPrincipal = "User:ANONYMOUS"
+)
+
+// makes the server side of an exchange for each mechanism the mock supports
+var saslExchanges = map[string]func(ds *kafkaDataStore) kafkaSaslExchange{
+	saslPlain:       func(ds *kafkaDataStore) kafkaSaslExchange { return &kafkaPlainExchange{ds: ds} },
+	saslScramSha256: func(ds *kafkaDataStore) kafkaSaslExchange { return newScramExchange(ds, saslScramSha256) },
+	saslScramSha512: func(ds *kafkaDataStore) kafkaSaslExchange { return newScramExchange(ds, saslScramSha512) },
+	saslOAuthBearer: newOAuthBearerExchange,
+}
+
+// whether the client may make a request of the api in its authentication
+// state; a listener without SASL mechanisms doesn't require authentication,
+// and a client whose session expired may only authenticate again (KIP-368)
+func (kc *kafkaClient) saslAllows(apiKey kafkaApiKey) bool {
+	if len(kc.saslMechanisms) == 0 {
+		return true
+	}
+
+	switch kc.saslState {
+	case saslInitial:
+		return apiKey == ApiKeyApiVersions || apiKey == ApiKeySaslHandshake
+	case saslHandshaken:
+		return apiKey == ApiKeySaslAuthenticate
+	case saslAuthenticated:
+		if kc.sessionExpired() {
+			return apiKey == ApiKeySaslHandshake
+		}
+		return apiKey != ApiKeySaslAuthenticate
+	}
+	return false
+}
+
+// chooses the mechanism the client will authenticate with; a client with a
+// session lifetime starts its re-authentication with the same mechanism
+func (kc *kafkaClient) saslHandshake(mechanism string) kafkaErrorCode {
+	reauthenticating := kc.saslState == saslAuthenticated && !kc.sessionExpiry.IsZero()
+	if len(kc.saslMechanisms) == 0 || (kc.saslState != saslInitial && !reauthenticating) {
+		return IllegalSaslState
+	}
+	if !slices.Contains(kc.saslMechanisms, mechanism) {
+		return UnsupportedSaslMechanism
+	}
+	if reauthenticating && mechanism != kc.saslMechanism {
+		return IllegalSaslState
+	}
+
+	kc.saslMechanism = mechanism
+	kc.saslExchange = saslExchanges[mechanism](kc.ds)
+	kc.saslState = saslHandshaken
+	return NoError
+}
+
+// takes the next step of the client's authentication exchange; a failure
+// ends the exchange, and the client can't make any more requests
+func (kc *kafkaClient) saslAuthenticate(authBytes []byte) (reply []byte, ec kafkaErrorCode, message string) {
+	if kc.saslState != saslHandshaken {
+		return nil, IllegalSaslState, "SaslAuthenticate request received without a SaslHandshake"
+	}
+
+	reply, principal, err := kc.saslExchange.next(authBytes)
+	if err != nil {
+		kc.saslState = saslFailed
+		kc.saslExchange = nil
+		return nil, SaslAuthenticationFailed, err.Error()
+	}
+
+	if principal != "" {
+		// a session that authenticates again can't change its principal
+		if !kc.sessionExpiry.IsZero() && principal != kc.principal {
+			kc.saslState = saslFailed
+			kc.saslExchange = nil
+			return nil, SaslAuthenticationFailed, "Cannot change principals during re-authentication"
+		}
+
+		kc.sessionExpiry = time.Time{}
+		if ee, expiring := kc.saslExchange.(kafkaSaslExpiringExchange); expiring {
+			kc.sessionExpiry = ee.sessionExpiry()
+		}
+
+		kc.l.Tracef("kafka %d authenticated as %s", kc.clientPort, principal)
+		kc.principal = principal
+		kc.saslState = saslAuthenticated
+		kc.saslExchange = nil
+	}
+	return reply, NoError, ""
+}
+
+// the time left until the client must authenticate again, or 0 when it
+// doesn't need to
+func (kc *kafkaClient) sessionLifetime() time.Duration {
+	if kc.sessionExpiry.IsZero() {
+		return 0
+	}
+	return max(time.Until(kc.sessionExpiry), time.Millisecond)
+}
+
+func (kc *kafkaClient) sessionExpired() bool {
+	return !kc.sessionExpiry.IsZero() && !time.Now().Before(kc.sessionExpiry)
+}
+
+// adds users that can authenticate with SASL/PLAIN, or changes their passwords
+func (ds *kafkaDataStore) setPlainUsers(users map[string]string) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	for name, password := range users {
+		ds.userOf(name).password = password
+	}
+}
+
+func (ds *kafkaDataStore) checkPlainPassword(name, password string) bool {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	user := ds.Users[name]
+	return user != nil && user.password != "" && user.password == password
+}
+
+// SASL/PLAIN sends the authorization id, the user name and the password in
+// a single message, separated by NULs
+type kafkaPlainExchange struct {
+	ds *kafkaDataStore
+}
+
+func (pe *kafkaPlainExchange) next(authBytes []byte) (reply []byte, principal string, err error) {
+	tokens := strings.Split(string(authBytes), "\x00")
+	if len(tokens) != 3 {
+		return nil, "", errors.New("Invalid SASL/PLAIN response: expected 3 tokens")
+	}
+
+	authorizationId, name, password := tokens[0], tokens[1], tokens[2]
+	if name == "" {
+		return nil, "", errors.New("Authentication failed: username not specified")
+	}
+	if authorizationId != "" && authorizationId != name {
+		return nil, "", errors.New("Authentication failed: Client requested an authorization id that is different from username")
+	}
+	if !pe.ds.checkPlainPassword(name, password) {
+		return nil, "", errors.New("Authentication failed: Invalid username or password")
+	}
+	return []byte{}, "User:" + name, nil
+}
@@ -0,0 +1,92 @@
+package kafkamock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jimsnab/go-lane"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+)
+
+// makes an admin client that authenticates with a SASL mechanism
+func testSaslKafkaClient(serverPort uint, mechanism sasl.Mechanism) *kafka.Client {
+	return &kafka.Client{
+		Addr:      kafka.TCP(fmt.Sprintf("localhost:%d", serverPort)),
+		Transport: &kafka.Transport{SASL: mechanism},
+		Timeout:   5 * time.Second,
+	}
+}
+
+func TestKafkaSaslPlain(t *testing.T) {
+	tl, mock := testCreateKafkaMockServer(t, 21001, []string{"topic-a"})
+	defer testStopMockServer(t, mock)
+
+	// the listener requires authentication of the clients that connect after a restart
+	mock.SetSaslPlainUsers(map[string]string{"alice": "alice-secret"})
+	mock.Restart()
+
+	client := testSaslKafkaClient(21001, plain.Mechanism{Username: "alice", Password: "alice-secret"})
+	resp, err := client.Metadata(tl, &kafka.MetadataRequest{Topics: []string{"topic-a"}})
+	if err != nil || len(resp.Topics) != 1 {
+		t.Fatalf("metadata error: %v %+v", err, resp)
+	}
+
+	client = testSaslKafkaClient(21001, plain.Mechanism{Username: "alice", Password: "wrong"})
+	if _, err = client.Metadata(tl, &kafka.MetadataRequest{Topics: []string{"topic-a"}}); !errors.Is(err, kafka.SASLAuthenticationFailed) {
+		t.Errorf("expected sasl authentication failed, got %v", err)
+	}
+
+	// a client that doesn't authenticate is dropped
+	ctx, cancel := context.WithTimeout(tl, 5*time.Second)
+	defer cancel()
+	if _, err = testKafkaClient(21001).Metadata(ctx, &kafka.MetadataRequest{Topics: []string{"topic-a"}}); err == nil {
+		t.Error("expected an unauthenticated client to fail")
+	}
+}
+
+func TestSaslStateMachine(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	kc := &kafkaClient{l: tl, ds: newKafkaDataStore(), saslMechanisms: []string{saslPlain}, principal: kAnonymousPrincipal}
+	kc.ds.setPlainUsers(map[string]string{"bob": "bob-secret"})
+
+	if !kc.saslAllows(ApiKeyApiVersions) || !kc.saslAllows(ApiKeySaslHandshake) || kc.saslAllows(ApiKeyMetadata) || kc.saslAllows(ApiKeySaslAuthenticate) {
+		t.Error("unexpected apis allowed before the handshake")
+	}
+	if _, ec, _ := kc.saslAuthenticate([]byte("\x00bob\x00bob-secret")); ec != IllegalSaslState {
+		t.Errorf("expected illegal sasl state, got %d", ec)
+	}
+	if ec := kc.saslHandshake("GSSAPI"); ec != UnsupportedSaslMechanism {
+		t.Errorf("expected unsupported sasl mechanism, got %d", ec)
+	}
+	if ec := kc.saslHandshake(saslPlain); ec != NoError {
+		t.Fatalf("handshake error: %d", ec)
+	}
+	if kc.saslAllows(ApiKeyApiVersions) || !kc.saslAllows(ApiKeySaslAuthenticate) {
+		t.Error("unexpected apis allowed after the handshake")
+	}
+
+	// the authorization id can't name another user
+	kc2 := &kafkaClient{l: tl, ds: kc.ds, saslMechanisms: []string{saslPlain}}
+	kc2.saslHandshake(saslPlain)
+	if _, ec, message := kc2.saslAuthenticate([]byte("alice\x00bob\x00bob-secret")); ec != SaslAuthenticationFailed || message == "" {
+		t.Errorf("expected sasl authentication failed, got %d %s", ec, message)
+	}
+	if kc2.saslAllows(ApiKeyApiVersions) || kc2.saslAllows(ApiKeySaslHandshake) {
+		t.Error("a client that failed to authenticate is allowed more requests")
+	}
+
+	if _, ec, message := kc.saslAuthenticate([]byte("bob\x00bob\x00bob-secret")); ec != NoError {
+		t.Fatalf("authenticate error: %d %s", ec, message)
+	}
+	if kc.principal != "User:bob" || !kc.saslAllows(ApiKeyMetadata) {
+		t.Errorf("unexpected authenticated client %s", kc.principal)
+	}
+	if ec := kc.saslHandshake(saslPlain); ec != IllegalSaslState {
+		t.Errorf("expected illegal sasl state, got %d", ec)
+	}
+}
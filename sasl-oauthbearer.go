@@ -0,0 +1,295 @@
+package kafkamock
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// validates the JWTs that OAUTHBEARER clients present, either unsecured
+	// ones or ones signed by a key the mock was given
+	kafkaOAuthBearerValidator struct {
+		mu             sync.Mutex
+		allowUnsecured bool
+		keys           []kafkaJwtKey
+	}
+
+	kafkaJwtKey struct {
+		kid string // matches the token header's kid, or any token when empty
+		key crypto.PublicKey
+	}
+
+	kafkaJwtHeader struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+
+	kafkaJwtClaims struct {
+		Sub string   `json:"sub"`
+		Exp *float64 `json:"exp"`
+		Nbf *float64 `json:"nbf"`
+	}
+
+	kafkaJwks struct {
+		Keys []kafkaJwk `json:"keys"`
+	}
+
+	kafkaJwk struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		Crv string `json:"crv"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}
+
+	// the server side of an OAUTHBEARER exchange; a rejected token gets an
+	// error status that the client acknowledges before the exchange fails
+	kafkaOAuthBearerExchange struct {
+		validator *kafkaOAuthBearerValidator
+		failure   error
+		expiry    time.Time
+	}
+)
+
+const saslOAuthBearer = "OAUTHBEARER"
+
+func newKafkaOAuthBearerValidator() *kafkaOAuthBearerValidator {
+	return &kafkaOAuthBearerValidator{keys: []kafkaJwtKey{}}
+}
+
+func (v *kafkaOAuthBearerValidator) allowUnsecuredTokens() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.allowUnsecured = true
+}
+
+func (v *kafkaOAuthBearerValidator) addKey(kid string, key crypto.PublicKey) error {
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+	default:
+		return fmt.Errorf("unsupported public key type %T", key)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.keys = append(v.keys, kafkaJwtKey{kid: kid, key: key})
+	return nil
+}
+
+// adds the RSA and EC signing keys of a JSON web key set
+func (v *kafkaOAuthBearerValidator) addJwks(jwks []byte) error {
+	var set kafkaJwks
+	if err := json.Unmarshal(jwks, &set); err != nil {
+		return fmt.Errorf("invalid JWKS: %v", err)
+	}
+
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			return err
+		}
+		if err = v.addKey(jwk.Kid, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (jwk *kafkaJwk) publicKey() (crypto.PublicKey, error) {
+	decode := func(s string) *big.Int {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil || len(b) == 0 {
+			return nil
+		}
+		return new(big.Int).SetBytes(b)
+	}
+
+	switch jwk.Kty {
+	case "RSA":
+		n, e := decode(jwk.N), decode(jwk.E)
+		if n == nil || e == nil || !e.IsInt64() {
+			return nil, fmt.Errorf("invalid RSA key %s", jwk.Kid)
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, x, y := curves[jwk.Crv], decode(jwk.X), decode(jwk.Y)
+		if curve == nil || x == nil || y == nil {
+			return nil, fmt.Errorf("invalid EC key %s", jwk.Kid)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s of key %s", jwk.Kty, jwk.Kid)
+}
+
+// checks the token's signature, expiry and subject, returning the subject
+// and when the token expires
+func (v *kafkaOAuthBearerValidator) validate(token string) (subject string, expiry time.Time, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", time.Time{}, errors.New("malformed token")
+	}
+
+	var header kafkaJwtHeader
+	if err = decodeJwtPart(parts[0], &header); err != nil {
+		return "", time.Time{}, fmt.Errorf("malformed token header: %v", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", time.Time{}, errors.New("malformed token signature")
+	}
+	if err = v.verifySignature(header, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return "", time.Time{}, err
+	}
+
+	var claims kafkaJwtClaims
+	if err = decodeJwtPart(parts[1], &claims); err != nil {
+		return "", time.Time{}, fmt.Errorf("malformed token claims: %v", err)
+	}
+
+	now := time.Now()
+	if claims.Exp == nil {
+		return "", time.Time{}, errors.New("the token has no expiration time")
+	}
+	expiry = time.UnixMilli(int64(*claims.Exp * 1000))
+	if !now.Before(expiry) {
+		return "", time.Time{}, fmt.Errorf("the token expired at %s", expiry.UTC().Format(time.RFC3339))
+	}
+	if claims.Nbf != nil && now.Before(time.UnixMilli(int64(*claims.Nbf*1000))) {
+		return "", time.Time{}, errors.New("the token is not valid yet")
+	}
+	if claims.Sub == "" {
+		return "", time.Time{}, errors.New("the token has no subject")
+	}
+	return claims.Sub, expiry, nil
+}
+
+func decodeJwtPart(part string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// the signing algorithms that tokens can use, and their hashes
+var oauthBearerAlgHashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"PS256": crypto.SHA256, "PS384": crypto.SHA384, "PS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+}
+
+func (v *kafkaOAuthBearerValidator) verifySignature(header kafkaJwtHeader, signed, signature []byte) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if header.Alg == "none" {
+		if !v.allowUnsecured || len(signature) != 0 {
+			return errors.New("unsecured tokens are not accepted")
+		}
+		return nil
+	}
+
+	hash, supported := oauthBearerAlgHashes[header.Alg]
+	if !supported {
+		return fmt.Errorf("unsupported token algorithm %s", header.Alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	for _, jk := range v.keys {
+		if jk.kid != "" && header.Kid != "" && jk.kid != header.Kid {
+			continue
+		}
+
+		switch key := jk.key.(type) {
+		case *rsa.PublicKey:
+			if strings.HasPrefix(header.Alg, "RS") && rsa.VerifyPKCS1v15(key, hash, digest, signature) == nil {
+				return nil
+			}
+			if strings.HasPrefix(header.Alg, "PS") && rsa.VerifyPSS(key, hash, digest, signature, nil) == nil {
+				return nil
+			}
+		case *ecdsa.PublicKey:
+			// the signature is r and s, each the size of the curve
+			size := (key.Curve.Params().BitSize + 7) / 8
+			if strings.HasPrefix(header.Alg, "ES") && len(signature) == 2*size {
+				r := new(big.Int).SetBytes(signature[:size])
+				s := new(big.Int).SetBytes(signature[size:])
+				if ecdsa.Verify(key, digest, r, s) {
+					return nil
+				}
+			}
+		}
+	}
+	return errors.New("the token signature is invalid")
+}
+
+func newOAuthBearerExchange(ds *kafkaDataStore) kafkaSaslExchange {
+	return &kafkaOAuthBearerExchange{validator: ds.OAuthBearer}
+}
+
+// handles "n,[a=authzid],^Aauth=Bearer token^A[key=value^A]*^A", where ^A
+// is the 0x01 separator
+func (oe *kafkaOAuthBearerExchange) next(authBytes []byte) (reply []byte, principal string, err error) {
+	message := string(authBytes)
+	if oe.failure != nil {
+		// the client acknowledged the error status
+		return nil, "", oe.failure
+	}
+
+	parts := strings.SplitN(message, ",", 3)
+	if len(parts) != 3 || parts[0] != "n" || !strings.HasPrefix(parts[2], "\x01") || !strings.HasSuffix(parts[2], "\x01\x01") {
+		return nil, "", errors.New("Authentication failed: Invalid OAUTHBEARER client first message")
+	}
+
+	token := ""
+	for _, kv := range strings.Split(strings.Trim(parts[2], "\x01"), "\x01") {
+		if key, value, found := strings.Cut(kv, "="); found && key == "auth" {
+			scheme, credential, _ := strings.Cut(value, " ")
+			if strings.EqualFold(scheme, "bearer") {
+				token = strings.TrimSpace(credential)
+			}
+		}
+	}
+	if token == "" {
+		return nil, "", errors.New("Authentication failed: Invalid OAUTHBEARER client first message")
+	}
+
+	subject, expiry, err := oe.validator.validate(token)
+	if err == nil && parts[1] != "" && strings.TrimPrefix(parts[1], "a=") != subject {
+		err = errors.New("the authorization id is different from the token's subject")
+	}
+	if err != nil {
+		oe.failure = fmt.Errorf("Authentication failed: %v", err)
+		return []byte(`{"status":"invalid_token"}`), "", nil
+	}
+
+	oe.expiry = expiry
+	return []byte{}, "User:" + subject, nil
+}
+
+// the client must authenticate again before its token expires
+func (oe *kafkaOAuthBearerExchange) sessionExpiry() time.Time {
+	return oe.expiry
+}
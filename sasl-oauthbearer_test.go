@@ -0,0 +1,204 @@
+package kafkamock
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jimsnab/go-lane"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+)
+
+type (
+	// the client side of an OAUTHBEARER exchange
+	testOAuthBearerMechanism struct {
+		token string
+	}
+
+	testOAuthBearerSession struct{}
+)
+
+func (m testOAuthBearerMechanism) Name() string {
+	return saslOAuthBearer
+}
+
+func (m testOAuthBearerMechanism) Start(ctx context.Context) (sasl.StateMachine, []byte, error) {
+	return testOAuthBearerSession{}, []byte("n,,\x01auth=Bearer " + m.token + "\x01\x01"), nil
+}
+
+func (session testOAuthBearerSession) Next(ctx context.Context, challenge []byte) (done bool, response []byte, err error) {
+	if len(challenge) != 0 {
+		// acknowledges the error status, so that the server fails the exchange
+		return false, []byte("\x01"), nil
+	}
+	return true, nil, nil
+}
+
+// makes a JWT for the subject that expires after the lifetime, signed by the
+// key with the algorithm, or unsecured when the key is nil
+func testJwt(t *testing.T, alg, kid string, key crypto.Signer, subject string, lifetime time.Duration) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]any{"sub": subject, "iat": time.Now().Unix(), "exp": float64(time.Now().Add(lifetime).UnixMilli()) / 1000})
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	var signature []byte
+	if key != nil {
+		hash := map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}[alg[2:]]
+		h := hash.New()
+		h.Write([]byte(signed))
+
+		var err error
+		switch k := key.(type) {
+		case *rsa.PrivateKey:
+			signature, err = rsa.SignPKCS1v15(rand.Reader, k, hash, h.Sum(nil))
+		case *ecdsa.PrivateKey:
+			var r, s *big.Int
+			r, s, err = ecdsa.Sign(rand.Reader, k, h.Sum(nil))
+			size := (k.Curve.Params().BitSize + 7) / 8
+			signature = append(r.FillBytes(make([]byte, size)), s.FillBytes(make([]byte, size))...)
+		}
+		if err != nil {
+			t.Fatalf("sign error: %v", err)
+		}
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func testJwks(kid string, key *rsa.PublicKey) []byte {
+	return []byte(fmt.Sprintf(`{"keys":[{"kty":"RSA","kid":%q,"use":"sig","n":%q,"e":%q}]}`,
+		kid,
+		base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())))
+}
+
+func testOAuthBearerAuthenticate(t *testing.T, kc *kafkaClient, token string) (ec kafkaErrorCode, message string) {
+	if ec = kc.saslHandshake(saslOAuthBearer); ec != NoError {
+		return
+	}
+
+	_, response, _ := testOAuthBearerMechanism{token: token}.Start(context.Background())
+	challenge, ec, message := kc.saslAuthenticate(response)
+	if ec == NoError && len(challenge) != 0 {
+		_, ec, message = kc.saslAuthenticate([]byte("\x01"))
+	}
+	return
+}
+
+func TestKafkaSaslOAuthBearer(t *testing.T) {
+	tl, mock := testCreateKafkaMockServer(t, 21001, []string{"topic-a"})
+	defer testStopMockServer(t, mock)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key error: %v", err)
+	}
+	if err = mock.SetSaslOAuthBearerJwks(testJwks("key-1", &rsaKey.PublicKey)); err != nil {
+		t.Fatalf("set jwks error: %v", err)
+	}
+	mock.Restart()
+
+	token := testJwt(t, "RS256", "key-1", rsaKey, "svc-a", time.Hour)
+	client := testSaslKafkaClient(21001, testOAuthBearerMechanism{token: token})
+	resp, err := client.Metadata(tl, &kafka.MetadataRequest{Topics: []string{"topic-a"}})
+	if err != nil || len(resp.Topics) != 1 {
+		t.Fatalf("metadata error: %v %+v", err, resp)
+	}
+
+	// an unsecured token isn't accepted when the mock validates signatures
+	token = testJwt(t, "none", "", nil, "svc-a", time.Hour)
+	client = testSaslKafkaClient(21001, testOAuthBearerMechanism{token: token})
+	if _, err = client.Metadata(tl, &kafka.MetadataRequest{Topics: []string{"topic-a"}}); !errors.Is(err, kafka.SASLAuthenticationFailed) {
+		t.Errorf("expected sasl authentication failed, got %v", err)
+	}
+}
+
+func TestSaslOAuthBearerValidation(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	ds := newKafkaDataStore()
+	newClient := func() *kafkaClient {
+		return &kafkaClient{l: tl, ds: ds, saslMechanisms: []string{saslOAuthBearer}, principal: kAnonymousPrincipal}
+	}
+
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err := ds.OAuthBearer.addKey("", &ecKey.PublicKey); err != nil {
+		t.Fatalf("add key error: %v", err)
+	}
+
+	kc := newClient()
+	if ec, message := testOAuthBearerAuthenticate(t, kc, testJwt(t, "ES256", "", ecKey, "svc-b", time.Hour)); ec != NoError {
+		t.Fatalf("authentication error: %d %s", ec, message)
+	}
+	if kc.principal != "User:svc-b" || kc.sessionLifetime() <= 59*time.Minute || kc.sessionLifetime() > time.Hour {
+		t.Errorf("unexpected principal %s and lifetime %s", kc.principal, kc.sessionLifetime())
+	}
+
+	failures := map[string]string{
+		"expired":   testJwt(t, "ES256", "", ecKey, "svc-b", -time.Minute),
+		"wrong key": testJwt(t, "ES256", "", otherKey, "svc-b", time.Hour),
+		"unsecured": testJwt(t, "none", "", nil, "svc-b", time.Hour),
+		"malformed": "not-a-token",
+	}
+	for name, token := range failures {
+		if ec, message := testOAuthBearerAuthenticate(t, newClient(), token); ec != SaslAuthenticationFailed || !strings.HasPrefix(message, "Authentication failed") {
+			t.Errorf("expected the %s token to fail, got %d %s", name, ec, message)
+		}
+	}
+
+	for _, alg := range []string{"S 256", " P384", "HS256", "ES255"} {
+		if err := ds.OAuthBearer.verifySignature(kafkaJwtHeader{Alg: alg}, nil, nil); err == nil || !strings.Contains(err.Error(), "unsupported token algorithm") {
+			t.Errorf("expected algorithm %q to be unsupported, got %v", alg, err)
+		}
+	}
+
+	ds.OAuthBearer.allowUnsecuredTokens()
+	if ec, message := testOAuthBearerAuthenticate(t, newClient(), failures["unsecured"]); ec != NoError {
+		t.Errorf("unsecured token error: %d %s", ec, message)
+	}
+}
+
+func TestSaslReauthentication(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	ds := newKafkaDataStore()
+	ds.OAuthBearer.allowUnsecuredTokens()
+
+	kc := &kafkaClient{l: tl, ds: ds, saslMechanisms: []string{saslOAuthBearer, saslPlain}, principal: kAnonymousPrincipal}
+	if ec, message := testOAuthBearerAuthenticate(t, kc, testJwt(t, "none", "", nil, "svc-c", 100*time.Millisecond)); ec != NoError {
+		t.Fatalf("authentication error: %d %s", ec, message)
+	}
+	if !kc.saslAllows(ApiKeyMetadata) {
+		t.Error("an authenticated client can't make requests")
+	}
+
+	// once the session expires, the client may only authenticate again,
+	// with the same mechanism and as the same principal
+	time.Sleep(150 * time.Millisecond)
+	if kc.saslAllows(ApiKeyMetadata) || !kc.saslAllows(ApiKeySaslHandshake) {
+		t.Error("unexpected apis allowed after the session expired")
+	}
+	if ec := kc.saslHandshake(saslPlain); ec != IllegalSaslState {
+		t.Errorf("expected illegal sasl state, got %d", ec)
+	}
+	if ec, message := testOAuthBearerAuthenticate(t, kc, testJwt(t, "none", "", nil, "svc-c", time.Hour)); ec != NoError {
+		t.Fatalf("re-authentication error: %d %s", ec, message)
+	}
+	if !kc.saslAllows(ApiKeyMetadata) {
+		t.Error("a re-authenticated client can't make requests")
+	}
+
+	if ec, _ := testOAuthBearerAuthenticate(t, kc, testJwt(t, "none", "", nil, "svc-d", time.Hour)); ec != SaslAuthenticationFailed {
+		t.Errorf("expected re-authentication as another principal to fail, got %d", ec)
+	}
+}
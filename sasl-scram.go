@@ -0,0 +1,378 @@
+package kafkamock
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"sort"
+	"strings"
+)
+
+type (
+	// a SCRAM mechanism, with the type that identifies it in the credential apis
+	kafkaScramMechanism struct {
+		name string
+		kind int8
+		hash func() hash.Hash
+	}
+
+	// what the broker keeps of a user's SCRAM password: enough to check the
+	// client's proof and to prove itself, but not the password
+	kafkaScramCredential struct {
+		salt       []byte
+		iterations int
+		storedKey  []byte
+		serverKey  []byte
+	}
+
+	// a deletion or upsertion of a user's credential
+	kafkaScramAlteration struct {
+		user           string
+		kind           int8
+		delete         bool
+		iterations     int
+		salt           []byte
+		saltedPassword []byte
+	}
+
+	kafkaScramAlterResult struct {
+		user    string
+		ec      kafkaErrorCode
+		message string
+	}
+
+	kafkaScramDescribeResult struct {
+		user        string
+		ec          kafkaErrorCode
+		message     string
+		credentials map[int8]int // iterations by mechanism type
+	}
+
+	// the server side of a SCRAM exchange: the client's first message gets
+	// the salt and iterations, and its proof gets the server's signature
+	kafkaScramExchange struct {
+		ds              *kafkaDataStore
+		mechanism       *kafkaScramMechanism
+		user            string
+		credential      *kafkaScramCredential
+		gs2Header       string
+		clientFirstBare string
+		serverFirst     string
+		nonce           string
+	}
+)
+
+const (
+	saslScramSha256 = "SCRAM-SHA-256"
+	saslScramSha512 = "SCRAM-SHA-512"
+
+	kScramMinIterations     = 4096
+	kScramMaxIterations     = 16384
+	kScramDefaultIterations = 4096
+)
+
+var scramMechanisms = map[string]*kafkaScramMechanism{
+	saslScramSha256: {name: saslScramSha256, kind: 1, hash: sha256.New},
+	saslScramSha512: {name: saslScramSha512, kind: 2, hash: sha512.New},
+}
+
+func scramMechanismOfKind(kind int8) *kafkaScramMechanism {
+	for _, sm := range scramMechanisms {
+		if sm.kind == kind {
+			return sm
+		}
+	}
+	return nil
+}
+
+func (sm *kafkaScramMechanism) hmac(key, data []byte) []byte {
+	mac := hmac.New(sm.hash, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func (sm *kafkaScramMechanism) digest(data []byte) []byte {
+	h := sm.hash()
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// PBKDF2 of the password, with the hash's size as the key length so that
+// only the first block is needed
+func (sm *kafkaScramMechanism) saltPassword(password, salt []byte, iterations int) []byte {
+	block := make([]byte, len(salt)+4)
+	copy(block, salt)
+	binary.BigEndian.PutUint32(block[len(salt):], 1)
+
+	u := sm.hmac(password, block)
+	result := bytes.Clone(u)
+	for i := 1; i < iterations; i++ {
+		u = sm.hmac(password, u)
+		for j := range result {
+			result[j] ^= u[j]
+		}
+	}
+	return result
+}
+
+// derives the keys the broker stores from the salted password a client
+// or admin provides
+func (sm *kafkaScramMechanism) newCredential(salt, saltedPassword []byte, iterations int) *kafkaScramCredential {
+	clientKey := sm.hmac(saltedPassword, []byte("Client Key"))
+	return &kafkaScramCredential{
+		salt:       bytes.Clone(salt),
+		iterations: iterations,
+		storedKey:  sm.digest(clientKey),
+		serverKey:  sm.hmac(saltedPassword, []byte("Server Key")),
+	}
+}
+
+// adds users that can authenticate with a SCRAM mechanism, or changes their passwords
+func (ds *kafkaDataStore) setScramUsers(sm *kafkaScramMechanism, users map[string]string) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	for name, password := range users {
+		salt := make([]byte, 32)
+		rand.Read(salt)
+		saltedPassword := sm.saltPassword([]byte(password), salt, kScramDefaultIterations)
+		ds.userOf(name).scram[sm.kind] = sm.newCredential(salt, saltedPassword, kScramDefaultIterations)
+	}
+}
+
+// finds or adds a user; the caller holds the data store lock
+func (ds *kafkaDataStore) userOf(name string) *kafkaUser {
+	user := ds.Users[name]
+	if user == nil {
+		user = &kafkaUser{scram: map[int8]*kafkaScramCredential{}}
+		ds.Users[name] = user
+	}
+	return user
+}
+
+func (ds *kafkaDataStore) scramCredential(name string, kind int8) *kafkaScramCredential {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	if user := ds.Users[name]; user != nil {
+		return user.scram[kind]
+	}
+	return nil
+}
+
+// describes the SCRAM credentials of the users, or of every user that has
+// any when none are named
+func (ds *kafkaDataStore) describeScramCredentials(users []string) []kafkaScramDescribeResult {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	if len(users) == 0 {
+		for name, user := range ds.Users {
+			if len(user.scram) > 0 {
+				users = append(users, name)
+			}
+		}
+		sort.Strings(users)
+	}
+
+	counts := map[string]int{}
+	for _, name := range users {
+		counts[name]++
+	}
+
+	results := make([]kafkaScramDescribeResult, 0, len(users))
+	for _, name := range users {
+		result := kafkaScramDescribeResult{user: name}
+		user := ds.Users[name]
+		if counts[name] > 1 {
+			result.ec = DuplicateResource
+			result.message = "Cannot describe SCRAM credentials for the same user twice in a single request: " + name
+		} else if user == nil || len(user.scram) == 0 {
+			result.ec = ResourceNotFound
+			result.message = "Attempt to describe a user credential that does not exist: " + name
+		} else {
+			result.credentials = map[int8]int{}
+			for kind, credential := range user.scram {
+				result.credentials[kind] = credential.iterations
+			}
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// applies the deletions and upsertions of SCRAM credentials; a user's
+// changes are made only when all of them are valid, and there is a result
+// for each user
+func (ds *kafkaDataStore) alterScramCredentials(alterations []kafkaScramAlteration) []kafkaScramAlterResult {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	results := []kafkaScramAlterResult{}
+	byUser := map[string][]kafkaScramAlteration{}
+	for _, alteration := range alterations {
+		if _, exists := byUser[alteration.user]; !exists {
+			results = append(results, kafkaScramAlterResult{user: alteration.user})
+		}
+		byUser[alteration.user] = append(byUser[alteration.user], alteration)
+	}
+
+	for i := range results {
+		result := &results[i]
+		result.ec, result.message = ds.validateScramAlterations(result.user, byUser[result.user])
+		if result.ec != NoError {
+			continue
+		}
+
+		for _, alteration := range byUser[result.user] {
+			if alteration.delete {
+				delete(ds.Users[result.user].scram, alteration.kind)
+			} else {
+				sm := scramMechanismOfKind(alteration.kind)
+				ds.userOf(result.user).scram[alteration.kind] = sm.newCredential(alteration.salt, alteration.saltedPassword, alteration.iterations)
+			}
+		}
+	}
+	return results
+}
+
+// the caller holds the data store lock
+func (ds *kafkaDataStore) validateScramAlterations(name string, alterations []kafkaScramAlteration) (ec kafkaErrorCode, message string) {
+	if name == "" {
+		return UnacceptableCredential, "Username must not be empty"
+	}
+
+	kinds := map[int8]bool{}
+	for _, alteration := range alterations {
+		if kinds[alteration.kind] {
+			return DuplicateResource, "A user credential cannot be altered twice in the same request"
+		}
+		kinds[alteration.kind] = true
+
+		if scramMechanismOfKind(alteration.kind) == nil {
+			return UnsupportedSaslMechanism, "Unknown SCRAM mechanism"
+		}
+
+		if alteration.delete {
+			if user := ds.Users[name]; user == nil || user.scram[alteration.kind] == nil {
+				return ResourceNotFound, "Attempt to delete a user credential that does not exist"
+			}
+		} else if alteration.iterations < kScramMinIterations {
+			return UnacceptableCredential, "Too few iterations"
+		} else if alteration.iterations > kScramMaxIterations {
+			return UnacceptableCredential, "Too many iterations"
+		}
+	}
+	return NoError, ""
+}
+
+func newScramExchange(ds *kafkaDataStore, mechanism string) kafkaSaslExchange {
+	return &kafkaScramExchange{ds: ds, mechanism: scramMechanisms[mechanism]}
+}
+
+func (se *kafkaScramExchange) next(authBytes []byte) (reply []byte, principal string, err error) {
+	if se.clientFirstBare == "" {
+		return se.clientFirst(string(authBytes))
+	}
+	return se.clientFinal(string(authBytes))
+}
+
+// handles "n,[a=authzid],n=user,r=client-nonce[,extensions]"
+func (se *kafkaScramExchange) clientFirst(message string) (reply []byte, principal string, err error) {
+	parts := strings.SplitN(message, ",", 3)
+	if len(parts) != 3 || (parts[0] != "n" && parts[0] != "y") {
+		return nil, "", errors.New("Authentication failed: Invalid SCRAM client first message")
+	}
+
+	attrs := scramAttributes(parts[2])
+	name, nonce := scramUnescape(attrs["n"]), attrs["r"]
+	if name == "" || nonce == "" {
+		return nil, "", errors.New("Authentication failed: Invalid SCRAM client first message")
+	}
+	if parts[1] != "" && scramUnescape(strings.TrimPrefix(parts[1], "a=")) != name {
+		return nil, "", errors.New("Authentication failed: Authorization id is different from username")
+	}
+
+	se.credential = se.ds.scramCredential(name, se.mechanism.kind)
+	if se.credential == nil {
+		return nil, "", errors.New("Authentication failed: Invalid user credentials")
+	}
+
+	serverNonce := make([]byte, 24)
+	rand.Read(serverNonce)
+
+	se.user = name
+	se.gs2Header = parts[0] + "," + parts[1] + ","
+	se.clientFirstBare = parts[2]
+	se.nonce = nonce + base64.RawURLEncoding.EncodeToString(serverNonce)
+	se.serverFirst = fmt.Sprintf("r=%s,s=%s,i=%d", se.nonce, base64.StdEncoding.EncodeToString(se.credential.salt), se.credential.iterations)
+	return []byte(se.serverFirst), "", nil
+}
+
+// handles "c=channel-binding,r=nonce,p=client-proof", checking the proof
+// against the stored key and replying with the server's signature
+func (se *kafkaScramExchange) clientFinal(message string) (reply []byte, principal string, err error) {
+	proofAt := strings.LastIndex(message, ",p=")
+	if proofAt < 0 {
+		return nil, "", errors.New("Authentication failed: Invalid SCRAM client final message")
+	}
+	withoutProof := message[:proofAt]
+	attrs := scramAttributes(withoutProof)
+
+	if attrs["c"] != base64.StdEncoding.EncodeToString([]byte(se.gs2Header)) {
+		return nil, "", errors.New("Authentication failed: Invalid channel binding")
+	}
+	if attrs["r"] != se.nonce {
+		return nil, "", errors.New("Authentication failed: Invalid client nonce")
+	}
+	proof, err := base64.StdEncoding.DecodeString(message[proofAt+3:])
+	if err != nil || len(proof) != len(se.credential.storedKey) {
+		return nil, "", errors.New("Authentication failed: Invalid client proof")
+	}
+
+	authMessage := []byte(se.clientFirstBare + "," + se.serverFirst + "," + withoutProof)
+	clientSignature := se.mechanism.hmac(se.credential.storedKey, authMessage)
+	clientKey := make([]byte, len(proof))
+	for i := range proof {
+		clientKey[i] = proof[i] ^ clientSignature[i]
+	}
+	if !hmac.Equal(se.mechanism.digest(clientKey), se.credential.storedKey) {
+		return nil, "", errors.New("Authentication failed: Invalid user credentials")
+	}
+
+	serverSignature := se.mechanism.hmac(se.credential.serverKey, authMessage)
+	return []byte("v=" + base64.StdEncoding.EncodeToString(serverSignature)), "User:" + se.user, nil
+}
+
+// splits a SCRAM message into its attributes
+func scramAttributes(message string) map[string]string {
+	attrs := map[string]string{}
+	for _, attr := range strings.Split(message, ",") {
+		if name, value, found := strings.Cut(attr, "="); found {
+			attrs[name] = value
+		}
+	}
+	return attrs
+}
+
+// user names escape the SCRAM delimiters
+func scramUnescape(name string) string {
+	return strings.NewReplacer("=2C", ",", "=3D", "=").Replace(name)
+}
+
+// the mechanism types of the credentials, in order
+func sortedScramKinds(credentials map[int8]int) []int8 {
+	kinds := make([]int8, 0, len(credentials))
+	for kind := range credentials {
+		kinds = append(kinds, kind)
+	}
+	sort.Slice(kinds, func(i, j int) bool { return kinds[i] < kinds[j] })
+	return kinds
+}
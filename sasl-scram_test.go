@@ -0,0 +1,186 @@
+package kafkamock
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jimsnab/go-lane"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+)
+
+type (
+	// the client side of a SCRAM exchange
+	testScramMechanism struct {
+		name     string
+		user     string
+		password string
+	}
+
+	testScramSession struct {
+		sm              *kafkaScramMechanism
+		password        string
+		clientFirstBare string
+		serverSignature []byte
+	}
+)
+
+const testScramNonce = "fyko+d2lbbFgONRv9qkxdawL"
+
+func (m testScramMechanism) Name() string {
+	return m.name
+}
+
+func (m testScramMechanism) Start(ctx context.Context) (sasl.StateMachine, []byte, error) {
+	session := &testScramSession{sm: scramMechanisms[m.name], password: m.password, clientFirstBare: "n=" + m.user + ",r=" + testScramNonce}
+	return session, []byte("n,," + session.clientFirstBare), nil
+}
+
+func (session *testScramSession) Next(ctx context.Context, challenge []byte) (done bool, response []byte, err error) {
+	if session.serverSignature != nil {
+		if string(challenge) != "v="+base64.StdEncoding.EncodeToString(session.serverSignature) {
+			return false, nil, errors.New("invalid server signature")
+		}
+		return true, nil, nil
+	}
+
+	serverFirst := string(challenge)
+	attrs := scramAttributes(serverFirst)
+	if !strings.HasPrefix(attrs["r"], testScramNonce) {
+		return false, nil, errors.New("invalid server nonce")
+	}
+	salt, _ := base64.StdEncoding.DecodeString(attrs["s"])
+	iterations, _ := strconv.Atoi(attrs["i"])
+
+	sm := session.sm
+	saltedPassword := sm.saltPassword([]byte(session.password), salt, iterations)
+	clientKey := sm.hmac(saltedPassword, []byte("Client Key"))
+	withoutProof := "c=biws,r=" + attrs["r"]
+	authMessage := []byte(session.clientFirstBare + "," + serverFirst + "," + withoutProof)
+
+	proof := sm.hmac(sm.digest(clientKey), authMessage)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+	session.serverSignature = sm.hmac(sm.hmac(saltedPassword, []byte("Server Key")), authMessage)
+	return false, []byte(withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof)), nil
+}
+
+// runs a SCRAM exchange directly against a client's authenticator
+func testScramAuthenticate(t *testing.T, kc *kafkaClient, mechanism sasl.Mechanism) (ec kafkaErrorCode, message string) {
+	if ec = kc.saslHandshake(mechanism.Name()); ec != NoError {
+		return
+	}
+
+	sm, response, err := mechanism.Start(context.Background())
+	if err != nil {
+		t.Fatalf("scram start error: %v", err)
+	}
+	for done := false; !done; {
+		var challenge []byte
+		if challenge, ec, message = kc.saslAuthenticate(response); ec != NoError {
+			return
+		}
+		if done, response, err = sm.Next(context.Background(), challenge); err != nil {
+			t.Fatalf("scram client error: %v", err)
+		}
+	}
+	return
+}
+
+func TestSaslScram(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	ds := newKafkaDataStore()
+
+	for _, name := range []string{saslScramSha256, saslScramSha512} {
+		ds.setScramUsers(scramMechanisms[name], map[string]string{"carol": "carol-secret"})
+
+		kc := &kafkaClient{l: tl, ds: ds, saslMechanisms: []string{saslScramSha256, saslScramSha512}}
+		if ec, message := testScramAuthenticate(t, kc, testScramMechanism{name: name, user: "carol", password: "carol-secret"}); ec != NoError {
+			t.Fatalf("%s authentication error: %d %s", name, ec, message)
+		}
+		if kc.principal != "User:carol" {
+			t.Errorf("unexpected %s principal %s", name, kc.principal)
+		}
+
+		kc = &kafkaClient{l: tl, ds: ds, saslMechanisms: []string{saslScramSha256, saslScramSha512}}
+		if ec, _ := testScramAuthenticate(t, kc, testScramMechanism{name: name, user: "carol", password: "wrong"}); ec != SaslAuthenticationFailed {
+			t.Errorf("expected %s authentication to fail, got %d", name, ec)
+		}
+		kc = &kafkaClient{l: tl, ds: ds, saslMechanisms: []string{saslScramSha256, saslScramSha512}}
+		if ec, _ := testScramAuthenticate(t, kc, testScramMechanism{name: name, user: "dave", password: "carol-secret"}); ec != SaslAuthenticationFailed {
+			t.Errorf("expected %s authentication of an unknown user to fail, got %d", name, ec)
+		}
+	}
+}
+
+func TestKafkaScramCredentials(t *testing.T) {
+	tl, mock := testCreateKafkaMockServer(t, 21001, []string{"topic-a"})
+	defer testStopMockServer(t, mock)
+
+	if err := mock.SetSaslScramUsers(saslScramSha256, map[string]string{"admin": "admin-secret"}); err != nil {
+		t.Fatalf("set scram users error: %v", err)
+	}
+	mock.Restart()
+
+	client := testSaslKafkaClient(21001, testScramMechanism{name: saslScramSha256, user: "admin", password: "admin-secret"})
+
+	// rotate a user's credential the way an admin tool would
+	salt := []byte("salt-of-erin")
+	saltedPassword := scramMechanisms[saslScramSha512].saltPassword([]byte("erin-secret"), salt, 8192)
+	alterResp, err := client.AlterUserScramCredentials(tl, &kafka.AlterUserScramCredentialsRequest{
+		Upsertions: []kafka.UserScramCredentialsUpsertion{
+			{Name: "erin", Mechanism: kafka.ScramMechanismSha512, Iterations: 8192, Salt: salt, SaltedPassword: saltedPassword},
+			{Name: "frank", Mechanism: kafka.ScramMechanismSha256, Iterations: 1000, Salt: salt, SaltedPassword: saltedPassword},
+		},
+		Deletions: []kafka.UserScramCredentialsDeletion{{Name: "grace", Mechanism: kafka.ScramMechanismSha256}},
+	})
+	if err != nil || len(alterResp.Results) != 3 {
+		t.Fatalf("alter scram credentials error: %v %+v", err, alterResp)
+	}
+	for _, result := range alterResp.Results {
+		expected := map[string]error{"erin": nil, "frank": kafka.UnacceptableCredential, "grace": kafka.ResourceNotFound}[result.User]
+		if !errors.Is(result.Error, expected) && (expected != nil || result.Error != nil) {
+			t.Errorf("unexpected result for %s: %v", result.User, result.Error)
+		}
+	}
+
+	descResp, err := client.DescribeUserScramCredentials(tl, &kafka.DescribeUserScramCredentialsRequest{})
+	if err != nil || len(descResp.Results) != 2 {
+		t.Fatalf("describe scram credentials error: %v %+v", err, descResp)
+	}
+	if r := descResp.Results[1]; r.User != "erin" || len(r.CredentialInfos) != 1 || r.CredentialInfos[0].Mechanism != kafka.ScramMechanismSha512 || r.CredentialInfos[0].Iterations != 8192 {
+		t.Errorf("unexpected description %+v", r)
+	}
+
+	// the new credential authenticates, and deleting it ends that
+	erin := testScramMechanism{name: saslScramSha512, user: "erin", password: "erin-secret"}
+	kc := &kafkaClient{l: tl, ds: mock.ds, saslMechanisms: []string{saslScramSha512}}
+	if ec, message := testScramAuthenticate(t, kc, erin); ec != NoError {
+		t.Errorf("authentication error: %d %s", ec, message)
+	}
+
+	alterResp, err = client.AlterUserScramCredentials(tl, &kafka.AlterUserScramCredentialsRequest{
+		Deletions: []kafka.UserScramCredentialsDeletion{{Name: "erin", Mechanism: kafka.ScramMechanismSha512}},
+	})
+	if err != nil || alterResp.Results[0].Error != nil {
+		t.Fatalf("delete scram credential error: %v %+v", err, alterResp)
+	}
+	kc = &kafkaClient{l: tl, ds: mock.ds, saslMechanisms: []string{saslScramSha512}}
+	if ec, _ := testScramAuthenticate(t, kc, erin); ec != SaslAuthenticationFailed {
+		t.Errorf("expected authentication to fail, got %d", ec)
+	}
+
+	descResp, err = client.DescribeUserScramCredentials(tl, &kafka.DescribeUserScramCredentialsRequest{Users: []kafka.UserScramCredentialsUser{{Name: "erin"}}})
+	if err != nil || !errors.Is(descResp.Results[0].Error, kafka.ResourceNotFound) {
+		t.Errorf("expected resource not found, got %v %+v", err, descResp)
+	}
+
+	if err := mock.SetSaslScramUsers("SCRAM-SHA-1", nil); err == nil {
+		t.Error("expected SCRAM-SHA-1 to be unsupported")
+	}
+}
@@ -0,0 +1,42 @@
+package kafkamock
+
+import (
+	"bufio"
+)
+
+type (
+	saslAuthenticateRequest struct {
+		AuthBytes []byte
+	}
+
+	saslAuthenticateResponse struct {
+		ErrorCode         int16
+		ErrorMessage      NullableString
+		AuthBytes         []byte
+		SessionLifetimeMs int64 `kafka:"min=1"`
+	}
+)
+
+func saslAuthenticate(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeader) (response any, rtags map[int]any, err error) {
+	request, err := readVersionedRequest[saslAuthenticateRequest](reader, kmh)
+	if err != nil {
+		return
+	}
+
+	reply, ec, message := kc.saslAuthenticate(request.AuthBytes)
+
+	sar := &saslAuthenticateResponse{ErrorCode: int16(ec), AuthBytes: reply}
+	if ec != NoError {
+		kc.l.Tracef("kafka %d sasl authentication failed: %s", kc.clientPort, message)
+		sar.ErrorMessage = &message
+	} else if kc.saslState == saslAuthenticated {
+		// tells the client when to authenticate again (KIP-368)
+		sar.SessionLifetimeMs = kc.sessionLifetime().Milliseconds()
+	}
+	if sar.AuthBytes == nil {
+		sar.AuthBytes = []byte{}
+	}
+
+	response = sar
+	return
+}
@@ -0,0 +1,36 @@
+package kafkamock
+
+import (
+	"bufio"
+)
+
+type (
+	saslHandshakeRequest struct {
+		Mechanism string
+	}
+
+	saslHandshakeResponse struct {
+		ErrorCode  int16
+		Mechanisms []string
+	}
+)
+
+func saslHandshake(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeader) (response any, rtags map[int]any, err error) {
+	request, err := readVersionedRequest[saslHandshakeRequest](reader, kmh)
+	if err != nil {
+		return
+	}
+
+	ec := kc.saslHandshake(request.Mechanism)
+	if ec != NoError {
+		kc.l.Tracef("kafka %d sasl handshake for %s failed: %d", kc.clientPort, request.Mechanism, ec)
+	}
+
+	mechanisms := kc.saslMechanisms
+	if mechanisms == nil {
+		mechanisms = []string{}
+	}
+
+	response = &saslHandshakeResponse{ErrorCode: int16(ec), Mechanisms: mechanisms}
+	return
+}
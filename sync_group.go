@@ -2,78 +2,74 @@ package kafkamock
 
 import (
 	"bufio"
-	"bytes"
-	"errors"
 )
 
 type (
-	syncGroupRequestV0 struct {
-		GroupId      string
-		GenerationId int32
-		MemberId     string
-		Assignments  []syncGroupRequestAssignmentV0
+	syncGroupRequest struct {
+		GroupId         string
+		GenerationId    int32
+		MemberId        string
+		GroupInstanceId NullableString `kafka:"min=3"`
+		ProtocolType    NullableString `kafka:"min=5"`
+		ProtocolName    NullableString `kafka:"min=5"`
+		Assignments     []syncGroupRequestAssignment
 	}
 
-	syncGroupRequestAssignmentV0 struct {
+	syncGroupRequestAssignment struct {
 		MemberID    string
 		Assignments []byte
 	}
 
-	memberAssignment struct {
-		Version              int16
-		PartitionAssignments []memberPartitionAssignment
-		UserData             []byte
-	}
-
-	memberPartitionAssignment struct {
-		Topic      string
-		Partitions []int32
-	}
-
-	syncGroupResponseV0 struct {
-		ErrorCode   int16
-		Assignments []byte
+	syncGroupResponse struct {
+		ThrottleTimeMs int32 `kafka:"min=1"`
+		ErrorCode      int16
+		ProtocolType   NullableString `kafka:"min=5"`
+		ProtocolName   NullableString `kafka:"min=5"`
+		Assignments    []byte
 	}
 )
 
-func syncGroupV0(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeader) (response any, rtags map[int]any, err error) {
-	request, err := readRequest[syncGroupRequestV0](reader)
+func syncGroup(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeader) (response any, rtags map[int]any, err error) {
+	request, err := readVersionedRequest[syncGroupRequest](reader, kmh)
 	if err != nil {
 		return
 	}
 
-	if len(request.Assignments) != 0 {
-		err = errors.New("mock server is the leader - assignments in sync_group request are not supported")
+	if ec := kc.authorizationError(aclOpRead, aclResourceGroup, request.GroupId); ec != NoError {
+		response = &syncGroupResponse{ErrorCode: int16(ec), Assignments: []byte{}}
 		return
 	}
 
-	response = &syncGroupResponseV0{
-		Assignments: makeMemberAssignment(kc.ds),
-	}
-	return
-}
+	kg := kc.ds.getGroup(request.GroupId)
 
-func makeMemberAssignment(ds *kafkaDataStore) []byte {
-	// assign all topics & partitions to this client
-	mpas := make([]memberPartitionAssignment, 0, len(ds.Topics))
-	for name, topic := range ds.Topics {
-		pars := make([]int32, 0, len(topic.Partitions))
-		for _, par := range topic.Partitions {
-			pars = append(pars, par.Index)
-		}
-
-		mpas = append(mpas, memberPartitionAssignment{Topic: name, Partitions: pars})
+	// v5 clients state the protocol they joined with
+	protocolType, protocolName := kg.protocol()
+	if protocolName != "" && ((request.ProtocolType != nil && *request.ProtocolType != protocolType) || (request.ProtocolName != nil && *request.ProtocolName != protocolName)) {
+		response = &syncGroupResponse{ErrorCode: int16(InconsistentGroupProtocol), Assignments: []byte{}}
+		return
 	}
 
-	a := memberAssignment{
-		Version:              1,
-		PartitionAssignments: mpas,
+	assignments := make(map[string][]byte, len(request.Assignments))
+	for _, a := range request.Assignments {
+		assignments[a.MemberID] = a.Assignments
 	}
 
-	var buf bytes.Buffer
-	w := bufio.NewWriter(&buf)
-	encodeObject(w, a)
-	w.Flush()
+	result := kg.sync(kc.l, request.MemberId, stringOrEmpty(request.GroupInstanceId), request.GenerationId, assignments)
+
+	// the assignment is not nullable
+	assignment := result.assignment
+	if assignment == nil {
+		assignment = []byte{}
+	}
 
-	return buf.Bytes()
+	sgr := &syncGroupResponse{
+		ErrorCode:   int16(result.ec),
+		Assignments: assignment,
+	}
+	if result.ec == NoError {
+		sgr.ProtocolType = &protocolType
+		sgr.ProtocolName = &protocolName
+	}
+	response = sgr
+	return
 }
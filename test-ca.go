@@ -0,0 +1,163 @@
+package kafkamock
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"time"
+)
+
+type (
+	// An ephemeral certificate authority for tests, which issues the mock's
+	// server certificate and the certificates of its clients.
+	KafkaMockCA struct {
+		cert *x509.Certificate
+		key  *ecdsa.PrivateKey
+		pool *x509.CertPool
+	}
+)
+
+const kTestCertLifetime = 24 * time.Hour
+
+// Creates a certificate authority with a new key, which is valid for a day.
+func NewKafkaMockCA() (*KafkaMockCA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	template, err := newCertTemplate(pkix.Name{CommonName: "kafka-mock test CA"})
+	if err != nil {
+		return nil, err
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &KafkaMockCA{cert: cert, key: key, pool: pool}, nil
+}
+
+func newCertTemplate(subject pkix.Name) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      subject,
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(kTestCertLifetime),
+	}, nil
+}
+
+// The pool that trusts the authority's certificates.
+func (ca *KafkaMockCA) CertPool() *x509.CertPool {
+	return ca.pool
+}
+
+// The authority's certificate in PEM form, for clients that read their
+// trust store from a file.
+func (ca *KafkaMockCA) CertPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})
+}
+
+// Issues a server certificate for the host names and IP addresses, or for
+// localhost when none are given.
+func (ca *KafkaMockCA) ServerCertificate(hosts ...string) (tls.Certificate, error) {
+	if len(hosts) == 0 {
+		hosts = []string{"localhost", "127.0.0.1", "::1"}
+	}
+
+	template, err := newCertTemplate(pkix.Name{CommonName: hosts[0]})
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	return ca.issue(template)
+}
+
+// Issues a client certificate with the common name, which the mock maps to
+// the principal "User:CN=<commonName>".
+func (ca *KafkaMockCA) ClientCertificate(commonName string) (tls.Certificate, error) {
+	template, err := newCertTemplate(pkix.Name{CommonName: commonName})
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	return ca.issue(template)
+}
+
+func (ca *KafkaMockCA) issue(template *x509.Certificate) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der, ca.cert.Raw}, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// Makes the config of a mock listener with a server certificate for
+// localhost. With tls.RequireAndVerifyClientCert, only clients that have a
+// certificate of this authority can connect.
+func (ca *KafkaMockCA) ServerTLSConfig(clientAuth tls.ClientAuthType) (*tls.Config, error) {
+	cert, err := ca.ServerCertificate()
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   clientAuth,
+		ClientCAs:    ca.pool,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// Makes the config of a client that trusts the authority, and that presents
+// a certificate with the common name unless it is empty.
+func (ca *KafkaMockCA) ClientTLSConfig(commonName string) (*tls.Config, error) {
+	config := &tls.Config{RootCAs: ca.pool, MinVersion: tls.VersionTLS12}
+	if commonName != "" {
+		cert, err := ca.ClientCertificate(commonName)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
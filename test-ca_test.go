@@ -0,0 +1,147 @@
+package kafkamock
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+)
+
+func testTLSKafkaClient(t *testing.T, serverPort uint, ca *KafkaMockCA, commonName string) *kafka.Client {
+	config, err := ca.ClientTLSConfig(commonName)
+	if err != nil {
+		t.Fatalf("client tls config error: %v", err)
+	}
+	return &kafka.Client{
+		Addr:      kafka.TCP(fmt.Sprintf("localhost:%d", serverPort)),
+		Transport: &kafka.Transport{TLS: config},
+		Timeout:   5 * time.Second,
+	}
+}
+
+func TestKafkaMutualTLS(t *testing.T) {
+	tl, mock := testCreateKafkaMockServer(t, 21001, []string{"topic-a"})
+	defer testStopMockServer(t, mock)
+
+	ca, err := NewKafkaMockCA()
+	if err != nil {
+		t.Fatalf("ca error: %v", err)
+	}
+	config, err := ca.ServerTLSConfig(tls.RequireAndVerifyClientCert)
+	if err != nil {
+		t.Fatalf("server tls config error: %v", err)
+	}
+	mock.SetTLSConfig(config)
+	mock.Restart()
+
+	client := testTLSKafkaClient(t, 21001, ca, "alice")
+	resp, err := client.Metadata(tl, &kafka.MetadataRequest{Topics: []string{"topic-a"}})
+	if err != nil || len(resp.Topics) != 1 {
+		t.Fatalf("metadata error: %v %+v", err, resp)
+	}
+	if !strings.Contains(tl.EventsToString(), "client certificate principal is User:CN=alice") {
+		t.Error("expected the client certificate's principal")
+	}
+
+	// a client without a certificate can't connect
+	ctx, cancel := context.WithTimeout(tl, 5*time.Second)
+	defer cancel()
+	if _, err = testTLSKafkaClient(t, 21001, ca, "").Metadata(ctx, &kafka.MetadataRequest{Topics: []string{"topic-a"}}); err == nil {
+		t.Error("expected a client without a certificate to fail")
+	}
+}
+
+func TestKafkaSaslSSL(t *testing.T) {
+	tl, mock := testCreateKafkaMockServer(t, 21001, []string{"topic-a"})
+	defer testStopMockServer(t, mock)
+
+	ca, err := NewKafkaMockCA()
+	if err != nil {
+		t.Fatalf("ca error: %v", err)
+	}
+	config, err := ca.ServerTLSConfig(tls.NoClientCert)
+	if err != nil {
+		t.Fatalf("server tls config error: %v", err)
+	}
+	mock.SetTLSConfig(config)
+	mock.SetSaslPlainUsers(map[string]string{"bob": "bob-secret"})
+	mock.Restart()
+
+	clientConfig, err := ca.ClientTLSConfig("")
+	if err != nil {
+		t.Fatalf("client tls config error: %v", err)
+	}
+	client := &kafka.Client{
+		Addr:      kafka.TCP("localhost:21001"),
+		Transport: &kafka.Transport{TLS: clientConfig, SASL: plain.Mechanism{Username: "bob", Password: "bob-secret"}},
+		Timeout:   5 * time.Second,
+	}
+	resp, err := client.Metadata(tl, &kafka.MetadataRequest{Topics: []string{"topic-a"}})
+	if err != nil || len(resp.Topics) != 1 {
+		t.Fatalf("metadata error: %v %+v", err, resp)
+	}
+	if !strings.Contains(tl.EventsToString(), "authenticated as User:bob") {
+		t.Error("expected the sasl principal")
+	}
+
+	// a plaintext client can't talk to the tls listener
+	ctx, cancel := context.WithTimeout(tl, 5*time.Second)
+	defer cancel()
+	if _, err = testKafkaClient(21001).Metadata(ctx, &kafka.MetadataRequest{Topics: []string{"topic-a"}}); err == nil {
+		t.Error("expected a plaintext client to fail")
+	}
+}
+
+func TestKafkaTLSPeerDrops(t *testing.T) {
+	tl, mock := testCreateKafkaMockServer(t, 21001, []string{"topic-a"})
+	defer testStopMockServer(t, mock)
+
+	ca, err := NewKafkaMockCA()
+	if err != nil {
+		t.Fatalf("ca error: %v", err)
+	}
+	config, err := ca.ServerTLSConfig(tls.NoClientCert)
+	if err != nil {
+		t.Fatalf("server tls config error: %v", err)
+	}
+	mock.SetTLSConfig(config)
+	mock.Restart()
+
+	clientConfig, err := ca.ClientTLSConfig("")
+	if err != nil {
+		t.Fatalf("client tls config error: %v", err)
+	}
+	connect := func() *tls.Conn {
+		conn, err := tls.Dial("tcp", "localhost:21001", clientConfig)
+		if err != nil {
+			t.Fatalf("dial error: %v", err)
+		}
+		return conn
+	}
+
+	// a peer that goes away in the middle of a record
+	conn := connect()
+	conn.NetConn().Write([]byte{23, 3, 3, 0, 100, 1, 2, 3, 4, 5})
+	conn.NetConn().Close()
+
+	// a peer whose record the server can't decrypt, which the server ends with an alert
+	conn = connect()
+	conn.NetConn().Write(append([]byte{23, 3, 3, 0, 32}, make([]byte, 32)...))
+	time.Sleep(100 * time.Millisecond)
+	conn.NetConn().Close()
+
+	// the mock keeps serving other clients
+	client := &kafka.Client{
+		Addr:      kafka.TCP("localhost:21001"),
+		Transport: &kafka.Transport{TLS: clientConfig},
+		Timeout:   5 * time.Second,
+	}
+	if resp, err := client.Metadata(tl, &kafka.MetadataRequest{Topics: []string{"topic-a"}}); err != nil || len(resp.Topics) != 1 {
+		t.Fatalf("metadata error: %v %+v", err, resp)
+	}
+}
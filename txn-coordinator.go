@@ -0,0 +1,384 @@
+package kafkamock
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/jimsnab/go-lane"
+)
+
+type (
+	kafkaTxnState int
+
+	// the transaction of a transactional producer, which the coordinator
+	// guards with the data store lock
+	kafkaTxn struct {
+		state      kafkaTxnState
+		timeout    time.Duration
+		partitions map[kafkaTxnPartition]bool
+		groups     map[string]bool
+		offsets    map[string]map[kafkaTxnPartition]int64 // offsets committed in the transaction, by group
+		seq        int                                    // identifies the transaction to its timer
+		timer      *time.Timer                            // aborts the transaction when it runs too long
+	}
+
+	kafkaTxnPartition struct {
+		topic     string
+		partition int32
+	}
+
+	// a transaction that is being prepared, with what is needed to complete
+	// it once the data store lock is released
+	kafkaTxnOutcome struct {
+		producerId    int64
+		producerEpoch int16
+		commit        bool
+		partitions    []kafkaTxnPartition
+		offsets       map[string]map[kafkaTxnPartition]int64
+		seq           int
+	}
+)
+
+const (
+	txnEmpty kafkaTxnState = iota
+	txnOngoing
+	txnPrepareCommit
+	txnPrepareAbort
+	txnCompleteCommit
+	txnCompleteAbort
+)
+
+var txnStateNames = map[kafkaTxnState]string{
+	txnEmpty:          "Empty",
+	txnOngoing:        "Ongoing",
+	txnPrepareCommit:  "PrepareCommit",
+	txnPrepareAbort:   "PrepareAbort",
+	txnCompleteCommit: "CompleteCommit",
+	txnCompleteAbort:  "CompleteAbort",
+}
+
+func (ts kafkaTxnState) String() string {
+	return txnStateNames[ts]
+}
+
+// the control record types of transaction markers
+const (
+	kControlAbort  = 0
+	kControlCommit = 1
+)
+
+func newKafkaTxn(timeout time.Duration) *kafkaTxn {
+	return &kafkaTxn{state: txnEmpty, timeout: timeout}
+}
+
+// finds the producer of a transactional id and checks that the request
+// comes from its current session; the caller holds the data store lock
+func (ds *kafkaDataStore) txnProducer(transactionalId string, producerId int64, producerEpoch int16) (kp *kafkaProducer, ec kafkaErrorCode) {
+	kp = ds.TransactionalIds[transactionalId]
+	if kp == nil || kp.id != producerId {
+		return nil, InvalidProducerIdMapping
+	}
+	if kp.epoch != producerEpoch {
+		return nil, ProducerFenced
+	}
+	return kp, NoError
+}
+
+// starts a transaction unless one is ongoing; the caller holds the data store lock
+func (ds *kafkaDataStore) beginTxn(l lane.Lane, kp *kafkaProducer) kafkaErrorCode {
+	txn := kp.txn
+	switch txn.state {
+	case txnOngoing:
+		return NoError
+	case txnPrepareCommit, txnPrepareAbort:
+		return ConcurrentTransactions
+	}
+
+	txn.state = txnOngoing
+	txn.partitions = map[kafkaTxnPartition]bool{}
+	txn.groups = map[string]bool{}
+	txn.offsets = map[string]map[kafkaTxnPartition]int64{}
+	txn.seq++
+	seq := txn.seq
+	txn.timer = time.AfterFunc(txn.timeout, func() {
+		ds.expireTxn(l, kp, seq)
+	})
+	return NoError
+}
+
+// adds partitions to the producer's transaction, starting one if necessary
+func (ds *kafkaDataStore) addPartitionsToTxn(l lane.Lane, transactionalId string, producerId int64, producerEpoch int16, partitions []kafkaTxnPartition) kafkaErrorCode {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	kp, ec := ds.txnProducer(transactionalId, producerId, producerEpoch)
+	if ec != NoError {
+		return ec
+	}
+	if ec = ds.beginTxn(l, kp); ec != NoError {
+		return ec
+	}
+	for _, tp := range partitions {
+		kp.txn.partitions[tp] = true
+	}
+	return NoError
+}
+
+// adds a consumer group to the producer's transaction, so that offsets can
+// be committed to it within the transaction
+func (ds *kafkaDataStore) addOffsetsToTxn(l lane.Lane, transactionalId string, producerId int64, producerEpoch int16, groupId string) kafkaErrorCode {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	kp, ec := ds.txnProducer(transactionalId, producerId, producerEpoch)
+	if ec != NoError {
+		return ec
+	}
+	if ec = ds.beginTxn(l, kp); ec != NoError {
+		return ec
+	}
+	kp.txn.groups[groupId] = true
+	return NoError
+}
+
+// holds offsets that are committed within a transaction, which the
+// group sees only once the transaction commits
+func (ds *kafkaDataStore) txnCommitOffsets(transactionalId string, producerId int64, producerEpoch int16, groupId string, offsets map[kafkaTxnPartition]int64) kafkaErrorCode {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	kp, ec := ds.txnProducer(transactionalId, producerId, producerEpoch)
+	if ec != NoError {
+		return ec
+	}
+	if kp.txn.state != txnOngoing || !kp.txn.groups[groupId] {
+		return InvalidTxnState
+	}
+
+	pending := kp.txn.offsets[groupId]
+	if pending == nil {
+		pending = map[kafkaTxnPartition]int64{}
+		kp.txn.offsets[groupId] = pending
+	}
+	for tp, offset := range offsets {
+		pending[tp] = offset
+	}
+	return NoError
+}
+
+// commits or aborts the producer's transaction; a retry of an end that
+// already completed succeeds again
+func (ds *kafkaDataStore) endTxn(l lane.Lane, transactionalId string, producerId int64, producerEpoch int16, commit bool) kafkaErrorCode {
+	ds.mu.Lock()
+
+	kp, ec := ds.txnProducer(transactionalId, producerId, producerEpoch)
+	if ec != NoError {
+		ds.mu.Unlock()
+		return ec
+	}
+
+	switch kp.txn.state {
+	case txnPrepareCommit, txnPrepareAbort:
+		ec = ConcurrentTransactions
+	case txnCompleteCommit:
+		if !commit {
+			ec = InvalidTxnState
+		}
+	case txnCompleteAbort:
+		if commit {
+			ec = InvalidTxnState
+		}
+	case txnEmpty:
+		ec = InvalidTxnState
+	}
+	if ec != NoError || kp.txn.state != txnOngoing {
+		ds.mu.Unlock()
+		return ec
+	}
+
+	outcome := kp.prepareTxnEnd(commit)
+	ds.mu.Unlock()
+
+	ds.completeTxn(l, kp, outcome)
+	return NoError
+}
+
+// aborts a transaction that ran longer than its timeout, fencing the
+// producer by bumping its epoch; the producer learns of the timeout when
+// its next transactional request is rejected
+func (ds *kafkaDataStore) expireTxn(l lane.Lane, kp *kafkaProducer, seq int) {
+	ds.mu.Lock()
+	if kp.txn.seq != seq || kp.txn.state != txnOngoing {
+		ds.mu.Unlock()
+		return
+	}
+
+	l.Tracef("transaction of %s timed out after %s", kp.transactionalId, kp.txn.timeout)
+	if kp.epoch < math.MaxInt16 {
+		kp.epoch++
+	}
+	outcome := kp.prepareTxnEnd(false)
+	ds.mu.Unlock()
+
+	ds.completeTxn(l, kp, outcome)
+}
+
+// moves the ongoing transaction to its prepare state; the caller holds the
+// data store lock and completes the transaction after releasing it
+func (kp *kafkaProducer) prepareTxnEnd(commit bool) *kafkaTxnOutcome {
+	txn := kp.txn
+	txn.timer.Stop()
+
+	outcome := &kafkaTxnOutcome{
+		producerId:    kp.id,
+		producerEpoch: kp.epoch,
+		commit:        commit,
+		partitions:    make([]kafkaTxnPartition, 0, len(txn.partitions)),
+		seq:           txn.seq,
+	}
+	for tp := range txn.partitions {
+		outcome.partitions = append(outcome.partitions, tp)
+	}
+	sort.Slice(outcome.partitions, func(i, j int) bool {
+		if outcome.partitions[i].topic != outcome.partitions[j].topic {
+			return outcome.partitions[i].topic < outcome.partitions[j].topic
+		}
+		return outcome.partitions[i].partition < outcome.partitions[j].partition
+	})
+
+	if commit {
+		txn.state = txnPrepareCommit
+		outcome.offsets = txn.offsets
+	} else {
+		txn.state = txnPrepareAbort
+	}
+	return outcome
+}
+
+// writes the markers that end the transaction in its partitions, makes the
+// offsets it committed visible, and completes it
+func (ds *kafkaDataStore) completeTxn(l lane.Lane, kp *kafkaProducer, outcome *kafkaTxnOutcome) {
+	for _, tp := range outcome.partitions {
+		if kt := ds.getTopic(tp.topic); kt != nil {
+			if par := kt.getPartition(tp.partition); par != nil {
+				par.postTxnMarker(outcome.producerId, outcome.producerEpoch, outcome.commit)
+			}
+		}
+	}
+
+	for groupId, offsets := range outcome.offsets {
+		for tp, offset := range offsets {
+			if kt := ds.getTopic(tp.topic); kt != nil {
+				if par := kt.getPartition(tp.partition); par != nil {
+					par.lock()
+					par.GroupCommittedOffsets[groupId] = offset
+					par.unlock()
+				}
+			}
+		}
+	}
+
+	ds.mu.Lock()
+	if kp.txn.seq == outcome.seq {
+		if outcome.commit {
+			kp.txn.state = txnCompleteCommit
+		} else {
+			kp.txn.state = txnCompleteAbort
+		}
+	}
+	ds.mu.Unlock()
+
+	if outcome.commit {
+		l.Tracef("transaction of %s committed in %d partitions", kp.transactionalId, len(outcome.partitions))
+	} else {
+		l.Tracef("transaction of %s aborted in %d partitions", kp.transactionalId, len(outcome.partitions))
+	}
+}
+
+// checks that a transactional batch belongs to the producer's ongoing
+// transaction, which must include the partition
+func (ds *kafkaDataStore) verifyTxnWrite(producerId int64, producerEpoch int16, topic string, partition int32) kafkaErrorCode {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	kp := ds.Producers[producerId]
+	if kp == nil || kp.txn == nil {
+		return InvalidTxnState
+	}
+	if producerEpoch < kp.epoch {
+		return InvalidProducerEpoch
+	}
+	if kp.txn.state != txnOngoing || !kp.txn.partitions[kafkaTxnPartition{topic: topic, partition: partition}] {
+		return InvalidTxnState
+	}
+	return NoError
+}
+
+// appends the control record that ends a producer's transaction in the
+// partition; a marker from a newer epoch fences the producer's older sessions
+func (kp *kafkaPartition) postTxnMarker(producerId int64, producerEpoch int16, commit bool) int64 {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+
+	return kp.appendTxnMarker(producerId, producerEpoch, commit)
+}
+
+// the caller holds the lock
+func (kp *kafkaPartition) appendTxnMarker(producerId int64, producerEpoch int16, commit bool) int64 {
+	controlType := uint16(kControlAbort)
+	if commit {
+		controlType = kControlCommit
+	}
+
+	// the key is the version and the type, and the value is the version
+	// and the coordinator epoch
+	key := make([]byte, 4)
+	binary.BigEndian.PutUint16(key[2:], controlType)
+
+	record := &kafkaRecord{
+		Timestamp:       time.Now().UnixMilli(),
+		Key:             key,
+		Value:           make([]byte, 6),
+		Headers:         []kafkaRecordHeader{},
+		ProducerId:      producerId,
+		ProducerEpoch:   producerEpoch,
+		Sequence:        kNoSequence,
+		BatchAttributes: kBatchTransactional | kBatchControl,
+	}
+
+	if pp := kp.Producers[producerId]; pp == nil || pp.epoch < producerEpoch {
+		kp.Producers[producerId] = &kafkaPartitionProducer{epoch: producerEpoch}
+	}
+
+	offset := kp.endOffset()
+	kp.appendRecords(record)
+
+	// the transaction is no longer holding back the last stable offset
+	if firstOffset, ongoing := kp.OngoingTxns[producerId]; ongoing {
+		delete(kp.OngoingTxns, producerId)
+		if !commit {
+			kp.AbortedTxns = append(kp.AbortedTxns, kafkaAbortedTxn{producerId: producerId, firstOffset: firstOffset, lastOffset: offset})
+		}
+	}
+	return offset
+}
+
+// whether the record is a transaction marker rather than data
+func (record *kafkaRecord) isControl() bool {
+	return record.BatchAttributes&kBatchControl != 0
+}
+
+// whether a transaction marker commits its transaction rather than aborting it
+func (record *kafkaRecord) isCommitMarker() bool {
+	return record.isControl() && len(record.Key) >= 4 && binary.BigEndian.Uint16(record.Key[2:]) == kControlCommit
+}
+
+// clients from before PRODUCER_FENCED was introduced expect INVALID_PRODUCER_EPOCH
+func fencedError(ec kafkaErrorCode, version, fencedVersion int) kafkaErrorCode {
+	if ec == ProducerFenced && version < fencedVersion {
+		return InvalidProducerEpoch
+	}
+	return ec
+}
@@ -0,0 +1,275 @@
+package kafkamock
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"testing"
+	"time"
+
+	"github.com/jimsnab/go-lane"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+)
+
+// makes a v2 record batch the way a transactional producer would
+func testTxnBatch(t *testing.T, producerId int64, producerEpoch int16, baseSequence int32, records ...protocol.Record) []byte {
+	batch := testProducerBatch(t, producerId, producerEpoch, baseSequence, records...)
+
+	binary.BigEndian.PutUint16(batch[21:], binary.BigEndian.Uint16(batch[21:])|kBatchTransactional)
+	binary.BigEndian.PutUint32(batch[kBatchCrcStart-4:], crc32.Checksum(batch[kBatchCrcStart:], crcTable))
+	return batch
+}
+
+func testTxnInit(t *testing.T, client *kafka.Client, ctx context.Context, transactionalId string) *kafka.ProducerSession {
+	resp, err := client.InitProducerID(ctx, &kafka.InitProducerIDRequest{TransactionalID: transactionalId, TransactionTimeoutMs: 60000, ProducerID: -1, ProducerEpoch: -1})
+	if err != nil || resp.Error != nil {
+		t.Fatalf("init producer id error: %v %v", err, resp.Error)
+	}
+	return resp.Producer
+}
+
+func testTxnAddPartition(t *testing.T, client *kafka.Client, ctx context.Context, transactionalId string, producer *kafka.ProducerSession) error {
+	resp, err := client.AddPartitionsToTxn(ctx, &kafka.AddPartitionsToTxnRequest{
+		TransactionalID: transactionalId,
+		ProducerID:      producer.ProducerID,
+		ProducerEpoch:   producer.ProducerEpoch,
+		Topics:          map[string][]kafka.AddPartitionToTxn{"topic-a": {{Partition: 0}}},
+	})
+	if err != nil {
+		t.Fatalf("add partitions to txn error: %v", err)
+	}
+	return resp.Topics["topic-a"][0].Error
+}
+
+func TestKafkaTransactionCommit(t *testing.T) {
+	tl, mock := testCreateKafkaMockServer(t, 21001, []string{"topic-a"})
+	defer testStopMockServer(t, mock)
+	mock.ds.getTopic("topic-a").createPartition(0)
+
+	client := testKafkaClient(21001)
+	producer := testTxnInit(t, client, tl, "txn-a")
+
+	if err := testTxnAddPartition(t, client, tl, "txn-a", producer); err != nil {
+		t.Fatalf("add partition error: %v", err)
+	}
+	id, epoch := int64(producer.ProducerID), int16(producer.ProducerEpoch)
+	batch := testTxnBatch(t, id, epoch, 0, protocol.Record{Value: protocol.NewBytes([]byte("in txn"))})
+	if rpar := testProduceBatch(t, tl, mock.ds, batch); rpar.ErrorCode != 0 {
+		t.Fatalf("unexpected transactional produce %+v", rpar)
+	}
+
+	aoResp, err := client.AddOffsetsToTxn(tl, &kafka.AddOffsetsToTxnRequest{TransactionalID: "txn-a", ProducerID: producer.ProducerID, ProducerEpoch: producer.ProducerEpoch, GroupID: "group-a"})
+	if err != nil || aoResp.Error != nil {
+		t.Fatalf("add offsets to txn error: %v %v", err, aoResp.Error)
+	}
+	tocResp, err := client.TxnOffsetCommit(tl, &kafka.TxnOffsetCommitRequest{
+		TransactionalID: "txn-a",
+		GroupID:         "group-a",
+		ProducerID:      producer.ProducerID,
+		ProducerEpoch:   producer.ProducerEpoch,
+		GenerationID:    -1,
+		Topics:          map[string][]kafka.TxnOffsetCommit{"topic-a": {{Partition: 0, Offset: 7}}},
+	})
+	if err != nil || tocResp.Topics["topic-a"][0].Error != nil {
+		t.Fatalf("txn offset commit error: %v %+v", err, tocResp)
+	}
+
+	// the offset isn't visible until the transaction commits
+	kp := mock.ds.getTopic("topic-a").getPartition(0)
+	if offset := kp.groupCommittedOffset("group-a"); offset != 0 {
+		t.Errorf("uncommitted transaction offset is visible: %d", offset)
+	}
+
+	endResp, err := client.EndTxn(tl, &kafka.EndTxnRequest{TransactionalID: "txn-a", ProducerID: producer.ProducerID, ProducerEpoch: producer.ProducerEpoch, Committed: true})
+	if err != nil || endResp.Error != nil {
+		t.Fatalf("end txn error: %v %v", err, endResp.Error)
+	}
+	if offset := kp.groupCommittedOffset("group-a"); offset != 7 {
+		t.Errorf("committed transaction offset is not visible: %d", offset)
+	}
+
+	// the commit marker follows the transaction's record
+	kp.lock()
+	if len(kp.Records) != 2 || !kp.Records[1].isControl() || binary.BigEndian.Uint16(kp.Records[1].Key[2:]) != kControlCommit {
+		t.Errorf("expected a commit marker, got %+v", kp.Records)
+	}
+	kp.unlock()
+
+	// a retried commit succeeds, but the transaction can't change its outcome
+	if resp, _ := client.EndTxn(tl, &kafka.EndTxnRequest{TransactionalID: "txn-a", ProducerID: producer.ProducerID, ProducerEpoch: producer.ProducerEpoch, Committed: true}); resp.Error != nil {
+		t.Errorf("expected a retried commit to succeed, got %v", resp.Error)
+	}
+	if resp, _ := client.EndTxn(tl, &kafka.EndTxnRequest{TransactionalID: "txn-a", ProducerID: producer.ProducerID, ProducerEpoch: producer.ProducerEpoch, Committed: false}); !errors.Is(resp.Error, kafka.InvalidTransactionState) {
+		t.Errorf("expected invalid txn state, got %v", resp.Error)
+	}
+
+	// a transactional write outside of a transaction is rejected
+	batch = testTxnBatch(t, id, epoch, 1, protocol.Record{Value: protocol.NewBytes([]byte("no txn"))})
+	if rpar := testProduceBatch(t, tl, mock.ds, batch); rpar.ErrorCode != int16(InvalidTxnState) {
+		t.Errorf("expected invalid txn state, got %+v", rpar)
+	}
+}
+
+func TestKafkaTransactionFencing(t *testing.T) {
+	tl, mock := testCreateKafkaMockServer(t, 21001, []string{"topic-a"})
+	defer testStopMockServer(t, mock)
+	mock.ds.getTopic("topic-a").createPartition(0)
+
+	client := testKafkaClient(21001)
+	old := testTxnInit(t, client, tl, "txn-a")
+	if err := testTxnAddPartition(t, client, tl, "txn-a", old); err != nil {
+		t.Fatalf("add partition error: %v", err)
+	}
+	batch := testTxnBatch(t, int64(old.ProducerID), int16(old.ProducerEpoch), 0, protocol.Record{Value: protocol.NewBytes([]byte("zombie"))})
+	if rpar := testProduceBatch(t, tl, mock.ds, batch); rpar.ErrorCode != 0 {
+		t.Fatalf("unexpected transactional produce %+v", rpar)
+	}
+
+	// a new session aborts the old session's transaction, and fences it
+	current := testTxnInit(t, client, tl, "txn-a")
+	if current.ProducerID != old.ProducerID || current.ProducerEpoch <= old.ProducerEpoch {
+		t.Fatalf("unexpected new session %+v", current)
+	}
+	kp := mock.ds.getTopic("topic-a").getPartition(0)
+	kp.lock()
+	if len(kp.Records) != 2 || binary.BigEndian.Uint16(kp.Records[1].Key[2:]) != kControlAbort {
+		t.Errorf("expected an abort marker, got %+v", kp.Records)
+	}
+	kp.unlock()
+
+	if err := testTxnAddPartition(t, client, tl, "txn-a", old); !errors.Is(err, kafka.ProducerFenced) {
+		t.Errorf("expected producer fenced, got %v", err)
+	}
+	resp, err := client.EndTxn(tl, &kafka.EndTxnRequest{TransactionalID: "txn-a", ProducerID: old.ProducerID, ProducerEpoch: old.ProducerEpoch, Committed: true})
+	if err != nil || !errors.Is(resp.Error, kafka.ProducerFenced) {
+		t.Errorf("expected producer fenced, got %v %v", err, resp.Error)
+	}
+	batch = testTxnBatch(t, int64(old.ProducerID), int16(old.ProducerEpoch), 1, protocol.Record{Value: protocol.NewBytes([]byte("zombie"))})
+	if rpar := testProduceBatch(t, tl, mock.ds, batch); rpar.ErrorCode != int16(InvalidProducerEpoch) {
+		t.Errorf("expected invalid producer epoch, got %+v", rpar)
+	}
+
+	// the timeout is checked against the broker's maximum
+	ipResp, err := client.InitProducerID(tl, &kafka.InitProducerIDRequest{TransactionalID: "txn-b", TransactionTimeoutMs: 3600000, ProducerID: -1, ProducerEpoch: -1})
+	if err != nil || !errors.Is(ipResp.Error, kafka.InvalidTransactionTimeout) {
+		t.Errorf("expected invalid transaction timeout, got %v %v", err, ipResp.Error)
+	}
+}
+
+func TestProduceTransactionalWithoutProducerId(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	ds := newKafkaDataStore()
+	kp := ds.createTopic("topic-a").createPartition(0)
+
+	// no marker could end the transaction, so the batch is rejected
+	batch := testTxnBatch(t, kNoProducerId, kNoProducerEpoch, kNoSequence, protocol.Record{Value: protocol.NewBytes([]byte("orphan"))})
+	if rpar := testProduceBatch(t, tl, ds, batch); rpar.ErrorCode != int16(InvalidRecord) || rpar.BaseOffset != -1 {
+		t.Errorf("expected invalid record, got %+v", rpar)
+	}
+
+	kp.postRecord(0, time.Now(), nil, []byte("plain"), nil)
+	kp.lock()
+	defer kp.unlock()
+	if len(kp.Records) != 1 || len(kp.OngoingTxns) != 0 || kp.lastStableOffset() != 1 {
+		t.Errorf("unexpected log of %d records, ongoing transactions %v", len(kp.Records), kp.OngoingTxns)
+	}
+}
+
+func TestTransactionTimeout(t *testing.T) {
+	tl := lane.NewTestingLane(context.Background())
+	ds := newKafkaDataStore()
+	kp := ds.createTopic("topic-a").createPartition(0)
+
+	id, epoch, _ := ds.initProducer(tl, "txn-a", 50*time.Millisecond, kNoProducerId, kNoProducerEpoch)
+	tp := kafkaTxnPartition{topic: "topic-a", partition: 0}
+	if ec := ds.addPartitionsToTxn(tl, "txn-a", id, epoch, []kafkaTxnPartition{tp}); ec != NoError {
+		t.Fatalf("add partitions error: %d", ec)
+	}
+	if ec := ds.addOffsetsToTxn(tl, "txn-a", id, epoch, "group-a"); ec != NoError {
+		t.Fatalf("add offsets error: %d", ec)
+	}
+	if ec := ds.txnCommitOffsets("txn-a", id, epoch, "group-a", map[kafkaTxnPartition]int64{tp: 3}); ec != NoError {
+		t.Fatalf("txn offset commit error: %d", ec)
+	}
+
+	// the coordinator aborts the transaction that ran too long, which the
+	// producer learns when it is fenced
+	time.Sleep(200 * time.Millisecond)
+	if ec := ds.endTxn(tl, "txn-a", id, epoch, true); ec != ProducerFenced {
+		t.Errorf("expected producer fenced, got %d", ec)
+	}
+	if kp.groupCommittedOffset("group-a") != 0 {
+		t.Error("aborted transaction offset is visible")
+	}
+
+	kp.lock()
+	defer kp.unlock()
+	if len(kp.Records) != 1 || binary.BigEndian.Uint16(kp.Records[0].Key[2:]) != kControlAbort || kp.Records[0].ProducerEpoch != epoch+1 {
+		t.Errorf("expected an abort marker with a bumped epoch, got %+v", kp.Records)
+	}
+}
+
+func TestKafkaReadCommitted(t *testing.T) {
+	tl, mock := testCreateKafkaMockServer(t, 21001, []string{"topic-a"})
+	defer testStopMockServer(t, mock)
+	mock.ds.getTopic("topic-a").createPartition(0)
+
+	client := testKafkaClient(21001)
+	producer := testTxnInit(t, client, tl, "txn-a")
+	if err := testTxnAddPartition(t, client, tl, "txn-a", producer); err != nil {
+		t.Fatalf("add partition error: %v", err)
+	}
+	batch := testTxnBatch(t, int64(producer.ProducerID), int16(producer.ProducerEpoch), 0, protocol.Record{Value: protocol.NewBytes([]byte("in txn"))})
+	if rpar := testProduceBatch(t, tl, mock.ds, batch); rpar.ErrorCode != 0 {
+		t.Fatalf("unexpected transactional produce %+v", rpar)
+	}
+
+	fetchPartition := func(isolationLevel int8) *fetchResponsePartition {
+		fr := testHandlerRequest[*fetchResponse](t, tl, mock.ds, fetch, ApiKeyFetch, 11, &fetchRequest{
+			ReplicaId:      -1,
+			MaxBytes:       1000,
+			IsolationLevel: isolationLevel,
+			Topics:         []fetchTopic{{Topic: "topic-a", Partitions: []fetchPartition{{Partition: 0, PartitionMaxBytes: 1000}}}},
+		})
+		return &fr.Responses[0].Partitions[0]
+	}
+	latestOffset := func(isolationLevel kafka.IsolationLevel) int64 {
+		resp, err := client.ListOffsets(tl, &kafka.ListOffsetsRequest{
+			Topics:         map[string][]kafka.OffsetRequest{"topic-a": {kafka.LastOffsetOf(0)}},
+			IsolationLevel: isolationLevel,
+		})
+		if err != nil || resp.Topics["topic-a"][0].Error != nil {
+			t.Fatalf("list offsets error: %v %+v", err, resp)
+		}
+		return resp.Topics["topic-a"][0].LastOffset
+	}
+
+	// the open transaction holds back a read_committed consumer
+	if fp := fetchPartition(isolationReadCommitted); fp.LastStableOffset != 0 || fp.Records != nil && len(testFetchedRecords(t, fp)) != 0 {
+		t.Errorf("unexpected read_committed fetch %+v", fp)
+	}
+	if fp := fetchPartition(isolationReadUncommitted); len(testFetchedRecords(t, fp)) != 1 {
+		t.Errorf("unexpected read_uncommitted fetch %+v", fp)
+	}
+	if lso, hw := latestOffset(kafka.ReadCommitted), latestOffset(kafka.ReadUncommitted); lso != 0 || hw != 1 {
+		t.Errorf("unexpected latest offsets %d and %d", lso, hw)
+	}
+
+	// once aborted, the transaction is stable and listed for the consumer to skip
+	endResp, err := client.EndTxn(tl, &kafka.EndTxnRequest{TransactionalID: "txn-a", ProducerID: producer.ProducerID, ProducerEpoch: producer.ProducerEpoch, Committed: false})
+	if err != nil || endResp.Error != nil {
+		t.Fatalf("end txn error: %v %v", err, endResp.Error)
+	}
+	fp := fetchPartition(isolationReadCommitted)
+	if fp.LastStableOffset != 2 || len(fp.AbortedTransactions) != 1 {
+		t.Fatalf("unexpected read_committed fetch %+v", fp)
+	}
+	if at := fp.AbortedTransactions[0]; at.ProducerId != int64(producer.ProducerID) || at.FirstOffset != 0 {
+		t.Errorf("unexpected aborted transaction %+v", at)
+	}
+	if lso := latestOffset(kafka.ReadCommitted); lso != 2 {
+		t.Errorf("unexpected last stable offset %d", lso)
+	}
+}
@@ -0,0 +1,111 @@
+package kafkamock
+
+import (
+	"bufio"
+)
+
+type (
+	txnOffsetCommitRequest struct {
+		TransactionalId string
+		GroupId         string
+		ProducerId      int64
+		ProducerEpoch   int16
+		GenerationId    int32          `kafka:"min=3"`
+		MemberId        string         `kafka:"min=3"`
+		GroupInstanceId NullableString `kafka:"min=3"`
+		Topics          []txnOffsetCommitTopic
+	}
+
+	txnOffsetCommitTopic struct {
+		Name       string
+		Partitions []txnOffsetCommitPartition
+	}
+
+	txnOffsetCommitPartition struct {
+		PartitionIndex       int32
+		CommittedOffset      int64
+		CommittedLeaderEpoch int32 `kafka:"min=2"`
+		CommittedMetadata    NullableString
+	}
+
+	txnOffsetCommitResponse struct {
+		ThrottleTimeMs int32
+		Topics         []txnOffsetCommitResponseTopic
+	}
+
+	txnOffsetCommitResponseTopic struct {
+		Name       string
+		Partitions []txnOffsetCommitResponsePartition
+	}
+
+	txnOffsetCommitResponsePartition struct {
+		PartitionIndex int32
+		ErrorCode      int16
+	}
+)
+
+func txnOffsetCommit(reader *bufio.Reader, kc *kafkaClient, kmh *kafkaMessageHeader) (response any, rtags map[int]any, err error) {
+	request, err := readVersionedRequest[txnOffsetCommitRequest](reader, kmh)
+	if err != nil {
+		return
+	}
+
+	// the producer must be allowed to write with its transactional id and to
+	// read the group
+	ec := kc.authorizationError(aclOpWrite, aclResourceTransactionalId, request.TransactionalId)
+	if ec == NoError {
+		ec = kc.authorizationError(aclOpRead, aclResourceGroup, request.GroupId)
+	}
+
+	// like other commits, a commit from a group member must be from its current
+	// generation; older versions don't identify the member
+	if ec == NoError && kmh.RequestApiVersion >= 3 {
+		if cg := kc.ds.findConsumerGroup(request.GroupId); cg != nil {
+			ec = cg.validateCommit(request.MemberId, request.GenerationId)
+		} else {
+			ec = kc.ds.getGroup(request.GroupId).validateCommit(request.MemberId, request.GenerationId)
+		}
+	}
+
+	offsets := map[kafkaTxnPartition]int64{}
+	failed := map[kafkaTxnPartition]kafkaErrorCode{}
+	for _, topic := range request.Topics {
+		kt := kc.ds.getTopic(topic.Name)
+		authEc := kc.authorizationError(aclOpRead, aclResourceTopic, topic.Name)
+		for _, par := range topic.Partitions {
+			tp := kafkaTxnPartition{topic: topic.Name, partition: par.PartitionIndex}
+			if authEc != NoError {
+				failed[tp] = authEc
+			} else if kt == nil || kt.getPartition(par.PartitionIndex) == nil {
+				failed[tp] = UnknownTopicOrPartition
+			} else {
+				offsets[tp] = par.CommittedOffset
+			}
+		}
+	}
+
+	if ec == NoError {
+		ec = kc.ds.txnCommitOffsets(request.TransactionalId, request.ProducerId, request.ProducerEpoch, request.GroupId, offsets)
+		ec = fencedError(ec, kmh.RequestApiVersion, 3)
+		kc.l.Tracef("transaction of %s committed %d offsets for group %s: %d", request.TransactionalId, len(offsets), request.GroupId, ec)
+	}
+
+	tcr := &txnOffsetCommitResponse{Topics: make([]txnOffsetCommitResponseTopic, 0, len(request.Topics))}
+	for _, topic := range request.Topics {
+		rtopic := txnOffsetCommitResponseTopic{
+			Name:       topic.Name,
+			Partitions: make([]txnOffsetCommitResponsePartition, 0, len(topic.Partitions)),
+		}
+		for _, par := range topic.Partitions {
+			rpar := txnOffsetCommitResponsePartition{PartitionIndex: par.PartitionIndex, ErrorCode: int16(ec)}
+			if ec, is := failed[kafkaTxnPartition{topic: topic.Name, partition: par.PartitionIndex}]; is {
+				rpar.ErrorCode = int16(ec)
+			}
+			rtopic.Partitions = append(rtopic.Partitions, rpar)
+		}
+		tcr.Topics = append(tcr.Topics, rtopic)
+	}
+
+	response = tcr
+	return
+}